@@ -0,0 +1,107 @@
+package aiutil
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// BillingReport summarizes usage and estimated cost per model over a time window
+type BillingReport struct {
+	Start  time.Time    `json:"start"`
+	End    time.Time    `json:"end"`
+	Models []ModelUsage `json:"models"` // Sorted by provider, then model
+	Total  ModelUsage   `json:"total"`
+}
+
+// ModelUsage is the usage and estimated cost of one model in a BillingReport
+type ModelUsage struct {
+	Model            string  `json:"model,omitempty"`
+	Provider         string  `json:"provider,omitempty"`
+	Requests         int     `json:"requests"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	TotalTokens      int     `json:"total_tokens"`
+	Cost             float64 `json:"cost"`
+}
+
+// add counts a usage event
+func (u *ModelUsage) add(event UsageEvent) {
+	u.Requests++
+	u.PromptTokens += event.Usage.PromptTokens
+	u.CompletionTokens += event.Usage.CompletionTokens
+	u.TotalTokens += event.Usage.TotalTokens
+	u.Cost += event.Cost
+}
+
+// BillingReport aggregates the retained usage events recorded in [start, end) by model.
+// Events evicted from the ring buffer are not included, so size the capacity to cover the
+// reporting period.
+func (m *MetricsMiddleware) BillingReport(start, end time.Time) *BillingReport {
+	report := &BillingReport{Start: start, End: end}
+
+	byModel := make(map[[2]string]*ModelUsage)
+	m.eachSince(start, func(event UsageEvent) {
+		if !event.Time.Before(end) {
+			return
+		}
+		key := [2]string{event.Provider, event.Model}
+		usage, ok := byModel[key]
+		if !ok {
+			usage = &ModelUsage{Model: event.Model, Provider: event.Provider}
+			byModel[key] = usage
+		}
+		usage.add(event)
+		report.Total.add(event)
+	})
+
+	report.Models = make([]ModelUsage, 0, len(byModel))
+	for _, usage := range byModel {
+		report.Models = append(report.Models, *usage)
+	}
+	sort.Slice(report.Models, func(i, j int) bool {
+		a, b := report.Models[i], report.Models[j]
+		if a.Provider != b.Provider {
+			return a.Provider < b.Provider
+		}
+		return a.Model < b.Model
+	})
+	return report
+}
+
+// WriteJSON writes the report as indented JSON
+func (r *BillingReport) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+// WriteCSV writes one row per model, followed by a total row with an empty provider and
+// the model "total"
+func (r *BillingReport) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"provider", "model", "requests", "prompt_tokens", "completion_tokens", "total_tokens", "cost"})
+	row := func(usage ModelUsage) {
+		writer.Write([]string{
+			usage.Provider,
+			usage.Model,
+			strconv.Itoa(usage.Requests),
+			strconv.Itoa(usage.PromptTokens),
+			strconv.Itoa(usage.CompletionTokens),
+			strconv.Itoa(usage.TotalTokens),
+			strconv.FormatFloat(usage.Cost, 'f', -1, 64),
+		})
+	}
+	for _, usage := range r.Models {
+		row(usage)
+	}
+	total := r.Total
+	total.Model = "total"
+	row(total)
+
+	writer.Flush()
+	return writer.Error()
+}
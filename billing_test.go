@@ -0,0 +1,86 @@
+package aiutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ztkent/ai-util/types"
+)
+
+func TestMetricsMiddleware_BillingReport(t *testing.T) {
+	metrics := NewMetricsMiddleware(10)
+	metrics.Pricing["gpt-4o"] = &types.Model{ID: "gpt-4o", InputCost: 2.5, OutputCost: 10.0}
+	metrics.Pricing["gemini-2.5-flash"] = &types.Model{ID: "gemini-2.5-flash", InputCost: 0.3, OutputCost: 2.5}
+
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+	metrics.clock = func() time.Time { return now }
+
+	record := func(at time.Duration, model, provider string, prompt, completion int) {
+		now = start.Add(at)
+		metrics.Record(model, provider, types.Usage{PromptTokens: prompt, CompletionTokens: completion, TotalTokens: prompt + completion})
+	}
+	record(-time.Hour, "gpt-4o", "openai", 5000, 5000) // Before the window
+	record(time.Hour, "gpt-4o", "openai", 1000, 500)
+	record(2*time.Hour, "gemini-2.5-flash", "google", 10000, 2000)
+	record(3*time.Hour, "gpt-4o", "openai", 3000, 1500)
+	record(24*time.Hour, "gpt-4o", "openai", 5000, 5000) // At the end, excluded
+
+	report := metrics.BillingReport(start, start.Add(24*time.Hour))
+	if len(report.Models) != 2 {
+		t.Fatalf("Expected 2 models, got %+v", report.Models)
+	}
+
+	google, openai := report.Models[0], report.Models[1]
+	if google.Provider != "google" || google.Requests != 1 || google.TotalTokens != 12000 {
+		t.Errorf("Expected 1 google request with 12000 tokens, got %+v", google)
+	}
+	// (10000 * $0.3 + 2000 * $2.5) / 1M tokens
+	if math.Abs(google.Cost-0.008) > 1e-9 {
+		t.Errorf("Expected google cost 0.008, got %f", google.Cost)
+	}
+	if openai.Model != "gpt-4o" || openai.Requests != 2 || openai.PromptTokens != 4000 || openai.CompletionTokens != 2000 {
+		t.Errorf("Expected 2 gpt-4o requests with 4000/2000 tokens, got %+v", openai)
+	}
+	// (4000 * $2.5 + 2000 * $10) / 1M tokens
+	if math.Abs(openai.Cost-0.03) > 1e-9 {
+		t.Errorf("Expected gpt-4o cost 0.03, got %f", openai.Cost)
+	}
+	if report.Total.Requests != 3 || report.Total.TotalTokens != 18000 || math.Abs(report.Total.Cost-0.038) > 1e-9 {
+		t.Errorf("Expected totals of 3 requests, 18000 tokens and 0.038, got %+v", report.Total)
+	}
+
+	var csvOut bytes.Buffer
+	if err := report.WriteCSV(&csvOut); err != nil {
+		t.Fatalf("WriteCSV failed: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(csvOut.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("Expected a header, 2 model rows and a total row, got %q", lines)
+	}
+	if lines[0] != "provider,model,requests,prompt_tokens,completion_tokens,total_tokens,cost" {
+		t.Errorf("Unexpected CSV header %q", lines[0])
+	}
+	if lines[2] != "openai,gpt-4o,2,4000,2000,6000,0.03" {
+		t.Errorf("Unexpected gpt-4o row %q", lines[2])
+	}
+	if !strings.HasPrefix(lines[3], ",total,3,14000,4000,18000,") {
+		t.Errorf("Unexpected total row %q", lines[3])
+	}
+
+	var jsonOut bytes.Buffer
+	if err := report.WriteJSON(&jsonOut); err != nil {
+		t.Fatalf("WriteJSON failed: %v", err)
+	}
+	var decoded BillingReport
+	if err := json.Unmarshal(jsonOut.Bytes(), &decoded); err != nil {
+		t.Fatalf("Failed to decode JSON report: %v", err)
+	}
+	if !decoded.Start.Equal(start) || len(decoded.Models) != 2 || decoded.Models[1] != openai || decoded.Total.Requests != 3 {
+		t.Errorf("Expected the report to round-trip through JSON, got %+v", decoded)
+	}
+}
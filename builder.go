@@ -1,7 +1,10 @@
 package aiutil
 
 import (
+	"errors"
 	"fmt"
+	"sort"
+	"time"
 
 	"github.com/ztkent/ai-util/providers/google"
 	"github.com/ztkent/ai-util/providers/openai"
@@ -53,6 +56,18 @@ func (b *AIClient) WithDefaultTemperature(temperature float64) *AIClient {
 	return b
 }
 
+// WithDefaultRequestTimeout sets the timeout for requests whose context has no deadline
+func (b *AIClient) WithDefaultRequestTimeout(timeout time.Duration) *AIClient {
+	b.config.DefaultRequestTimeout = timeout
+	return b
+}
+
+// WithImagePolicy sets how images are downscaled and detailed before they are sent
+func (b *AIClient) WithImagePolicy(policy *ImagePolicy) *AIClient {
+	b.config.ImagePolicy = policy
+	return b
+}
+
 // WithOpenAI configures OpenAI provider
 func (b *AIClient) WithOpenAI(apiKey string, options ...OpenAIOption) *AIClient {
 	config := &openai.Config{
@@ -71,6 +86,38 @@ func (b *AIClient) WithOpenAI(apiKey string, options ...OpenAIOption) *AIClient
 	return b
 }
 
+// WithAzureOpenAI configures the OpenAI provider for an Azure OpenAI deployment. The
+// deployment is registered as a model under its own name; add more with WithAzureDeployment.
+func (b *AIClient) WithAzureOpenAI(apiKey, endpoint, deployment, apiVersion string, options ...OpenAIOption) *AIClient {
+	return b.WithOpenAI(apiKey, append([]OpenAIOption{func(c *openai.Config) {
+		c.AzureEndpoint = endpoint
+		c.AzureAPIVersion = apiVersion
+		c.AzureDeployments = map[string]string{deployment: deployment}
+	}}, options...)...)
+}
+
+// WithOpenAICompatible configures a provider for a server speaking the OpenAI chat completions
+// protocol, such as Ollama or vLLM, registered under name. Models are listed from the server's
+// /models endpoint and not checked against the OpenAI model list; apiKey may be empty.
+func (b *AIClient) WithOpenAICompatible(name, baseURL, apiKey string, options ...OpenAIOption) *AIClient {
+	config := &openai.Config{
+		BaseConfig: types.BaseConfig{
+			Provider: name,
+			APIKey:   apiKey,
+			BaseURL:  baseURL,
+		},
+		Compatible: true,
+	}
+
+	// Apply options
+	for _, option := range options {
+		option(config)
+	}
+
+	b.providerConfigs[name] = config
+	return b
+}
+
 // WithReplicate configures Replicate provider
 func (b *AIClient) WithReplicate(apiKey string, options ...ReplicateOption) *AIClient {
 	config := &replicate.Config{
@@ -116,6 +163,48 @@ func (b *AIClient) WithMiddleware(middleware ...Middleware) *AIClient {
 	return b
 }
 
+// WithValidators adds response validators, and retries failed responses up to retries times
+// with the validation error fed back to the model
+func (b *AIClient) WithValidators(retries int, validators ...ResponseValidator) *AIClient {
+	b.config.Validators = append(b.config.Validators, validators...)
+	b.config.ValidationRetries = retries
+	return b
+}
+
+// Validate checks the builder's configuration without making any network calls: each
+// provider's config, and the default model's format when the default provider is Replicate.
+// All problems found are returned together.
+func (b *AIClient) Validate() error {
+	names := make([]string, 0, len(b.providerConfigs))
+	for name := range b.providerConfigs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs []error
+	for _, name := range names {
+		switch name {
+		case "openai", "replicate", "google":
+		default:
+			if !isCompatibleConfig(b.providerConfigs[name]) {
+				errs = append(errs, fmt.Errorf("unknown provider: %s", name))
+				continue
+			}
+		}
+		if err := b.providerConfigs[name].Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s config: %w", name, err))
+		}
+	}
+
+	if b.config.DefaultProvider == "replicate" && b.config.DefaultModel != "" {
+		if err := replicate.ValidateModelFormat(b.config.DefaultModel); err != nil {
+			errs = append(errs, fmt.Errorf("invalid default model: %w", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
 // Build creates and configures the client
 func (b *AIClient) Build() (*Client, error) {
 	// Set provider configs
@@ -137,7 +226,10 @@ func (b *AIClient) Build() (*Client, error) {
 		case "google":
 			provider = google.NewProvider()
 		default:
-			return nil, fmt.Errorf("unknown provider: %s", providerName)
+			if !isCompatibleConfig(b.providerConfigs[providerName]) {
+				return nil, fmt.Errorf("unknown provider: %s", providerName)
+			}
+			provider = openai.NewCompatibleProvider(providerName)
 		}
 
 		if err := client.RegisterProvider(provider); err != nil {
@@ -148,6 +240,12 @@ func (b *AIClient) Build() (*Client, error) {
 	return client, nil
 }
 
+// isCompatibleConfig reports whether a provider config is for an OpenAI-compatible server
+func isCompatibleConfig(config types.Config) bool {
+	openaiConfig, ok := config.(*openai.Config)
+	return ok && openaiConfig.Compatible
+}
+
 // Option types for provider-specific configuration
 
 // OpenAIOption configures OpenAI-specific settings
@@ -181,6 +279,30 @@ func WithOpenAIUser(user string) OpenAIOption {
 	}
 }
 
+// WithOpenAIRoleMap remaps outgoing role names for OpenAI-compatible endpoints
+func WithOpenAIRoleMap(roleMap map[types.Role]string) OpenAIOption {
+	return func(c *openai.Config) {
+		c.RoleMap = roleMap
+	}
+}
+
+// WithOpenAIBeta enables OpenAI beta features via the OpenAI-Beta header
+func WithOpenAIBeta(features ...string) OpenAIOption {
+	return func(c *openai.Config) {
+		c.BetaFeatures = append(c.BetaFeatures, features...)
+	}
+}
+
+// WithAzureDeployment maps a model name to the Azure OpenAI deployment that serves it
+func WithAzureDeployment(model, deployment string) OpenAIOption {
+	return func(c *openai.Config) {
+		if c.AzureDeployments == nil {
+			c.AzureDeployments = make(map[string]string)
+		}
+		c.AzureDeployments[model] = deployment
+	}
+}
+
 // ReplicateOption configures Replicate-specific settings
 type ReplicateOption func(*replicate.Config)
 
@@ -225,6 +347,22 @@ func WithGoogleBaseURL(baseURL string) GoogleOption {
 	}
 }
 
+// WithGoogleToolTextAsUser sends tool messages without a ToolResult as user text instead of
+// function responses
+func WithGoogleToolTextAsUser() GoogleOption {
+	return func(c *google.Config) {
+		c.ToolTextAsUser = true
+	}
+}
+
+// WithGoogleSystemMessages sets how system messages after the start of the conversation are
+// handled: moved into the system instruction with a warning (default), silently, or rejected
+func WithGoogleSystemMessages(mode google.SystemMessageMode) GoogleOption {
+	return func(c *google.Config) {
+		c.SystemMessages = mode
+	}
+}
+
 // Simple Client Connections
 func NewOpenAI(apiKey string) (*Client, error) {
 	return NewAIClient().
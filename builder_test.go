@@ -0,0 +1,99 @@
+package aiutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ztkent/ai-util/types"
+)
+
+func TestAIClient_Validate(t *testing.T) {
+	valid := NewAIClient().
+		WithReplicate("test-token").
+		WithDefaultProvider("replicate").
+		WithDefaultModel("meta/meta-llama-3-8b-instruct")
+	if err := valid.Validate(); err != nil {
+		t.Errorf("Expected valid config, got %v", err)
+	}
+
+	invalid := NewAIClient().
+		WithReplicate("test-token").
+		WithOpenAI("").
+		WithDefaultProvider("replicate").
+		WithDefaultModel("llama 3 8b")
+	err := invalid.Validate()
+	if err == nil {
+		t.Fatal("Expected validation errors")
+	}
+
+	var aiErr *types.Error
+	if !errors.As(err, &aiErr) || aiErr.Code != types.ErrCodeInvalidConfig {
+		t.Errorf("Expected %s error, got %v", types.ErrCodeInvalidConfig, err)
+	}
+	if !strings.Contains(err.Error(), "invalid default model") {
+		t.Errorf("Expected the misconfigured Replicate model to be reported, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "invalid openai config") {
+		t.Errorf("Expected the missing OpenAI key to be reported alongside, got %v", err)
+	}
+}
+
+func TestAIClient_OpenAICompatible(t *testing.T) {
+	var chatPath string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1/models":
+			fmt.Fprint(w, `{"object":"list","data":[{"id":"llama3.1","object":"model"},{"id":"qwen2.5","object":"model"}]}`)
+		default:
+			chatPath = r.URL.Path
+			fmt.Fprint(w, `{
+				"id":"chatcmpl-1","object":"chat.completion","created":1,"model":"llama3.1",
+				"choices":[{"index":0,"message":{"role":"assistant","content":"Hello from Ollama"},"finish_reason":"stop"}],
+				"usage":{"prompt_tokens":5,"completion_tokens":4,"total_tokens":9}
+			}`)
+		}
+	}))
+	defer server.Close()
+
+	builder := NewAIClient().WithOpenAICompatible("ollama", server.URL+"/v1", "")
+	if err := builder.Validate(); err != nil {
+		t.Fatalf("Expected compatible config without an API key to validate, got %v", err)
+	}
+	client, err := builder.Build()
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+
+	models := client.ListModelsByProvider("ollama")
+	if len(models) != 2 {
+		t.Fatalf("Expected 2 models from the server's model list, got %d", len(models))
+	}
+
+	resp, err := client.Complete(context.Background(), &types.CompletionRequest{
+		Model:    "llama3.1",
+		Messages: []*types.Message{types.NewTextMessage(types.RoleUser, "Hi")},
+	})
+	if err != nil {
+		t.Fatalf("Complete failed: %v", err)
+	}
+	if chatPath != "/v1/chat/completions" {
+		t.Errorf("Expected request to the chat completions endpoint, got %s", chatPath)
+	}
+	if resp.Provider != "ollama" || resp.Message.TextData != "Hello from Ollama" {
+		t.Errorf("Expected response from the ollama provider, got %s: %q", resp.Provider, resp.Message.TextData)
+	}
+
+	provider, err := client.GetProvider("ollama")
+	if err != nil {
+		t.Fatalf("GetProvider failed: %v", err)
+	}
+	if err := provider.ValidateModel("qwen2.5"); err != nil {
+		t.Errorf("Expected local model to be accepted, got %v", err)
+	}
+}
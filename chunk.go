@@ -0,0 +1,127 @@
+package aiutil
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/ztkent/ai-util/types"
+)
+
+// chunkWordPattern matches a word and the whitespace that follows it
+var chunkWordPattern = regexp.MustCompile(`\S+\s*`)
+
+// ChunkText splits text into chunks of at most maxTokens tokens, as counted by the model's
+// token estimator. Chunks break between words, and each chunk after the first begins with up
+// to overlap tokens from the end of the previous chunk so context isn't lost at the boundaries.
+// Words too long to fit in a chunk on their own are split.
+func (c *Client) ChunkText(text string, maxTokens int, overlap int, model string) []string {
+	if strings.TrimSpace(text) == "" {
+		return nil
+	}
+	if maxTokens <= 0 {
+		return []string{strings.TrimSpace(text)}
+	}
+	if overlap < 0 {
+		overlap = 0
+	}
+
+	count := func(words []string) int {
+		return c.countTextTokens(strings.TrimSpace(strings.Join(words, "")), model)
+	}
+
+	var words []string
+	for _, word := range chunkWordPattern.FindAllString(text, -1) {
+		words = append(words, c.splitOversizedWord(word, maxTokens, model)...)
+	}
+
+	// Each word is counted once, and chunks grow by a running total of their words' tokens
+	wordTokens := make([]int, len(words))
+	for i, word := range words {
+		wordTokens[i] = c.countTextTokens(strings.TrimSpace(word), model)
+	}
+
+	var chunks []string
+	start := 0
+	for start < len(words) {
+		end, tokens := start+1, wordTokens[start]
+		for end < len(words) && tokens+wordTokens[end] <= maxTokens {
+			tokens += wordTokens[end]
+			end++
+		}
+
+		// The joined text can count as more than the sum of its words, so the chunk is
+		// measured once and, if it's over, cut to the longest prefix that fits
+		if end-start > 1 && count(words[start:end]) > maxTokens {
+			end = start + max(1, sort.Search(end-start, func(n int) bool {
+				return count(words[start:start+n+1]) > maxTokens
+			}))
+		}
+
+		chunks = append(chunks, strings.TrimSpace(strings.Join(words[start:end], "")))
+		if end == len(words) {
+			break
+		}
+
+		// Step back over trailing words that fit in the overlap, always making progress. The
+		// overlap is small, so it is measured exactly.
+		next := end
+		for next > start+1 && count(words[next-1:end]) <= overlap {
+			next--
+		}
+		start = next
+	}
+
+	return chunks
+}
+
+// AddChunkedReference splits reference text into token-bounded chunks and adds each chunk
+// as a reference message
+func (c *Conversation) AddChunkedReference(text string, maxTokens int, overlap int, model string) error {
+	if c.client == nil {
+		return types.NewError(types.ErrCodeInvalidConfig, "no client available for token estimation", "")
+	}
+
+	for _, chunk := range c.client.ChunkText(text, maxTokens, overlap, model) {
+		if err := c.AddReferenceMessage(chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// countTextTokens estimates the tokens in text, falling back to ~4 characters per token
+// when the model has no estimator. Estimators count whole messages, so the count of an empty
+// message is subtracted to leave out per-message and reply priming overhead.
+func (c *Client) countTextTokens(text string, model string) int {
+	ctx := context.Background()
+	tokens, err := c.EstimateTokens(ctx, []*types.Message{{Role: types.RoleUser, TextData: text}}, model)
+	if err != nil {
+		return types.ApproxTextTokens(text)
+	}
+	baseline, err := c.EstimateTokens(ctx, []*types.Message{{Role: types.RoleUser}}, model)
+	if err != nil {
+		return tokens
+	}
+	return max(0, tokens-baseline)
+}
+
+// splitOversizedWord splits a word that exceeds maxTokens on its own into pieces that fit
+func (c *Client) splitOversizedWord(word string, maxTokens int, model string) []string {
+	if c.countTextTokens(strings.TrimSpace(word), model) <= maxTokens {
+		return []string{word}
+	}
+
+	var pieces []string
+	runes := []rune(word)
+	for len(runes) > 0 {
+		size := 1
+		for size < len(runes) && c.countTextTokens(strings.TrimSpace(string(runes[:size+1])), model) <= maxTokens {
+			size++
+		}
+		pieces = append(pieces, string(runes[:size]))
+		runes = runes[size:]
+	}
+	return pieces
+}
@@ -0,0 +1,123 @@
+package aiutil
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/ztkent/ai-util/providers/openai"
+	"github.com/ztkent/ai-util/types"
+)
+
+func TestClient_ChunkText(t *testing.T) {
+	provider := newMockProvider("mock-model")
+	client := newMockClient(t, provider)
+
+	var words []string
+	for i := 1; i <= 40; i++ {
+		words = append(words, fmt.Sprintf("word%02d", i))
+	}
+	text := strings.Join(words, " ")
+
+	// The mock estimator counts ~4 characters per token, so each chunk holds at most 40 characters
+	chunks := client.ChunkText(text, 10, 2, "mock-model")
+	if len(chunks) < 2 {
+		t.Fatalf("Expected multiple chunks, got %d", len(chunks))
+	}
+
+	ctx := context.Background()
+	for i, chunk := range chunks {
+		tokens, _ := provider.EstimateTokens(ctx, []*types.Message{{TextData: chunk}}, "mock-model")
+		if tokens > 10 {
+			t.Errorf("Chunk %d has %d tokens, exceeding the limit of 10: %q", i, tokens, chunk)
+		}
+	}
+
+	// Each chunk starts with the last word of the previous chunk
+	for i := 1; i < len(chunks); i++ {
+		previous := strings.Fields(chunks[i-1])
+		current := strings.Fields(chunks[i])
+		if current[0] != previous[len(previous)-1] {
+			t.Errorf("Chunk %d: expected overlap with %q, got %q", i, previous[len(previous)-1], current[0])
+		}
+	}
+
+	if !strings.HasPrefix(chunks[0], "word01") || !strings.HasSuffix(chunks[len(chunks)-1], "word40") {
+		t.Errorf("Expected chunks to cover the whole text, got %q ... %q", chunks[0], chunks[len(chunks)-1])
+	}
+
+	// Without overlap, chunks partition the text
+	chunks = client.ChunkText(text, 10, 0, "mock-model")
+	if strings.Join(chunks, " ") != text {
+		t.Errorf("Expected chunks without overlap to rejoin to the original text")
+	}
+}
+
+func TestClient_ChunkTextCountsLinearly(t *testing.T) {
+	provider := newMockProvider("mock-model")
+	client := newMockClient(t, provider)
+
+	// One token per word; measured records how much text the counter was given in total
+	measured := 0
+	provider.SetTokenCounter(types.TokenCounterFunc(func(messages []*types.Message, model string) (int, error) {
+		tokens := 0
+		for _, msg := range messages {
+			measured += len(msg.GetText())
+			tokens += len(strings.Fields(msg.GetText()))
+		}
+		return tokens, nil
+	}))
+
+	text := strings.TrimSpace(strings.Repeat("lorem ipsum dolor sit amet ", 400))
+	chunks := client.ChunkText(text, 100, 0, "mock-model")
+	if len(chunks) != 20 {
+		t.Fatalf("Expected 20 chunks of 100 words, got %d", len(chunks))
+	}
+	if measured > 5*len(text) {
+		t.Errorf("Expected chunking to measure about as much text as it splits, measured %d characters of %d", measured, len(text))
+	}
+}
+
+func TestClient_ChunkTextExcludesMessageOverhead(t *testing.T) {
+	provider := newMockProvider("mock-model")
+	client := newMockClient(t, provider)
+
+	// The OpenAI counter adds per-message and priming tokens, which mustn't be charged per word
+	provider.SetTokenCounter(openai.ApproxCounter{})
+
+	chunks := client.ChunkText(strings.Repeat("hello ", 400), 100, 10, "mock-model")
+	if len(chunks) > 10 {
+		t.Errorf("Expected chunks of close to 100 tokens, got %d chunks", len(chunks))
+	}
+	for i, chunk := range chunks {
+		if tokens := client.countTextTokens(chunk, "mock-model"); tokens > 100 {
+			t.Errorf("Chunk %d has %d tokens, exceeding the limit of 100", i, tokens)
+		}
+	}
+
+	chunks = client.ChunkText("abcdefgh ijkl", 5, 0, "mock-model")
+	if len(chunks) != 1 || chunks[0] != "abcdefgh ijkl" {
+		t.Errorf("Expected a single chunk, got %q", chunks)
+	}
+}
+
+func TestConversation_AddChunkedReference(t *testing.T) {
+	client := newMockClient(t, newMockProvider("mock-model"))
+	conv := client.NewConversation(&ConversationConfig{SystemPrompt: "You are a test assistant"})
+
+	document := strings.Repeat("lorem ipsum dolor sit amet ", 10)
+	if err := conv.AddChunkedReference(document, 10, 2, "mock-model"); err != nil {
+		t.Fatalf("AddChunkedReference failed: %v", err)
+	}
+
+	references := 0
+	for _, msg := range conv.GetMessages() {
+		if msg.Metadata[MessageTypeKey] == MessageTypeReference {
+			references++
+		}
+	}
+	if references < 2 {
+		t.Errorf("Expected multiple reference messages, got %d", references)
+	}
+}
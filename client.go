@@ -2,9 +2,15 @@ package aiutil
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
+	"sort"
 	"sync"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/ztkent/ai-util/types"
 )
 
@@ -13,6 +19,9 @@ type Client struct {
 	providers     map[string]types.Provider
 	modelRegistry *types.ModelRegistry
 	defaultConfig *ClientConfig
+	abortCtx      context.Context // All requests derive from this; cancelled by Abort
+	abortCancel   context.CancelFunc
+	totalCost     float64 // Estimated cost of all completions, guarded by mu
 	mu            sync.RWMutex
 }
 
@@ -24,6 +33,31 @@ type ClientConfig struct {
 	DefaultTemperature float64                 `json:"default_temperature,omitempty"`
 	ProviderConfigs    map[string]types.Config `json:"provider_configs,omitempty"`
 	Middleware         []Middleware            `json:"-"`
+
+	// ModelContextOverrides sets context window sizes by model ID, taking precedence over the
+	// model registry. Useful for fine-tuned or proxied models the registry doesn't know.
+	ModelContextOverrides map[string]int `json:"model_context_overrides,omitempty"`
+
+	// ModelAliases maps short names to model IDs (e.g. "fast" -> "gemini-2.5-flash")
+	ModelAliases map[string]string `json:"model_aliases,omitempty"`
+
+	// StripUnsupportedTools removes tools from requests to models the registry lists without
+	// tool support, logging a warning, instead of rejecting the request
+	StripUnsupportedTools bool `json:"strip_unsupported_tools,omitempty"`
+
+	// ImagePolicy downscales or sets the detail level of images before they are sent
+	ImagePolicy *ImagePolicy `json:"image_policy,omitempty"`
+
+	// DefaultRequestTimeout bounds Complete and Stream calls whose context has no deadline.
+	// For streams it caps the whole response, not the gap between chunks.
+	DefaultRequestTimeout time.Duration `json:"default_request_timeout,omitempty"`
+
+	// Validators check Complete responses, in order. When one fails, the request is retried
+	// up to ValidationRetries times with the error fed back to the model. Responses with tool
+	// calls, streams, and completions made by other helpers (continuations, tool loops,
+	// structured output, conversation turns and summaries) are not validated.
+	Validators        []ResponseValidator `json:"-"`
+	ValidationRetries int                 `json:"validation_retries,omitempty"`
 }
 
 // Middleware defines the interface for request/response middleware
@@ -32,19 +66,122 @@ type Middleware interface {
 	ProcessResponse(ctx context.Context, resp *types.CompletionResponse) (*types.CompletionResponse, error)
 }
 
-// LoggingMiddleware is an example middleware that logs requests and responses
-type LoggingMiddleware struct{}
+// middlewareStateKey is the context key for per-request middleware state
+type middlewareStateKey struct{}
+
+// middlewareState lets a middleware carry values from ProcessRequest to ProcessResponse
+type middlewareState struct {
+	mu     sync.Mutex
+	values map[interface{}]interface{}
+}
+
+// withMiddlewareState returns a context carrying fresh per-request middleware state
+func withMiddlewareState(ctx context.Context) context.Context {
+	return context.WithValue(ctx, middlewareStateKey{}, &middlewareState{values: make(map[interface{}]interface{})})
+}
+
+// setMiddlewareValue stores a per-request value, if the context carries middleware state
+func setMiddlewareValue(ctx context.Context, key, value interface{}) {
+	if state, ok := ctx.Value(middlewareStateKey{}).(*middlewareState); ok {
+		state.mu.Lock()
+		state.values[key] = value
+		state.mu.Unlock()
+	}
+}
+
+// middlewareValue returns a per-request value stored by setMiddlewareValue
+func middlewareValue(ctx context.Context, key interface{}) (interface{}, bool) {
+	state, ok := ctx.Value(middlewareStateKey{}).(*middlewareState)
+	if !ok {
+		return nil, false
+	}
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	value, ok := state.values[key]
+	return value, ok
+}
+
+// requestIDKey stores the request ID in the middleware state
+type requestIDKey struct{}
+
+// requestID returns the request's ID from its Metadata, or a new one if it has none
+func requestID(req *types.CompletionRequest) string {
+	if id, ok := req.Metadata[types.MetadataKeyRequestID].(string); ok && id != "" {
+		return id
+	}
+	return uuid.New().String()
+}
+
+// mergeRequestMetadata copies the caller's request metadata and the request ID into response
+// metadata, keeping any keys the provider already set. Provider overrides aren't copied.
+func mergeRequestMetadata(metadata, requestMetadata map[string]interface{}, id string) map[string]interface{} {
+	if metadata == nil {
+		metadata = make(map[string]interface{}, len(requestMetadata)+1)
+	}
+	for key, value := range requestMetadata {
+		if types.IsInternalMetadataKey(key) {
+			continue
+		}
+		if _, exists := metadata[key]; !exists {
+			metadata[key] = value
+		}
+	}
+	if _, exists := metadata[types.MetadataKeyRequestID]; !exists {
+		metadata[types.MetadataKeyRequestID] = id
+	}
+	return metadata
+}
+
+// loggingStartKey stores the request start time for LoggingMiddleware
+type loggingStartKey struct{}
+
+// LoggingMiddleware logs each completion with structured fields: provider, model, token
+// usage, finish reason, and latency. A nil Logger uses slog.Default().
+type LoggingMiddleware struct {
+	Logger *slog.Logger
+	Level  slog.Level
+}
 
 func (m *LoggingMiddleware) ProcessRequest(ctx context.Context, req *types.CompletionRequest) (*types.CompletionRequest, error) {
-	fmt.Printf("Request: Model=%s, Messages=%d\n", req.Model, len(req.Messages))
+	setMiddlewareValue(ctx, loggingStartKey{}, time.Now())
+	id, _ := middlewareValue(ctx, requestIDKey{})
+	m.logger().Log(ctx, m.Level, "completion request",
+		slog.Any("request_id", id),
+		slog.String("model", req.Model),
+		slog.Int("messages", len(req.Messages)),
+	)
 	return req, nil
 }
 
 func (m *LoggingMiddleware) ProcessResponse(ctx context.Context, resp *types.CompletionResponse) (*types.CompletionResponse, error) {
-	fmt.Printf("Response: Provider=%s, Tokens=%d\n", resp.Provider, resp.Usage.TotalTokens)
+	attrs := []slog.Attr{
+		slog.Any("request_id", resp.Metadata[types.MetadataKeyRequestID]),
+		slog.String("provider", resp.Provider),
+		slog.String("model", resp.Model),
+		slog.String("finish_reason", resp.FinishReason),
+	}
+	if resp.Usage != nil {
+		attrs = append(attrs,
+			slog.Int("prompt_tokens", resp.Usage.PromptTokens),
+			slog.Int("completion_tokens", resp.Usage.CompletionTokens),
+			slog.Int("total_tokens", resp.Usage.TotalTokens),
+		)
+	}
+	if start, ok := middlewareValue(ctx, loggingStartKey{}); ok {
+		attrs = append(attrs, slog.Duration("latency", time.Since(start.(time.Time))))
+	}
+
+	m.logger().LogAttrs(ctx, m.Level, "completion response", attrs...)
 	return resp, nil
 }
 
+func (m *LoggingMiddleware) logger() *slog.Logger {
+	if m.Logger != nil {
+		return m.Logger
+	}
+	return slog.Default()
+}
+
 // NewClient creates a new AI client
 func NewClient(config *ClientConfig) *Client {
 	if config == nil {
@@ -60,6 +197,7 @@ func NewClient(config *ClientConfig) *Client {
 		modelRegistry: types.NewModelRegistry(),
 		defaultConfig: config,
 	}
+	client.abortCtx, client.abortCancel = context.WithCancel(context.Background())
 
 	return client
 }
@@ -91,6 +229,7 @@ func (c *Client) RegisterProvider(provider types.Provider) error {
 		// Log warning but don't fail registration
 		fmt.Printf("Warning: failed to get models for provider %s: %v\n", providerName, err)
 	} else {
+		applyProviderPricing(ctx, provider, models)
 		for _, model := range models {
 			c.modelRegistry.Register(model)
 		}
@@ -99,6 +238,30 @@ func (c *Client) RegisterProvider(provider types.Provider) error {
 	return nil
 }
 
+// applyProviderPricing sets InputCost and OutputCost on models from the provider's pricing
+// API, for providers implementing types.PricingProvider. Models without a price, or any
+// models if the pricing request fails, keep their existing costs.
+func applyProviderPricing(ctx context.Context, provider types.Provider, models []*types.Model) {
+	pricingProvider, ok := provider.(types.PricingProvider)
+	if !ok {
+		return
+	}
+
+	pricing, err := pricingProvider.GetPricing(ctx)
+	if err != nil {
+		slog.Warn("Failed to get provider pricing, keeping existing model costs",
+			"provider", provider.GetName(), "error", err)
+		return
+	}
+
+	for _, model := range models {
+		if price, ok := pricing[model.ID]; ok {
+			model.InputCost = price.InputCost
+			model.OutputCost = price.OutputCost
+		}
+	}
+}
+
 // GetProvider returns a provider by name
 func (c *Client) GetProvider(name string) (types.Provider, error) {
 	c.mu.RLock()
@@ -113,6 +276,15 @@ func (c *Client) GetProvider(name string) (types.Provider, error) {
 	return provider, nil
 }
 
+// ProviderCapabilities returns the capabilities offered by a registered provider's models
+func (c *Client) ProviderCapabilities(name string) ([]types.ModelCapability, error) {
+	provider, err := c.GetProvider(name)
+	if err != nil {
+		return nil, err
+	}
+	return provider.SupportedCapabilities(), nil
+}
+
 // GetModel returns a model by provider and ID
 func (c *Client) GetModel(provider, id string) (*types.Model, error) {
 	model, exists := c.modelRegistry.Get(provider, id)
@@ -133,20 +305,176 @@ func (c *Client) ListModelsByProvider(provider string) []*types.Model {
 	return c.modelRegistry.GetByProvider(provider)
 }
 
-// Complete performs a completion request
+// SelectModel returns the cheapest registered model, by InputCost, that supports every
+// requested capability. Models without pricing are only picked when no priced model matches.
+func (c *Client) SelectModel(caps ...types.ModelCapability) (*types.Model, error) {
+	if len(caps) == 0 {
+		return nil, types.NewError(types.ErrCodeInvalidRequest, "at least one capability is required", "")
+	}
+
+	var candidates []*types.Model
+	for _, model := range c.modelRegistry.GetByCapability(caps[0]) {
+		if hasCapabilities(model, caps[1:]) {
+			candidates = append(candidates, model)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, types.NewError(types.ErrCodeModelNotFound,
+			fmt.Sprintf("no model supports all of %v", caps), "")
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if (a.InputCost > 0) != (b.InputCost > 0) {
+			return a.InputCost > 0
+		}
+		if a.InputCost != b.InputCost {
+			return a.InputCost < b.InputCost
+		}
+		// Break ties deterministically, since the registry is unordered
+		if a.Provider != b.Provider {
+			return a.Provider < b.Provider
+		}
+		return a.ID < b.ID
+	})
+	return candidates[0], nil
+}
+
+// hasCapabilities reports whether the model supports all of caps
+func hasCapabilities(model *types.Model, caps []types.ModelCapability) bool {
+	for _, capability := range caps {
+		if !model.HasCapability(capability) {
+			return false
+		}
+	}
+	return true
+}
+
+// RefreshModels re-queries every registered provider for its models and replaces the
+// registry contents in one update. Providers are refreshed independently: one that fails
+// keeps its previously registered models, and the failures are returned joined.
+func (c *Client) RefreshModels(ctx context.Context) error {
+	c.mu.RLock()
+	providers := make([]types.Provider, 0, len(c.providers))
+	for _, provider := range c.providers {
+		providers = append(providers, provider)
+	}
+	c.mu.RUnlock()
+
+	var models []*types.Model
+	var errs []error
+	for _, provider := range providers {
+		providerModels, err := provider.GetModels(ctx)
+		if err != nil {
+			errs = append(errs, types.WrapError(err, types.ErrCodeServerError, provider.GetName()))
+			models = append(models, c.modelRegistry.GetByProvider(provider.GetName())...)
+			continue
+		}
+		applyProviderPricing(ctx, provider, providerModels)
+		models = append(models, providerModels...)
+	}
+
+	c.modelRegistry.Replace(models)
+	return errors.Join(errs...)
+}
+
+// Abort cancels all in-flight requests. Subsequent requests fail immediately until Reset is called.
+func (c *Client) Abort() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.abortCancel != nil {
+		c.abortCancel()
+	}
+}
+
+// Reset re-enables requests after Abort
+func (c *Client) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.abortCtx != nil && c.abortCtx.Err() == nil {
+		return
+	}
+	c.abortCtx, c.abortCancel = context.WithCancel(context.Background())
+}
+
+// requestContext derives a per-request context that is cancelled by either the caller or Abort,
+// applying the default request timeout when the caller's context has no deadline
+func (c *Client) requestContext(ctx context.Context) (context.Context, context.CancelFunc, error) {
+	c.mu.RLock()
+	abortCtx := c.abortCtx
+	c.mu.RUnlock()
+
+	if abortCtx != nil && abortCtx.Err() != nil {
+		abortErr := types.NewError(types.ErrCodeAborted, "client aborted, call Reset to resume", "")
+		abortErr.Cause = context.Canceled
+		return nil, nil, abortErr
+	}
+
+	cancelTimeout := context.CancelFunc(func() {})
+	if timeout := c.defaultConfig.DefaultRequestTimeout; timeout > 0 {
+		if _, ok := ctx.Deadline(); !ok {
+			ctx, cancelTimeout = context.WithTimeout(ctx, timeout)
+		}
+	}
+
+	if abortCtx == nil {
+		return ctx, cancelTimeout, nil
+	}
+
+	reqCtx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(abortCtx, cancel)
+	return reqCtx, func() {
+		stop()
+		cancel()
+		cancelTimeout()
+	}, nil
+}
+
+// Complete performs a completion request, checking the response with the client's validators
 func (c *Client) Complete(ctx context.Context, req *types.CompletionRequest) (*types.CompletionResponse, error) {
+	resp, err := c.complete(ctx, req, "")
+	if err != nil || len(c.defaultConfig.Validators) == 0 {
+		return resp, err
+	}
+	return c.validateResponse(ctx, req, resp)
+}
+
+// complete performs a completion request with the named provider, or with the provider for
+// the request's model when providerName is empty
+func (c *Client) complete(ctx context.Context, req *types.CompletionRequest, providerName string) (*types.CompletionResponse, error) {
+	start := time.Now()
+	ctx, cancel, err := c.requestContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
 	// Apply defaults
 	if err := c.applyDefaults(req); err != nil {
 		return nil, err
 	}
 
 	// Get provider for the model
-	provider, err := c.getProviderForModel(req.Model)
+	var provider types.Provider
+	if providerName != "" {
+		provider, err = c.GetProvider(providerName)
+	} else {
+		provider, err = c.getProviderForModel(req.Model)
+	}
 	if err != nil {
 		return nil, err
 	}
 
+	if err := c.checkToolSupport(provider.GetName(), req); err != nil {
+		return nil, err
+	}
+
 	// Apply middleware to request
+	ctx = withMiddlewareState(ctx)
+	id := requestID(req)
+	setMiddlewareValue(ctx, requestIDKey{}, id)
 	processedReq := req
 	for _, middleware := range c.defaultConfig.Middleware {
 		processedReq, err = middleware.ProcessRequest(ctx, processedReq)
@@ -156,10 +484,15 @@ func (c *Client) Complete(ctx context.Context, req *types.CompletionRequest) (*t
 	}
 
 	// Perform completion
+	dispatched := time.Now()
 	resp, err := provider.Complete(ctx, processedReq)
 	if err != nil {
 		return nil, err
 	}
+	resp.Metadata = mergeRequestMetadata(resp.Metadata, req.Metadata, id)
+	timing := resp.Timing
+
+	c.recordCost(provider.GetName(), processedReq.Model, resp.Model, resp.Usage)
 
 	// Apply middleware to response
 	for _, middleware := range c.defaultConfig.Middleware {
@@ -169,11 +502,39 @@ func (c *Client) Complete(ctx context.Context, req *types.CompletionRequest) (*t
 		}
 	}
 
+	if resp != nil {
+		resp.Timing = completeTiming(timing, start, dispatched)
+	}
+
 	return resp, nil
 }
 
+// completeTiming fills in the client-measured timing, adding provider-reported queue time
+// to the time spent before the request was dispatched
+func completeTiming(provider *types.Timing, start, dispatched time.Time) *types.Timing {
+	timing := &types.Timing{}
+	if provider != nil {
+		*timing = *provider
+	}
+	timing.QueueTime += dispatched.Sub(start)
+	timing.TotalTime = time.Since(start)
+	return timing
+}
+
 // Stream performs a streaming completion request
 func (c *Client) Stream(ctx context.Context, req *types.CompletionRequest, callback types.StreamCallback) error {
+	return c.stream(ctx, req, callback, nil)
+}
+
+// stream performs a streaming completion request, recording timing into timing when it is non-nil
+func (c *Client) stream(ctx context.Context, req *types.CompletionRequest, callback types.StreamCallback, timing *types.Timing) error {
+	start := time.Now()
+	ctx, cancel, err := c.requestContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
 	// Apply defaults
 	if err := c.applyDefaults(req); err != nil {
 		return err
@@ -185,7 +546,14 @@ func (c *Client) Stream(ctx context.Context, req *types.CompletionRequest, callb
 		return err
 	}
 
+	if err := c.checkToolSupport(provider.GetName(), req); err != nil {
+		return err
+	}
+
 	// Apply middleware to request
+	ctx = withMiddlewareState(ctx)
+	id := requestID(req)
+	setMiddlewareValue(ctx, requestIDKey{}, id)
 	processedReq := req
 	for _, middleware := range c.defaultConfig.Middleware {
 		processedReq, err = middleware.ProcessRequest(ctx, processedReq)
@@ -197,8 +565,164 @@ func (c *Client) Stream(ctx context.Context, req *types.CompletionRequest, callb
 	// Set stream flag
 	processedReq.Stream = true
 
-	// Perform streaming
-	return provider.Stream(ctx, processedReq, callback)
+	// Perform streaming, converting callback panics into errors
+	recovered := types.RecoverCallback(callback, provider.GetName())
+	received := newStreamAccumulator()
+	chunks := 0
+	var firstToken time.Duration
+	var usage *types.Usage
+	var usageModel string
+	dispatched := time.Now()
+	err = provider.Stream(ctx, processedReq, func(ctx context.Context, chunk *types.StreamResponse) error {
+		chunks++
+		if chunk.FinishReason != "" {
+			chunk.Metadata = mergeRequestMetadata(chunk.Metadata, req.Metadata, id)
+		}
+		if firstToken == 0 && chunk.Delta != nil && (chunk.Delta.TextData != "" || len(chunk.Delta.ToolCalls) > 0) {
+			firstToken = time.Since(start)
+		}
+		received.add(chunk)
+		if chunk.Usage != nil {
+			usage, usageModel = chunk.Usage, chunk.Model
+		}
+		return recovered(ctx, chunk)
+	})
+
+	// Providers may report running usage on every chunk, so cost is recorded once, from the last
+	if usage != nil {
+		c.recordCost(provider.GetName(), processedReq.Model, usageModel, usage)
+	}
+
+	if timing != nil {
+		*timing = *completeTiming(nil, start, dispatched)
+		timing.FirstTokenTime = firstToken
+	}
+
+	// Failures after output has arrived keep what was received
+	if err != nil && chunks > 0 {
+		partial := received.response().Message
+		return &types.StreamError{
+			Err:              err,
+			PartialContent:   partial.TextData,
+			PartialToolCalls: partial.ToolCalls,
+		}
+	}
+	return err
+}
+
+// GenerateImage generates images with an image generation model, routed to its provider
+func (c *Client) GenerateImage(ctx context.Context, req *types.ImageRequest) (*types.ImageResponse, error) {
+	ctx, cancel, err := c.requestContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	if req.Model == "" || req.Prompt == "" {
+		return nil, types.NewError(types.ErrCodeInvalidRequest, "image requests need a model and a prompt", "")
+	}
+
+	provider, err := c.getProviderForModel(req.Model)
+	if err != nil {
+		return nil, err
+	}
+
+	if model, ok := c.modelRegistry.Get(provider.GetName(), req.Model); !ok || !model.HasCapability(types.CapabilityImage) {
+		return nil, types.NewError(types.ErrCodeInvalidRequest,
+			fmt.Sprintf("model %s does not support image generation", req.Model), provider.GetName())
+	}
+
+	imageProvider, ok := provider.(types.ImageProvider)
+	if !ok {
+		return nil, types.NewError(types.ErrCodeInvalidRequest,
+			fmt.Sprintf("provider %s does not support image generation", provider.GetName()), provider.GetName())
+	}
+
+	return imageProvider.GenerateImage(ctx, req)
+}
+
+// Synthesize converts text to speech with a TTS model, routed to its provider
+func (c *Client) Synthesize(ctx context.Context, req *types.TTSRequest) (*types.TTSResponse, error) {
+	ctx, cancel, err := c.requestContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	if req.Model == "" || req.Text == "" {
+		return nil, types.NewError(types.ErrCodeInvalidRequest, "speech requests need a model and text", "")
+	}
+
+	provider, err := c.getProviderForModel(req.Model)
+	if err != nil {
+		return nil, err
+	}
+
+	if model, ok := c.modelRegistry.Get(provider.GetName(), req.Model); !ok || !model.HasCapability(types.CapabilityTTS) {
+		return nil, types.NewError(types.ErrCodeInvalidRequest,
+			fmt.Sprintf("model %s does not support text-to-speech", req.Model), provider.GetName())
+	}
+
+	ttsProvider, ok := provider.(types.TTSProvider)
+	if !ok {
+		return nil, types.NewError(types.ErrCodeInvalidRequest,
+			fmt.Sprintf("provider %s does not support text-to-speech", provider.GetName()), provider.GetName())
+	}
+
+	return ttsProvider.Synthesize(ctx, req)
+}
+
+// TotalCost returns the estimated dollar cost of all completions made through the client,
+// for models with known pricing
+func (c *Client) TotalCost() float64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.totalCost
+}
+
+// recordCost adds the cost of usage to the client total
+func (c *Client) recordCost(provider, requestModel, responseModel string, usage *types.Usage) {
+	cost := c.usageCost(provider, requestModel, responseModel, usage)
+	if cost == 0 {
+		return
+	}
+
+	c.mu.Lock()
+	c.totalCost += cost
+	c.mu.Unlock()
+}
+
+// usageCost estimates the cost of usage, pricing by the model the provider reported or, if
+// that isn't registered (e.g. a dated snapshot), the requested model
+func (c *Client) usageCost(provider, requestModel, responseModel string, usage *types.Usage) float64 {
+	if usage == nil {
+		return 0
+	}
+
+	model, ok := c.modelRegistry.Get(provider, responseModel)
+	if !ok {
+		if model, ok = c.modelRegistry.Get(provider, requestModel); !ok {
+			return 0
+		}
+	}
+	return (&types.CompletionResponse{Usage: usage}).EstimateCost(model)
+}
+
+// SetTokenCounter sets the token counter a registered provider estimates tokens with, used
+// for token budgets and conversation truncation. A nil counter restores the provider's default.
+func (c *Client) SetTokenCounter(provider string, tc types.TokenCounter) error {
+	p, err := c.GetProvider(provider)
+	if err != nil {
+		return err
+	}
+
+	counterProvider, ok := p.(types.TokenCounterProvider)
+	if !ok {
+		return types.NewError(types.ErrCodeInvalidConfig,
+			fmt.Sprintf("provider %s does not support custom token counters", provider), provider)
+	}
+	counterProvider.SetTokenCounter(tc)
+	return nil
 }
 
 // EstimateTokens estimates token count for messages and model
@@ -211,6 +735,180 @@ func (c *Client) EstimateTokens(ctx context.Context, messages []*types.Message,
 	return provider.EstimateTokens(ctx, messages, model)
 }
 
+// Approximate prompt tokens for an image, by detail level
+const (
+	imageTokensLow  = 85
+	imageTokensHigh = 765
+)
+
+// EstimateRequestTokens estimates the prompt tokens for a full request: its messages, the
+// serialized tool and response format schemas, and a fixed estimate per image
+func (c *Client) EstimateRequestTokens(ctx context.Context, req *types.CompletionRequest) (int, error) {
+	tokens, err := c.EstimateTokens(ctx, req.Messages, req.Model)
+	if err != nil {
+		return 0, err
+	}
+
+	// Schemas are sent as JSON, so they are estimated as text
+	var schemas []string
+	if len(req.Tools) > 0 {
+		data, err := json.Marshal(req.Tools)
+		if err != nil {
+			return 0, types.WrapError(err, types.ErrCodeInvalidRequest, "")
+		}
+		schemas = append(schemas, string(data))
+	}
+	if req.ResponseFormat != nil && req.ResponseFormat.Schema != nil {
+		data, err := json.Marshal(req.ResponseFormat.Schema)
+		if err != nil {
+			return 0, types.WrapError(err, types.ErrCodeInvalidRequest, "")
+		}
+		schemas = append(schemas, string(data))
+	}
+	if len(schemas) > 0 {
+		schemaMessages := make([]*types.Message, len(schemas))
+		for i, schema := range schemas {
+			schemaMessages[i] = &types.Message{Role: types.RoleSystem, TextData: schema}
+		}
+		schemaTokens, err := c.EstimateTokens(ctx, schemaMessages, req.Model)
+		if err != nil {
+			return 0, err
+		}
+		tokens += schemaTokens
+	}
+
+	return tokens + estimateImageTokens(req.Messages, 0), nil
+}
+
+// EstimationOptions tunes which message parts EstimateTokensWithOptions counts
+type EstimationOptions struct {
+	IncludeImages  bool // Add an estimate per image; provider estimates only count text
+	PerImageTokens int  // Fixed tokens per image; 0 uses 85 for low detail images and 765 otherwise
+}
+
+// EstimateTokensWithOptions estimates token count for messages and model, counting images
+// according to the options so estimates can match the caller's budget model
+func (c *Client) EstimateTokensWithOptions(ctx context.Context, messages []*types.Message, model string, opts EstimationOptions) (int, error) {
+	tokens, err := c.EstimateTokens(ctx, messages, model)
+	if err != nil {
+		return 0, err
+	}
+
+	if opts.IncludeImages {
+		tokens += estimateImageTokens(messages, opts.PerImageTokens)
+	}
+	return tokens, nil
+}
+
+// estimateImageTokens returns the estimated tokens for the images in messages, at perImage
+// tokens each or, when perImage is 0, by detail level
+func estimateImageTokens(messages []*types.Message, perImage int) int {
+	tokens := 0
+	for _, msg := range messages {
+		for _, content := range msg.Content {
+			image, ok := content.(types.ImageContent)
+			switch {
+			case !ok:
+			case perImage > 0:
+				tokens += perImage
+			case image.Detail == "low":
+				tokens += imageTokensLow
+			default:
+				tokens += imageTokensHigh
+			}
+		}
+	}
+	return tokens
+}
+
+// MaxCompletionTokens returns the maximum number of completion tokens that can be requested
+// for the given messages: the model's context window minus the estimated prompt tokens,
+// capped at the model's MaxOutputTokens when set.
+func (c *Client) MaxCompletionTokens(ctx context.Context, messages []*types.Message, model string) (int, error) {
+	contextWindow, exists := c.contextWindow(model)
+	if !exists {
+		return 0, types.NewError(types.ErrCodeModelNotFound,
+			fmt.Sprintf("context window unknown for model %s", model), "")
+	}
+
+	promptTokens, err := c.EstimateTokens(ctx, messages, model)
+	if err != nil {
+		return 0, err
+	}
+
+	remaining := contextWindow - promptTokens
+	if registeredModel, exists := c.findModel(model); exists && registeredModel.MaxOutputTokens > 0 && remaining > registeredModel.MaxOutputTokens {
+		remaining = registeredModel.MaxOutputTokens
+	}
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	return remaining, nil
+}
+
+// minUsefulOutputTokens is the output budget below which AnalyzeBudget warns that a response
+// is likely to be cut off
+const minUsefulOutputTokens = 32
+
+// BudgetAnalysis is the split of a model's context window between a request's prompt and output
+type BudgetAnalysis struct {
+	Model         string   `json:"model"`
+	PromptTokens  int      `json:"prompt_tokens"`  // Estimated, including tool and response schemas
+	OutputTokens  int      `json:"output_tokens"`  // Requested MaxTokens, or the client default; 0 when unlimited
+	ContextWindow int      `json:"context_window"` // The model's context window
+	Warning       bool     `json:"warning"`
+	Reasons       []string `json:"reasons,omitempty"` // Why Warning is set
+}
+
+// AnalyzeBudget estimates how a request splits the model's context window between prompt and
+// output, warning when they don't fit together or the output budget is implausibly small.
+// The request is not modified or sent.
+func (c *Client) AnalyzeBudget(ctx context.Context, req *types.CompletionRequest) (BudgetAnalysis, error) {
+	model := req.Model
+	if model == "" {
+		model = c.defaultConfig.DefaultModel
+	}
+	model = c.resolveModel(model)
+
+	contextWindow, exists := c.contextWindow(model)
+	if !exists {
+		return BudgetAnalysis{}, types.NewError(types.ErrCodeModelNotFound,
+			fmt.Sprintf("context window unknown for model %s", model), "")
+	}
+
+	estimateReq := *req
+	estimateReq.Model = model
+	promptTokens, err := c.EstimateRequestTokens(ctx, &estimateReq)
+	if err != nil {
+		return BudgetAnalysis{}, err
+	}
+
+	outputTokens := req.MaxTokens
+	if outputTokens == 0 {
+		outputTokens = c.defaultConfig.DefaultMaxTokens
+	}
+	outputTokens = max(outputTokens, 0) // MaxTokensUnlimited leaves the output to the model
+
+	analysis := BudgetAnalysis{
+		Model:         model,
+		PromptTokens:  promptTokens,
+		OutputTokens:  outputTokens,
+		ContextWindow: contextWindow,
+	}
+	if promptTokens+outputTokens > contextWindow {
+		analysis.Reasons = append(analysis.Reasons, fmt.Sprintf(
+			"prompt (%d tokens) and output (%d tokens) exceed the %d token context window",
+			promptTokens, outputTokens, contextWindow))
+	}
+	if outputTokens > 0 && outputTokens < minUsefulOutputTokens {
+		analysis.Reasons = append(analysis.Reasons, fmt.Sprintf(
+			"output limit of %d tokens is likely to cut the response off", outputTokens))
+	}
+	analysis.Warning = len(analysis.Reasons) > 0
+	return analysis, nil
+}
+
 // Close closes all providers and cleans up resources
 func (c *Client) Close() error {
 	c.mu.Lock()
@@ -232,32 +930,145 @@ func (c *Client) Close() error {
 
 // applyDefaults applies default configuration to the request
 func (c *Client) applyDefaults(req *types.CompletionRequest) error {
+	if req.Model == "" && len(req.RequiredCapabilities) > 0 {
+		model, err := c.SelectModel(req.RequiredCapabilities...)
+		if err != nil {
+			return err
+		}
+		req.Model = model.ID
+	}
 	if req.Model == "" {
 		if c.defaultConfig.DefaultModel == "" {
 			return types.NewError(types.ErrCodeInvalidRequest, "model is required", "")
 		}
 		req.Model = c.defaultConfig.DefaultModel
 	}
+	req.Model = c.resolveModel(req.Model)
 
 	if req.MaxTokens == 0 {
 		req.MaxTokens = c.defaultConfig.DefaultMaxTokens
 	}
 
-	if req.Temperature == 0 {
-		req.Temperature = c.defaultConfig.DefaultTemperature
+	// A zero default leaves the temperature to the provider
+	if req.Temperature == nil && c.defaultConfig.DefaultTemperature != 0 {
+		req.Temperature = types.Float64(c.defaultConfig.DefaultTemperature)
+	}
+
+	applyResponseLanguage(req)
+	return applyImagePolicy(req, c.defaultConfig.ImagePolicy)
+}
+
+// supportsPreviousResponse reports whether the provider for model keeps conversation state
+// server-side, so requests can continue from a previous response
+func (c *Client) supportsPreviousResponse(model string) bool {
+	if model == "" {
+		model = c.defaultConfig.DefaultModel
+	}
+	model = c.resolveModel(model)
+
+	provider, err := c.getProviderForModel(model)
+	if err != nil {
+		return false
+	}
+	stateful, ok := provider.(types.StatefulProvider)
+	return ok && stateful.SupportsPreviousResponse(model)
+}
+
+// checkToolSupport rejects requests with tools for models the registry lists without tool
+// support, or strips the tools when StripUnsupportedTools is set. Models without capability
+// information are assumed to support tools.
+func (c *Client) checkToolSupport(provider string, req *types.CompletionRequest) error {
+	if len(req.Tools) == 0 {
+		return nil
 	}
 
+	model, ok := c.modelRegistry.Get(provider, req.Model)
+	if !ok || len(model.Capabilities) == 0 || model.HasCapability(types.CapabilityTools) {
+		return nil
+	}
+
+	if !c.defaultConfig.StripUnsupportedTools {
+		return types.NewError(types.ErrCodeInvalidRequest,
+			fmt.Sprintf("model %s does not support tools, but the request includes %d", req.Model, len(req.Tools)), provider)
+	}
+
+	slog.Warn("stripping tools from request to model without tool support",
+		"provider", provider, "model", req.Model, "tools", len(req.Tools))
+	req.Tools = nil
+	req.ToolChoice = nil
 	return nil
 }
 
-// getProviderForModel determines which provider should handle the given model
-func (c *Client) getProviderForModel(model string) (types.Provider, error) {
-	// First try to find the model in registry
+// responseLanguageKey marks the system message injected for CompletionRequest.ResponseLanguage
+const responseLanguageKey = "response_language"
+
+// applyResponseLanguage prepends a system instruction to respond in the requested language.
+// The instruction is only injected once, so retried requests are not duplicated.
+func applyResponseLanguage(req *types.CompletionRequest) {
+	if req.ResponseLanguage == "" {
+		return
+	}
+
+	for _, msg := range req.Messages {
+		if _, exists := msg.Metadata[responseLanguageKey]; exists {
+			return
+		}
+	}
+
+	instruction := types.NewTextMessage(types.RoleSystem,
+		fmt.Sprintf("Always respond in %s, regardless of the language used in the conversation.", req.ResponseLanguage))
+	instruction.Metadata = map[string]interface{}{responseLanguageKey: req.ResponseLanguage}
+
+	messages := make([]*types.Message, 0, len(req.Messages)+1)
+	messages = append(messages, instruction)
+	req.Messages = append(messages, req.Messages...)
+}
+
+// findModel looks up a registered model by ID across all providers
+func (c *Client) findModel(model string) (*types.Model, bool) {
 	for _, registeredModel := range c.modelRegistry.List() {
 		if registeredModel.ID == model {
-			return c.GetProvider(registeredModel.Provider)
+			return registeredModel, true
 		}
 	}
+	return nil, false
+}
+
+// contextWindow returns the context window size for a model, preferring ModelContextOverrides
+// over the model registry
+func (c *Client) contextWindow(model string) (int, bool) {
+	if tokens, ok := c.defaultConfig.ModelContextOverrides[model]; ok && tokens > 0 {
+		return tokens, true
+	}
+	if registeredModel, exists := c.findModel(model); exists && registeredModel.MaxTokens > 0 {
+		return registeredModel.MaxTokens, true
+	}
+	return 0, false
+}
+
+// ProviderForModel returns the name of the provider that handles a model, resolving aliases
+func (c *Client) ProviderForModel(model string) (string, error) {
+	provider, err := c.getProviderForModel(c.resolveModel(model))
+	if err != nil {
+		return "", err
+	}
+	return provider.GetName(), nil
+}
+
+// resolveModel returns the model ID for an alias, or the model unchanged if it isn't one
+func (c *Client) resolveModel(model string) string {
+	if resolved, ok := c.defaultConfig.ModelAliases[model]; ok && resolved != "" {
+		return resolved
+	}
+	return model
+}
+
+// getProviderForModel determines which provider should handle the given model
+func (c *Client) getProviderForModel(model string) (types.Provider, error) {
+	// First try to find the model in registry
+	if registeredModel, exists := c.findModel(model); exists {
+		return c.GetProvider(registeredModel.Provider)
+	}
 
 	// Fallback to default provider if configured
 	if c.defaultConfig.DefaultProvider != "" {
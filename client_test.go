@@ -0,0 +1,1096 @@
+package aiutil
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ztkent/ai-util/types"
+)
+
+func TestApplyDefaults_ResponseLanguage(t *testing.T) {
+	client := NewClient(&ClientConfig{DefaultModel: "gpt-4o-mini"})
+
+	req := &types.CompletionRequest{
+		Messages: []*types.Message{
+			types.NewTextMessage(types.RoleUser, "Hello"),
+		},
+		ResponseLanguage: "fr-FR",
+	}
+
+	if err := client.applyDefaults(req); err != nil {
+		t.Fatalf("Unexpected error applying defaults: %v", err)
+	}
+
+	if len(req.Messages) != 2 {
+		t.Fatalf("Expected 2 messages, got %d", len(req.Messages))
+	}
+
+	instruction := req.Messages[0]
+	if instruction.Role != types.RoleSystem {
+		t.Errorf("Expected instruction role to be system, got %s", instruction.Role)
+	}
+	if !strings.Contains(instruction.GetText(), "fr-FR") {
+		t.Errorf("Expected instruction to mention 'fr-FR', got %q", instruction.GetText())
+	}
+
+	// Applying again (e.g. on retry) must not inject a second instruction
+	if err := client.applyDefaults(req); err != nil {
+		t.Fatalf("Unexpected error applying defaults: %v", err)
+	}
+	if len(req.Messages) != 2 {
+		t.Errorf("Expected instruction to be injected once, got %d messages", len(req.Messages))
+	}
+}
+
+func TestApplyDefaults_NoResponseLanguage(t *testing.T) {
+	client := NewClient(&ClientConfig{DefaultModel: "gpt-4o-mini"})
+
+	req := &types.CompletionRequest{
+		Messages: []*types.Message{
+			types.NewTextMessage(types.RoleUser, "Hello"),
+		},
+	}
+
+	if err := client.applyDefaults(req); err != nil {
+		t.Fatalf("Unexpected error applying defaults: %v", err)
+	}
+
+	if len(req.Messages) != 1 {
+		t.Errorf("Expected messages to be unchanged, got %d", len(req.Messages))
+	}
+}
+
+func TestApplyDefaults_MaxTokens(t *testing.T) {
+	client := NewClient(&ClientConfig{DefaultModel: "gpt-4o-mini", DefaultMaxTokens: 2048})
+
+	unset := &types.CompletionRequest{}
+	if err := client.applyDefaults(unset); err != nil {
+		t.Fatalf("Unexpected error applying defaults: %v", err)
+	}
+	if unset.MaxTokens != 2048 {
+		t.Errorf("Expected unset max tokens to use the default 2048, got %d", unset.MaxTokens)
+	}
+
+	unlimited := &types.CompletionRequest{MaxTokens: types.MaxTokensUnlimited}
+	if err := client.applyDefaults(unlimited); err != nil {
+		t.Fatalf("Unexpected error applying defaults: %v", err)
+	}
+	if unlimited.MaxTokens != types.MaxTokensUnlimited {
+		t.Errorf("Expected unlimited max tokens to be kept, got %d", unlimited.MaxTokens)
+	}
+}
+
+func TestClient_MaxCompletionTokens(t *testing.T) {
+	provider := newMockProvider()
+	provider.models = []*types.Model{
+		{ID: "small-model", Provider: "mock", MaxTokens: 1000},
+		{ID: "capped-model", Provider: "mock", MaxTokens: 100000, MaxOutputTokens: 4096},
+	}
+	client := newMockClient(t, provider)
+
+	ctx := context.Background()
+	// 400 characters estimates to 100 prompt tokens
+	messages := []*types.Message{
+		types.NewTextMessage(types.RoleUser, strings.Repeat("a", 400)),
+	}
+
+	remaining, err := client.MaxCompletionTokens(ctx, messages, "small-model")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if remaining != 900 {
+		t.Errorf("Expected 900 remaining tokens, got %d", remaining)
+	}
+
+	remaining, err = client.MaxCompletionTokens(ctx, messages, "capped-model")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if remaining != 4096 {
+		t.Errorf("Expected remaining tokens capped at 4096, got %d", remaining)
+	}
+
+	if _, err := client.MaxCompletionTokens(ctx, messages, "unknown-model"); err == nil {
+		t.Error("Expected error for unknown model")
+	}
+}
+
+func TestClient_Abort(t *testing.T) {
+	provider := newMockProvider("mock-model")
+	provider.complete = func(ctx context.Context, req *types.CompletionRequest) (*types.CompletionResponse, error) {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(5 * time.Second):
+			return nil, errors.New("request was not aborted")
+		}
+	}
+	client := newMockClient(t, provider)
+
+	req := &types.CompletionRequest{
+		Model:    "mock-model",
+		Messages: []*types.Message{types.NewTextMessage(types.RoleUser, "Hello")},
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := client.Complete(context.Background(), req)
+		errCh <- err
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	client.Abort()
+
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected context.Canceled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected in-flight request to return promptly after Abort")
+	}
+
+	// Requests fail fast while aborted
+	provider.complete = nil
+	if _, err := client.Complete(context.Background(), req); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected request after Abort to fail with context.Canceled, got %v", err)
+	}
+
+	client.Reset()
+	if _, err := client.Complete(context.Background(), req); err != nil {
+		t.Errorf("Expected request after Reset to succeed, got %v", err)
+	}
+}
+
+func TestClient_DefaultRequestTimeout(t *testing.T) {
+	provider := newMockProvider("mock-model")
+	provider.complete = func(ctx context.Context, req *types.CompletionRequest) (*types.CompletionResponse, error) {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(5 * time.Second):
+			return nil, errors.New("request did not time out")
+		}
+	}
+	client := newMockClient(t, provider)
+	client.defaultConfig.DefaultRequestTimeout = 50 * time.Millisecond
+
+	req := &types.CompletionRequest{
+		Model:    "mock-model",
+		Messages: []*types.Message{types.NewTextMessage(types.RoleUser, "Hello")},
+	}
+
+	start := time.Now()
+	_, err := client.Complete(context.Background(), req)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected request to time out at the default, took %v", elapsed)
+	}
+
+	// A caller deadline takes precedence over the default
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	if _, err := client.Complete(ctx, req); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("Expected caller deadline to be used, timed out after %v", elapsed)
+	}
+
+	// Streams are capped as a whole
+	provider.stream = func(ctx context.Context, req *types.CompletionRequest, callback types.StreamCallback) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	if err := client.Stream(context.Background(), req, func(ctx context.Context, resp *types.StreamResponse) error {
+		return nil
+	}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected stream to fail with context.DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestLoggingMiddleware(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	provider := newMockProvider("mock-model")
+	provider.complete = func(ctx context.Context, req *types.CompletionRequest) (*types.CompletionResponse, error) {
+		return &types.CompletionResponse{
+			Model:        req.Model,
+			Provider:     "mock",
+			Message:      types.NewTextMessage(types.RoleAssistant, "Hi"),
+			FinishReason: "stop",
+			Usage:        &types.Usage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15},
+		}, nil
+	}
+	client := newMockClient(t, provider)
+	client.defaultConfig.Middleware = []Middleware{&LoggingMiddleware{Logger: logger, Level: slog.LevelDebug}}
+
+	_, err := client.Complete(context.Background(), &types.CompletionRequest{
+		Model:    "mock-model",
+		Messages: []*types.Message{types.NewTextMessage(types.RoleUser, "Hello")},
+	})
+	if err != nil {
+		t.Fatalf("Complete failed: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected request and response log records, got %d", len(lines))
+	}
+
+	var record map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[1]), &record); err != nil {
+		t.Fatalf("Failed to parse log record: %v", err)
+	}
+
+	if record["level"] != "DEBUG" {
+		t.Errorf("Expected DEBUG level, got %v", record["level"])
+	}
+	expected := map[string]interface{}{
+		"provider":          "mock",
+		"model":             "mock-model",
+		"finish_reason":     "stop",
+		"prompt_tokens":     float64(10),
+		"completion_tokens": float64(5),
+		"total_tokens":      float64(15),
+	}
+	for field, value := range expected {
+		if record[field] != value {
+			t.Errorf("Expected %s=%v, got %v", field, value, record[field])
+		}
+	}
+	if _, ok := record["latency"]; !ok {
+		t.Error("Expected latency field in log record")
+	}
+}
+
+func TestLoggingMiddleware_NilUsage(t *testing.T) {
+	var buf bytes.Buffer
+	middleware := &LoggingMiddleware{Logger: slog.New(slog.NewJSONHandler(&buf, nil))}
+
+	_, err := middleware.ProcessResponse(context.Background(), &types.CompletionResponse{Provider: "mock"})
+	if err != nil {
+		t.Fatalf("ProcessResponse failed: %v", err)
+	}
+	if strings.Contains(buf.String(), "total_tokens") {
+		t.Errorf("Expected no token fields without usage, got %s", buf.String())
+	}
+}
+
+func TestClient_EstimateRequestTokens(t *testing.T) {
+	client := newMockClient(t, newMockProvider("mock-model"))
+	ctx := context.Background()
+
+	textOnly := &types.CompletionRequest{
+		Model:    "mock-model",
+		Messages: []*types.Message{types.NewTextMessage(types.RoleUser, "What is the weather in this photo?")},
+	}
+	textTokens, err := client.EstimateRequestTokens(ctx, textOnly)
+	if err != nil {
+		t.Fatalf("EstimateRequestTokens failed: %v", err)
+	}
+	messageTokens, _ := client.EstimateTokens(ctx, textOnly.Messages, "mock-model")
+	if textTokens != messageTokens {
+		t.Errorf("Expected a text-only request to match the message estimate %d, got %d", messageTokens, textTokens)
+	}
+
+	full := &types.CompletionRequest{
+		Model: "mock-model",
+		Messages: []*types.Message{
+			types.NewTextMessage(types.RoleUser, "What is the weather in this photo?"),
+			types.NewContentMessage(types.RoleUser, []types.MessageContent{
+				types.ImageContent{URL: "https://example.com/photo.jpg", Detail: "low"},
+			}),
+		},
+		Tools: []types.Tool{{
+			Type: "function",
+			Function: &types.ToolFunction{
+				Name:        "get_weather",
+				Description: "Get the current weather for a location",
+				Parameters: map[string]interface{}{
+					"type":       "object",
+					"properties": map[string]interface{}{"location": map[string]interface{}{"type": "string"}},
+				},
+			},
+		}},
+	}
+	fullTokens, err := client.EstimateRequestTokens(ctx, full)
+	if err != nil {
+		t.Fatalf("EstimateRequestTokens failed: %v", err)
+	}
+
+	if fullTokens <= textTokens+imageTokensLow {
+		t.Errorf("Expected tool schema and image to add to the estimate, got %d (text-only %d)", fullTokens, textTokens)
+	}
+}
+
+func TestClient_EstimateTokensWithOptions(t *testing.T) {
+	client := newMockClient(t, newMockProvider("mock-model"))
+	ctx := context.Background()
+
+	// 36 characters: 9 text tokens
+	messages := []*types.Message{
+		types.NewTextMessage(types.RoleUser, "What is the weather in these photos?"),
+		types.NewContentMessage(types.RoleUser, []types.MessageContent{
+			types.ImageContent{URL: "https://example.com/a.jpg", Detail: "low"},
+			types.ImageContent{URL: "https://example.com/b.jpg"},
+		}),
+	}
+
+	tests := []struct {
+		name     string
+		opts     EstimationOptions
+		expected int
+	}{
+		{"text only", EstimationOptions{}, 9},
+		{"images by detail", EstimationOptions{IncludeImages: true}, 9 + imageTokensLow + imageTokensHigh},
+		{"fixed image cost", EstimationOptions{IncludeImages: true, PerImageTokens: 258}, 9 + 2*258},
+		{"fixed cost without images", EstimationOptions{PerImageTokens: 258}, 9},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens, err := client.EstimateTokensWithOptions(ctx, messages, "mock-model", tt.opts)
+			if err != nil {
+				t.Fatalf("EstimateTokensWithOptions failed: %v", err)
+			}
+			if tokens != tt.expected {
+				t.Errorf("Expected %d tokens, got %d", tt.expected, tokens)
+			}
+		})
+	}
+}
+
+func TestClient_StreamCallbackPanic(t *testing.T) {
+	provider := newMockProvider("mock-model")
+	provider.stream = func(ctx context.Context, req *types.CompletionRequest, callback types.StreamCallback) error {
+		// Produce chunks from a goroutine, stopping once the consumer returns
+		chunks := make(chan *types.StreamResponse)
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			defer close(chunks)
+			for _, text := range []string{"Hello", " world"} {
+				select {
+				case chunks <- &types.StreamResponse{Delta: types.NewTextMessage(types.RoleAssistant, text)}:
+				case <-done:
+					return
+				}
+			}
+		}()
+
+		for chunk := range chunks {
+			if err := callback(ctx, chunk); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	client := newMockClient(t, provider)
+
+	before := runtime.NumGoroutine()
+	err := client.Stream(context.Background(), &types.CompletionRequest{
+		Model:    "mock-model",
+		Messages: []*types.Message{types.NewTextMessage(types.RoleUser, "Hi")},
+	}, func(ctx context.Context, response *types.StreamResponse) error {
+		panic("callback failed")
+	})
+
+	var aiErr *types.Error
+	if !errors.As(err, &aiErr) || aiErr.Code != types.ErrCodeCallbackPanic {
+		t.Fatalf("Expected a callback panic error, got %v", err)
+	}
+	if !strings.Contains(aiErr.Message, "callback failed") {
+		t.Errorf("Expected the panic value in the error message, got '%s'", aiErr.Message)
+	}
+
+	// The producer goroutine exits once the stream returns
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("Expected no leaked goroutines, had %d before and %d after", before, after)
+	}
+}
+
+func TestClient_ProviderForModel(t *testing.T) {
+	client := NewClient(&ClientConfig{
+		ModelAliases: map[string]string{"fast": "gemini-2.5-flash"},
+	})
+	for name, models := range map[string][]string{
+		"openai": {"gpt-4o", "gpt-4o-mini"},
+		"google": {"gemini-2.5-flash", "gemini-2.5-pro"},
+	} {
+		provider := &mockProvider{name: name}
+		for _, id := range models {
+			provider.models = append(provider.models, &types.Model{ID: id, Name: id, Provider: name})
+		}
+		if err := client.RegisterProvider(provider); err != nil {
+			t.Fatalf("Failed to register %s provider: %v", name, err)
+		}
+	}
+
+	for model, expected := range map[string]string{
+		"gpt-4o":           "openai",
+		"gemini-2.5-flash": "google",
+		"fast":             "google",
+	} {
+		provider, err := client.ProviderForModel(model)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", model, err)
+			continue
+		}
+		if provider != expected {
+			t.Errorf("Expected %s to resolve to %s, got %s", model, expected, provider)
+		}
+	}
+
+	if _, err := client.ProviderForModel("unknown-model"); err == nil {
+		t.Error("Expected error for an unknown model without a default provider")
+	}
+
+	// Aliases are also resolved on requests
+	req := &types.CompletionRequest{Model: "fast"}
+	if err := client.applyDefaults(req); err != nil {
+		t.Fatalf("Unexpected error applying defaults: %v", err)
+	}
+	if req.Model != "gemini-2.5-flash" {
+		t.Errorf("Expected alias to resolve to gemini-2.5-flash, got %s", req.Model)
+	}
+}
+
+func TestClient_ProviderCapabilities(t *testing.T) {
+	provider := newMockProvider()
+	provider.models = []*types.Model{
+		{ID: "chat-model", Capabilities: []string{string(types.CapabilityChat), string(types.CapabilityTools)}},
+		{ID: "tts-model", Capabilities: []string{string(types.CapabilityTTS), string(types.CapabilityChat)}},
+	}
+	client := newMockClient(t, provider)
+
+	capabilities, err := client.ProviderCapabilities("mock")
+	if err != nil {
+		t.Fatalf("ProviderCapabilities failed: %v", err)
+	}
+	expected := []types.ModelCapability{types.CapabilityChat, types.CapabilityTools, types.CapabilityTTS}
+	if len(capabilities) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, capabilities)
+	}
+	for i := range expected {
+		if capabilities[i] != expected[i] {
+			t.Errorf("Expected %v, got %v", expected, capabilities)
+		}
+	}
+
+	if _, err := client.ProviderCapabilities("missing"); err == nil {
+		t.Error("Expected error for unregistered provider")
+	}
+}
+
+func TestClient_TotalCost(t *testing.T) {
+	provider := newMockProvider()
+	provider.models = []*types.Model{
+		{ID: "priced-model", Provider: "mock", InputCost: 2.00, OutputCost: 8.00},
+		{ID: "free-model", Provider: "mock"},
+	}
+	provider.complete = func(ctx context.Context, req *types.CompletionRequest) (*types.CompletionResponse, error) {
+		return &types.CompletionResponse{
+			Model:    req.Model,
+			Provider: "mock",
+			Message:  types.NewTextMessage(types.RoleAssistant, "ok"),
+			Usage:    &types.Usage{PromptTokens: 1_000_000, CompletionTokens: 500_000, TotalTokens: 1_500_000},
+		}, nil
+	}
+	client := newMockClient(t, provider)
+
+	for _, model := range []string{"priced-model", "priced-model", "free-model"} {
+		_, err := client.Complete(context.Background(), &types.CompletionRequest{
+			Model:    model,
+			Messages: []*types.Message{types.NewTextMessage(types.RoleUser, "Hello")},
+		})
+		if err != nil {
+			t.Fatalf("Complete failed: %v", err)
+		}
+	}
+
+	// Two priced calls at $2 input + $4 output each
+	if cost := client.TotalCost(); cost != 12.0 {
+		t.Errorf("Expected total cost 12.0, got %v", cost)
+	}
+}
+
+func TestClient_StreamCost(t *testing.T) {
+	provider := newMockProvider()
+	provider.models = []*types.Model{{ID: "priced-model", Provider: "mock", InputCost: 2.00, OutputCost: 8.00}}
+
+	// Like Google, every chunk carries the running usage
+	provider.stream = func(ctx context.Context, req *types.CompletionRequest, callback types.StreamCallback) error {
+		for i := 1; i <= 3; i++ {
+			err := callback(ctx, &types.StreamResponse{
+				Model: req.Model,
+				Delta: types.NewTextMessage(types.RoleAssistant, "ok "),
+				Usage: &types.Usage{PromptTokens: 1_000_000, CompletionTokens: i * 250_000, TotalTokens: 1_000_000 + i*250_000},
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	client := newMockClient(t, provider)
+
+	err := client.Stream(context.Background(), &types.CompletionRequest{
+		Model:    "priced-model",
+		Messages: []*types.Message{types.NewTextMessage(types.RoleUser, "Hello")},
+	}, func(ctx context.Context, chunk *types.StreamResponse) error { return nil })
+	if err != nil {
+		t.Fatalf("Stream failed: %v", err)
+	}
+
+	// Priced once from the final usage: $2 input + $6 output
+	if cost := client.TotalCost(); cost != 8.0 {
+		t.Errorf("Expected total cost 8.0, got %v", cost)
+	}
+}
+
+func TestClient_UnsupportedTools(t *testing.T) {
+	newRequest := func() *types.CompletionRequest {
+		return &types.CompletionRequest{
+			Model:    "tts-model",
+			Messages: []*types.Message{types.NewTextMessage(types.RoleUser, "Hello")},
+			Tools: []types.Tool{{
+				Type:     "function",
+				Function: &types.ToolFunction{Name: "get_weather", Description: "Get the weather"},
+			}},
+			ToolChoice: "auto",
+		}
+	}
+
+	provider := newMockProvider()
+	provider.models = []*types.Model{
+		{ID: "tts-model", Provider: "mock", Capabilities: []string{string(types.CapabilityTTS)}},
+	}
+	client := newMockClient(t, provider)
+
+	_, err := client.Complete(context.Background(), newRequest())
+	var aiErr *types.Error
+	if !errors.As(err, &aiErr) || aiErr.Code != types.ErrCodeInvalidRequest {
+		t.Fatalf("Expected %s error, got %v", types.ErrCodeInvalidRequest, err)
+	}
+	if err := client.Stream(context.Background(), newRequest(), func(ctx context.Context, resp *types.StreamResponse) error {
+		return nil
+	}); !errors.As(err, &aiErr) || aiErr.Code != types.ErrCodeInvalidRequest {
+		t.Errorf("Expected %s error from Stream, got %v", types.ErrCodeInvalidRequest, err)
+	}
+	if len(provider.requests) != 0 {
+		t.Errorf("Expected no requests to reach the provider, got %d", len(provider.requests))
+	}
+
+	client.defaultConfig.StripUnsupportedTools = true
+	if _, err := client.Complete(context.Background(), newRequest()); err != nil {
+		t.Fatalf("Expected tools to be stripped, got %v", err)
+	}
+	if len(provider.requests) != 1 {
+		t.Fatalf("Expected 1 request to reach the provider, got %d", len(provider.requests))
+	}
+	if sent := provider.requests[0]; len(sent.Tools) != 0 || sent.ToolChoice != nil {
+		t.Errorf("Expected tools and tool choice to be stripped, got %+v and %v", sent.Tools, sent.ToolChoice)
+	}
+}
+
+func TestClient_RefreshModels(t *testing.T) {
+	provider := newMockProvider("model-a", "model-b")
+	client := newMockClient(t, provider)
+
+	if len(client.ListModels()) != 2 {
+		t.Fatalf("Expected 2 models after registration, got %d", len(client.ListModels()))
+	}
+
+	provider.models = []*types.Model{
+		{ID: "model-b", Name: "model-b", Provider: "mock", MaxTokens: 16384},
+		{ID: "model-c", Name: "model-c", Provider: "mock", MaxTokens: 8192},
+	}
+	if err := client.RefreshModels(context.Background()); err != nil {
+		t.Fatalf("RefreshModels failed: %v", err)
+	}
+
+	if _, err := client.GetModel("mock", "model-a"); err == nil {
+		t.Errorf("Expected model-a to be removed after refresh")
+	}
+	if _, err := client.GetModel("mock", "model-c"); err != nil {
+		t.Errorf("Expected model-c after refresh, got %v", err)
+	}
+	if model, err := client.GetModel("mock", "model-b"); err != nil || model.MaxTokens != 16384 {
+		t.Errorf("Expected model-b to be updated, got %+v (%v)", model, err)
+	}
+	if len(client.ListModels()) != 2 {
+		t.Errorf("Expected 2 models after refresh, got %d", len(client.ListModels()))
+	}
+}
+
+// failingModelsProvider is a mock provider whose model listing fails
+type failingModelsProvider struct {
+	*mockProvider
+}
+
+func (p *failingModelsProvider) GetModels(ctx context.Context) ([]*types.Model, error) {
+	return nil, types.NewError(types.ErrCodeServerError, "models unavailable", p.name)
+}
+
+func TestClient_RefreshModelsPartialFailure(t *testing.T) {
+	client := NewClient(nil)
+	healthy := newNamedMockProvider("healthy", "healthy-old")
+	failing := newNamedMockProvider("failing", "failing-model")
+	for _, provider := range []types.Provider{healthy, failing} {
+		if err := client.RegisterProvider(provider); err != nil {
+			t.Fatalf("RegisterProvider failed: %v", err)
+		}
+	}
+
+	// Swap in a provider whose listing fails, keeping its registered models
+	client.mu.Lock()
+	client.providers["failing"] = &failingModelsProvider{mockProvider: failing}
+	client.mu.Unlock()
+	healthy.models = []*types.Model{{ID: "healthy-new", Name: "healthy-new", Provider: "healthy"}}
+
+	err := client.RefreshModels(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "models unavailable") {
+		t.Errorf("Expected the failing provider's error, got %v", err)
+	}
+	if _, err := client.GetModel("healthy", "healthy-new"); err != nil {
+		t.Errorf("Expected the healthy provider to be refreshed, got %v", err)
+	}
+	if _, err := client.GetModel("healthy", "healthy-old"); err == nil {
+		t.Error("Expected the healthy provider's old model to be removed")
+	}
+	if _, err := client.GetModel("failing", "failing-model"); err != nil {
+		t.Errorf("Expected the failing provider to keep its models, got %v", err)
+	}
+}
+
+// pricingMockProvider is a mock provider that reports model pricing from its API
+type pricingMockProvider struct {
+	*mockProvider
+	pricing map[string]types.ModelPricing
+}
+
+func (p *pricingMockProvider) GetPricing(ctx context.Context) (map[string]types.ModelPricing, error) {
+	return p.pricing, nil
+}
+
+func TestClient_RegisterProviderPricing(t *testing.T) {
+	mock := newMockProvider("priced-model", "unpriced-model")
+	mock.models[1].InputCost = 1
+	mock.models[1].OutputCost = 2
+	provider := &pricingMockProvider{
+		mockProvider: mock,
+		pricing: map[string]types.ModelPricing{
+			"priced-model": {InputCost: 0.5, OutputCost: 1.5},
+		},
+	}
+
+	client := NewClient(nil)
+	if err := client.RegisterProvider(provider); err != nil {
+		t.Fatalf("RegisterProvider failed: %v", err)
+	}
+
+	priced, err := client.GetModel("mock", "priced-model")
+	if err != nil {
+		t.Fatalf("GetModel failed: %v", err)
+	}
+	if priced.InputCost != 0.5 || priced.OutputCost != 1.5 {
+		t.Errorf("Expected pricing from the provider API, got %v/%v", priced.InputCost, priced.OutputCost)
+	}
+
+	unpriced, err := client.GetModel("mock", "unpriced-model")
+	if err != nil {
+		t.Fatalf("GetModel failed: %v", err)
+	}
+	if unpriced.InputCost != 1 || unpriced.OutputCost != 2 {
+		t.Errorf("Expected models without API pricing to keep their costs, got %v/%v", unpriced.InputCost, unpriced.OutputCost)
+	}
+}
+
+func TestClient_StreamErrorKeepsPartialOutput(t *testing.T) {
+	provider := newMockProvider("mock-model")
+	provider.stream = func(ctx context.Context, req *types.CompletionRequest, callback types.StreamCallback) error {
+		index := 0
+		chunks := []*types.StreamResponse{
+			{Delta: &types.Message{TextData: "Once upon "}},
+			{Delta: &types.Message{TextData: "a time"}},
+			{Delta: &types.Message{ToolCalls: []types.ToolCall{{
+				Index:    &index,
+				ID:       "call_1",
+				Function: types.ToolCallFunction{Name: "lookup", Arguments: `{"q":`},
+			}}}},
+		}
+		for _, chunk := range chunks {
+			if err := callback(ctx, chunk); err != nil {
+				return err
+			}
+		}
+		return types.NewError(types.ErrCodeServerError, "connection reset", "mock")
+	}
+	client := newMockClient(t, provider)
+
+	err := client.Stream(context.Background(), &types.CompletionRequest{
+		Model:    "mock-model",
+		Messages: []*types.Message{types.NewTextMessage(types.RoleUser, "Tell me a story")},
+	}, func(ctx context.Context, response *types.StreamResponse) error {
+		return nil
+	})
+
+	var streamErr *types.StreamError
+	if !errors.As(err, &streamErr) {
+		t.Fatalf("Expected StreamError, got %v", err)
+	}
+	if streamErr.PartialContent != "Once upon a time" {
+		t.Errorf("Expected partial content %q, got %q", "Once upon a time", streamErr.PartialContent)
+	}
+	if len(streamErr.PartialToolCalls) != 1 || streamErr.PartialToolCalls[0].Function.Arguments != `{"q":` {
+		t.Errorf("Expected partial tool call, got %+v", streamErr.PartialToolCalls)
+	}
+
+	var aiErr *types.Error
+	if !errors.As(err, &aiErr) || aiErr.Code != types.ErrCodeServerError {
+		t.Errorf("Expected the provider error to be unwrappable, got %v", err)
+	}
+}
+
+func TestClient_Timing(t *testing.T) {
+	provider := newMockProvider("test-model")
+	provider.complete = func(ctx context.Context, req *types.CompletionRequest) (*types.CompletionResponse, error) {
+		time.Sleep(5 * time.Millisecond)
+		return &types.CompletionResponse{
+			Model:    req.Model,
+			Provider: "mock",
+			Message:  types.NewTextMessage(types.RoleAssistant, "ok"),
+			Timing:   &types.Timing{QueueTime: time.Second},
+		}, nil
+	}
+	provider.stream = func(ctx context.Context, req *types.CompletionRequest, callback types.StreamCallback) error {
+		time.Sleep(5 * time.Millisecond)
+		if err := callback(ctx, &types.StreamResponse{Delta: &types.Message{TextData: "Hello"}}); err != nil {
+			return err
+		}
+		time.Sleep(5 * time.Millisecond)
+		return callback(ctx, &types.StreamResponse{FinishReason: "stop"})
+	}
+	client := newMockClient(t, provider)
+
+	req := &types.CompletionRequest{
+		Model:    "test-model",
+		Messages: []*types.Message{types.NewTextMessage(types.RoleUser, "Hello")},
+	}
+	resp, err := client.Complete(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Complete failed: %v", err)
+	}
+	if resp.Timing == nil {
+		t.Fatal("Expected timing on the response")
+	}
+	if resp.Timing.TotalTime < 5*time.Millisecond {
+		t.Errorf("Expected total time to cover the provider call, got %v", resp.Timing.TotalTime)
+	}
+	if resp.Timing.QueueTime < time.Second {
+		t.Errorf("Expected provider-reported queue time to be kept, got %v", resp.Timing.QueueTime)
+	}
+	if resp.Timing.FirstTokenTime != 0 {
+		t.Errorf("Expected no first token time for a non-streaming call, got %v", resp.Timing.FirstTokenTime)
+	}
+
+	streamed, err := client.StreamComplete(context.Background(), req, nil)
+	if err != nil {
+		t.Fatalf("StreamComplete failed: %v", err)
+	}
+	if streamed.Timing == nil || streamed.Timing.FirstTokenTime < 5*time.Millisecond {
+		t.Fatalf("Expected first token time on the streamed response, got %+v", streamed.Timing)
+	}
+	if streamed.Timing.TotalTime < streamed.Timing.FirstTokenTime+5*time.Millisecond {
+		t.Errorf("Expected total time after the first token, got %+v", streamed.Timing)
+	}
+}
+
+// imageMockProvider is a mock provider that generates images
+type imageMockProvider struct {
+	*mockProvider
+	requests []*types.ImageRequest
+}
+
+func (p *imageMockProvider) GenerateImage(ctx context.Context, req *types.ImageRequest) (*types.ImageResponse, error) {
+	p.requests = append(p.requests, req)
+	return &types.ImageResponse{
+		Model:    req.Model,
+		Provider: p.name,
+		Images:   []types.ImageData{{URL: "https://example.com/image.png"}},
+	}, nil
+}
+
+func TestClient_GenerateImage(t *testing.T) {
+	mock := newMockProvider("image-model", "chat-model")
+	mock.models[0].Capabilities = []string{string(types.CapabilityImage)}
+	mock.models[1].Capabilities = []string{string(types.CapabilityChat)}
+	provider := &imageMockProvider{mockProvider: mock}
+
+	client := NewClient(nil)
+	if err := client.RegisterProvider(provider); err != nil {
+		t.Fatalf("RegisterProvider failed: %v", err)
+	}
+
+	resp, err := client.GenerateImage(context.Background(), &types.ImageRequest{Model: "image-model", Prompt: "A cat"})
+	if err != nil {
+		t.Fatalf("GenerateImage failed: %v", err)
+	}
+	if len(provider.requests) != 1 || len(resp.Images) != 1 || resp.Images[0].URL != "https://example.com/image.png" {
+		t.Errorf("Expected the request routed to the provider, got %+v", resp)
+	}
+
+	// Models without the image capability are rejected before reaching the provider
+	_, err = client.GenerateImage(context.Background(), &types.ImageRequest{Model: "chat-model", Prompt: "A cat"})
+	var aiErr *types.Error
+	if !errors.As(err, &aiErr) || aiErr.Code != types.ErrCodeInvalidRequest || !strings.Contains(aiErr.Message, "image generation") {
+		t.Errorf("Expected image capability error, got %v", err)
+	}
+	if len(provider.requests) != 1 {
+		t.Errorf("Expected the chat model request not to reach the provider")
+	}
+
+	// Models unknown to the registry are rejected, even when a default provider would take them
+	fallback := NewClient(&ClientConfig{DefaultProvider: "mock"})
+	if err := fallback.RegisterProvider(provider); err != nil {
+		t.Fatalf("RegisterProvider failed: %v", err)
+	}
+	_, err = fallback.GenerateImage(context.Background(), &types.ImageRequest{Model: "unknown-model", Prompt: "A cat"})
+	if !errors.As(err, &aiErr) || aiErr.Code != types.ErrCodeInvalidRequest {
+		t.Errorf("Expected image capability error for an unknown model, got %v", err)
+	}
+	if len(provider.requests) != 1 {
+		t.Errorf("Expected the unknown model request not to reach the provider")
+	}
+
+	// Providers without image support are reported
+	plain := NewClient(nil)
+	imageOnly := newMockProvider("image-model")
+	imageOnly.models[0].Capabilities = []string{string(types.CapabilityImage)}
+	if err := plain.RegisterProvider(imageOnly); err != nil {
+		t.Fatalf("RegisterProvider failed: %v", err)
+	}
+	if _, err := plain.GenerateImage(context.Background(), &types.ImageRequest{Model: "image-model", Prompt: "A cat"}); err == nil {
+		t.Error("Expected an error for a provider without image generation")
+	}
+}
+
+// ttsMockProvider is a mock provider that synthesizes speech
+type ttsMockProvider struct {
+	*mockProvider
+}
+
+func (p *ttsMockProvider) Synthesize(ctx context.Context, req *types.TTSRequest) (*types.TTSResponse, error) {
+	return &types.TTSResponse{Audio: []byte(req.Text), MimeType: "audio/L16"}, nil
+}
+
+func TestClient_Synthesize(t *testing.T) {
+	mock := newMockProvider("tts-model", "chat-model")
+	mock.models[0].Capabilities = []string{string(types.CapabilityTTS)}
+	mock.models[1].Capabilities = []string{string(types.CapabilityChat)}
+
+	client := NewClient(nil)
+	if err := client.RegisterProvider(&ttsMockProvider{mockProvider: mock}); err != nil {
+		t.Fatalf("RegisterProvider failed: %v", err)
+	}
+
+	resp, err := client.Synthesize(context.Background(), &types.TTSRequest{Model: "tts-model", Text: "Hello"})
+	if err != nil {
+		t.Fatalf("Synthesize failed: %v", err)
+	}
+	if string(resp.Audio) != "Hello" {
+		t.Errorf("Expected audio from the provider, got %q", resp.Audio)
+	}
+
+	_, err = client.Synthesize(context.Background(), &types.TTSRequest{Model: "chat-model", Text: "Hello"})
+	var aiErr *types.Error
+	if !errors.As(err, &aiErr) || aiErr.Code != types.ErrCodeInvalidRequest || !strings.Contains(aiErr.Message, "text-to-speech") {
+		t.Errorf("Expected TTS capability error, got %v", err)
+	}
+}
+
+func TestClient_SelectModel(t *testing.T) {
+	vision, tools, chat := string(types.CapabilityVision), string(types.CapabilityTools), string(types.CapabilityChat)
+	provider := newMockProvider()
+	provider.models = []*types.Model{
+		{ID: "premium", Provider: "mock", InputCost: 5.00, Capabilities: []string{chat, vision, tools}},
+		{ID: "budget", Provider: "mock", InputCost: 0.50, Capabilities: []string{chat, vision, tools}},
+		{ID: "text-only", Provider: "mock", InputCost: 0.10, Capabilities: []string{chat, tools}},
+		{ID: "unpriced", Provider: "mock", Capabilities: []string{chat, vision, tools}},
+	}
+	client := newMockClient(t, provider)
+
+	model, err := client.SelectModel(types.CapabilityVision, types.CapabilityTools)
+	if err != nil {
+		t.Fatalf("SelectModel failed: %v", err)
+	}
+	if model.ID != "budget" {
+		t.Errorf("Expected the cheapest matching model 'budget', got '%s'", model.ID)
+	}
+
+	var aiErr *types.Error
+	if _, err := client.SelectModel(types.CapabilityTTS); !errors.As(err, &aiErr) || aiErr.Code != types.ErrCodeModelNotFound {
+		t.Errorf("Expected %s error, got %v", types.ErrCodeModelNotFound, err)
+	}
+
+	// Complete picks a model when only capabilities are given
+	if _, err := client.Complete(context.Background(), &types.CompletionRequest{
+		RequiredCapabilities: []types.ModelCapability{types.CapabilityTools},
+		Messages:             []*types.Message{types.NewTextMessage(types.RoleUser, "Hello")},
+	}); err != nil {
+		t.Fatalf("Complete failed: %v", err)
+	}
+	if len(provider.requests) != 1 || provider.requests[0].Model != "text-only" {
+		t.Errorf("Expected the request to use 'text-only', got %+v", provider.requests)
+	}
+}
+
+func TestClient_RequestMetadata(t *testing.T) {
+	provider := newMockProvider("mock-model")
+	provider.complete = func(ctx context.Context, req *types.CompletionRequest) (*types.CompletionResponse, error) {
+		return &types.CompletionResponse{
+			Model:    req.Model,
+			Provider: "mock",
+			Message:  types.NewTextMessage(types.RoleAssistant, "ok"),
+			Metadata: map[string]interface{}{"source": "provider"},
+		}, nil
+	}
+	client := newMockClient(t, provider)
+
+	newRequest := func() *types.CompletionRequest {
+		return &types.CompletionRequest{
+			Model:    "mock-model",
+			Messages: []*types.Message{types.NewTextMessage(types.RoleUser, "Hello")},
+			Metadata: map[string]interface{}{
+				"correlation_id":                 "abc-123",
+				"source":                         "request",
+				types.MetadataKeyRequestID:       "req-1",
+				types.MetadataKeyOpenAIOverrides: map[string]interface{}{"n": 2},
+			},
+		}
+	}
+
+	resp, err := client.Complete(context.Background(), newRequest())
+	if err != nil {
+		t.Fatalf("Complete failed: %v", err)
+	}
+	if resp.Metadata["correlation_id"] != "abc-123" {
+		t.Errorf("Expected the correlation ID to be copied, got %v", resp.Metadata)
+	}
+	if resp.Metadata["source"] != "provider" {
+		t.Errorf("Expected provider-set keys to be kept, got %v", resp.Metadata["source"])
+	}
+	if resp.Metadata[types.MetadataKeyRequestID] != "req-1" {
+		t.Errorf("Expected the request ID 'req-1', got %v", resp.Metadata[types.MetadataKeyRequestID])
+	}
+	if _, exists := resp.Metadata[types.MetadataKeyOpenAIOverrides]; exists {
+		t.Errorf("Expected provider overrides to stay on the request, got %v", resp.Metadata)
+	}
+
+	// Only the final stream chunk carries the metadata
+	provider.stream = func(ctx context.Context, req *types.CompletionRequest, callback types.StreamCallback) error {
+		callback(ctx, &types.StreamResponse{Model: req.Model, Delta: types.NewTextMessage(types.RoleAssistant, "o")})
+		return callback(ctx, &types.StreamResponse{Model: req.Model, Delta: types.NewTextMessage(types.RoleAssistant, "k"), FinishReason: "stop"})
+	}
+	var chunks []*types.StreamResponse
+	if err := client.Stream(context.Background(), newRequest(), func(ctx context.Context, resp *types.StreamResponse) error {
+		chunks = append(chunks, resp)
+		return nil
+	}); err != nil {
+		t.Fatalf("Stream failed: %v", err)
+	}
+	if len(chunks) != 2 || chunks[0].Metadata != nil {
+		t.Fatalf("Expected no metadata before the final chunk, got %+v", chunks)
+	}
+	final := chunks[1].Metadata
+	if final["correlation_id"] != "abc-123" || final[types.MetadataKeyRequestID] != "req-1" {
+		t.Errorf("Expected request metadata on the final chunk, got %v", final)
+	}
+	if _, exists := final[types.MetadataKeyOpenAIOverrides]; exists {
+		t.Errorf("Expected provider overrides to stay on the request, got %v", final)
+	}
+
+	// Assembled stream responses keep the final chunk's metadata
+	resp, err = client.StreamComplete(context.Background(), newRequest(), nil)
+	if err != nil {
+		t.Fatalf("StreamComplete failed: %v", err)
+	}
+	if resp.Metadata["correlation_id"] != "abc-123" || resp.Metadata[types.MetadataKeyRequestID] != "req-1" {
+		t.Errorf("Expected request metadata on the assembled response, got %v", resp.Metadata)
+	}
+
+	// Requests without an ID get a generated one
+	resp, err = client.Complete(context.Background(), &types.CompletionRequest{
+		Model:    "mock-model",
+		Messages: []*types.Message{types.NewTextMessage(types.RoleUser, "Hello")},
+	})
+	if err != nil {
+		t.Fatalf("Complete failed: %v", err)
+	}
+	if id, _ := resp.Metadata[types.MetadataKeyRequestID].(string); id == "" {
+		t.Errorf("Expected a generated request ID, got %v", resp.Metadata)
+	}
+}
+
+func TestClient_AnalyzeBudget(t *testing.T) {
+	client := newMockClient(t, newMockProvider("mock-model"))
+	client.defaultConfig.DefaultMaxTokens = 1000
+
+	// 8000 prompt tokens and 1000 output tokens don't fit an 8192 token window
+	crowded := &types.CompletionRequest{
+		Model:    "mock-model",
+		Messages: []*types.Message{types.NewTextMessage(types.RoleUser, strings.Repeat("word", 8000))},
+	}
+	analysis, err := client.AnalyzeBudget(context.Background(), crowded)
+	if err != nil {
+		t.Fatalf("AnalyzeBudget failed: %v", err)
+	}
+	if analysis.PromptTokens != 8000 || analysis.OutputTokens != 1000 || analysis.ContextWindow != 8192 {
+		t.Errorf("Expected 8000 prompt, 1000 output and 8192 window tokens, got %+v", analysis)
+	}
+	if !analysis.Warning || len(analysis.Reasons) != 1 || !strings.Contains(analysis.Reasons[0], "exceed") {
+		t.Errorf("Expected a warning that the window is exceeded, got %+v", analysis)
+	}
+	if crowded.MaxTokens != 0 {
+		t.Errorf("Expected the request to be left unchanged, got MaxTokens %d", crowded.MaxTokens)
+	}
+
+	// A tiny output limit is flagged even when everything fits
+	analysis, err = client.AnalyzeBudget(context.Background(), &types.CompletionRequest{
+		Model:     "mock-model",
+		MaxTokens: 10,
+		Messages:  []*types.Message{types.NewTextMessage(types.RoleUser, "Write an essay")},
+	})
+	if err != nil {
+		t.Fatalf("AnalyzeBudget failed: %v", err)
+	}
+	if !analysis.Warning || len(analysis.Reasons) != 1 || !strings.Contains(analysis.Reasons[0], "cut the response off") {
+		t.Errorf("Expected a warning for the small output limit, got %+v", analysis)
+	}
+
+	analysis, err = client.AnalyzeBudget(context.Background(), &types.CompletionRequest{
+		Model:    "mock-model",
+		Messages: []*types.Message{types.NewTextMessage(types.RoleUser, "Write an essay")},
+	})
+	if err != nil {
+		t.Fatalf("AnalyzeBudget failed: %v", err)
+	}
+	if analysis.Warning {
+		t.Errorf("Expected no warning for a balanced budget, got %+v", analysis.Reasons)
+	}
+}
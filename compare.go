@@ -0,0 +1,126 @@
+package aiutil
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/ztkent/ai-util/types"
+)
+
+// ModelResult holds the outcome of running a request against a single model
+type ModelResult struct {
+	Model    string                    `json:"model"`
+	Provider string                    `json:"provider,omitempty"`
+	Content  string                    `json:"content,omitempty"`
+	Usage    *types.Usage              `json:"usage,omitempty"`
+	Latency  time.Duration             `json:"latency"`
+	Response *types.CompletionResponse `json:"-"`
+	Error    error                     `json:"-"`
+}
+
+// CompareModels runs the same request against each model concurrently.
+// Results are returned in the order of models; per-model failures are reported in
+// ModelResult.Error rather than failing the whole comparison.
+func (c *Client) CompareModels(ctx context.Context, req *types.CompletionRequest, models []string) ([]ModelResult, error) {
+	if len(models) == 0 {
+		return nil, types.NewError(types.ErrCodeInvalidRequest, "at least one model is required", "")
+	}
+
+	results := make([]ModelResult, len(models))
+	fanned := fanOut(ctx, req, models, func(ctx context.Context, req *types.CompletionRequest, model string) (*types.CompletionResponse, error) {
+		req.Model = model
+		return c.Complete(ctx, req)
+	})
+	for range models {
+		r := <-fanned
+		results[r.index] = r.result
+	}
+	return results, nil
+}
+
+// CompleteAll sends the same request to several providers concurrently. Each target is a
+// provider name, which uses the request's model, or "provider/model" to pick the model too
+// (e.g. "openai/gpt-4o-mini" or "replicate/meta/meta-llama-3-8b-instruct").
+// Results are keyed by target; per-target failures are reported in ModelResult.Error.
+func (c *Client) CompleteAll(ctx context.Context, req *types.CompletionRequest, providers []string) map[string]ModelResult {
+	results := make(map[string]ModelResult, len(providers))
+	fanned := fanOut(ctx, req, providers, c.completeTarget)
+	for range providers {
+		r := <-fanned
+		results[providers[r.index]] = r.result
+	}
+	return results
+}
+
+// CompleteRace sends the same request to several providers concurrently and returns the first
+// successful response, cancelling the others. Targets are given as for CompleteAll. If every
+// target fails, the errors are returned joined.
+func (c *Client) CompleteRace(ctx context.Context, req *types.CompletionRequest, providers []string) (*types.CompletionResponse, error) {
+	if len(providers) == 0 {
+		return nil, types.NewError(types.ErrCodeInvalidRequest, "at least one provider is required", "")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	fanned := fanOut(ctx, req, providers, c.completeTarget)
+	var errs []error
+	for range providers {
+		r := <-fanned
+		if r.result.Error == nil {
+			return r.result.Response, nil
+		}
+		errs = append(errs, r.result.Error)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// fanOutResult is the result for the target at index in a fanOut call
+type fanOutResult struct {
+	index  int
+	result ModelResult
+}
+
+// fanOut runs a copy of the request against each target concurrently, with complete choosing
+// the provider or model for a target. Results are sent as they finish; the channel is
+// buffered, so callers may stop reading early.
+func fanOut(ctx context.Context, req *types.CompletionRequest, targets []string,
+	complete func(ctx context.Context, req *types.CompletionRequest, target string) (*types.CompletionResponse, error)) <-chan fanOutResult {
+	results := make(chan fanOutResult, len(targets))
+	for i, target := range targets {
+		go func(i int, target string) {
+			// Each target gets its own copy of the request, since Complete applies defaults in place
+			targetReq := *req
+			targetReq.Messages = append([]*types.Message(nil), req.Messages...)
+
+			start := time.Now()
+			resp, err := complete(ctx, &targetReq, target)
+			result := ModelResult{
+				Model:    targetReq.Model,
+				Latency:  time.Since(start),
+				Response: resp,
+				Error:    err,
+			}
+			if resp != nil {
+				result.Provider = resp.Provider
+				result.Usage = resp.Usage
+				if resp.Message != nil {
+					result.Content = resp.Message.GetText()
+				}
+			}
+			results <- fanOutResult{index: i, result: result}
+		}(i, target)
+	}
+	return results
+}
+
+// completeTarget runs the request against a "provider" or "provider/model" target
+func (c *Client) completeTarget(ctx context.Context, req *types.CompletionRequest, target string) (*types.CompletionResponse, error) {
+	provider, model, found := strings.Cut(target, "/")
+	if found {
+		req.Model = model
+	}
+	return c.complete(ctx, req, provider)
+}
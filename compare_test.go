@@ -0,0 +1,140 @@
+package aiutil
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ztkent/ai-util/types"
+)
+
+func TestClient_CompareModels(t *testing.T) {
+	provider := newMockProvider("model-a", "model-b")
+	provider.complete = func(ctx context.Context, req *types.CompletionRequest) (*types.CompletionResponse, error) {
+		return &types.CompletionResponse{
+			Model:    req.Model,
+			Provider: "mock",
+			Message:  types.NewTextMessage(types.RoleAssistant, "answer from "+req.Model),
+			Usage:    &types.Usage{TotalTokens: len(req.Model)},
+		}, nil
+	}
+	client := newMockClient(t, provider)
+
+	req := &types.CompletionRequest{
+		Messages: []*types.Message{types.NewTextMessage(types.RoleUser, "What is 2+2?")},
+	}
+
+	results, err := client.CompareModels(context.Background(), req, []string{"model-a", "model-b"})
+	if err != nil {
+		t.Fatalf("CompareModels failed: %v", err)
+	}
+
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 results, got %d", len(results))
+	}
+	for i, model := range []string{"model-a", "model-b"} {
+		result := results[i]
+		if result.Error != nil {
+			t.Errorf("%s: unexpected error: %v", model, result.Error)
+		}
+		if result.Model != model {
+			t.Errorf("Expected result %d to be for %s, got %s", i, model, result.Model)
+		}
+		if result.Content != "answer from "+model {
+			t.Errorf("%s: unexpected content %q", model, result.Content)
+		}
+		if result.Usage == nil || result.Provider != "mock" {
+			t.Errorf("%s: expected usage and provider to be populated", model)
+		}
+	}
+
+	if req.Model != "" {
+		t.Errorf("Expected caller's request to be left unmodified, got model %q", req.Model)
+	}
+}
+
+// newNamedMockProvider returns a mock provider registered under name that answers with its name
+func newNamedMockProvider(name string, models ...string) *mockProvider {
+	provider := newMockProvider()
+	provider.name = name
+	for _, id := range models {
+		provider.models = append(provider.models, &types.Model{ID: id, Name: id, Provider: name, MaxTokens: 8192})
+	}
+	provider.complete = func(ctx context.Context, req *types.CompletionRequest) (*types.CompletionResponse, error) {
+		return &types.CompletionResponse{
+			Model:    req.Model,
+			Provider: name,
+			Message:  types.NewTextMessage(types.RoleAssistant, name+" answered with "+req.Model),
+		}, nil
+	}
+	return provider
+}
+
+func TestClient_CompleteAll(t *testing.T) {
+	client := NewClient(nil)
+	fast := newNamedMockProvider("fast", "shared-model", "fast-model")
+	failing := newNamedMockProvider("failing", "failing-model")
+	failing.complete = func(ctx context.Context, req *types.CompletionRequest) (*types.CompletionResponse, error) {
+		return nil, types.NewError(types.ErrCodeServerError, "unavailable", "failing")
+	}
+	for _, provider := range []*mockProvider{fast, failing, newNamedMockProvider("slow", "shared-model")} {
+		if err := client.RegisterProvider(provider); err != nil {
+			t.Fatalf("RegisterProvider failed: %v", err)
+		}
+	}
+
+	req := &types.CompletionRequest{
+		Model:    "shared-model",
+		Messages: []*types.Message{types.NewTextMessage(types.RoleUser, "What is 2+2?")},
+	}
+	results := client.CompleteAll(context.Background(), req, []string{"fast/fast-model", "slow", "failing"})
+
+	if len(results) != 3 {
+		t.Fatalf("Expected a result for each of 3 targets, got %d", len(results))
+	}
+	if r := results["fast/fast-model"]; r.Error != nil || r.Model != "fast-model" || r.Content != "fast answered with fast-model" {
+		t.Errorf("Expected the fast provider to use its named model, got %+v", r)
+	}
+	if r := results["slow"]; r.Error != nil || r.Provider != "slow" || r.Content != "slow answered with shared-model" {
+		t.Errorf("Expected the slow provider to use the request model, got %+v", r)
+	}
+	if r := results["failing"]; r.Error == nil || r.Response != nil {
+		t.Errorf("Expected an error for the failing provider, got %+v", r)
+	}
+	if req.Model != "shared-model" {
+		t.Errorf("Expected caller's request to be left unmodified, got model %q", req.Model)
+	}
+}
+
+func TestClient_CompleteRace(t *testing.T) {
+	client := NewClient(nil)
+	slow := newNamedMockProvider("slow", "shared-model")
+	cancelled := make(chan struct{})
+	slow.complete = func(ctx context.Context, req *types.CompletionRequest) (*types.CompletionResponse, error) {
+		<-ctx.Done()
+		close(cancelled)
+		return nil, ctx.Err()
+	}
+	for _, provider := range []*mockProvider{slow, newNamedMockProvider("fast", "shared-model")} {
+		if err := client.RegisterProvider(provider); err != nil {
+			t.Fatalf("RegisterProvider failed: %v", err)
+		}
+	}
+
+	resp, err := client.CompleteRace(context.Background(), &types.CompletionRequest{
+		Model:    "shared-model",
+		Messages: []*types.Message{types.NewTextMessage(types.RoleUser, "What is 2+2?")},
+	}, []string{"slow", "fast"})
+	if err != nil {
+		t.Fatalf("CompleteRace failed: %v", err)
+	}
+	if resp.Provider != "fast" {
+		t.Errorf("Expected the fast provider to win, got %s", resp.Provider)
+	}
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Error("Expected the slow request to be cancelled")
+	}
+}
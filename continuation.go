@@ -0,0 +1,64 @@
+package aiutil
+
+import (
+	"context"
+
+	"github.com/ztkent/ai-util/types"
+)
+
+// continuationPrompt asks the model to resume output that was cut off by the token limit
+const continuationPrompt = "Continue exactly where you left off, without repeating any previous text."
+
+// CompleteFull performs a completion request and, while the response stops for length,
+// re-requests with the partial output so far, concatenating the continuations. At most
+// maxContinuations follow-up requests are made. Usage is summed across all requests.
+func (c *Client) CompleteFull(ctx context.Context, req *types.CompletionRequest, maxContinuations int) (*types.CompletionResponse, error) {
+	resp, err := c.complete(ctx, req, "")
+	if err != nil {
+		return nil, err
+	}
+
+	text := responseText(resp)
+	usage := &types.Usage{}
+	addUsage(usage, resp.Usage)
+
+	for i := 0; i < maxContinuations && isLengthFinishReason(resp.FinishReason); i++ {
+		continuation := *req
+		continuation.Messages = append(append([]*types.Message(nil), req.Messages...),
+			types.NewTextMessage(types.RoleAssistant, text),
+			types.NewTextMessage(types.RoleUser, continuationPrompt),
+		)
+
+		resp, err = c.complete(ctx, &continuation, "")
+		if err != nil {
+			return nil, err
+		}
+		text += responseText(resp)
+		addUsage(usage, resp.Usage)
+	}
+
+	full := *resp
+	full.Message = types.NewTextMessage(types.RoleAssistant, text)
+	full.Usage = usage
+	return &full, nil
+}
+
+// responseText returns the text of a response message, if any
+func responseText(resp *types.CompletionResponse) string {
+	if resp.Message == nil {
+		return ""
+	}
+	return resp.Message.GetText()
+}
+
+// addUsage adds usage into total
+func addUsage(total *types.Usage, usage *types.Usage) {
+	if usage == nil {
+		return
+	}
+	total.PromptTokens += usage.PromptTokens
+	total.CompletionTokens += usage.CompletionTokens
+	total.TotalTokens += usage.TotalTokens
+	total.AcceptedPredictionTokens += usage.AcceptedPredictionTokens
+	total.RejectedPredictionTokens += usage.RejectedPredictionTokens
+}
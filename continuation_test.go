@@ -0,0 +1,84 @@
+package aiutil
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ztkent/ai-util/types"
+)
+
+func TestClient_CompleteFull(t *testing.T) {
+	provider := newMockProvider("mock-model")
+	outputs := []struct {
+		text         string
+		finishReason string
+	}{
+		{"The quick brown fox ", "length"},
+		{"jumps over ", "length"},
+		{"the lazy dog.", "stop"},
+	}
+	provider.complete = func(ctx context.Context, req *types.CompletionRequest) (*types.CompletionResponse, error) {
+		output := outputs[len(provider.requests)-1]
+		return &types.CompletionResponse{
+			Model:        req.Model,
+			Provider:     "mock",
+			Message:      types.NewTextMessage(types.RoleAssistant, output.text),
+			FinishReason: output.finishReason,
+			Usage:        &types.Usage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15},
+		}, nil
+	}
+	client := newMockClient(t, provider)
+
+	resp, err := client.CompleteFull(context.Background(), &types.CompletionRequest{
+		Model:    "mock-model",
+		Messages: []*types.Message{types.NewTextMessage(types.RoleUser, "Tell me about the fox")},
+	}, 3)
+	if err != nil {
+		t.Fatalf("CompleteFull failed: %v", err)
+	}
+
+	if len(provider.requests) != 3 {
+		t.Fatalf("Expected 3 requests, got %d", len(provider.requests))
+	}
+	if resp.Message.GetText() != "The quick brown fox jumps over the lazy dog." {
+		t.Errorf("Expected concatenated output, got %q", resp.Message.GetText())
+	}
+	if resp.FinishReason != "stop" {
+		t.Errorf("Expected final finish reason 'stop', got '%s'", resp.FinishReason)
+	}
+	if resp.Usage.PromptTokens != 30 || resp.Usage.CompletionTokens != 15 || resp.Usage.TotalTokens != 45 {
+		t.Errorf("Expected summed usage, got %+v", resp.Usage)
+	}
+
+	// Continuations carry the partial output so far
+	last := provider.requests[2].Messages
+	if len(last) != 3 || last[1].Role != types.RoleAssistant || last[1].GetText() != "The quick brown fox jumps over " {
+		t.Errorf("Expected the partial output in the final request, got %d messages", len(last))
+	}
+}
+
+func TestClient_CompleteFull_ContinuationCap(t *testing.T) {
+	provider := newMockProvider("mock-model")
+	provider.complete = func(ctx context.Context, req *types.CompletionRequest) (*types.CompletionResponse, error) {
+		return &types.CompletionResponse{
+			Message:      types.NewTextMessage(types.RoleAssistant, "more "),
+			FinishReason: "length",
+		}, nil
+	}
+	client := newMockClient(t, provider)
+
+	resp, err := client.CompleteFull(context.Background(), &types.CompletionRequest{
+		Model:    "mock-model",
+		Messages: []*types.Message{types.NewTextMessage(types.RoleUser, "Go on forever")},
+	}, 2)
+	if err != nil {
+		t.Fatalf("CompleteFull failed: %v", err)
+	}
+
+	if len(provider.requests) != 3 {
+		t.Errorf("Expected the initial request plus 2 continuations, got %d", len(provider.requests))
+	}
+	if resp.Message.GetText() != "more more more " || resp.FinishReason != "length" {
+		t.Errorf("Expected capped output with length finish reason, got %q (%s)", resp.Message.GetText(), resp.FinishReason)
+	}
+}
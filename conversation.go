@@ -1,8 +1,14 @@
 package aiutil
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
@@ -12,28 +18,114 @@ import (
 
 // Conversation represents a conversation with message history and management
 type Conversation struct {
-	ID              string                 `json:"id"`
-	Messages        []*types.Message       `json:"messages"`
-	MaxTokens       int                    `json:"max_tokens"`
-	CurrentTokens   int                    `json:"current_tokens"`
-	CreatedAt       time.Time              `json:"created_at"`
-	UpdatedAt       time.Time              `json:"updated_at"`
-	Metadata        map[string]interface{} `json:"metadata,omitempty"`
-	client          *Client
-	estimatedTokens int
-	mu              sync.RWMutex
+	ID                string                 `json:"id"`
+	Messages          []*types.Message       `json:"messages"`
+	MaxTokens         int                    `json:"max_tokens"`
+	CurrentTokens     int                    `json:"current_tokens"`
+	CreatedAt         time.Time              `json:"created_at"`
+	UpdatedAt         time.Time              `json:"updated_at"`
+	Metadata          map[string]interface{} `json:"metadata,omitempty"`
+	MaxMessages       int                    `json:"max_messages,omitempty"`       // Oldest non-system messages are dropped beyond this count
+	ReferenceTTL      time.Duration          `json:"reference_ttl,omitempty"`      // Reference messages older than this are pruned on send
+	DefaultSeed       *int                   `json:"default_seed,omitempty"`       // Seed applied to every turn unless overridden
+	StopDelimiters    []string               `json:"stop_delimiters,omitempty"`    // Stop sequences applied to every turn
+	ExportFormat      ExportFormat           `json:"-"`                            // Key casing used by Export and MarshalJSON
+	IncrementalSend   bool                   `json:"incremental_send,omitempty"`   // Send only new messages to providers that keep server-side state
+	TruncateStrategy  TruncateStrategy       `json:"truncate_strategy,omitempty"`  // How TruncateToFit makes room (default drop)
+	SummarizeMessages int                    `json:"summarize_messages,omitempty"` // Oldest messages condensed per summary
+	client            *Client
+	serverState       *serverState
+	estimatedTokens   int
+	systemSections    []string
+	clock             func() time.Time
+	activeSends       int               // Sends in progress, for CloneWithActiveGuard
+	sendsDone         chan struct{}     // Closed when activeSends drops to zero
+	snapshots         []historySnapshot // Restore points from Snapshot, oldest first
+	lastSnapshotID    SnapshotID
+	mu                sync.RWMutex
 }
 
 // ConversationConfig holds configuration for creating a conversation
 type ConversationConfig struct {
-	SystemPrompt   string                 `json:"system_prompt,omitempty"`
-	MaxTokens      int                    `json:"max_tokens,omitempty"`
-	Model          string                 `json:"model,omitempty"`
-	Metadata       map[string]interface{} `json:"metadata,omitempty"`
-	AutoTruncate   bool                   `json:"auto_truncate,omitempty"`
-	PreserveSystem bool                   `json:"preserve_system,omitempty"` // Keep system message when truncating
+	SystemPrompt         string                 `json:"system_prompt,omitempty"`
+	SystemPromptSections []string               `json:"system_prompt_sections,omitempty"` // Joined after SystemPrompt into one system message
+	MaxTokens            int                    `json:"max_tokens,omitempty"`
+	Model                string                 `json:"model,omitempty"`
+	Metadata             map[string]interface{} `json:"metadata,omitempty"`
+	AutoTruncate         bool                   `json:"auto_truncate,omitempty"`
+	PreserveSystem       bool                   `json:"preserve_system,omitempty"`    // Keep system message when truncating
+	MaxMessages          int                    `json:"max_messages,omitempty"`       // Cap on message count (0 disables)
+	ReferenceTTL         time.Duration          `json:"reference_ttl,omitempty"`      // Prune reference messages older than this (0 disables)
+	DefaultSeed          *int                   `json:"default_seed,omitempty"`       // Seed applied to every turn unless overridden
+	StopDelimiters       []string               `json:"stop_delimiters,omitempty"`    // Stop sequences applied to every turn, before per-call stops
+	ExportFormat         ExportFormat           `json:"export_format,omitempty"`      // Key casing for Export and MarshalJSON (default snake_case)
+	IncrementalSend      bool                   `json:"incremental_send,omitempty"`   // Send only new messages to providers that keep server-side state
+	TruncateStrategy     TruncateStrategy       `json:"truncate_strategy,omitempty"`  // How TruncateToFit makes room (default drop)
+	SummarizeMessages    int                    `json:"summarize_messages,omitempty"` // Oldest messages condensed per summary (default 4)
+}
+
+// serverState records the provider response that holds the conversation server-side, and
+// the messages it covers
+type serverState struct {
+	responseID string
+	messages   []*types.Message
+}
+
+// ExportFormat controls the casing of JSON keys when a conversation is exported
+type ExportFormat string
+
+const (
+	ExportSnakeCase ExportFormat = "snake_case"
+	ExportCamelCase ExportFormat = "camelCase"
+)
+
+// TruncateStrategy controls how TruncateToFit makes room in the conversation
+type TruncateStrategy string
+
+const (
+	// TruncateDrop removes the oldest messages
+	TruncateDrop TruncateStrategy = "drop"
+	// TruncateSummarize replaces the oldest messages with a model-generated summary, and
+	// drops messages instead if the summary request fails
+	TruncateSummarize TruncateStrategy = "summarize"
+)
+
+// defaultSummarizeMessages is the number of oldest messages condensed per summary
+const defaultSummarizeMessages = 4
+
+// SendOption configures the completion request for a single conversation turn
+type SendOption func(*types.CompletionRequest)
+
+// WithSeed sets the sampling seed for a turn
+func WithSeed(seed int) SendOption {
+	return func(req *types.CompletionRequest) {
+		req.Seed = &seed
+	}
 }
 
+// WithStop adds stop sequences for a turn, after any conversation StopDelimiters
+func WithStop(stop ...string) SendOption {
+	return func(req *types.CompletionRequest) {
+		for _, sequence := range stop {
+			if !slices.Contains(req.Stop, sequence) {
+				req.Stop = append(req.Stop, sequence)
+			}
+		}
+	}
+}
+
+// Message metadata used to track conversation-managed message types
+const (
+	MessageTypeKey          = "message_type"
+	MessageTypeReference    = "reference"
+	MessageTypeSystemPrompt = "system_prompt"
+	MessageTypeSummary      = "summary" // System note condensing earlier messages
+	MessagePinnedKey        = "pinned"  // Pinned messages are never removed by truncation
+)
+
+// systemSectionSeparator separates the sections of a multi-part system prompt
+const systemSectionSeparator = "\n\n---\n\n"
+
 // NewConversation creates a new conversation with optional system prompt
 func (c *Client) NewConversation(config *ConversationConfig) *Conversation {
 	if config == nil {
@@ -47,20 +139,40 @@ func (c *Client) NewConversation(config *ConversationConfig) *Conversation {
 	if config.MaxTokens <= 0 {
 		config.MaxTokens = 4096
 	}
+	if config.SummarizeMessages <= 0 {
+		config.SummarizeMessages = defaultSummarizeMessages
+	}
 
 	conv := &Conversation{
-		ID:        uuid.New().String(),
-		Messages:  make([]*types.Message, 0),
-		MaxTokens: config.MaxTokens,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
-		Metadata:  config.Metadata,
-		client:    c,
+		ID:                uuid.New().String(),
+		Messages:          make([]*types.Message, 0),
+		MaxTokens:         config.MaxTokens,
+		CreatedAt:         time.Now(),
+		UpdatedAt:         time.Now(),
+		Metadata:          config.Metadata,
+		MaxMessages:       config.MaxMessages,
+		ReferenceTTL:      config.ReferenceTTL,
+		DefaultSeed:       config.DefaultSeed,
+		StopDelimiters:    config.StopDelimiters,
+		ExportFormat:      config.ExportFormat,
+		IncrementalSend:   config.IncrementalSend,
+		TruncateStrategy:  config.TruncateStrategy,
+		SummarizeMessages: config.SummarizeMessages,
+		client:            c,
 	}
 
 	// Add system message if provided
 	if config.SystemPrompt != "" {
-		systemMsg := types.NewTextMessage(types.RoleSystem, config.SystemPrompt)
+		conv.systemSections = append(conv.systemSections, config.SystemPrompt)
+	}
+	for _, section := range config.SystemPromptSections {
+		if section != "" {
+			conv.systemSections = append(conv.systemSections, section)
+		}
+	}
+	if len(conv.systemSections) > 0 {
+		systemMsg := types.NewTextMessage(types.RoleSystem, strings.Join(conv.systemSections, systemSectionSeparator))
+		systemMsg.Metadata = map[string]interface{}{MessageTypeKey: MessageTypeSystemPrompt}
 		conv.AddMessage(systemMsg)
 	}
 
@@ -73,29 +185,126 @@ func (c *Conversation) AddMessage(message *types.Message) error {
 	defer c.mu.Unlock()
 
 	if message.Timestamp.IsZero() {
-		message.Timestamp = time.Now()
+		message.Timestamp = c.now()
+	}
+	if message.ID == "" {
+		message.ID = uuid.New().String()
 	}
 
 	c.Messages = append(c.Messages, message)
-	c.UpdatedAt = time.Now()
+	c.UpdatedAt = c.now()
 
-	// Update token count estimation
+	// Drop the oldest messages once the message cap is exceeded, never the message just added
+	// or the tool call it answers, since removing the call also removes its results
+	dropped := false
+	if c.MaxMessages > 0 {
+		for len(c.Messages) > c.MaxMessages {
+			if oldest := c.oldestNonSystemMessage(); oldest == message || hasToolCallFor(oldest, message) {
+				break
+			}
+			if err := c.removeOldestNonSystemMessage(true); err != nil {
+				break
+			}
+			dropped = true
+		}
+	}
+
+	// Update token count estimation, recounting the whole history once messages were dropped
 	if c.client != nil {
-		// Use a default model for estimation if none specified
-		model := c.client.defaultConfig.DefaultModel
-		if model == "" {
-			model = "gpt-4o-mini" // Fallback
+		counted := []*types.Message{message}
+		if dropped {
+			counted = c.Messages
+		}
+		tokens, err := c.client.EstimateTokens(context.Background(), counted, c.estimationModel())
+		if err == nil {
+			if dropped {
+				c.estimatedTokens = tokens
+			} else {
+				c.estimatedTokens += tokens
+			}
+		}
+	}
+
+	return nil
+}
+
+// ReplaceMessages replaces the whole history, e.g. with one condensed by an external memory
+// system. The messages are validated first, and the token count is recomputed.
+func (c *Conversation) ReplaceMessages(messages []*types.Message) error {
+	if err := ValidateMessages(messages); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	replaced := make([]*types.Message, len(messages))
+	for i, message := range messages {
+		if message.Timestamp.IsZero() {
+			message.Timestamp = c.now()
+		}
+		if message.ID == "" {
+			message.ID = uuid.New().String()
 		}
+		replaced[i] = message
+	}
 
-		tokens, err := c.client.EstimateTokens(context.Background(), []*types.Message{message}, model)
+	c.Messages = replaced
+	c.estimatedTokens = 0
+	c.serverState = nil
+	c.UpdatedAt = c.now()
+
+	if c.client != nil && len(replaced) > 0 {
+		tokens, err := c.client.EstimateTokens(context.Background(), replaced, c.estimationModel())
 		if err == nil {
-			c.estimatedTokens += tokens
+			c.estimatedTokens = tokens
 		}
 	}
 
 	return nil
 }
 
+// ValidateMessages checks that messages form a valid history: every message has a known role,
+// and every tool message answers a tool call made by an earlier assistant message
+func ValidateMessages(messages []*types.Message) error {
+	calls := make(map[string]bool)
+	for i, msg := range messages {
+		if msg == nil {
+			return types.NewError(types.ErrCodeInvalidRequest, fmt.Sprintf("message %d is nil", i), "")
+		}
+
+		switch msg.Role {
+		case types.RoleSystem, types.RoleUser:
+		case types.RoleAssistant:
+			for _, call := range msg.ToolCalls {
+				calls[call.ID] = true
+			}
+		case types.RoleTool:
+			if len(calls) == 0 {
+				return types.NewError(types.ErrCodeInvalidRequest,
+					fmt.Sprintf("tool message %d does not follow a tool call", i), "")
+			}
+			if msg.ToolResult != nil && !calls[msg.ToolResult.ToolCallID] {
+				return types.NewError(types.ErrCodeInvalidRequest,
+					fmt.Sprintf("tool message %d answers unknown tool call %s", i, msg.ToolResult.ToolCallID), "")
+			}
+		default:
+			return types.NewError(types.ErrCodeInvalidRequest,
+				fmt.Sprintf("message %d has unknown role %q", i, msg.Role), "")
+		}
+	}
+	return nil
+}
+
+// estimationModel returns the model used to estimate the conversation's token count
+func (c *Conversation) estimationModel() string {
+	// Use a default model for estimation if none specified
+	if model := c.client.defaultConfig.DefaultModel; model != "" {
+		return model
+	}
+	return "gpt-4o-mini" // Fallback
+}
+
 // AddUserMessage adds a user message to the conversation
 func (c *Conversation) AddUserMessage(text string) error {
 	message := types.NewTextMessage(types.RoleUser, text)
@@ -114,6 +323,83 @@ func (c *Conversation) AddSystemMessage(text string) error {
 	return c.AddMessage(message)
 }
 
+// AddReferenceMessage adds reference material (e.g. retrieved documents) to the conversation.
+// Reference messages are pruned on send once they are older than ReferenceTTL.
+func (c *Conversation) AddReferenceMessage(text string) error {
+	message := types.NewTextMessage(types.RoleSystem, text)
+	message.Timestamp = c.now()
+	message.Metadata = map[string]interface{}{MessageTypeKey: MessageTypeReference}
+	return c.AddMessage(message)
+}
+
+// AddSystemSection appends a section to the system prompt and rebuilds the system message
+func (c *Conversation) AddSystemSection(text string) error {
+	if text == "" {
+		return nil
+	}
+
+	c.mu.Lock()
+	c.systemSections = append(c.systemSections, text)
+	prompt := strings.Join(c.systemSections, systemSectionSeparator)
+	c.UpdatedAt = c.now()
+
+	rewritten := false
+	for _, msg := range c.Messages {
+		if msg.Metadata[MessageTypeKey] == MessageTypeSystemPrompt {
+			msg.TextData = prompt
+			rewritten = true
+			break
+		}
+	}
+
+	// No system prompt yet, so insert one at the start of the conversation
+	if !rewritten {
+		systemMsg := types.NewTextMessage(types.RoleSystem, prompt)
+		systemMsg.ID = uuid.New().String()
+		systemMsg.Timestamp = c.now()
+		systemMsg.Metadata = map[string]interface{}{MessageTypeKey: MessageTypeSystemPrompt}
+		c.Messages = append([]*types.Message{systemMsg}, c.Messages...)
+	}
+	messages, client := c.Messages, c.client
+	c.mu.Unlock()
+
+	c.recountTokens(client, messages)
+	return nil
+}
+
+// PinMessage marks a message so truncation never removes it
+func (c *Conversation) PinMessage(id string) error {
+	return c.setPinned(id, true)
+}
+
+// UnpinMessage makes a pinned message removable by truncation again
+func (c *Conversation) UnpinMessage(id string) error {
+	return c.setPinned(id, false)
+}
+
+func (c *Conversation) setPinned(id string, pinned bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, msg := range c.Messages {
+		if msg.ID != id {
+			continue
+		}
+		if pinned {
+			if msg.Metadata == nil {
+				msg.Metadata = make(map[string]interface{})
+			}
+			msg.Metadata[MessagePinnedKey] = true
+		} else {
+			delete(msg.Metadata, MessagePinnedKey)
+		}
+		c.UpdatedAt = c.now()
+		return nil
+	}
+
+	return types.NewError(types.ErrCodeInvalidRequest, fmt.Sprintf("message %s not found", id), "")
+}
+
 // GetMessages returns a copy of all messages
 func (c *Conversation) GetMessages() []*types.Message {
 	c.mu.RLock()
@@ -149,49 +435,313 @@ func (c *Conversation) GetMessagesByRole(role types.Role) []*types.Message {
 	return filtered
 }
 
-// TruncateToFit ensures the conversation fits within token limits
+// TruncateToFit ensures the conversation fits within token limits: the conversation's
+// MaxTokens, or the model's context window if that is smaller. With TruncateSummarize,
+// the oldest messages are condensed into a summary note instead of being dropped.
 func (c *Conversation) TruncateToFit(ctx context.Context, model string, preserveSystem bool) error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	// Token counting and summaries can call providers, so they run on a copy of the history
+	// without holding the lock; the result is applied only if the history hasn't changed.
+	for attempt := 0; ; attempt++ {
+		c.mu.RLock()
+		client := c.client
+		original := slices.Clone(c.Messages)
+		maxTokens, strategy, summarizeLimit, id := c.MaxTokens, c.TruncateStrategy, c.SummarizeMessages, c.ID
+		c.mu.RUnlock()
 
-	if c.client == nil {
-		return types.NewError(types.ErrCodeInvalidConfig, "no client available for token estimation", "")
-	}
+		if client == nil {
+			return types.NewError(types.ErrCodeInvalidConfig, "no client available for token estimation", "")
+		}
 
-	for {
-		tokens, err := c.client.EstimateTokens(ctx, c.Messages, model)
+		messages, tokens, err := truncateMessages(ctx, client, model, slices.Clone(original), maxTokens, strategy, summarizeLimit, preserveSystem, id)
 		if err != nil {
 			return err
 		}
 
-		if tokens <= c.MaxTokens {
+		c.mu.Lock()
+		if slices.Equal(c.Messages, original) {
+			if len(messages) != len(original) || !slices.Equal(messages, original) {
+				c.Messages = messages
+				c.UpdatedAt = c.now()
+			}
 			c.estimatedTokens = tokens
+			c.mu.Unlock()
+			return nil
+		}
+		c.mu.Unlock()
+
+		if attempt >= maxTruncateAttempts {
+			return types.NewError(types.ErrCodeInvalidRequest, "conversation changed while it was being truncated", "")
+		}
+	}
+}
+
+// maxTruncateAttempts bounds how often TruncateToFit starts over when the history changes
+// while it runs
+const maxTruncateAttempts = 3
+
+// truncateMessages removes or summarizes the oldest messages until they fit the smaller of
+// maxTokens and the model's context window, returning the kept messages and their tokens
+func truncateMessages(ctx context.Context, client *Client, model string, messages []*types.Message, maxTokens int, strategy TruncateStrategy, summarizeLimit int, preserveSystem bool, conversationID string) ([]*types.Message, int, error) {
+	limit := maxTokens
+	if contextWindow, ok := client.contextWindow(model); ok && contextWindow < limit {
+		limit = contextWindow
+	}
+
+	for {
+		tokens, err := client.EstimateTokens(ctx, messages, model)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if tokens <= limit {
+			return messages, tokens, nil
+		}
+
+		if strategy == TruncateSummarize {
+			summarized, err := summarizeOldestMessages(ctx, client, model, messages, summarizeLimit, preserveSystem)
+			if err == nil {
+				messages = summarized
+				continue
+			}
+			slog.Warn("Conversation summary failed, dropping oldest message instead",
+				"conversation", conversationID, "error", err)
+		}
+
+		// Remove messages from the middle, preserving system message if requested.
+		// The error reports the last estimate that didn't fit.
+		messages, err = removeOldestMessage(messages, preserveSystem)
+		if err != nil {
+			return nil, 0, types.NewTokenLimitError(tokens, 0, limit, "")
+		}
+
+		if len(messages) == 0 || (preserveSystem && len(messages) == 1) {
+			return nil, 0, types.NewTokenLimitError(tokens, 0, limit, "")
+		}
+	}
+}
+
+// summaryPrompt instructs the model when condensing old messages
+const summaryPrompt = "Summarize the following conversation excerpt in a few sentences. " +
+	"Keep facts, decisions, and open questions that later turns may rely on."
+
+// summarizeOldestMessages replaces the oldest removable messages with a single system note
+// summarizing them, along with the results of any tool calls they made. Earlier summary
+// notes can be condensed again, even when system messages are preserved.
+func summarizeOldestMessages(ctx context.Context, client *Client, model string, messages []*types.Message, limit int, preserveSystem bool) ([]*types.Message, error) {
+	if limit <= 0 {
+		limit = defaultSummarizeMessages
+	}
+
+	var selected []*types.Message
+	for _, msg := range messages {
+		if len(selected) == limit {
 			break
 		}
+		if isPinned(msg) || (preserveSystem && msg.Role == types.RoleSystem && !isSummary(msg)) {
+			continue
+		}
+		selected = append(selected, msg)
+	}
+	if len(selected) < 2 {
+		return nil, fmt.Errorf("not enough messages to summarize")
+	}
 
-		// Remove messages from the middle, preserving system message if requested
-		if err := c.removeOldestNonSystemMessage(preserveSystem); err != nil {
-			return err
+	remove := make(map[*types.Message]bool, len(selected))
+	for _, msg := range selected {
+		remove[msg] = true
+		for _, call := range msg.ToolCalls {
+			for _, result := range messages {
+				if result.ToolResult != nil && result.ToolResult.ToolCallID == call.ID {
+					remove[result] = true
+				}
+			}
 		}
+	}
 
-		if len(c.Messages) == 0 || (preserveSystem && len(c.Messages) == 1) {
-			return types.NewError(types.ErrCodeTokenLimitExceeded,
-				"cannot fit conversation within token limit", "")
+	var transcript []string
+	for _, msg := range messages {
+		if remove[msg] {
+			transcript = append(transcript, summaryLine(msg))
 		}
 	}
 
-	return nil
+	resp, err := client.complete(ctx, &types.CompletionRequest{
+		Model: model,
+		Messages: []*types.Message{
+			types.NewTextMessage(types.RoleSystem, summaryPrompt),
+			types.NewTextMessage(types.RoleUser, strings.Join(transcript, "\n")),
+		},
+	}, "")
+	if err != nil {
+		return nil, err
+	}
+	if resp.Message == nil || resp.Message.GetText() == "" {
+		return nil, fmt.Errorf("summary response was empty")
+	}
+
+	summary := types.NewTextMessage(types.RoleSystem, "Summary of earlier conversation:\n"+resp.Message.GetText())
+	summary.ID = uuid.New().String()
+	summary.Metadata = map[string]interface{}{MessageTypeKey: MessageTypeSummary}
+
+	// The summary takes the place of the first message it replaces
+	kept := make([]*types.Message, 0, len(messages))
+	for _, msg := range messages {
+		if msg == selected[0] {
+			kept = append(kept, summary)
+		}
+		if !remove[msg] {
+			kept = append(kept, msg)
+		}
+	}
+	return kept, nil
+}
+
+// isSummary reports whether a message is a summary note added by truncation
+func isSummary(msg *types.Message) bool {
+	messageType, _ := msg.Metadata[MessageTypeKey].(string)
+	return messageType == MessageTypeSummary
+}
+
+// summaryLine renders a message as one transcript line for summarization
+func summaryLine(msg *types.Message) string {
+	if msg.ToolResult != nil {
+		return fmt.Sprintf("%s: %s", msg.Role, msg.ToolResult.Content)
+	}
+
+	text := msg.FlattenToText()
+	for _, call := range msg.ToolCalls {
+		text += fmt.Sprintf(" [called %s(%s)]", call.Function.Name, call.Function.Arguments)
+	}
+	return fmt.Sprintf("%s: %s", msg.Role, strings.TrimSpace(text))
 }
 
-// removeOldestNonSystemMessage removes the oldest non-system message
+// removeOldestNonSystemMessage removes the oldest non-system message, skipping pinned messages,
+// along with the results of any tool calls it made
 func (c *Conversation) removeOldestNonSystemMessage(preserveSystem bool) error {
-	for i, msg := range c.Messages {
+	messages, err := removeOldestMessage(c.Messages, preserveSystem)
+	if err != nil {
+		return err
+	}
+	c.Messages = messages
+	return nil
+}
+
+// removeOldestMessage returns messages without the oldest removable message, skipping pinned
+// messages and, when preserveSystem is set, system messages. Removing an assistant message
+// with tool calls also removes the matching tool results, so a tool result is never left
+// without the call that produced it.
+func removeOldestMessage(messages []*types.Message, preserveSystem bool) ([]*types.Message, error) {
+	for i, msg := range messages {
+		if isPinned(msg) {
+			continue
+		}
 		if !preserveSystem || msg.Role != types.RoleSystem {
-			c.Messages = append(c.Messages[:i], c.Messages[i+1:]...)
-			return nil
+			kept := slices.Delete(slices.Clone(messages), i, i+1)
+			if len(msg.ToolCalls) > 0 {
+				kept = removeToolResults(kept, msg.ToolCalls)
+			}
+			return kept, nil
 		}
 	}
-	return fmt.Errorf("no removable messages found")
+	return nil, fmt.Errorf("no removable messages found")
+}
+
+// oldestNonSystemMessage returns the oldest removable non-system message, or nil if there is none
+func (c *Conversation) oldestNonSystemMessage() *types.Message {
+	for _, msg := range c.Messages {
+		if msg.Role != types.RoleSystem && !isPinned(msg) {
+			return msg
+		}
+	}
+	return nil
+}
+
+// hasToolCallFor reports whether msg holds the tool call that result answers
+func hasToolCallFor(msg *types.Message, result *types.Message) bool {
+	if msg == nil || result.ToolResult == nil {
+		return false
+	}
+	return slices.ContainsFunc(msg.ToolCalls, func(tc types.ToolCall) bool {
+		return tc.ID == result.ToolResult.ToolCallID
+	})
+}
+
+// isPinned reports whether a message has been pinned
+func isPinned(msg *types.Message) bool {
+	pinned, _ := msg.Metadata[MessagePinnedKey].(bool)
+	return pinned
+}
+
+// removeToolResults returns messages without the tool results answering the given tool calls
+func removeToolResults(messages []*types.Message, toolCalls []types.ToolCall) []*types.Message {
+	callIDs := make(map[string]bool, len(toolCalls))
+	for _, tc := range toolCalls {
+		callIDs[tc.ID] = true
+	}
+
+	kept := make([]*types.Message, 0, len(messages))
+	for _, msg := range messages {
+		if msg.ToolResult != nil && callIDs[msg.ToolResult.ToolCallID] {
+			continue
+		}
+		kept = append(kept, msg)
+	}
+	return kept
+}
+
+// pruneExpiredReferences removes reference messages older than ReferenceTTL
+func (c *Conversation) pruneExpiredReferences() {
+	c.mu.Lock()
+	if c.ReferenceTTL <= 0 {
+		c.mu.Unlock()
+		return
+	}
+
+	cutoff := c.now().Add(-c.ReferenceTTL)
+	kept := make([]*types.Message, 0, len(c.Messages))
+	for _, msg := range c.Messages {
+		if msg.Metadata[MessageTypeKey] == MessageTypeReference && msg.Timestamp.Before(cutoff) {
+			continue
+		}
+		kept = append(kept, msg)
+	}
+	if len(kept) == len(c.Messages) {
+		c.mu.Unlock()
+		return
+	}
+	c.Messages = kept
+	client := c.client
+	c.mu.Unlock()
+
+	c.recountTokens(client, kept)
+}
+
+// recountTokens re-estimates the token count of messages after the history changed. The count
+// runs without the lock, since the token counter may call a provider, and is only stored if
+// the history hasn't changed in the meantime.
+func (c *Conversation) recountTokens(client *Client, messages []*types.Message) {
+	tokens := 0
+	if client != nil && len(messages) > 0 {
+		var err error
+		tokens, err = client.EstimateTokens(context.Background(), messages, c.estimationModel())
+		if err != nil {
+			return
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if slices.Equal(c.Messages, messages) {
+		c.estimatedTokens = tokens
+	}
+}
+
+// now returns the current time from the conversation clock
+func (c *Conversation) now() time.Time {
+	if c.clock != nil {
+		return c.clock()
+	}
+	return time.Now()
 }
 
 // Clear removes all messages from the conversation
@@ -201,12 +751,20 @@ func (c *Conversation) Clear() {
 
 	c.Messages = make([]*types.Message, 0)
 	c.estimatedTokens = 0
+	c.serverState = nil
 	c.UpdatedAt = time.Now()
 }
 
-// Send sends a user message and gets a response
-func (c *Conversation) Send(ctx context.Context, userMessage string, model string) (*types.CompletionResponse, error) {
+// Send sends a user message and gets a response. If the request fails, the user message is
+// rolled back so history never holds a turn without a response.
+func (c *Conversation) Send(ctx context.Context, userMessage string, model string, opts ...SendOption) (*types.CompletionResponse, error) {
+	c.beginSend()
+	defer c.endSend()
+
+	c.pruneExpiredReferences()
+
 	// Add user message
+	before := c.snapshot()
 	if err := c.AddUserMessage(userMessage); err != nil {
 		return nil, err
 	}
@@ -216,10 +774,13 @@ func (c *Conversation) Send(ctx context.Context, userMessage string, model strin
 		Messages: c.GetMessages(),
 		Model:    model,
 	}
+	c.applySendOptions(req, opts)
+	sent := c.applyIncrementalSend(req)
 
 	// Send completion request
-	resp, err := c.client.Complete(ctx, req)
+	resp, err := c.client.complete(ctx, req, "")
 	if err != nil {
+		c.restore(before)
 		return nil, err
 	}
 
@@ -228,14 +789,23 @@ func (c *Conversation) Send(ctx context.Context, userMessage string, model strin
 		if err := c.AddMessage(resp.Message); err != nil {
 			return nil, err
 		}
+		c.recordServerState(req.Model, resp.ID, append(sent, resp.Message))
 	}
 
 	return resp, nil
 }
 
-// SendStream sends a user message and streams the response
-func (c *Conversation) SendStream(ctx context.Context, userMessage string, model string, callback types.StreamCallback) error {
+// SendStream sends a user message and streams the response. The assistant message is added
+// once the stream completes; if the stream fails, the user message is rolled back so history
+// is left unchanged.
+func (c *Conversation) SendStream(ctx context.Context, userMessage string, model string, callback types.StreamCallback, opts ...SendOption) error {
+	c.beginSend()
+	defer c.endSend()
+
+	c.pruneExpiredReferences()
+
 	// Add user message
+	before := c.snapshot()
 	if err := c.AddUserMessage(userMessage); err != nil {
 		return err
 	}
@@ -246,29 +816,185 @@ func (c *Conversation) SendStream(ctx context.Context, userMessage string, model
 		Model:    model,
 		Stream:   true,
 	}
+	c.applySendOptions(req, opts)
+	sent := c.applyIncrementalSend(req)
 
 	// Collect streaming response for conversation history
-	var fullResponse string
+	var fullResponse, responseID string
+	finished := false
 	wrappedCallback := func(ctx context.Context, response *types.StreamResponse) error {
 		if response.Delta != nil && response.Delta.TextData != "" {
 			fullResponse += response.Delta.TextData
 		}
+		if response.FinishReason != "" {
+			finished = true
+			responseID = response.ID
+		}
 
 		// Call the original callback
-		if err := callback(ctx, response); err != nil {
+		return callback(ctx, response)
+	}
+
+	if err := c.client.Stream(ctx, req, wrappedCallback); err != nil {
+		c.restore(before)
+		return err
+	}
+
+	// Add complete response to conversation
+	if finished && fullResponse != "" {
+		assistantMsg := types.NewTextMessage(types.RoleAssistant, fullResponse)
+		if err := c.AddMessage(assistantMsg); err != nil {
 			return err
 		}
+		c.recordServerState(req.Model, responseID, append(sent, assistantMsg))
+	}
 
-		// Add complete response to conversation when finished
-		if response.FinishReason != "" && fullResponse != "" {
-			assistantMsg := types.NewTextMessage(types.RoleAssistant, fullResponse)
-			c.AddMessage(assistantMsg)
-		}
+	return nil
+}
 
+// historySnapshot records the conversation history, so a failed turn can be rolled back and
+// Restore can return to a Snapshot
+type historySnapshot struct {
+	id              SnapshotID // Set for restore points taken with Snapshot
+	messages        []*types.Message
+	estimatedTokens int
+	currentTokens   int
+	systemSections  []string
+	serverState     *serverState
+	updatedAt       time.Time
+}
+
+// snapshot captures the current history
+func (c *Conversation) snapshot() historySnapshot {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.snapshotLocked()
+}
+
+// snapshotLocked captures the current history; the caller must hold c.mu
+func (c *Conversation) snapshotLocked() historySnapshot {
+	return historySnapshot{
+		messages:        slices.Clone(c.Messages),
+		estimatedTokens: c.estimatedTokens,
+		currentTokens:   c.CurrentTokens,
+		systemSections:  slices.Clone(c.systemSections),
+		serverState:     c.serverState,
+		updatedAt:       c.UpdatedAt,
+	}
+}
+
+// restore resets the history to a snapshot, including messages dropped by the message cap
+func (c *Conversation) restore(snapshot historySnapshot) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.restoreLocked(snapshot)
+}
+
+// restoreLocked resets the history to a snapshot; the caller must hold c.mu
+func (c *Conversation) restoreLocked(snapshot historySnapshot) {
+	c.Messages = slices.Clone(snapshot.messages)
+	c.estimatedTokens = snapshot.estimatedTokens
+	c.CurrentTokens = snapshot.currentTokens
+	c.systemSections = slices.Clone(snapshot.systemSections)
+	c.serverState = snapshot.serverState
+	c.UpdatedAt = snapshot.updatedAt
+}
+
+// SnapshotID identifies a restore point taken with Snapshot
+type SnapshotID int
+
+// maxSnapshots bounds the restore points kept per conversation; the oldest are dropped first
+const maxSnapshots = 32
+
+// Snapshot saves the message history and token counts as a restore point for Restore.
+// Only the most recent maxSnapshots restore points are kept.
+func (c *Conversation) Snapshot() SnapshotID {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lastSnapshotID++
+	// Restore points outlive the current turn, so they keep their own copies of the messages
+	point := c.snapshotLocked()
+	point.id = c.lastSnapshotID
+	point.messages = copyMessages(point.messages)
+	c.snapshots = append(c.snapshots, point)
+	if len(c.snapshots) > maxSnapshots {
+		c.snapshots = slices.Delete(c.snapshots, 0, len(c.snapshots)-maxSnapshots)
+	}
+	return c.lastSnapshotID
+}
+
+// Restore rolls the history and token counts back to a restore point. The restore point is
+// kept, so the conversation can be restored to it again.
+func (c *Conversation) Restore(id SnapshotID) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, point := range c.snapshots {
+		if point.id != id {
+			continue
+		}
+		c.restoreLocked(point)
+		c.Messages = copyMessages(c.Messages)
+		c.UpdatedAt = c.now()
 		return nil
 	}
 
-	return c.client.Stream(ctx, req, wrappedCallback)
+	return types.NewError(types.ErrCodeInvalidRequest, fmt.Sprintf("snapshot %d not found", id), "")
+}
+
+// applySendOptions applies conversation defaults and per-turn options to a request
+func (c *Conversation) applySendOptions(req *types.CompletionRequest, opts []SendOption) {
+	if c.DefaultSeed != nil {
+		seed := *c.DefaultSeed
+		req.Seed = &seed
+	}
+	if len(c.StopDelimiters) > 0 {
+		req.Stop = append([]string(nil), c.StopDelimiters...)
+	}
+	for _, opt := range opts {
+		opt(req)
+	}
+}
+
+// applyIncrementalSend trims the request to the messages added since the last response when
+// incremental sending is enabled and the server-side state still matches the history. It
+// returns the full history being sent.
+func (c *Conversation) applyIncrementalSend(req *types.CompletionRequest) []*types.Message {
+	messages := req.Messages
+	if !c.IncrementalSend || !c.client.supportsPreviousResponse(req.Model) {
+		return messages
+	}
+
+	c.mu.RLock()
+	state := c.serverState
+	c.mu.RUnlock()
+
+	// Fall back to the full history if truncation or edits changed what the server has seen
+	if state == nil || len(state.messages) >= len(messages) {
+		return messages
+	}
+	for i, msg := range state.messages {
+		if messages[i] != msg {
+			return messages
+		}
+	}
+
+	req.Messages = messages[len(state.messages):]
+	req.PreviousResponseID = state.responseID
+	return messages
+}
+
+// recordServerState remembers the response holding the conversation server-side, so the
+// next turn can send only the new messages
+func (c *Conversation) recordServerState(model, responseID string, messages []*types.Message) {
+	if !c.IncrementalSend || responseID == "" || !c.client.supportsPreviousResponse(model) {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.serverState = &serverState{responseID: responseID, messages: messages}
 }
 
 // EstimateTokens estimates the current token count of the conversation
@@ -287,13 +1013,59 @@ func (c *Conversation) GetTokenCount() int {
 	return c.estimatedTokens
 }
 
-// Clone creates a copy of the conversation
+// Clone creates a copy of the conversation. The history is snapshotted atomically, but a
+// Send in progress on another goroutine may or may not have added its messages yet; use
+// CloneWithActiveGuard to wait for in-flight sends. Messages are copied, so pinning or
+// unpinning in one conversation doesn't affect the other.
 func (c *Conversation) Clone() *Conversation {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
+	return c.cloneLocked()
+}
 
-	messages := make([]*types.Message, len(c.Messages))
-	copy(messages, c.Messages)
+// CloneWithActiveGuard waits for any in-flight Send or SendStream to finish, then clones the
+// conversation, so the clone never holds half a turn. It returns an error if ctx is done first.
+func (c *Conversation) CloneWithActiveGuard(ctx context.Context) (*Conversation, error) {
+	for {
+		c.mu.Lock()
+		if c.activeSends == 0 {
+			clone := c.cloneLocked()
+			c.mu.Unlock()
+			return clone, nil
+		}
+		done := c.sendsDone
+		c.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-done:
+		}
+	}
+}
+
+// beginSend marks a send as in progress until the matching endSend
+func (c *Conversation) beginSend() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.activeSends == 0 {
+		c.sendsDone = make(chan struct{})
+	}
+	c.activeSends++
+}
+
+func (c *Conversation) endSend() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.activeSends--
+	if c.activeSends == 0 {
+		close(c.sendsDone)
+	}
+}
+
+// cloneLocked copies the conversation; the caller must hold the lock
+func (c *Conversation) cloneLocked() *Conversation {
+	messages := copyMessages(c.Messages)
 
 	metadata := make(map[string]interface{})
 	for k, v := range c.Metadata {
@@ -301,24 +1073,52 @@ func (c *Conversation) Clone() *Conversation {
 	}
 
 	return &Conversation{
-		ID:              uuid.New().String(),
-		Messages:        messages,
-		MaxTokens:       c.MaxTokens,
-		CurrentTokens:   c.CurrentTokens,
-		CreatedAt:       time.Now(),
-		UpdatedAt:       time.Now(),
-		Metadata:        metadata,
-		client:          c.client,
-		estimatedTokens: c.estimatedTokens,
+		ID:                uuid.New().String(),
+		Messages:          messages,
+		MaxTokens:         c.MaxTokens,
+		MaxMessages:       c.MaxMessages,
+		CurrentTokens:     c.CurrentTokens,
+		CreatedAt:         time.Now(),
+		UpdatedAt:         time.Now(),
+		Metadata:          metadata,
+		ReferenceTTL:      c.ReferenceTTL,
+		DefaultSeed:       c.DefaultSeed,
+		StopDelimiters:    append([]string(nil), c.StopDelimiters...),
+		ExportFormat:      c.ExportFormat,
+		IncrementalSend:   c.IncrementalSend,
+		TruncateStrategy:  c.TruncateStrategy,
+		SummarizeMessages: c.SummarizeMessages,
+		client:            c.client,
+		serverState:       c.serverState,
+		estimatedTokens:   c.estimatedTokens,
+		systemSections:    append([]string(nil), c.systemSections...),
+		clock:             c.clock,
 	}
 }
 
-// Export exports the conversation to a JSON-serializable format
+// copyMessages copies messages and their metadata, so later edits in place (like pinning or
+// rebuilding the system prompt) don't affect the copies
+func copyMessages(messages []*types.Message) []*types.Message {
+	copies := make([]*types.Message, len(messages))
+	for i, msg := range messages {
+		copied := *msg
+		if msg.Metadata != nil {
+			copied.Metadata = make(map[string]interface{}, len(msg.Metadata))
+			for k, v := range msg.Metadata {
+				copied.Metadata[k] = v
+			}
+		}
+		copies[i] = &copied
+	}
+	return copies
+}
+
+// Export exports the conversation to a JSON-serializable format, with keys cased per ExportFormat
 func (c *Conversation) Export() map[string]interface{} {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
-	return map[string]interface{}{
+	export := map[string]interface{}{
 		"id":               c.ID,
 		"messages":         c.Messages,
 		"max_tokens":       c.MaxTokens,
@@ -328,4 +1128,185 @@ func (c *Conversation) Export() map[string]interface{} {
 		"updated_at":       c.UpdatedAt,
 		"metadata":         c.Metadata,
 	}
+
+	if c.ExportFormat != ExportCamelCase {
+		return export
+	}
+
+	// Nested values carry snake_case struct tags, so convert through generic JSON
+	generic, err := toGenericJSON(export)
+	if err != nil {
+		return export
+	}
+	return transformKeys(generic, snakeToCamel).(map[string]interface{})
+}
+
+// MarshalJSON encodes the conversation with keys cased per ExportFormat
+func (c *Conversation) MarshalJSON() ([]byte, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	// The alias has no methods, so this doesn't recurse into MarshalJSON
+	type conversationJSON Conversation
+	if c.ExportFormat != ExportCamelCase {
+		return json.Marshal((*conversationJSON)(c))
+	}
+
+	generic, err := toGenericJSON((*conversationJSON)(c))
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(transformKeys(generic, snakeToCamel))
+}
+
+// conversationFile is the on-disk form of a conversation written by SaveToFile
+type conversationFile struct {
+	ID                string                 `json:"id"`
+	Messages          []*types.Message       `json:"messages"`
+	MaxTokens         int                    `json:"max_tokens"`
+	CurrentTokens     int                    `json:"current_tokens"`
+	EstimatedTokens   int                    `json:"estimated_tokens"`
+	CreatedAt         time.Time              `json:"created_at"`
+	UpdatedAt         time.Time              `json:"updated_at"`
+	Metadata          map[string]interface{} `json:"metadata,omitempty"`
+	MaxMessages       int                    `json:"max_messages,omitempty"`
+	ReferenceTTL      time.Duration          `json:"reference_ttl,omitempty"`
+	DefaultSeed       *int                   `json:"default_seed,omitempty"`
+	StopDelimiters    []string               `json:"stop_delimiters,omitempty"`
+	ExportFormat      ExportFormat           `json:"export_format,omitempty"`
+	IncrementalSend   bool                   `json:"incremental_send,omitempty"`
+	TruncateStrategy  TruncateStrategy       `json:"truncate_strategy,omitempty"`
+	SummarizeMessages int                    `json:"summarize_messages,omitempty"`
+	SystemSections    []string               `json:"system_sections,omitempty"`
+}
+
+// SaveToFile writes the full conversation state to path as JSON, for reloading with
+// Client.LoadConversation. Server-side response state is not saved, so the first turn
+// after a reload sends the full history.
+func (c *Conversation) SaveToFile(path string) error {
+	c.mu.RLock()
+	file := conversationFile{
+		ID:                c.ID,
+		Messages:          c.Messages,
+		MaxTokens:         c.MaxTokens,
+		CurrentTokens:     c.CurrentTokens,
+		EstimatedTokens:   c.estimatedTokens,
+		CreatedAt:         c.CreatedAt,
+		UpdatedAt:         c.UpdatedAt,
+		Metadata:          c.Metadata,
+		MaxMessages:       c.MaxMessages,
+		ReferenceTTL:      c.ReferenceTTL,
+		DefaultSeed:       c.DefaultSeed,
+		StopDelimiters:    c.StopDelimiters,
+		ExportFormat:      c.ExportFormat,
+		IncrementalSend:   c.IncrementalSend,
+		TruncateStrategy:  c.TruncateStrategy,
+		SummarizeMessages: c.SummarizeMessages,
+		SystemSections:    c.systemSections,
+	}
+	data, err := json.MarshalIndent(file, "", "  ")
+	c.mu.RUnlock()
+	if err != nil {
+		return fmt.Errorf("failed to encode conversation: %w", err)
+	}
+
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write conversation file: %w", err)
+	}
+	return nil
+}
+
+// LoadConversation reads a conversation saved with SaveToFile and attaches it to the client
+func (c *Client) LoadConversation(path string) (*Conversation, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read conversation file: %w", err)
+	}
+
+	var file conversationFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to decode conversation file: %w", err)
+	}
+
+	messages := file.Messages
+	if messages == nil {
+		messages = make([]*types.Message, 0)
+	}
+
+	return &Conversation{
+		ID:                file.ID,
+		Messages:          messages,
+		MaxTokens:         file.MaxTokens,
+		CurrentTokens:     file.CurrentTokens,
+		CreatedAt:         file.CreatedAt,
+		UpdatedAt:         file.UpdatedAt,
+		Metadata:          file.Metadata,
+		MaxMessages:       file.MaxMessages,
+		ReferenceTTL:      file.ReferenceTTL,
+		DefaultSeed:       file.DefaultSeed,
+		StopDelimiters:    file.StopDelimiters,
+		ExportFormat:      file.ExportFormat,
+		IncrementalSend:   file.IncrementalSend,
+		TruncateStrategy:  file.TruncateStrategy,
+		SummarizeMessages: file.SummarizeMessages,
+		client:            c,
+		estimatedTokens:   file.EstimatedTokens,
+		systemSections:    file.SystemSections,
+	}, nil
+}
+
+// exportOpaqueKeys hold user-defined data, so the keys inside them are never renamed
+var exportOpaqueKeys = map[string]bool{
+	"metadata":   true,
+	"args":       true,
+	"parameters": true,
+}
+
+// toGenericJSON converts a value to generic maps and slices through a JSON round trip
+func toGenericJSON(value interface{}) (interface{}, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var generic interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&generic); err != nil {
+		return nil, err
+	}
+	return generic, nil
+}
+
+// transformKeys renames the keys of generic JSON maps, recursing into nested values
+func transformKeys(value interface{}, rename func(string) string) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		renamed := make(map[string]interface{}, len(v))
+		for key, child := range v {
+			if exportOpaqueKeys[key] {
+				renamed[rename(key)] = child
+				continue
+			}
+			renamed[rename(key)] = transformKeys(child, rename)
+		}
+		return renamed
+	case []interface{}:
+		for i, child := range v {
+			v[i] = transformKeys(child, rename)
+		}
+		return v
+	}
+	return value
+}
+
+// snakeToCamel converts a snake_case key to camelCase
+func snakeToCamel(key string) string {
+	parts := strings.Split(key, "_")
+	for i := 1; i < len(parts); i++ {
+		if parts[i] != "" {
+			parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+		}
+	}
+	return strings.Join(parts, "")
 }
@@ -0,0 +1,1100 @@
+package aiutil
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ztkent/ai-util/types"
+)
+
+// mockProvider is a minimal in-memory provider for exercising client and conversation logic
+type mockProvider struct {
+	name     string
+	models   []*types.Model
+	requests []*types.CompletionRequest
+	complete func(ctx context.Context, req *types.CompletionRequest) (*types.CompletionResponse, error)
+	stream   func(ctx context.Context, req *types.CompletionRequest, callback types.StreamCallback) error
+	counter  types.TokenCounter
+}
+
+func newMockProvider(models ...string) *mockProvider {
+	p := &mockProvider{name: "mock"}
+	for _, id := range models {
+		p.models = append(p.models, &types.Model{ID: id, Name: id, Provider: p.name, MaxTokens: 8192})
+	}
+	return p
+}
+
+func (p *mockProvider) GetName() string                      { return p.name }
+func (p *mockProvider) Initialize(config types.Config) error { return nil }
+func (p *mockProvider) ValidateModel(model string) error     { return nil }
+func (p *mockProvider) Close() error                         { return nil }
+
+func (p *mockProvider) GetModels(ctx context.Context) ([]*types.Model, error) {
+	return p.models, nil
+}
+
+func (p *mockProvider) SupportedCapabilities() []types.ModelCapability {
+	return types.CapabilitiesOf(p.models)
+}
+
+func (p *mockProvider) Complete(ctx context.Context, req *types.CompletionRequest) (*types.CompletionResponse, error) {
+	p.requests = append(p.requests, req)
+	if p.complete != nil {
+		return p.complete(ctx, req)
+	}
+	return &types.CompletionResponse{
+		ID:           "mock-response",
+		Model:        req.Model,
+		Provider:     p.name,
+		Message:      types.NewTextMessage(types.RoleAssistant, "ok"),
+		FinishReason: "stop",
+		Usage:        &types.Usage{},
+	}, nil
+}
+
+func (p *mockProvider) Stream(ctx context.Context, req *types.CompletionRequest, callback types.StreamCallback) error {
+	p.requests = append(p.requests, req)
+	if p.stream != nil {
+		return p.stream(ctx, req, callback)
+	}
+	return callback(ctx, &types.StreamResponse{
+		ID:           "mock-stream",
+		Model:        req.Model,
+		Provider:     p.name,
+		Delta:        types.NewTextMessage(types.RoleAssistant, "ok"),
+		FinishReason: "stop",
+	})
+}
+
+func (p *mockProvider) EstimateTokens(ctx context.Context, messages []*types.Message, model string) (int, error) {
+	if p.counter != nil {
+		return p.counter.Count(messages, model)
+	}
+	total := 0
+	for _, msg := range messages {
+		total += len(msg.GetText()) / 4
+	}
+	return total, nil
+}
+
+func (p *mockProvider) SetTokenCounter(tc types.TokenCounter) { p.counter = tc }
+
+func newMockClient(t *testing.T, provider *mockProvider) *Client {
+	t.Helper()
+	client := NewClient(nil)
+	if err := client.RegisterProvider(provider); err != nil {
+		t.Fatalf("Failed to register mock provider: %v", err)
+	}
+	return client
+}
+
+func TestConversation_ReferenceTTL(t *testing.T) {
+	provider := newMockProvider("mock-model")
+	client := newMockClient(t, provider)
+	client.defaultConfig.DefaultModel = "mock-model" // Used for token estimates
+
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	conv := client.NewConversation(&ConversationConfig{
+		SystemPrompt: "You are a test assistant",
+		ReferenceTTL: 10 * time.Minute,
+	})
+	conv.clock = func() time.Time { return now }
+
+	if err := conv.AddReferenceMessage("Reference document"); err != nil {
+		t.Fatalf("Unexpected error adding reference: %v", err)
+	}
+
+	ctx := context.Background()
+	if _, err := conv.Send(ctx, "First question", "mock-model"); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if !containsText(provider.requests[0].Messages, "Reference document") {
+		t.Error("Expected reference to be sent before TTL expires")
+	}
+
+	// Advance past the TTL
+	now = now.Add(11 * time.Minute)
+
+	if _, err := conv.Send(ctx, "Second question", "mock-model"); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if containsText(provider.requests[1].Messages, "Reference document") {
+		t.Error("Expected expired reference to be pruned before sending")
+	}
+	if !containsText(provider.requests[1].Messages, "You are a test assistant") {
+		t.Error("Expected system prompt to survive reference pruning")
+	}
+	if containsText(conv.GetMessages(), "Reference document") {
+		t.Error("Expected expired reference to be removed from history")
+	}
+	expected, err := client.EstimateTokens(ctx, conv.GetMessages(), conv.estimationModel())
+	if err != nil {
+		t.Fatalf("EstimateTokens failed: %v", err)
+	}
+	if conv.GetTokenCount() != expected {
+		t.Errorf("Expected the token count to be recalculated after pruning, got %d want %d", conv.GetTokenCount(), expected)
+	}
+}
+
+func containsText(messages []*types.Message, text string) bool {
+	for _, msg := range messages {
+		if msg.GetText() == text {
+			return true
+		}
+	}
+	return false
+}
+
+func TestConversation_MaxMessages(t *testing.T) {
+	client := NewClient(nil)
+	conv := client.NewConversation(&ConversationConfig{
+		SystemPrompt: "You are a test assistant",
+		MaxMessages:  5,
+	})
+
+	conv.AddUserMessage("first question")
+	conv.AddMessage(&types.Message{
+		Role:      types.RoleAssistant,
+		ToolCalls: []types.ToolCall{{ID: "call_1", Type: "function", Function: types.ToolCallFunction{Name: "lookup"}}},
+	})
+	conv.AddMessage(&types.Message{
+		Role:       types.RoleTool,
+		ToolResult: &types.ToolResult{ToolCallID: "call_1", Content: "result"},
+	})
+	conv.AddAssistantMessage("first answer")
+
+	// Exceeding the cap drops the oldest user message
+	conv.AddUserMessage("second question")
+
+	messages := conv.GetMessages()
+	if len(messages) != 5 {
+		t.Fatalf("Expected 5 messages, got %d", len(messages))
+	}
+	if messages[0].Role != types.RoleSystem {
+		t.Errorf("Expected system message to be preserved, got %s", messages[0].Role)
+	}
+	if containsText(messages, "first question") {
+		t.Error("Expected oldest user message to be pruned")
+	}
+
+	// Dropping the tool call also drops its result, leaving no orphaned tool messages
+	conv.AddUserMessage("third question")
+
+	messages = conv.GetMessages()
+	if len(messages) != 4 {
+		t.Fatalf("Expected 4 messages after pruning the tool call pair, got %d", len(messages))
+	}
+	for _, msg := range messages {
+		if len(msg.ToolCalls) > 0 || msg.ToolResult != nil {
+			t.Errorf("Expected tool call and result to be pruned together, found %s message", msg.Role)
+		}
+	}
+
+	expected := []string{"You are a test assistant", "first answer", "second question", "third question"}
+	for i, text := range expected {
+		if messages[i].GetText() != text {
+			t.Errorf("Message %d: expected %q, got %q", i, text, messages[i].GetText())
+		}
+	}
+}
+
+func TestConversation_MaxMessagesKeepsAnsweredToolCall(t *testing.T) {
+	client := NewClient(nil)
+	conv := client.NewConversation(&ConversationConfig{
+		SystemPrompt: "You are a test assistant",
+		MaxMessages:  2,
+	})
+
+	conv.AddUserMessage("question")
+	conv.AddMessage(&types.Message{
+		Role:      types.RoleAssistant,
+		ToolCalls: []types.ToolCall{{ID: "call_1", Type: "function", Function: types.ToolCallFunction{Name: "lookup"}}},
+	})
+
+	// The tool call is now the oldest removable message, but pruning it would take the new
+	// result with it, so the cap is exceeded instead
+	result := &types.Message{
+		Role:       types.RoleTool,
+		ToolResult: &types.ToolResult{ToolCallID: "call_1", Content: "result"},
+	}
+	conv.AddMessage(result)
+
+	messages := conv.GetMessages()
+	if len(messages) != 3 {
+		t.Fatalf("Expected 3 messages, got %d", len(messages))
+	}
+	if len(messages[1].ToolCalls) != 1 || messages[2].ToolResult == nil || messages[2].ID != result.ID {
+		t.Errorf("Expected the tool call and its new result to be kept, got %s and %s messages", messages[1].Role, messages[2].Role)
+	}
+}
+
+func TestConversation_SystemPromptSections(t *testing.T) {
+	client := NewClient(nil)
+	conv := client.NewConversation(&ConversationConfig{
+		SystemPrompt:         "You are a test assistant.",
+		SystemPromptSections: []string{"Be concise.", "Never reveal secrets."},
+	})
+
+	messages := conv.GetMessages()
+	if len(messages) != 1 {
+		t.Fatalf("Expected 1 system message, got %d", len(messages))
+	}
+
+	expected := "You are a test assistant.\n\n---\n\nBe concise.\n\n---\n\nNever reveal secrets."
+	if messages[0].GetText() != expected {
+		t.Errorf("Expected joined system prompt %q, got %q", expected, messages[0].GetText())
+	}
+
+	// Adding a section rebuilds the existing system message in place
+	conv.AddUserMessage("Hello")
+	if err := conv.AddSystemSection("Answer in English."); err != nil {
+		t.Fatalf("Unexpected error adding system section: %v", err)
+	}
+
+	messages = conv.GetMessages()
+	if len(messages) != 2 {
+		t.Fatalf("Expected 2 messages, got %d", len(messages))
+	}
+	if !strings.HasSuffix(messages[0].GetText(), "Never reveal secrets.\n\n---\n\nAnswer in English.") {
+		t.Errorf("Expected new section appended to system prompt, got %q", messages[0].GetText())
+	}
+}
+
+func TestConversation_AddSystemSectionWithoutPrompt(t *testing.T) {
+	client := NewClient(nil)
+	conv := client.NewConversation(nil)
+	conv.AddUserMessage("Hello")
+
+	if err := conv.AddSystemSection("You are a test assistant."); err != nil {
+		t.Fatalf("Unexpected error adding system section: %v", err)
+	}
+
+	messages := conv.GetMessages()
+	if len(messages) != 2 {
+		t.Fatalf("Expected 2 messages, got %d", len(messages))
+	}
+	if messages[0].Role != types.RoleSystem || messages[0].GetText() != "You are a test assistant." {
+		t.Errorf("Expected system message inserted first, got %s: %q", messages[0].Role, messages[0].GetText())
+	}
+}
+
+func TestConversation_AddSystemSectionRecountsTokens(t *testing.T) {
+	client := newMockClient(t, newMockProvider("mock-model"))
+	client.defaultConfig.DefaultModel = "mock-model" // Used for token estimates
+	ctx := context.Background()
+
+	for _, prompt := range []string{"You are a test assistant.", ""} {
+		conv := client.NewConversation(&ConversationConfig{SystemPrompt: prompt})
+		conv.AddUserMessage("Hello there")
+		before := conv.GetTokenCount()
+
+		// Rewrites the system prompt, or inserts one when there is none
+		if err := conv.AddSystemSection("Answer in one short paragraph, citing sources."); err != nil {
+			t.Fatalf("Unexpected error adding system section: %v", err)
+		}
+
+		expected, err := client.EstimateTokens(ctx, conv.GetMessages(), conv.estimationModel())
+		if err != nil {
+			t.Fatalf("EstimateTokens failed: %v", err)
+		}
+		if conv.GetTokenCount() != expected || expected <= before {
+			t.Errorf("Prompt %q: expected the token count to grow from %d to %d, got %d", prompt, before, expected, conv.GetTokenCount())
+		}
+	}
+}
+
+func TestConversation_SendSeed(t *testing.T) {
+	provider := newMockProvider("mock-model")
+	client := newMockClient(t, provider)
+
+	defaultSeed := 7
+	conv := client.NewConversation(&ConversationConfig{DefaultSeed: &defaultSeed})
+
+	ctx := context.Background()
+	if _, err := conv.Send(ctx, "Hello", "mock-model"); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if seed := provider.requests[0].Seed; seed == nil || *seed != 7 {
+		t.Errorf("Expected default seed 7 in dispatched request, got %v", seed)
+	}
+
+	if _, err := conv.Send(ctx, "Again", "mock-model", WithSeed(42)); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if seed := provider.requests[1].Seed; seed == nil || *seed != 42 {
+		t.Errorf("Expected per-turn seed 42 in dispatched request, got %v", seed)
+	}
+
+	err := conv.SendStream(ctx, "Stream", "mock-model", func(ctx context.Context, response *types.StreamResponse) error {
+		return nil
+	}, WithSeed(99))
+	if err != nil {
+		t.Fatalf("SendStream failed: %v", err)
+	}
+	if seed := provider.requests[2].Seed; seed == nil || *seed != 99 {
+		t.Errorf("Expected per-turn seed 99 in streamed request, got %v", seed)
+	}
+}
+
+func TestConversation_PinnedMessagesSurviveTruncation(t *testing.T) {
+	provider := newMockProvider("mock-model")
+	client := newMockClient(t, provider)
+	conv := client.NewConversation(&ConversationConfig{
+		SystemPrompt: "You are a test assistant",
+		MaxTokens:    30,
+	})
+
+	// Each message is 40 characters, or 10 tokens with the mock estimator
+	texts := []string{
+		"message one: padding padding padding pad",
+		"message two: padding padding padding pad",
+		"message three: padding padding padding p",
+		"message four: padding padding padding pa",
+		"message five: padding padding padding pa",
+	}
+	for _, text := range texts {
+		conv.AddUserMessage(text)
+	}
+
+	pinned := conv.GetMessages()[3]
+	if err := conv.PinMessage(pinned.ID); err != nil {
+		t.Fatalf("PinMessage failed: %v", err)
+	}
+
+	ctx := context.Background()
+	if err := conv.TruncateToFit(ctx, "mock-model", true); err != nil {
+		t.Fatalf("TruncateToFit failed: %v", err)
+	}
+
+	messages := conv.GetMessages()
+	expected := []string{"You are a test assistant", texts[2], texts[4]}
+	if len(messages) != len(expected) {
+		t.Fatalf("Expected %d messages, got %d", len(expected), len(messages))
+	}
+	for i, text := range expected {
+		if messages[i].GetText() != text {
+			t.Errorf("Message %d: expected %q, got %q", i, text, messages[i].GetText())
+		}
+	}
+
+	// Once unpinned, the message is removable again
+	if err := conv.UnpinMessage(pinned.ID); err != nil {
+		t.Fatalf("UnpinMessage failed: %v", err)
+	}
+	conv.MaxTokens = 20
+	if err := conv.TruncateToFit(ctx, "mock-model", true); err != nil {
+		t.Fatalf("TruncateToFit failed: %v", err)
+	}
+	if containsText(conv.GetMessages(), texts[2]) {
+		t.Error("Expected unpinned message to be truncated")
+	}
+
+	if err := conv.PinMessage("missing"); err == nil {
+		t.Error("Expected error pinning an unknown message")
+	}
+}
+
+func TestConversation_ExportFormat(t *testing.T) {
+	client := NewClient(nil)
+
+	for _, tc := range []struct {
+		format   ExportFormat
+		expected []string
+		absent   []string
+	}{
+		{
+			format:   ExportSnakeCase,
+			expected: []string{"max_tokens", "created_at", "estimated_tokens"},
+			absent:   []string{"maxTokens", "createdAt"},
+		},
+		{
+			format:   ExportCamelCase,
+			expected: []string{"maxTokens", "createdAt", "estimatedTokens"},
+			absent:   []string{"max_tokens", "created_at"},
+		},
+	} {
+		conv := client.NewConversation(&ConversationConfig{
+			SystemPrompt: "You are a test assistant",
+			Metadata:     map[string]interface{}{"user_id": "123"},
+			ExportFormat: tc.format,
+		})
+		conv.AddUserMessage("Hello")
+
+		export := conv.Export()
+		for _, key := range tc.expected {
+			if _, ok := export[key]; !ok {
+				t.Errorf("%s: expected key %q in export", tc.format, key)
+			}
+		}
+		for _, key := range tc.absent {
+			if _, ok := export[key]; ok {
+				t.Errorf("%s: unexpected key %q in export", tc.format, key)
+			}
+		}
+
+		data, err := json.Marshal(conv)
+		if err != nil {
+			t.Fatalf("%s: failed to marshal conversation: %v", tc.format, err)
+		}
+		var marshaled map[string]interface{}
+		json.Unmarshal(data, &marshaled)
+
+		messages, _ := marshaled["messages"].([]interface{})
+		if len(messages) != 2 {
+			t.Fatalf("%s: expected 2 marshaled messages, got %d", tc.format, len(messages))
+		}
+		message := messages[1].(map[string]interface{})
+
+		textKey, updatedKey := "text_data", "updated_at"
+		if tc.format == ExportCamelCase {
+			textKey, updatedKey = "textData", "updatedAt"
+		}
+		if message[textKey] != "Hello" {
+			t.Errorf("%s: expected message key %q, got %v", tc.format, textKey, message)
+		}
+		if _, ok := marshaled[updatedKey]; !ok {
+			t.Errorf("%s: expected key %q in marshaled conversation", tc.format, updatedKey)
+		}
+
+		// User-defined metadata keys are left as-is
+		metadata, _ := marshaled["metadata"].(map[string]interface{})
+		if metadata["user_id"] != "123" {
+			t.Errorf("%s: expected metadata keys to be preserved, got %v", tc.format, metadata)
+		}
+	}
+}
+
+func TestConversation_ModelContextOverride(t *testing.T) {
+	client := NewClient(&ClientConfig{
+		DefaultProvider:       "mock",
+		ModelContextOverrides: map[string]int{"ft:custom-model": 20},
+	})
+	if err := client.RegisterProvider(newMockProvider("mock-model")); err != nil {
+		t.Fatalf("Failed to register mock provider: %v", err)
+	}
+
+	conv := client.NewConversation(&ConversationConfig{
+		SystemPrompt: "You are a test assistant",
+		MaxTokens:    4096,
+	})
+	texts := []string{
+		"message one: padding padding padding pad",
+		"message two: padding padding padding pad",
+		"message three: padding padding padding p",
+	}
+	for _, text := range texts {
+		conv.AddUserMessage(text)
+	}
+
+	// The conversation allows 4096 tokens, but the override limits the model to 20
+	ctx := context.Background()
+	if err := conv.TruncateToFit(ctx, "ft:custom-model", true); err != nil {
+		t.Fatalf("TruncateToFit failed: %v", err)
+	}
+
+	messages := conv.GetMessages()
+	if len(messages) != 2 || messages[1].GetText() != texts[2] {
+		t.Errorf("Expected the system prompt and last message to remain, got %d messages", len(messages))
+	}
+
+	remaining, err := client.MaxCompletionTokens(ctx, messages, "ft:custom-model")
+	if err != nil {
+		t.Fatalf("MaxCompletionTokens failed: %v", err)
+	}
+	if remaining != 4 {
+		t.Errorf("Expected 4 completion tokens remaining in the overridden window, got %d", remaining)
+	}
+}
+
+// statefulMockProvider is a mock provider that keeps conversation state server-side
+type statefulMockProvider struct {
+	*mockProvider
+}
+
+func (p *statefulMockProvider) SupportsPreviousResponse(model string) bool { return true }
+
+func TestConversation_IncrementalSend(t *testing.T) {
+	mock := newMockProvider("mock-model")
+	mock.complete = func(ctx context.Context, req *types.CompletionRequest) (*types.CompletionResponse, error) {
+		return &types.CompletionResponse{
+			ID:       fmt.Sprintf("resp-%d", len(mock.requests)),
+			Model:    req.Model,
+			Provider: "mock",
+			Message:  types.NewTextMessage(types.RoleAssistant, "ok"),
+		}, nil
+	}
+	client := NewClient(nil)
+	if err := client.RegisterProvider(&statefulMockProvider{mock}); err != nil {
+		t.Fatalf("Failed to register mock provider: %v", err)
+	}
+
+	conv := client.NewConversation(&ConversationConfig{
+		SystemPrompt:    "You are helpful.",
+		IncrementalSend: true,
+	})
+	for _, text := range []string{"First", "Second", "Third"} {
+		if _, err := conv.Send(context.Background(), text, "mock-model"); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+	}
+
+	first := mock.requests[0]
+	if len(first.Messages) != 2 || first.PreviousResponseID != "" {
+		t.Errorf("Expected the first turn to send the full history, got %d messages and previous response %q",
+			len(first.Messages), first.PreviousResponseID)
+	}
+	for i, expected := range []string{"resp-1", "resp-2"} {
+		req := mock.requests[i+1]
+		if req.PreviousResponseID != expected {
+			t.Errorf("Expected turn %d to continue from %s, got %q", i+2, expected, req.PreviousResponseID)
+		}
+		if len(req.Messages) != 1 || req.Messages[0].GetText() != []string{"Second", "Third"}[i] {
+			t.Errorf("Expected turn %d to send only the new user message, got %d messages", i+2, len(req.Messages))
+		}
+	}
+	if len(conv.GetMessages()) != 7 {
+		t.Errorf("Expected the full history to be kept locally, got %d messages", len(conv.GetMessages()))
+	}
+
+	// Once the history diverges from the server state, the full history is sent again
+	conv.Clear()
+	if _, err := conv.Send(context.Background(), "Fresh start", "mock-model"); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if last := mock.requests[len(mock.requests)-1]; len(last.Messages) != 1 || last.PreviousResponseID != "" {
+		t.Errorf("Expected a full send after Clear, got previous response %q", last.PreviousResponseID)
+	}
+}
+
+func TestConversation_IncrementalSendUnsupported(t *testing.T) {
+	provider := newMockProvider("mock-model")
+	client := newMockClient(t, provider)
+
+	conv := client.NewConversation(&ConversationConfig{IncrementalSend: true})
+	for _, text := range []string{"First", "Second"} {
+		if _, err := conv.Send(context.Background(), text, "mock-model"); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+	}
+
+	last := provider.requests[1]
+	if len(last.Messages) != 3 || last.PreviousResponseID != "" {
+		t.Errorf("Expected the full history without server state, got %d messages and previous response %q",
+			len(last.Messages), last.PreviousResponseID)
+	}
+}
+
+func TestConversation_SaveAndLoad(t *testing.T) {
+	provider := newMockProvider("mock-model")
+	client := newMockClient(t, provider)
+
+	seed := 7
+	conv := client.NewConversation(&ConversationConfig{
+		SystemPrompt: "You are a test assistant",
+		MaxTokens:    2048,
+		Metadata:     map[string]interface{}{"user": "alice"},
+		DefaultSeed:  &seed,
+	})
+	conv.AddUserMessage("Hello")
+	conv.AddMessage(types.NewMultiImageMessage(types.RoleUser, "What is this?",
+		types.ImageContent{Base64: "aGVsbG8=", MimeType: "image/png"}))
+
+	path := filepath.Join(t.TempDir(), "conversation.json")
+	if err := conv.SaveToFile(path); err != nil {
+		t.Fatalf("SaveToFile failed: %v", err)
+	}
+
+	loaded, err := client.LoadConversation(path)
+	if err != nil {
+		t.Fatalf("LoadConversation failed: %v", err)
+	}
+
+	if loaded.ID != conv.ID || loaded.MaxTokens != 2048 || loaded.CurrentTokens != conv.CurrentTokens {
+		t.Errorf("Expected conversation state to survive, got id=%s max=%d current=%d",
+			loaded.ID, loaded.MaxTokens, loaded.CurrentTokens)
+	}
+	if !loaded.CreatedAt.Equal(conv.CreatedAt) || !loaded.UpdatedAt.Equal(conv.UpdatedAt) {
+		t.Errorf("Expected timestamps to survive")
+	}
+	if loaded.Metadata["user"] != "alice" || loaded.DefaultSeed == nil || *loaded.DefaultSeed != 7 {
+		t.Errorf("Expected metadata and seed to survive, got %v, %v", loaded.Metadata, loaded.DefaultSeed)
+	}
+	if len(loaded.Messages) != 3 {
+		t.Fatalf("Expected 3 messages, got %d", len(loaded.Messages))
+	}
+	if !loaded.Messages[2].HasImages() || loaded.Messages[2].GetText() != "What is this?" {
+		t.Errorf("Expected structured content to survive, got %+v", loaded.Messages[2].Content)
+	}
+
+	// The loaded conversation is attached to the client and can keep going
+	if _, err := loaded.Send(context.Background(), "Continue", "mock-model"); err != nil {
+		t.Fatalf("Send on loaded conversation failed: %v", err)
+	}
+	if len(provider.requests[0].Messages) != 4 {
+		t.Errorf("Expected full history to be sent, got %d messages", len(provider.requests[0].Messages))
+	}
+}
+
+func TestConversation_TruncateToFitTokenLimitDetails(t *testing.T) {
+	client := newMockClient(t, newMockProvider("mock-model"))
+	conv := client.NewConversation(&ConversationConfig{
+		SystemPrompt: "You are a test assistant", // 6 tokens with the mock estimator
+		MaxTokens:    5,
+	})
+	conv.AddUserMessage("this pinned message is forty characters") // 9 tokens
+	if err := conv.PinMessage(conv.GetLastMessage().ID); err != nil {
+		t.Fatalf("PinMessage failed: %v", err)
+	}
+
+	err := conv.TruncateToFit(context.Background(), "mock-model", true)
+
+	var aiErr *types.Error
+	if !errors.As(err, &aiErr) || aiErr.Code != types.ErrCodeTokenLimitExceeded {
+		t.Fatalf("Expected %s error, got %v", types.ErrCodeTokenLimitExceeded, err)
+	}
+	expected := map[string]int{
+		types.DetailPromptTokens: 15,
+		types.DetailMaxTokens:    0,
+		types.DetailModelLimit:   5,
+		types.DetailOverage:      10,
+	}
+	for key, value := range expected {
+		if aiErr.Details[key] != value {
+			t.Errorf("Expected %s = %d, got %v", key, value, aiErr.Details[key])
+		}
+	}
+}
+
+func TestConversation_SendStreamRollsBackOnError(t *testing.T) {
+	provider := newMockProvider("mock-model")
+	provider.stream = func(ctx context.Context, req *types.CompletionRequest, callback types.StreamCallback) error {
+		if err := callback(ctx, &types.StreamResponse{Delta: &types.Message{TextData: "Partial"}}); err != nil {
+			return err
+		}
+		return types.NewError(types.ErrCodeServerError, "connection reset", "mock")
+	}
+	client := newMockClient(t, provider)
+
+	conv := client.NewConversation(&ConversationConfig{SystemPrompt: "You are a test assistant"})
+	conv.AddUserMessage("First question")
+	conv.AddAssistantMessage("First answer")
+	before := conv.GetMessages()
+	tokensBefore := conv.GetTokenCount()
+
+	var received string
+	err := conv.SendStream(context.Background(), "Second question", "mock-model", func(ctx context.Context, response *types.StreamResponse) error {
+		if response.Delta != nil {
+			received += response.Delta.TextData
+		}
+		return nil
+	})
+	if err == nil {
+		t.Fatal("Expected stream error")
+	}
+	if received != "Partial" {
+		t.Errorf("Expected partial output to reach the callback, got %q", received)
+	}
+
+	after := conv.GetMessages()
+	if len(after) != len(before) {
+		t.Fatalf("Expected history to be unchanged at %d messages, got %d", len(before), len(after))
+	}
+	for i := range before {
+		if after[i] != before[i] {
+			t.Errorf("Message %d changed after failed stream", i)
+		}
+	}
+	if conv.GetTokenCount() != tokensBefore {
+		t.Errorf("Expected token count %d after rollback, got %d", tokensBefore, conv.GetTokenCount())
+	}
+
+	// A successful stream commits both messages
+	provider.stream = nil
+	if err := conv.SendStream(context.Background(), "Third question", "mock-model", func(ctx context.Context, response *types.StreamResponse) error {
+		return nil
+	}); err != nil {
+		t.Fatalf("SendStream failed: %v", err)
+	}
+	messages := conv.GetMessages()
+	if len(messages) != len(before)+2 || messages[len(messages)-2].GetText() != "Third question" || messages[len(messages)-1].GetText() != "ok" {
+		t.Errorf("Expected user and assistant messages to be committed, got %d messages", len(messages))
+	}
+}
+
+func TestConversation_TruncateSummarize(t *testing.T) {
+	texts := []string{
+		"first message with some padding padding", // 9 tokens each with the mock estimator
+		"second message with some padding paddin",
+		"third message with some padding padding",
+		"fourth message with some padding paddin",
+		"fifth message with some padding padding",
+		"sixth message with some padding padding",
+	}
+	newConversation := func(client *Client) *Conversation {
+		conv := client.NewConversation(&ConversationConfig{
+			SystemPrompt:     "You are a test assistant",
+			MaxTokens:        40,
+			TruncateStrategy: TruncateSummarize,
+		})
+		for i, text := range texts {
+			if i%2 == 0 {
+				conv.AddUserMessage(text)
+			} else {
+				conv.AddAssistantMessage(text)
+			}
+		}
+		return conv
+	}
+
+	provider := newMockProvider("mock-model")
+	var summaryInput string
+	provider.complete = func(ctx context.Context, req *types.CompletionRequest) (*types.CompletionResponse, error) {
+		summaryInput = req.Messages[1].GetText()
+		return &types.CompletionResponse{
+			Message: types.NewTextMessage(types.RoleAssistant, "The user asked about four things."),
+		}, nil
+	}
+	conv := newConversation(newMockClient(t, provider))
+
+	if err := conv.TruncateToFit(context.Background(), "mock-model", true); err != nil {
+		t.Fatalf("TruncateToFit failed: %v", err)
+	}
+
+	if !strings.Contains(summaryInput, "user: "+texts[0]) || !strings.Contains(summaryInput, "assistant: "+texts[3]) {
+		t.Errorf("Expected the four oldest messages in the summary request, got %q", summaryInput)
+	}
+	if strings.Contains(summaryInput, texts[4]) {
+		t.Errorf("Expected only the oldest messages to be summarized, got %q", summaryInput)
+	}
+
+	messages := conv.GetMessages()
+	if len(messages) != 4 {
+		t.Fatalf("Expected system prompt, summary, and 2 recent messages, got %d", len(messages))
+	}
+	if messages[0].GetText() != "You are a test assistant" {
+		t.Errorf("Expected system prompt to be preserved, got %q", messages[0].GetText())
+	}
+	if messages[1].Role != types.RoleSystem || messages[1].Metadata[MessageTypeKey] != MessageTypeSummary ||
+		!strings.HasSuffix(messages[1].GetText(), "The user asked about four things.") {
+		t.Errorf("Expected summary note in place of the oldest messages, got %+v", messages[1])
+	}
+	if messages[2].GetText() != texts[4] || messages[3].GetText() != texts[5] {
+		t.Errorf("Expected recent messages to be kept, got %q and %q", messages[2].GetText(), messages[3].GetText())
+	}
+
+	// A failed summary falls back to dropping the oldest messages
+	failing := newMockProvider("mock-model")
+	failing.complete = func(ctx context.Context, req *types.CompletionRequest) (*types.CompletionResponse, error) {
+		return nil, types.NewError(types.ErrCodeServerError, "unavailable", "mock")
+	}
+	conv = newConversation(newMockClient(t, failing))
+
+	if err := conv.TruncateToFit(context.Background(), "mock-model", true); err != nil {
+		t.Fatalf("TruncateToFit failed: %v", err)
+	}
+	messages = conv.GetMessages()
+	expected := []string{"You are a test assistant", texts[3], texts[4], texts[5]}
+	if len(messages) != len(expected) {
+		t.Fatalf("Expected %d messages after dropping, got %d", len(expected), len(messages))
+	}
+	for i, text := range expected {
+		if messages[i].GetText() != text {
+			t.Errorf("Message %d: expected %q, got %q", i, text, messages[i].GetText())
+		}
+	}
+}
+
+func TestConversation_TruncateWhileInUse(t *testing.T) {
+	provider := newMockProvider("mock-model")
+	client := newMockClient(t, provider)
+	conv := client.NewConversation(&ConversationConfig{
+		SystemPrompt:     "You are a test assistant",
+		MaxTokens:        40,
+		TruncateStrategy: TruncateSummarize,
+	})
+	for i := 0; i < 6; i++ {
+		conv.AddUserMessage("message with some padding padding padding")
+	}
+
+	// The summary call reads and changes the conversation; holding the lock would deadlock
+	summaries := 0
+	provider.complete = func(ctx context.Context, req *types.CompletionRequest) (*types.CompletionResponse, error) {
+		summaries++
+		if len(conv.GetMessages()) == 0 {
+			t.Error("Expected the conversation to be readable during summarization")
+		}
+		if summaries == 1 {
+			conv.AddAssistantMessage("late reply")
+		}
+		return &types.CompletionResponse{
+			Message: types.NewTextMessage(types.RoleAssistant, "Summary."),
+		}, nil
+	}
+
+	if err := conv.TruncateToFit(context.Background(), "mock-model", true); err != nil {
+		t.Fatalf("TruncateToFit failed: %v", err)
+	}
+	if summaries < 2 {
+		t.Errorf("Expected truncation to start over after the history changed, got %d summaries", summaries)
+	}
+	messages := conv.GetMessages()
+	if messages[len(messages)-1].GetText() != "late reply" {
+		t.Errorf("Expected the message added during truncation to be kept, got %q", messages[len(messages)-1].GetText())
+	}
+
+	// A history that keeps changing gives up with an error
+	provider.complete = func(ctx context.Context, req *types.CompletionRequest) (*types.CompletionResponse, error) {
+		conv.AddUserMessage("message with some padding padding padding")
+		return &types.CompletionResponse{
+			Message: types.NewTextMessage(types.RoleAssistant, "Summary."),
+		}, nil
+	}
+	for i := 0; i < 6; i++ {
+		conv.AddUserMessage("message with some padding padding padding")
+	}
+	if err := conv.TruncateToFit(context.Background(), "mock-model", true); err == nil {
+		t.Error("Expected an error when the history keeps changing")
+	}
+}
+
+func TestConversation_StopDelimiters(t *testing.T) {
+	provider := newMockProvider("mock-model")
+	client := newMockClient(t, provider)
+	conv := client.NewConversation(&ConversationConfig{
+		SystemPrompt:   "Reply with a single record ending in ###",
+		StopDelimiters: []string{"###", "\nUSER:"},
+	})
+
+	ctx := context.Background()
+	if _, err := conv.Send(ctx, "First", "mock-model"); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if got := provider.requests[0].Stop; !slices.Equal(got, []string{"###", "\nUSER:"}) {
+		t.Errorf("Expected configured delimiters as stop sequences, got %q", got)
+	}
+
+	// Per-call stops are added after the delimiters, without duplicates
+	if _, err := conv.Send(ctx, "Second", "mock-model", WithStop("END", "###")); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if got := provider.requests[1].Stop; !slices.Equal(got, []string{"###", "\nUSER:", "END"}) {
+		t.Errorf("Expected delimiters followed by per-call stops, got %q", got)
+	}
+
+	// Per-call stops don't leak into later turns
+	if err := conv.SendStream(ctx, "Third", "mock-model", func(ctx context.Context, response *types.StreamResponse) error {
+		return nil
+	}); err != nil {
+		t.Fatalf("SendStream failed: %v", err)
+	}
+	if got := provider.requests[2].Stop; !slices.Equal(got, []string{"###", "\nUSER:"}) {
+		t.Errorf("Expected only configured delimiters on the next turn, got %q", got)
+	}
+}
+
+func TestConversation_ReplaceMessages(t *testing.T) {
+	provider := newMockProvider("mock-model")
+	client := newMockClient(t, provider)
+	client.defaultConfig.DefaultModel = "mock-model"
+
+	conv := client.NewConversation(&ConversationConfig{SystemPrompt: "You are a test assistant"})
+	conv.AddUserMessage("A long question that will be summarized away")
+	conv.AddAssistantMessage("A long answer that will be summarized away")
+
+	// 19 + 8 characters: 4 + 2 tokens
+	replacement := []*types.Message{
+		types.NewTextMessage(types.RoleSystem, "Summary: user asked"),
+		types.NewTextMessage(types.RoleUser, "Continue"),
+	}
+	if err := conv.ReplaceMessages(replacement); err != nil {
+		t.Fatalf("ReplaceMessages failed: %v", err)
+	}
+
+	messages := conv.GetMessages()
+	if len(messages) != 2 || messages[0].GetText() != "Summary: user asked" {
+		t.Fatalf("Expected the replacement history, got %d messages", len(messages))
+	}
+	if messages[1].ID == "" || messages[1].Timestamp.IsZero() {
+		t.Error("Expected replacement messages to get IDs and timestamps")
+	}
+	if tokens := conv.GetTokenCount(); tokens != 6 {
+		t.Errorf("Expected token count recomputed to 6, got %d", tokens)
+	}
+
+	// A tool result without a preceding tool call is rejected and the history kept
+	invalid := []*types.Message{
+		types.NewTextMessage(types.RoleUser, "Hi"),
+		{Role: types.RoleTool, ToolResult: &types.ToolResult{ToolCallID: "call_1", Content: "42"}},
+	}
+	err := conv.ReplaceMessages(invalid)
+	var aiErr *types.Error
+	if !errors.As(err, &aiErr) || aiErr.Code != types.ErrCodeInvalidRequest {
+		t.Fatalf("Expected invalid request error, got %v", err)
+	}
+	if len(conv.GetMessages()) != 2 || conv.GetTokenCount() != 6 {
+		t.Error("Expected the history unchanged after a rejected replacement")
+	}
+}
+
+func TestConversation_SnapshotRestore(t *testing.T) {
+	provider := newMockProvider("mock-model")
+	client := newMockClient(t, provider)
+	client.defaultConfig.DefaultModel = "mock-model"
+
+	conv := client.NewConversation(&ConversationConfig{SystemPrompt: "You are a test assistant"})
+	conv.AddUserMessage("What is 2+2?")
+	conv.AddAssistantMessage("4")
+
+	id := conv.Snapshot()
+	snapshot := copyMessages(conv.GetMessages())
+	tokens := conv.GetTokenCount()
+
+	conv.AddUserMessage("And 3+3?")
+	conv.AddAssistantMessage("6")
+	conv.AddSystemSection("Answer in words")
+	if err := conv.PinMessage(snapshot[1].ID); err != nil {
+		t.Fatalf("PinMessage failed: %v", err)
+	}
+
+	if err := conv.Restore(id); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	messages := conv.GetMessages()
+	if len(messages) != len(snapshot) {
+		t.Fatalf("Expected %d messages after restore, got %d", len(snapshot), len(messages))
+	}
+	for i, msg := range messages {
+		if msg.ID != snapshot[i].ID || msg.GetText() != snapshot[i].GetText() {
+			t.Errorf("Expected message %d to be %q, got %q", i, snapshot[i].GetText(), msg.GetText())
+		}
+	}
+	if isPinned(messages[1]) {
+		t.Error("Expected changes made after the snapshot to be rolled back")
+	}
+	if got := conv.GetTokenCount(); got != tokens {
+		t.Errorf("Expected token count %d after restore, got %d", tokens, got)
+	}
+
+	// Old restore points are dropped beyond the limit
+	for range maxSnapshots {
+		conv.Snapshot()
+	}
+	var aiErr *types.Error
+	if err := conv.Restore(id); !errors.As(err, &aiErr) || aiErr.Code != types.ErrCodeInvalidRequest {
+		t.Errorf("Expected %s error for a dropped snapshot, got %v", types.ErrCodeInvalidRequest, err)
+	}
+}
+
+func TestConversation_TruncateWithTokenCounter(t *testing.T) {
+	provider := newMockProvider("mock-model")
+	client := newMockClient(t, provider)
+	client.defaultConfig.DefaultModel = "mock-model"
+
+	// Count each message as 100 tokens, so only 2 fit in a 250 token conversation
+	counter := types.TokenCounterFunc(func(messages []*types.Message, model string) (int, error) {
+		return 100 * len(messages), nil
+	})
+	if err := client.SetTokenCounter("mock", counter); err != nil {
+		t.Fatalf("SetTokenCounter failed: %v", err)
+	}
+
+	conv := client.NewConversation(&ConversationConfig{MaxTokens: 250})
+	conv.AddUserMessage("one")
+	conv.AddAssistantMessage("two")
+	conv.AddUserMessage("three")
+	if err := conv.TruncateToFit(context.Background(), "mock-model", true); err != nil {
+		t.Fatalf("TruncateToFit failed: %v", err)
+	}
+
+	messages := conv.GetMessages()
+	if len(messages) != 2 || messages[0].GetText() != "two" {
+		t.Errorf("Expected truncation by the custom counter to keep 2 messages, got %d", len(messages))
+	}
+	if tokens := conv.GetTokenCount(); tokens != 200 {
+		t.Errorf("Expected 200 tokens from the custom counter, got %d", tokens)
+	}
+
+	if err := client.SetTokenCounter("missing", counter); err == nil {
+		t.Error("Expected an error for an unregistered provider")
+	}
+}
+
+func TestConversation_CloneWithActiveGuard(t *testing.T) {
+	provider := newMockProvider("mock-model")
+	started := make(chan struct{})
+	release := make(chan struct{})
+	provider.complete = func(ctx context.Context, req *types.CompletionRequest) (*types.CompletionResponse, error) {
+		close(started)
+		<-release
+		return &types.CompletionResponse{
+			Model:    req.Model,
+			Provider: "mock",
+			Message:  types.NewTextMessage(types.RoleAssistant, "Hi there"),
+		}, nil
+	}
+	client := newMockClient(t, provider)
+	conv := client.NewConversation(&ConversationConfig{SystemPrompt: "You are a test assistant"})
+
+	sendDone := make(chan error, 1)
+	go func() {
+		_, err := conv.Send(context.Background(), "Hello", "mock-model")
+		sendDone <- err
+	}()
+	<-started
+
+	// Plain clones don't wait, and may run concurrently with the send
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if clone := conv.Clone(); len(clone.GetMessages()) != 2 {
+				t.Errorf("Expected a mid-send clone to hold the system and user messages, got %d", len(clone.GetMessages()))
+			}
+		}()
+	}
+	wg.Wait()
+
+	// A guarded clone waits for the send, or gives up with the context
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := conv.CloneWithActiveGuard(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected the guard to time out while the send is in flight, got %v", err)
+	}
+
+	cloned := make(chan *Conversation, 1)
+	go func() {
+		clone, err := conv.CloneWithActiveGuard(context.Background())
+		if err != nil {
+			t.Errorf("CloneWithActiveGuard failed: %v", err)
+		}
+		cloned <- clone
+	}()
+
+	close(release)
+	if err := <-sendDone; err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	clone := <-cloned
+	messages := clone.GetMessages()
+	if len(messages) != 3 || messages[2].GetText() != "Hi there" {
+		t.Fatalf("Expected the guarded clone to include the assistant reply, got %d messages", len(messages))
+	}
+
+	// Clones don't share message metadata with the original
+	if err := clone.PinMessage(messages[1].ID); err != nil {
+		t.Fatalf("PinMessage failed: %v", err)
+	}
+	if isPinned(conv.GetMessages()[1]) {
+		t.Error("Expected pinning in the clone not to pin the original message")
+	}
+}
@@ -63,7 +63,7 @@ func main() {
 		Messages:    messages,
 		Model:       "gemini-2.5-flash",
 		MaxTokens:   100,
-		Temperature: 0.7,
+		Temperature: types.Float64(0.7),
 	}
 
 	response, err := provider.Complete(ctx, completionReq)
@@ -85,7 +85,7 @@ func main() {
 		},
 		Model:       "gemini-2.5-flash",
 		MaxTokens:   200,
-		Temperature: 0.8,
+		Temperature: types.Float64(0.8),
 	}
 
 	fmt.Print("Streaming response: ")
@@ -175,7 +175,7 @@ func demonstrateTools(ctx context.Context, provider *google.Provider) {
 		Messages:    messages,
 		Model:       "gemini-2.5-flash", // Use a model that supports tools
 		MaxTokens:   300,
-		Temperature: 0.1,
+		Temperature: types.Float64(0.1),
 		Tools:       []types.Tool{weatherTool, calculatorTool},
 	}
 
@@ -226,7 +226,7 @@ func demonstrateTools(ctx context.Context, provider *google.Provider) {
 			Messages:    messages,
 			Model:       "gemini-2.5-flash",
 			MaxTokens:   200,
-			Temperature: 0.1,
+			Temperature: types.Float64(0.1),
 		}
 
 		finalResponse, err := provider.Complete(ctx, finalReq)
@@ -99,7 +99,7 @@ func demonstrateChat(ctx context.Context, provider *openai.Provider) {
 		Messages:    messages,
 		Model:       "gpt-4o-mini",
 		MaxTokens:   100,
-		Temperature: 0.7,
+		Temperature: types.Float64(0.7),
 	}
 
 	response, err := provider.Complete(ctx, completionReq)
@@ -128,7 +128,7 @@ func demonstrateStreaming(ctx context.Context, provider *openai.Provider) {
 		},
 		Model:       "gpt-4o-mini",
 		MaxTokens:   200,
-		Temperature: 0.8,
+		Temperature: types.Float64(0.8),
 	}
 
 	fmt.Print("Streaming response: ")
@@ -218,7 +218,7 @@ func demonstrateTools(ctx context.Context, provider *openai.Provider) {
 		Messages:    messages,
 		Model:       "gpt-4o-mini",
 		MaxTokens:   300,
-		Temperature: 0.1,
+		Temperature: types.Float64(0.1),
 		Tools:       []types.Tool{weatherTool, calculatorTool},
 		ToolChoice:  "auto", // Let the model decide when to use tools
 	}
@@ -266,7 +266,7 @@ func demonstrateTools(ctx context.Context, provider *openai.Provider) {
 			Messages:    messages,
 			Model:       "gpt-4o-mini",
 			MaxTokens:   200,
-			Temperature: 0.1,
+			Temperature: types.Float64(0.1),
 		}
 
 		finalResponse, err := provider.Complete(ctx, finalReq)
@@ -0,0 +1,160 @@
+package aiutil
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif" // Register GIF decoding for downscaling
+	"image/jpeg"
+	"image/png"
+
+	"github.com/ztkent/ai-util/types"
+)
+
+// downscaleJPEGQuality is the quality used when re-encoding downscaled JPEG images
+const downscaleJPEGQuality = 85
+
+// DefaultMaxImagePixels is the largest image, in pixels, that ImagePolicy will decode
+const DefaultMaxImagePixels = 50_000_000
+
+// ImagePolicy controls how images are prepared before they are sent to a provider
+type ImagePolicy struct {
+	// MaxDimension downscales base64 images whose width or height exceeds it, preserving
+	// aspect ratio (0 disables). URL images are sent unchanged.
+	MaxDimension int `json:"max_dimension,omitempty"`
+
+	// ForceDetail sets the detail level ("low", "high", "auto") on every image
+	ForceDetail string `json:"force_detail,omitempty"`
+
+	// MaxPixels rejects base64 images with more pixels than this before they are decoded for
+	// downscaling, so a small file can't expand into a huge bitmap (0 uses DefaultMaxImagePixels)
+	MaxPixels int `json:"max_pixels,omitempty"`
+}
+
+// applyImagePolicy rewrites the request's images according to policy. Messages with images
+// are copied, so the caller's history keeps the originals.
+func applyImagePolicy(req *types.CompletionRequest, policy *ImagePolicy) error {
+	if policy == nil || (policy.MaxDimension <= 0 && policy.ForceDetail == "") {
+		return nil
+	}
+
+	messages := make([]*types.Message, len(req.Messages))
+	for i, msg := range req.Messages {
+		messages[i] = msg
+		if !msg.HasImages() {
+			continue
+		}
+
+		copied := *msg
+		copied.Content = make([]types.MessageContent, len(msg.Content))
+		for j, content := range msg.Content {
+			if img, ok := content.(types.ImageContent); ok {
+				applied, err := policy.apply(img)
+				if err != nil {
+					return err
+				}
+				content = applied
+			}
+			copied.Content[j] = content
+		}
+		messages[i] = &copied
+	}
+	req.Messages = messages
+	return nil
+}
+
+// apply returns the image with the policy applied. Images that can't be decoded are only
+// given the forced detail level; images over the pixel limit are rejected.
+func (p *ImagePolicy) apply(img types.ImageContent) (types.ImageContent, error) {
+	if p.ForceDetail != "" {
+		img.Detail = p.ForceDetail
+	}
+	if p.MaxDimension <= 0 || img.Base64 == "" {
+		return img, nil
+	}
+
+	data, err := base64.StdEncoding.DecodeString(img.Base64)
+	if err != nil {
+		return img, nil
+	}
+
+	// Check the dimensions from the header before decoding the whole image
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return img, nil
+	}
+	if cfg.Width <= p.MaxDimension && cfg.Height <= p.MaxDimension {
+		return img, nil
+	}
+	maxPixels := p.MaxPixels
+	if maxPixels <= 0 {
+		maxPixels = DefaultMaxImagePixels
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > int64(maxPixels) {
+		return img, types.NewError(types.ErrCodeInvalidRequest,
+			fmt.Sprintf("image is %dx%d, more than the %d pixels allowed for downscaling", cfg.Width, cfg.Height, maxPixels), "")
+	}
+
+	src, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return img, nil
+	}
+
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	// Scale the longest side to MaxDimension
+	if width >= height {
+		height = max(1, height*p.MaxDimension/width)
+		width = p.MaxDimension
+	} else {
+		width = max(1, width*p.MaxDimension/height)
+		height = p.MaxDimension
+	}
+
+	var buf bytes.Buffer
+	scaled := downscaleImage(src, width, height)
+	if format == "jpeg" {
+		err = jpeg.Encode(&buf, scaled, &jpeg.Options{Quality: downscaleJPEGQuality})
+		img.MimeType = "image/jpeg"
+	} else {
+		err = png.Encode(&buf, scaled)
+		img.MimeType = "image/png"
+	}
+	if err != nil {
+		return img, nil
+	}
+
+	img.Base64 = base64.StdEncoding.EncodeToString(buf.Bytes())
+	return img, nil
+}
+
+// downscaleImage resizes src to width x height by averaging the source pixels covered by
+// each destination pixel
+func downscaleImage(src image.Image, width, height int) *image.RGBA {
+	bounds := src.Bounds()
+	srcWidth, srcHeight := bounds.Dx(), bounds.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		y0 := bounds.Min.Y + y*srcHeight/height
+		y1 := max(bounds.Min.Y+(y+1)*srcHeight/height, y0+1)
+		for x := 0; x < width; x++ {
+			x0 := bounds.Min.X + x*srcWidth/width
+			x1 := max(bounds.Min.X+(x+1)*srcWidth/width, x0+1)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			dst.Set(x, y, color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(b / n), A: uint16(a / n)})
+		}
+	}
+	return dst
+}
@@ -0,0 +1,98 @@
+package aiutil
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/ztkent/ai-util/types"
+)
+
+func TestClient_ImagePolicyDownscales(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 400, 200))
+	for y := 0; y < 200; y++ {
+		for x := 0; x < 400; x++ {
+			src.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, src); err != nil {
+		t.Fatalf("Failed to encode test image: %v", err)
+	}
+	original := types.ImageContent{
+		Base64:   base64.StdEncoding.EncodeToString(buf.Bytes()),
+		MimeType: "image/png",
+		Detail:   "high",
+	}
+
+	provider := newMockProvider("mock-model")
+	client := newMockClient(t, provider)
+	client.defaultConfig.ImagePolicy = &ImagePolicy{MaxDimension: 100, ForceDetail: "low"}
+
+	msg := types.NewMultiImageMessage(types.RoleUser, "Describe this", original)
+	_, err := client.Complete(context.Background(), &types.CompletionRequest{
+		Model:    "mock-model",
+		Messages: []*types.Message{msg},
+	})
+	if err != nil {
+		t.Fatalf("Complete failed: %v", err)
+	}
+
+	var sent types.ImageContent
+	for _, content := range provider.requests[0].Messages[0].Content {
+		if img, ok := content.(types.ImageContent); ok {
+			sent = img
+		}
+	}
+	data, err := base64.StdEncoding.DecodeString(sent.Base64)
+	if err != nil {
+		t.Fatalf("Failed to decode sent image: %v", err)
+	}
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Failed to decode sent image config: %v", err)
+	}
+	if cfg.Width != 100 || cfg.Height != 50 {
+		t.Errorf("Expected 100x50 image, got %dx%d", cfg.Width, cfg.Height)
+	}
+	if format != "png" || sent.MimeType != "image/png" {
+		t.Errorf("Expected png image, got %s (%s)", format, sent.MimeType)
+	}
+	if sent.Detail != "low" {
+		t.Errorf("Expected detail low, got %s", sent.Detail)
+	}
+
+	// The caller's message keeps the original image
+	if img := msg.Content[1].(types.ImageContent); img.Base64 != original.Base64 || img.Detail != "high" {
+		t.Errorf("Expected original message to be unchanged")
+	}
+}
+
+func TestClient_ImagePolicyRejectsHugeImages(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 400, 200))); err != nil {
+		t.Fatalf("Failed to encode test image: %v", err)
+	}
+	img := types.ImageContent{Base64: base64.StdEncoding.EncodeToString(buf.Bytes()), MimeType: "image/png"}
+
+	provider := newMockProvider("mock-model")
+	client := newMockClient(t, provider)
+	client.defaultConfig.ImagePolicy = &ImagePolicy{MaxDimension: 100, MaxPixels: 10000}
+
+	_, err := client.Complete(context.Background(), &types.CompletionRequest{
+		Model:    "mock-model",
+		Messages: []*types.Message{types.NewMultiImageMessage(types.RoleUser, "Describe this", img)},
+	})
+	var aiErr *types.Error
+	if !errors.As(err, &aiErr) || aiErr.Code != types.ErrCodeInvalidRequest {
+		t.Errorf("Expected an invalid request error for an image over the pixel limit, got %v", err)
+	}
+	if len(provider.requests) != 0 {
+		t.Errorf("Expected the request not to reach the provider, got %d requests", len(provider.requests))
+	}
+}
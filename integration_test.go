@@ -40,7 +40,7 @@ func TestOpenAIIntegration(t *testing.T) {
 			types.NewTextMessage(types.RoleUser, "Say hello"),
 		},
 		MaxTokens:   50,
-		Temperature: 0.7,
+		Temperature: types.Float64(0.7),
 	}
 
 	resp, err := client.Complete(ctx, req)
@@ -79,7 +79,7 @@ func TestReplicateIntegration(t *testing.T) {
 			types.NewTextMessage(types.RoleUser, "Say hello"),
 		},
 		MaxTokens:   50,
-		Temperature: 0.7,
+		Temperature: types.Float64(0.7),
 	}
 
 	resp, err := client.Complete(ctx, req)
@@ -118,7 +118,7 @@ func TestGoogleIntegration(t *testing.T) {
 			types.NewTextMessage(types.RoleUser, "Say hello"),
 		},
 		MaxTokens:   50,
-		Temperature: 0.7,
+		Temperature: types.Float64(0.7),
 	}
 
 	resp, err := client.Complete(ctx, req)
@@ -133,6 +133,98 @@ func TestGoogleIntegration(t *testing.T) {
 	t.Logf("Google Response: %s", resp.Message.GetText())
 }
 
+func TestGoogleSystemInstructionIntegration(t *testing.T) {
+	apiKey := os.Getenv("GOOGLE_API_KEY")
+	if apiKey == "" {
+		t.Skip("GOOGLE_API_KEY not set, skipping integration test")
+	}
+
+	client, err := NewAIClient().
+		WithGoogle(apiKey, "").
+		WithDefaultProvider("google").
+		WithDefaultModel("gemini-2.5-flash").
+		Build()
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	defer client.Close()
+
+	instruction := "Always answer in French, no matter what language the user writes in."
+	question := "What is the capital of Japan? Answer in one short sentence."
+
+	ctx := context.Background()
+	resp, err := client.Complete(ctx, &types.CompletionRequest{
+		Messages: []*types.Message{
+			types.NewTextMessage(types.RoleSystem, instruction),
+			types.NewTextMessage(types.RoleUser, question),
+		},
+		MaxTokens: 200,
+	})
+	if err != nil {
+		t.Fatalf("Completion failed: %v", err)
+	}
+
+	answer := strings.ToLower(resp.Message.GetText())
+	if !strings.Contains(answer, "capitale") && !strings.Contains(answer, " est ") {
+		t.Errorf("Expected a French answer with the system instruction, got %q", answer)
+	}
+
+	// The same instruction inlined as a user turn, as the provider used to send it
+	inlined, err := client.Complete(ctx, &types.CompletionRequest{
+		Messages: []*types.Message{
+			types.NewTextMessage(types.RoleUser, instruction),
+			types.NewTextMessage(types.RoleUser, question),
+		},
+		MaxTokens: 200,
+	})
+	if err != nil {
+		t.Fatalf("Inlined completion failed: %v", err)
+	}
+
+	t.Logf("System instruction response: %s", resp.Message.GetText())
+	t.Logf("Inlined instruction response: %s", inlined.Message.GetText())
+}
+
+func TestGoogleGroundingIntegration(t *testing.T) {
+	apiKey := os.Getenv("GOOGLE_API_KEY")
+	if apiKey == "" {
+		t.Skip("GOOGLE_API_KEY not set, skipping integration test")
+	}
+
+	client, err := NewAIClient().
+		WithGoogle(apiKey, "").
+		WithDefaultProvider("google").
+		WithDefaultModel("gemini-2.5-flash").
+		Build()
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	defer client.Close()
+
+	resp, err := client.Complete(context.Background(), &types.CompletionRequest{
+		Messages: []*types.Message{
+			types.NewTextMessage(types.RoleUser, "What were the top world news headlines today? Cite your sources."),
+		},
+		GroundingTools: []types.GroundingTool{{Type: types.GroundingToolGoogleSearch}},
+	})
+	if err != nil {
+		t.Fatalf("Completion failed: %v", err)
+	}
+
+	grounding, ok := resp.GroundingMetadata()
+	if !ok {
+		t.Fatal("Expected grounding metadata for a search-grounded response")
+	}
+	if len(grounding.WebSearchQueries) == 0 && len(grounding.Sources) == 0 {
+		t.Errorf("Expected search queries or sources in grounding metadata, got %+v", grounding)
+	}
+
+	t.Logf("Search queries: %v", grounding.WebSearchQueries)
+	for _, source := range grounding.Sources {
+		t.Logf("Source: %s (%s)", source.Title, source.URI)
+	}
+}
+
 func TestConversationIntegration(t *testing.T) {
 	apiKey := os.Getenv("OPENAI_API_KEY")
 	if apiKey == "" {
@@ -204,7 +296,7 @@ func TestOpenAIStreamingIntegration(t *testing.T) {
 			types.NewTextMessage(types.RoleUser, "Count from 1 to 5, one number per line"),
 		},
 		MaxTokens:   100,
-		Temperature: 0.1, // Low temperature for predictable output
+		Temperature: types.Float64(0.1), // Low temperature for predictable output
 		Stream:      true,
 	}
 
@@ -278,7 +370,7 @@ func TestGoogleStreamingIntegration(t *testing.T) {
 			types.NewTextMessage(types.RoleUser, "Write a very short haiku about coding"),
 		},
 		MaxTokens:   100,
-		Temperature: 0.3,
+		Temperature: types.Float64(0.3),
 		Stream:      true,
 	}
 
@@ -354,7 +446,7 @@ func TestReplicateStreamingIntegration(t *testing.T) {
 			types.NewTextMessage(types.RoleUser, "Say hello and explain what you are in one sentence"),
 		},
 		MaxTokens:   150,
-		Temperature: 0.2,
+		Temperature: types.Float64(0.2),
 		Stream:      true,
 	}
 
@@ -520,7 +612,7 @@ func TestMultiProviderStreamingComparison(t *testing.T) {
 					types.NewTextMessage(types.RoleUser, prompt),
 				},
 				MaxTokens:   100,
-				Temperature: 0.1,
+				Temperature: types.Float64(0.1),
 				Stream:      true,
 			}
 
@@ -564,7 +656,7 @@ func TestMultiProviderStreamingComparison(t *testing.T) {
 					types.NewTextMessage(types.RoleUser, prompt),
 				},
 				MaxTokens:   100,
-				Temperature: 0.1,
+				Temperature: types.Float64(0.1),
 				Stream:      true,
 			}
 
@@ -608,7 +700,7 @@ func TestMultiProviderStreamingComparison(t *testing.T) {
 					types.NewTextMessage(types.RoleUser, prompt),
 				},
 				MaxTokens:   100,
-				Temperature: 0.1,
+				Temperature: types.Float64(0.1),
 				Stream:      true,
 			}
 
@@ -701,7 +793,7 @@ func TestGoogleJSONModeIntegration(t *testing.T) {
 				types.NewTextMessage(types.RoleUser, `Count the words in this sentence: "Hello world this is a test"`),
 			},
 			MaxTokens:   256,
-			Temperature: 0.1,
+			Temperature: types.Float64(0.1),
 			ResponseFormat: &types.ResponseFormat{
 				Type: "json_object",
 			},
@@ -744,7 +836,7 @@ func TestGoogleJSONModeIntegration(t *testing.T) {
 [{"name": "<language>", "paradigm": "<paradigm>"}]`),
 			},
 			MaxTokens:   300,
-			Temperature: 0.1,
+			Temperature: types.Float64(0.1),
 			ResponseFormat: &types.ResponseFormat{
 				Type: "json_object",
 			},
@@ -780,7 +872,7 @@ func TestGoogleJSONModeIntegration(t *testing.T) {
 				types.NewTextMessage(types.RoleUser, `Return this exact JSON: {"test": true}`),
 			},
 			MaxTokens:   100,
-			Temperature: 0.1,
+			Temperature: types.Float64(0.1),
 			// Note: No ResponseFormat set
 		}
 
@@ -833,7 +925,7 @@ Respond with this JSON structure:
 {"articles": [{"id": 1, "title": "headline", "trending_score": 8.5, "trending_reason": "reason"}], "analysis_summary": "summary"}`),
 		},
 		MaxTokens:   800,
-		Temperature: 0.2,
+		Temperature: types.Float64(0.2),
 		ResponseFormat: &types.ResponseFormat{
 			Type: "json_object",
 		},
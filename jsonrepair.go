@@ -0,0 +1,177 @@
+package aiutil
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+)
+
+// jsonState tracks what an open object or array expects next
+type jsonState int
+
+const (
+	expectKey   jsonState = iota // object: key or '}'
+	expectColon                  // object: ':' after a key
+	expectValue                  // object or array: a value
+	expectNext                   // object or array: ',' or the closing bracket
+)
+
+type jsonFrame struct {
+	object bool
+	state  jsonState
+}
+
+// partialUnicodeEscape matches a \u escape cut off before its four hex digits
+var partialUnicodeEscape = regexp.MustCompile(`\\u[0-9a-fA-F]{0,3}$`)
+
+// RepairJSON makes a best-effort attempt to turn truncated JSON into parseable JSON by
+// closing open strings, completing partial literals, dropping trailing commas, filling
+// missing values with null, and closing open objects and arrays.
+// It returns the repaired JSON and true when a repair was applied, or the input and
+// false when the input is already valid or could not be repaired.
+func RepairJSON(partial string) (string, bool) {
+	trimmed := strings.TrimSpace(partial)
+	if trimmed == "" || json.Valid([]byte(trimmed)) {
+		return partial, false
+	}
+
+	var stack []jsonFrame
+	inString, escaped := false, false
+
+	valueDone := func() {
+		if len(stack) == 0 {
+			return
+		}
+		top := &stack[len(stack)-1]
+		if top.object && top.state == expectKey {
+			top.state = expectColon
+		} else {
+			top.state = expectNext
+		}
+	}
+
+	for i := 0; i < len(trimmed); i++ {
+		c := trimmed[i]
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+				valueDone()
+			}
+			continue
+		}
+
+		switch c {
+		case '"':
+			inString = true
+		case '{':
+			// A nested container is the parent's value
+			valueDone()
+			stack = append(stack, jsonFrame{object: true, state: expectKey})
+		case '[':
+			valueDone()
+			stack = append(stack, jsonFrame{state: expectValue})
+		case '}', ']':
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		case ':':
+			if len(stack) > 0 {
+				stack[len(stack)-1].state = expectValue
+			}
+		case ',':
+			if len(stack) > 0 {
+				top := &stack[len(stack)-1]
+				if top.object {
+					top.state = expectKey
+				} else {
+					top.state = expectValue
+				}
+			}
+		case ' ', '\t', '\n', '\r':
+		default:
+			// Start or continuation of a number or literal
+			if len(stack) > 0 && stack[len(stack)-1].state != expectNext {
+				valueDone()
+			}
+		}
+	}
+
+	var out strings.Builder
+	repaired := trimmed
+
+	if inString {
+		// Drop a dangling escape, then close the string
+		if escaped {
+			repaired = repaired[:len(repaired)-1]
+		}
+		repaired = partialUnicodeEscape.ReplaceAllString(repaired, "")
+		repaired += `"`
+		valueDone()
+	} else {
+		repaired = repairTrailingLiteral(repaired, stack)
+		repaired = strings.TrimRight(repaired, " \t\n\r")
+		if strings.HasSuffix(repaired, ",") {
+			repaired = strings.TrimRight(repaired[:len(repaired)-1], " \t\n\r")
+			if len(stack) > 0 {
+				stack[len(stack)-1].state = expectNext
+			}
+		}
+	}
+	out.WriteString(repaired)
+
+	// Close open containers from the innermost outwards
+	for i := len(stack) - 1; i >= 0; i-- {
+		frame := stack[i]
+		switch frame.state {
+		case expectColon:
+			out.WriteString(":null")
+		case expectValue:
+			if frame.object {
+				out.WriteString("null")
+			}
+		}
+		if frame.object {
+			out.WriteByte('}')
+		} else {
+			out.WriteByte(']')
+		}
+	}
+
+	result := out.String()
+	if !json.Valid([]byte(result)) {
+		return partial, false
+	}
+	return result, true
+}
+
+// repairTrailingLiteral completes a truncated true/false/null literal or trims an incomplete
+// number at the end of the input. If nothing usable remains, the enclosing container is
+// reset to expect a value.
+func repairTrailingLiteral(s string, stack []jsonFrame) string {
+	end := len(s)
+	start := end
+	for start > 0 && strings.IndexByte("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789.+-", s[start-1]) >= 0 {
+		start--
+	}
+	if start == end {
+		return s
+	}
+
+	token := s[start:end]
+	for _, literal := range []string{"true", "false", "null"} {
+		if strings.HasPrefix(literal, token) {
+			return s[:start] + literal
+		}
+	}
+
+	token = strings.TrimRight(token, ".eE+-")
+	if token == "" && len(stack) > 0 {
+		stack[len(stack)-1].state = expectValue
+	}
+	return s[:start] + token
+}
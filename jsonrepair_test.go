@@ -0,0 +1,84 @@
+package aiutil
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/ztkent/ai-util/types"
+)
+
+func TestRepairJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		partial string
+	}{
+		{"unterminated string", `{"name": "Ada Love`},
+		{"missing value", `{"name": "Ada", "age":`},
+		{"dangling key", `{"name": "Ada", "ag`},
+		{"trailing comma", `{"items": [1, 2, 3,`},
+		{"partial literal", `{"active": tr`},
+		{"partial number", `{"score": 12.`},
+		{"nested", `{"user": {"tags": ["a", "b"`},
+		{"array of objects", `[{"id": 1}, {"id": 2, "name": "x`},
+		{"dangling escape", `["line one\`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repaired, ok := RepairJSON(tt.partial)
+			if !ok {
+				t.Fatalf("Expected %q to be repaired", tt.partial)
+			}
+
+			var parsed interface{}
+			if err := json.Unmarshal([]byte(repaired), &parsed); err != nil {
+				t.Errorf("Expected repaired JSON to parse, got %q: %v", repaired, err)
+			}
+		})
+	}
+}
+
+func TestRepairJSON_ValidInput(t *testing.T) {
+	valid := `{"name": "Ada"}`
+	repaired, ok := RepairJSON(valid)
+	if ok || repaired != valid {
+		t.Errorf("Expected valid JSON to be returned unchanged, got %q (repaired=%v)", repaired, ok)
+	}
+}
+
+func TestClient_StreamComplete_RepairsTruncatedJSON(t *testing.T) {
+	provider := newMockProvider("mock-model")
+	provider.stream = func(ctx context.Context, req *types.CompletionRequest, callback types.StreamCallback) error {
+		for _, chunk := range []string{`{"city": "Par`, `is", "tags": ["capi`} {
+			if err := callback(ctx, &types.StreamResponse{Delta: &types.Message{TextData: chunk}}); err != nil {
+				return err
+			}
+		}
+		return callback(ctx, &types.StreamResponse{FinishReason: "length"})
+	}
+	client := newMockClient(t, provider)
+
+	resp, err := client.StreamComplete(context.Background(), &types.CompletionRequest{
+		Model:          "mock-model",
+		Messages:       []*types.Message{types.NewTextMessage(types.RoleUser, "City info as JSON")},
+		ResponseFormat: &types.ResponseFormat{Type: "json_object"},
+	}, nil)
+	if err != nil {
+		t.Fatalf("StreamComplete failed: %v", err)
+	}
+
+	var parsed map[string]interface{}
+	if err := json.Unmarshal([]byte(resp.Message.GetText()), &parsed); err != nil {
+		t.Fatalf("Expected repaired JSON to parse, got %q: %v", resp.Message.GetText(), err)
+	}
+	if parsed["city"] != "Paris" {
+		t.Errorf("Expected city 'Paris', got %v", parsed["city"])
+	}
+	if resp.Metadata[types.MetadataKeyJSONRepaired] != true {
+		t.Error("Expected repair to be flagged in response metadata")
+	}
+	if id, _ := resp.Metadata[types.MetadataKeyRequestID].(string); id == "" {
+		t.Errorf("Expected the request ID to be kept alongside the repair flag, got %v", resp.Metadata)
+	}
+}
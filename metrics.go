@@ -0,0 +1,128 @@
+package aiutil
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ztkent/ai-util/types"
+)
+
+// defaultMetricsCapacity is the number of usage events retained when no capacity is given
+const defaultMetricsCapacity = 10000
+
+// UsageEvent records the token usage and estimated cost of a single completion
+type UsageEvent struct {
+	Time     time.Time   `json:"time"`
+	Model    string      `json:"model"`
+	Provider string      `json:"provider"`
+	Usage    types.Usage `json:"usage"`
+	Cost     float64     `json:"cost"`
+}
+
+// MetricsMiddleware records timestamped usage for each completion in a ring buffer, so usage
+// and cost can be queried over recent time windows. Once the buffer is full, the oldest
+// events are overwritten. Costs are estimated from the per-1M-token prices in Pricing.
+type MetricsMiddleware struct {
+	Pricing map[string]*types.Model // Model ID -> model with InputCost/OutputCost
+
+	mu     sync.RWMutex
+	events []UsageEvent
+	next   int
+	count  int
+	clock  func() time.Time
+}
+
+// NewMetricsMiddleware creates a metrics middleware retaining up to capacity usage events
+func NewMetricsMiddleware(capacity int) *MetricsMiddleware {
+	if capacity <= 0 {
+		capacity = defaultMetricsCapacity
+	}
+	return &MetricsMiddleware{
+		Pricing: make(map[string]*types.Model),
+		events:  make([]UsageEvent, capacity),
+	}
+}
+
+func (m *MetricsMiddleware) ProcessRequest(ctx context.Context, req *types.CompletionRequest) (*types.CompletionRequest, error) {
+	return req, nil
+}
+
+func (m *MetricsMiddleware) ProcessResponse(ctx context.Context, resp *types.CompletionResponse) (*types.CompletionResponse, error) {
+	if resp.Usage != nil {
+		m.Record(resp.Model, resp.Provider, *resp.Usage)
+	}
+	return resp, nil
+}
+
+// Record adds a usage event for a completion
+func (m *MetricsMiddleware) Record(model, provider string, usage types.Usage) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.events) == 0 {
+		m.events = make([]UsageEvent, defaultMetricsCapacity)
+	}
+
+	m.events[m.next] = UsageEvent{
+		Time:     m.now(),
+		Model:    model,
+		Provider: provider,
+		Usage:    usage,
+		Cost:     m.cost(model, usage),
+	}
+	m.next = (m.next + 1) % len(m.events)
+	if m.count < len(m.events) {
+		m.count++
+	}
+}
+
+// UsageSince returns the total token usage recorded at or after t
+func (m *MetricsMiddleware) UsageSince(t time.Time) types.Usage {
+	var total types.Usage
+	m.eachSince(t, func(event UsageEvent) {
+		total.PromptTokens += event.Usage.PromptTokens
+		total.CompletionTokens += event.Usage.CompletionTokens
+		total.TotalTokens += event.Usage.TotalTokens
+	})
+	return total
+}
+
+// CostSince returns the total estimated cost recorded at or after t
+func (m *MetricsMiddleware) CostSince(t time.Time) float64 {
+	var total float64
+	m.eachSince(t, func(event UsageEvent) {
+		total += event.Cost
+	})
+	return total
+}
+
+// eachSince calls fn for each retained event recorded at or after t
+func (m *MetricsMiddleware) eachSince(t time.Time, fn func(UsageEvent)) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for i := 0; i < m.count; i++ {
+		event := m.events[i]
+		if !event.Time.Before(t) {
+			fn(event)
+		}
+	}
+}
+
+// cost estimates the cost of usage from the model's per-1M-token prices
+func (m *MetricsMiddleware) cost(model string, usage types.Usage) float64 {
+	pricing, ok := m.Pricing[model]
+	if !ok || pricing == nil {
+		return 0
+	}
+	return (&types.CompletionResponse{Usage: &usage}).EstimateCost(pricing)
+}
+
+// now returns the current time from the middleware clock
+func (m *MetricsMiddleware) now() time.Time {
+	if m.clock != nil {
+		return m.clock()
+	}
+	return time.Now()
+}
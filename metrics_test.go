@@ -0,0 +1,58 @@
+package aiutil
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/ztkent/ai-util/types"
+)
+
+func TestMetricsMiddleware_UsageSince(t *testing.T) {
+	metrics := NewMetricsMiddleware(3)
+	metrics.Pricing["mock-model"] = &types.Model{ID: "mock-model", InputCost: 1.0, OutputCost: 2.0}
+
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	now := start
+	metrics.clock = func() time.Time { return now }
+
+	ctx := context.Background()
+	record := func(prompt, completion int) {
+		metrics.ProcessResponse(ctx, &types.CompletionResponse{
+			Model: "mock-model",
+			Usage: &types.Usage{PromptTokens: prompt, CompletionTokens: completion, TotalTokens: prompt + completion},
+		})
+	}
+
+	record(1000, 500) // 12:00
+	now = start.Add(time.Hour)
+	record(2000, 1000) // 13:00
+	now = start.Add(2 * time.Hour)
+	record(4000, 2000) // 14:00
+
+	usage := metrics.UsageSince(start.Add(30 * time.Minute))
+	if usage.PromptTokens != 6000 || usage.CompletionTokens != 3000 || usage.TotalTokens != 9000 {
+		t.Errorf("Expected usage of the last two events, got %+v", usage)
+	}
+
+	// (6000 * $1 + 3000 * $2) / 1M tokens
+	if cost := metrics.CostSince(start.Add(30 * time.Minute)); math.Abs(cost-0.012) > 1e-9 {
+		t.Errorf("Expected cost 0.012, got %f", cost)
+	}
+
+	if usage := metrics.UsageSince(start); usage.TotalTokens != 10500 {
+		t.Errorf("Expected total usage of 10500 tokens, got %d", usage.TotalTokens)
+	}
+
+	// The ring buffer holds 3 events, so a fourth overwrites the oldest
+	now = start.Add(3 * time.Hour)
+	record(100, 100)
+	if usage := metrics.UsageSince(start); usage.TotalTokens != 9200 {
+		t.Errorf("Expected the oldest event to be evicted, got %d total tokens", usage.TotalTokens)
+	}
+
+	if usage := metrics.UsageSince(start.Add(4 * time.Hour)); usage.TotalTokens != 0 {
+		t.Errorf("Expected no usage in an empty window, got %d", usage.TotalTokens)
+	}
+}
@@ -0,0 +1,38 @@
+package google
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+
+	"github.com/ztkent/ai-util/types"
+	"google.golang.org/genai"
+)
+
+// inputTokenLimitPattern matches the error for prompts over the input limit, e.g. "The input
+// token count (1048577) exceeds the maximum number of tokens allowed (1048576)."
+var inputTokenLimitPattern = regexp.MustCompile(`input token count \((\d+)\) exceeds the maximum number of tokens allowed \((\d+)\)`)
+
+// wrapAPIError wraps an error from the Gemini API. Prompts over the input token limit are
+// reported as ErrCodeTokenLimitExceeded with token details. The limit applies to input
+// tokens only, so max_tokens is reported as 0.
+func wrapAPIError(err error) *types.Error {
+	var apiErr genai.APIError
+	if !errors.As(err, &apiErr) {
+		return types.WrapRequestError(err, "google")
+	}
+
+	match := inputTokenLimitPattern.FindStringSubmatch(apiErr.Message)
+	if match == nil {
+		return types.WrapRequestError(err, "google")
+	}
+	prompt, promptErr := strconv.Atoi(match[1])
+	limit, limitErr := strconv.Atoi(match[2])
+	if promptErr != nil || limitErr != nil {
+		return types.WrapError(err, types.ErrCodeTokenLimitExceeded, "google")
+	}
+
+	tokenErr := types.NewTokenLimitError(prompt, 0, limit, "google")
+	tokenErr.Cause = err
+	return tokenErr
+}
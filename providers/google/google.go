@@ -4,16 +4,24 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"log/slog"
+	"net/http"
 	"strings"
+	"sync"
 
+	"github.com/google/uuid"
 	"github.com/ztkent/ai-util/types"
 	"google.golang.org/genai"
 )
 
 // Provider implements the Google AI provider
 type Provider struct {
-	config *Config
-	client *genai.Client
+	config     *Config
+	client     *genai.Client
+	httpClient *http.Client // Shared by the genai client and image URL fetches
+
+	counterMu    sync.RWMutex
+	tokenCounter types.TokenCounter
 }
 
 // Config holds Google AI-specific configuration
@@ -21,8 +29,35 @@ type Config struct {
 	types.BaseConfig
 	ProjectID string `json:"project_id,omitempty"`
 	Location  string `json:"location,omitempty"`
+
+	// ToolTextAsUser sends tool messages without a ToolResult as user text, instead of
+	// coercing them into a function response for the preceding tool call
+	ToolTextAsUser bool `json:"tool_text_as_user,omitempty"`
+
+	// SystemMessages controls how system messages after the start of the conversation are
+	// handled. They are always moved into the system instruction; by default with a warning.
+	SystemMessages SystemMessageMode `json:"system_messages,omitempty"`
+
+	// MaxImageBytes limits the size of images fetched from URLs; 0 uses DefaultMaxImageBytes
+	MaxImageBytes int64 `json:"max_image_bytes,omitempty"`
+
+	// SystemPlacements overrides where the system prompt is sent for specific models, keyed
+	// by model ID. Models without an entry use the system instruction.
+	SystemPlacements map[string]types.SystemPlacement `json:"system_placements,omitempty"`
 }
 
+// SystemMessageMode controls how out-of-place system messages are handled
+type SystemMessageMode string
+
+const (
+	SystemMessagesWarn   SystemMessageMode = ""       // Move them into the system instruction and log a warning (default)
+	SystemMessagesQuiet  SystemMessageMode = "quiet"  // Move them into the system instruction silently
+	SystemMessagesStrict SystemMessageMode = "strict" // Reject the request
+)
+
+// maxTemperature is the highest temperature Gemini models accept
+const maxTemperature = 2.0
+
 // NewProvider creates a new Google AI provider
 func NewProvider() *Provider {
 	return &Provider{}
@@ -46,9 +81,11 @@ func (p *Provider) Initialize(config types.Config) error {
 
 	// Initialize Google AI client
 	ctx := context.Background()
+	httpClient := &http.Client{Transport: googleConfig.HTTPTransport()}
 	client, err := genai.NewClient(ctx, &genai.ClientConfig{
-		APIKey:  googleConfig.APIKey,
-		Backend: genai.BackendGeminiAPI,
+		APIKey:     googleConfig.APIKey,
+		Backend:    genai.BackendGeminiAPI,
+		HTTPClient: httpClient,
 	})
 	if err != nil {
 		return types.WrapError(err, types.ErrCodeAuthentication, "google")
@@ -56,6 +93,7 @@ func (p *Provider) Initialize(config types.Config) error {
 
 	p.config = googleConfig
 	p.client = client
+	p.httpClient = httpClient
 
 	return nil
 }
@@ -66,6 +104,37 @@ func (p *Provider) GetModels(ctx context.Context) ([]*types.Model, error) {
 		return nil, types.NewError(types.ErrCodeInvalidConfig, "provider not initialized", "google")
 	}
 
+	return p.providerModels(), nil
+}
+
+// providerModels returns the models this provider offers, with each model's configured
+// system placement recorded in its Metadata
+func (p *Provider) providerModels() []*types.Model {
+	models := supportedModels()
+	for _, m := range models {
+		if placement, ok := p.config.SystemPlacements[m.ID]; ok {
+			if m.Metadata == nil {
+				m.Metadata = make(map[string]interface{})
+			}
+			m.Metadata[types.ModelMetadataSystemPlacement] = placement
+		}
+	}
+	return models
+}
+
+// systemPlacement returns the system prompt placement configured for a model, if any.
+// It reads the provider config rather than registered models, which callers may modify.
+func (p *Provider) systemPlacement(model string) types.SystemPlacement {
+	return p.config.SystemPlacements[model]
+}
+
+// SupportedCapabilities returns the capabilities offered by any of the provider's models
+func (p *Provider) SupportedCapabilities() []types.ModelCapability {
+	return types.CapabilitiesOf(supportedModels())
+}
+
+// supportedModels returns the models this provider offers
+func supportedModels() []*types.Model {
 	// Return a list of current Google AI models based on official documentation
 	models := []*types.Model{
 		// Gemini 3.0 series - Next generation reasoning
@@ -75,6 +144,8 @@ func (p *Provider) GetModels(ctx context.Context) ([]*types.Model, error) {
 			Provider:    "google",
 			Description: "The most capable AI model, built for the future of reasoning and coding",
 			MaxTokens:   4000000,
+			InputCost:   2.00,
+			OutputCost:  12.00,
 			Capabilities: []string{
 				string(types.CapabilityChat),
 				string(types.CapabilityStreaming),
@@ -92,6 +163,8 @@ func (p *Provider) GetModels(ctx context.Context) ([]*types.Model, error) {
 			Provider:    "google",
 			Description: "Ultra-fast, low latency model with advanced reasoning capabilities",
 			MaxTokens:   2000000,
+			InputCost:   0.50,
+			OutputCost:  3.00,
 			Capabilities: []string{
 				string(types.CapabilityChat),
 				string(types.CapabilityStreaming),
@@ -110,6 +183,8 @@ func (p *Provider) GetModels(ctx context.Context) ([]*types.Model, error) {
 			Provider:    "google",
 			Description: "Most powerful thinking model with maximum response accuracy and state-of-the-art performance",
 			MaxTokens:   2000000,
+			InputCost:   1.25,
+			OutputCost:  10.00,
 			Capabilities: []string{
 				string(types.CapabilityChat),
 				string(types.CapabilityStreaming),
@@ -127,6 +202,8 @@ func (p *Provider) GetModels(ctx context.Context) ([]*types.Model, error) {
 			Provider:    "google",
 			Description: "Best model in terms of price-performance with adaptive thinking capabilities",
 			MaxTokens:   1000000,
+			InputCost:   0.30,
+			OutputCost:  2.50,
 			Capabilities: []string{
 				string(types.CapabilityChat),
 				string(types.CapabilityStreaming),
@@ -144,6 +221,8 @@ func (p *Provider) GetModels(ctx context.Context) ([]*types.Model, error) {
 			Provider:    "google",
 			Description: "Most cost-efficient model optimized for high throughput and low latency",
 			MaxTokens:   1000000,
+			InputCost:   0.10,
+			OutputCost:  0.40,
 			Capabilities: []string{
 				string(types.CapabilityChat),
 				string(types.CapabilityStreaming),
@@ -160,6 +239,8 @@ func (p *Provider) GetModels(ctx context.Context) ([]*types.Model, error) {
 			Provider:    "google",
 			Description: "Low latency, controllable text-to-speech audio generation",
 			MaxTokens:   1000000,
+			InputCost:   0.50,
+			OutputCost:  10.00,
 			Capabilities: []string{
 				string(types.CapabilityTTS),
 				string(types.CapabilityJSON),
@@ -171,6 +252,8 @@ func (p *Provider) GetModels(ctx context.Context) ([]*types.Model, error) {
 			Provider:    "google",
 			Description: "High-quality text-to-speech with single and multi-speaker support",
 			MaxTokens:   2000000,
+			InputCost:   1.00,
+			OutputCost:  20.00,
 			Capabilities: []string{
 				string(types.CapabilityTTS),
 				string(types.CapabilityJSON),
@@ -311,7 +394,7 @@ func (p *Provider) GetModels(ctx context.Context) ([]*types.Model, error) {
 		},
 	}
 
-	return models, nil
+	return models
 }
 
 // Complete performs a completion request
@@ -324,101 +407,29 @@ func (p *Provider) Complete(ctx context.Context, req *types.CompletionRequest) (
 		return nil, types.NewError(types.ErrCodeInvalidConfig, "Google AI client not initialized", "google")
 	}
 
-	// Convert messages to content format
-	var contents []*genai.Content
-	for _, msg := range req.Messages {
-		text := msg.GetText()
-		if text != "" {
-			var role genai.Role
-			switch msg.Role {
-			case types.RoleUser:
-				role = genai.RoleUser
-			case types.RoleAssistant:
-				role = genai.RoleModel
-			case types.RoleSystem:
-				role = genai.RoleUser // System messages are treated as user messages in Gemini
-			default:
-				role = genai.RoleUser
-			}
+	ctx, cancel := p.config.WithRequestTimeout(ctx)
+	defer cancel()
 
-			content := genai.NewContentFromText(text, role)
-			contents = append(contents, content)
-		}
+	// Convert messages to content format, passing system messages as the system instruction
+	if err := checkSystemMessages(req.Messages, p.config.SystemMessages); err != nil {
+		return nil, err
+	}
+	messages, err := p.inlineImageURLs(ctx, req.Messages)
+	if err != nil {
+		return nil, err
+	}
+	contents, systemInstruction, err := convertMessages(messages, p.config.ToolTextAsUser)
+	if err != nil {
+		return nil, err
 	}
+	contents, systemInstruction = placeSystemPrompt(contents, systemInstruction, p.systemPlacement(req.Model))
 
 	// Create generation config
-	var config *genai.GenerateContentConfig
-	needsConfig := req.MaxTokens > 0 || req.Temperature > 0 || req.TopP > 0 || req.TopK > 0 || len(req.Tools) > 0 || len(req.GroundingTools) > 0 || req.ResponseFormat != nil
-	if needsConfig {
-		config = &genai.GenerateContentConfig{}
-
-		// Set generation parameters
-		if req.MaxTokens > 0 {
-			config.MaxOutputTokens = int32(req.MaxTokens)
-		}
-		if req.Temperature > 0 {
-			temp := float32(req.Temperature)
-			config.Temperature = &temp
-		}
-		if req.TopP > 0 {
-			topP := float32(req.TopP)
-			config.TopP = &topP
-		}
-		if req.TopK > 0 {
-			topK := float32(req.TopK)
-			config.TopK = &topK
-		}
-
-		// Add function tools if present
-		var tools []*genai.Tool
-		if len(req.Tools) > 0 {
-			for _, tool := range req.Tools {
-				funcDecl := &genai.FunctionDeclaration{
-					Name:        tool.Function.Name,
-					Description: tool.Function.Description,
-					Parameters:  convertJSONSchemaToGeminiSchema(tool.Function.Parameters),
-				}
-				tools = append(tools, &genai.Tool{
-					FunctionDeclarations: []*genai.FunctionDeclaration{funcDecl},
-				})
-			}
-		}
-
-		// Add grounding tools if present (Google-specific: URL context, Google Search)
-		if len(req.GroundingTools) > 0 {
-			for _, gt := range req.GroundingTools {
-				switch gt.Type {
-				case types.GroundingToolURLContext:
-					tools = append(tools, &genai.Tool{
-						URLContext: &genai.URLContext{},
-					})
-				case types.GroundingToolGoogleSearch:
-					tools = append(tools, &genai.Tool{
-						GoogleSearch: &genai.GoogleSearch{},
-					})
-				}
-			}
-		}
-
-		if req.ThinkingConfig != nil {
-			config.ThinkingConfig = &genai.ThinkingConfig{
-				IncludeThoughts: req.ThinkingConfig.IncludeThoughts,
-				ThinkingBudget:  req.ThinkingConfig.ThinkingBudget,
-			}
-		}
-
-		if len(tools) > 0 {
-			config.Tools = tools
-		}
-
-		// Set JSON response format if requested
-		if req.ResponseFormat != nil && req.ResponseFormat.Type == "json_object" {
-			config.ResponseMIMEType = "application/json"
-			if req.ResponseFormat.Schema != nil {
-				config.ResponseSchema = convertJSONSchemaToGeminiSchema(req.ResponseFormat.Schema)
-			}
-		}
+	config, err := generationConfig(req, systemInstruction, req.N)
+	if err != nil {
+		return nil, err
 	}
+
 	// Generate content using the correct API
 	result, err := p.client.Models.GenerateContent(
 		ctx,
@@ -427,24 +438,20 @@ func (p *Provider) Complete(ctx context.Context, req *types.CompletionRequest) (
 		config,
 	)
 	if err != nil {
-		return nil, types.WrapError(err, types.ErrCodeServerError, "google")
+		return nil, wrapAPIError(err)
 	}
 
-	// Extract response text
-	responseText := result.Text()
-
-	// Create the message
-	message := &types.Message{
-		Role:     types.RoleAssistant,
-		TextData: responseText,
+	// Create a message per candidate, with the first as the response message
+	message := &types.Message{Role: types.RoleAssistant}
+	var choices []*types.Message
+	for _, candidate := range result.Candidates {
+		choices = append(choices, p.candidateMessage(candidate))
 	}
-
-	// Handle tool calls if present
-	if len(result.Candidates) > 0 {
-		toolCalls := p.handleToolCalls(result.Candidates)
-		if len(toolCalls) > 0 {
-			message.ToolCalls = toolCalls
-		}
+	if len(choices) > 0 {
+		message = choices[0]
+	}
+	if len(choices) < 2 {
+		choices = nil
 	}
 
 	// Convert usage information if available
@@ -463,10 +470,15 @@ func (p *Provider) Complete(ctx context.Context, req *types.CompletionRequest) (
 		finishReason = string(result.Candidates[0].FinishReason)
 	}
 
-	// Generate a simple ID
-	responseID := fmt.Sprintf("google-completion-%d", len(responseText))
-	if usage != nil {
-		responseID = fmt.Sprintf("google-%d", usage.TotalTokens)
+	// Prefer the API response ID, falling back to a generated one
+	responseID := result.ResponseID
+	if responseID == "" {
+		responseID = newResponseID()
+	}
+
+	var metadata map[string]interface{}
+	if len(result.Candidates) > 0 {
+		metadata = candidateMetadata(result.Candidates[0])
 	}
 
 	return &types.CompletionResponse{
@@ -474,11 +486,28 @@ func (p *Provider) Complete(ctx context.Context, req *types.CompletionRequest) (
 		Model:        req.Model,
 		Provider:     "google",
 		Message:      message,
+		Choices:      choices,
 		FinishReason: finishReason,
 		Usage:        usage,
+		Metadata:     metadata,
 	}, nil
 }
 
+// candidateMessage converts a response candidate into a message with its text, tool calls,
+// and thought blocks
+func (p *Provider) candidateMessage(candidate *genai.Candidate) *types.Message {
+	single := &genai.GenerateContentResponse{Candidates: []*genai.Candidate{candidate}}
+	message := &types.Message{
+		Role:     types.RoleAssistant,
+		TextData: single.Text(),
+	}
+	if toolCalls := p.handleToolCalls(single.Candidates); len(toolCalls) > 0 {
+		message.ToolCalls = toolCalls
+	}
+	message.Content = contentBlocks(candidate)
+	return message
+}
+
 // Stream performs a streaming completion request
 func (p *Provider) Stream(ctx context.Context, req *types.CompletionRequest, callback types.StreamCallback) error {
 	if p.config == nil {
@@ -489,111 +518,31 @@ func (p *Provider) Stream(ctx context.Context, req *types.CompletionRequest, cal
 		return types.NewError(types.ErrCodeInvalidConfig, "Google AI client not initialized", "google")
 	}
 
-	// Convert messages to content format for streaming
-	var contents []*genai.Content
-	for _, msg := range req.Messages {
-		text := msg.GetText()
-		if text != "" {
-			var role genai.Role
-			switch msg.Role {
-			case types.RoleUser:
-				role = genai.RoleUser
-			case types.RoleAssistant:
-				role = genai.RoleModel
-			case types.RoleSystem:
-				role = genai.RoleUser // System messages are treated as user messages in Gemini
-			default:
-				role = genai.RoleUser
-			}
+	callback = types.RecoverCallback(callback, "google")
 
-			content := genai.NewContentFromText(text, role)
-			contents = append(contents, content)
-		}
+	// Convert messages to content format, passing system messages as the system instruction
+	if err := checkSystemMessages(req.Messages, p.config.SystemMessages); err != nil {
+		return err
 	}
+	messages, err := p.inlineImageURLs(ctx, req.Messages)
+	if err != nil {
+		return err
+	}
+	contents, systemInstruction, err := convertMessages(messages, p.config.ToolTextAsUser)
+	if err != nil {
+		return err
+	}
+	contents, systemInstruction = placeSystemPrompt(contents, systemInstruction, p.systemPlacement(req.Model))
 
-	// Create generation config
-	var config *genai.GenerateContentConfig
-	needsConfig := req.MaxTokens > 0 || req.Temperature > 0 || req.TopP > 0 || req.TopK > 0 || len(req.Tools) > 0 || len(req.GroundingTools) > 0 || req.ResponseFormat != nil
-	if needsConfig {
-		config = &genai.GenerateContentConfig{}
-
-		// Set generation parameters
-		if req.MaxTokens > 0 {
-			config.MaxOutputTokens = int32(req.MaxTokens)
-		}
-		if req.Temperature > 0 {
-			temp := float32(req.Temperature)
-			config.Temperature = &temp
-		}
-		if req.TopP > 0 {
-			topP := float32(req.TopP)
-			config.TopP = &topP
-		}
-		if req.TopK > 0 {
-			topK := float32(req.TopK)
-			config.TopK = &topK
-		}
-
-		// Add function tools if present
-		var tools []*genai.Tool
-		if len(req.Tools) > 0 {
-			for _, tool := range req.Tools {
-				funcDecl := &genai.FunctionDeclaration{
-					Name:        tool.Function.Name,
-					Description: tool.Function.Description,
-					Parameters:  convertJSONSchemaToGeminiSchema(tool.Function.Parameters),
-				}
-				tools = append(tools, &genai.Tool{
-					FunctionDeclarations: []*genai.FunctionDeclaration{funcDecl},
-				})
-			}
-		}
-
-		// Add grounding tools if present (Google-specific: URL context, Google Search)
-		if len(req.GroundingTools) > 0 {
-			for _, gt := range req.GroundingTools {
-				switch gt.Type {
-				case types.GroundingToolURLContext:
-					tools = append(tools, &genai.Tool{
-						URLContext: &genai.URLContext{},
-					})
-				case types.GroundingToolGoogleSearch:
-					tools = append(tools, &genai.Tool{
-						GoogleSearch: &genai.GoogleSearch{},
-					})
-				}
-			}
-		}
-
-		if len(tools) > 0 {
-			config.Tools = tools
-		}
-
-		// Set JSON response format if requested
-		if req.ResponseFormat != nil && req.ResponseFormat.Type == "json_object" {
-			config.ResponseMIMEType = "application/json"
-			if req.ResponseFormat.Schema != nil {
-				config.ResponseSchema = convertJSONSchemaToGeminiSchema(req.ResponseFormat.Schema)
-			}
-		}
-
-		// Disable thinking for faster responses by default
-		// thinkingBudget := int32(0)
-		// config.ThinkingConfig = &genai.ThinkingConfig{
-		// 	ThinkingBudget: &thinkingBudget,
-		// }
-	} else {
-		// Default config with thinking disabled
-		// thinkingBudget := int32(0)
-		// config = &genai.GenerateContentConfig{
-		// 	ThinkingConfig: &genai.ThinkingConfig{
-		// 		ThinkingBudget: &thinkingBudget,
-		// 	},
-		// }
+	// Create generation config; streams carry a single candidate
+	config, err := generationConfig(req, systemInstruction, 1)
+	if err != nil {
+		return err
 	}
 
 	// Generate streaming content using the iterator
-	responseID := fmt.Sprintf("google-stream-%d", len(req.Messages))
+	// The response ID is fixed on the first chunk so it stays stable for the whole stream
+	responseID := ""
 	var fullResponse strings.Builder
 	var lastUsage *types.Usage
 
@@ -602,7 +551,14 @@ func (p *Provider) Stream(ctx context.Context, req *types.CompletionRequest, cal
 
 	for response, err := range stream {
 		if err != nil {
-			return types.WrapError(err, types.ErrCodeServerError, "google")
+			return wrapAPIError(err)
+		}
+
+		if responseID == "" {
+			responseID = response.ResponseID
+			if responseID == "" {
+				responseID = newResponseID()
+			}
 		}
 
 		// Extract text from this chunk
@@ -636,6 +592,16 @@ func (p *Provider) Stream(ctx context.Context, req *types.CompletionRequest, cal
 			FinishReason: finishReason,
 			Usage:        lastUsage,
 		}
+		if len(response.Candidates) > 0 {
+			streamResp.Metadata = candidateMetadata(response.Candidates[0])
+		}
+
+		// Gemini streams function calls whole rather than as fragments
+		if len(response.Candidates) > 0 {
+			if toolCalls := p.handleToolCalls(response.Candidates); len(toolCalls) > 0 {
+				streamResp.Delta.ToolCalls = toolCalls
+			}
+		}
 
 		if err := callback(ctx, streamResp); err != nil {
 			return err
@@ -650,16 +616,24 @@ func (p *Provider) Stream(ctx context.Context, req *types.CompletionRequest, cal
 	return nil
 }
 
-// EstimateTokens estimates token count for messages
+// EstimateTokens estimates token count for messages with the provider's token counter,
+// ~4 characters per token unless another is set
 func (p *Provider) EstimateTokens(ctx context.Context, messages []*types.Message, model string) (int, error) {
-	// Simple estimation for Google models
-	totalTokens := 0
-	for _, msg := range messages {
-		text := msg.GetText()
-		// Rough estimation: ~4 characters per token
-		totalTokens += len(text) / 4
+	p.counterMu.RLock()
+	counter := p.tokenCounter
+	p.counterMu.RUnlock()
+
+	if counter == nil {
+		counter = types.ApproxTokenCounter{}
 	}
-	return totalTokens, nil
+	return counter.Count(messages, model)
+}
+
+// SetTokenCounter replaces the token counter used by EstimateTokens; nil restores the default
+func (p *Provider) SetTokenCounter(tc types.TokenCounter) {
+	p.counterMu.Lock()
+	defer p.counterMu.Unlock()
+	p.tokenCounter = tc
 }
 
 // ValidateModel checks if a model is supported
@@ -717,9 +691,129 @@ func (c *Config) Validate() error {
 		c.Location = "us-central1" // Default location
 	}
 
+	for model, placement := range c.SystemPlacements {
+		switch placement {
+		case types.SystemPlacementInstruction, types.SystemPlacementFirstMessage, types.SystemPlacementPrependUser:
+		default:
+			return types.NewError(types.ErrCodeInvalidConfig,
+				fmt.Sprintf("unknown system placement %q for model %s", placement, model), "google")
+		}
+	}
+
 	return nil
 }
 
+// candidateMetadata converts grounding, safety, and citation data into standard response metadata
+func candidateMetadata(candidate *genai.Candidate) map[string]interface{} {
+	if candidate == nil {
+		return nil
+	}
+
+	metadata := make(map[string]interface{})
+
+	if gm := candidate.GroundingMetadata; gm != nil {
+		grounding := &types.GroundingMetadata{
+			WebSearchQueries: gm.WebSearchQueries,
+		}
+		for _, chunk := range gm.GroundingChunks {
+			if chunk != nil && chunk.Web != nil {
+				grounding.Sources = append(grounding.Sources, types.GroundingSource{
+					URI:    chunk.Web.URI,
+					Title:  chunk.Web.Title,
+					Domain: chunk.Web.Domain,
+				})
+			}
+		}
+		metadata[types.MetadataKeyGrounding] = grounding
+	}
+
+	if len(candidate.SafetyRatings) > 0 {
+		ratings := make([]types.SafetyRating, 0, len(candidate.SafetyRatings))
+		for _, rating := range candidate.SafetyRatings {
+			if rating == nil {
+				continue
+			}
+			ratings = append(ratings, types.SafetyRating{
+				Category:    string(rating.Category),
+				Probability: string(rating.Probability),
+				Score:       float64(rating.ProbabilityScore),
+				Blocked:     rating.Blocked,
+			})
+		}
+		metadata[types.MetadataKeySafetyRatings] = ratings
+	}
+
+	if candidate.CitationMetadata != nil && len(candidate.CitationMetadata.Citations) > 0 {
+		citations := make([]types.Citation, 0, len(candidate.CitationMetadata.Citations))
+		for _, citation := range candidate.CitationMetadata.Citations {
+			if citation == nil {
+				continue
+			}
+			citations = append(citations, types.Citation{
+				URI:        citation.URI,
+				Title:      citation.Title,
+				License:    citation.License,
+				StartIndex: int(citation.StartIndex),
+				EndIndex:   int(citation.EndIndex),
+			})
+		}
+		metadata[types.MetadataKeyCitations] = citations
+	}
+
+	if thoughts := candidateThoughts(candidate); thoughts != "" {
+		metadata[types.MetadataKeyThoughts] = thoughts
+	}
+
+	if len(metadata) == 0 {
+		return nil
+	}
+	return metadata
+}
+
+// candidateThoughts returns the text of the candidate's thought parts, which Text() omits
+func candidateThoughts(candidate *genai.Candidate) string {
+	if candidate == nil || candidate.Content == nil {
+		return ""
+	}
+
+	var thoughts strings.Builder
+	for _, part := range candidate.Content.Parts {
+		if part != nil && part.Thought {
+			thoughts.WriteString(part.Text)
+		}
+	}
+	return thoughts.String()
+}
+
+// applyThinkingConfig sets the request's thinking config, rejecting models that are known not
+// to support thinking
+func applyThinkingConfig(config *genai.GenerateContentConfig, req *types.CompletionRequest) (*genai.GenerateContentConfig, error) {
+	if req.ThinkingConfig == nil {
+		return config, nil
+	}
+
+	for _, model := range supportedModels() {
+		if model.ID == req.Model && !model.HasCapability(types.CapabilityThinking) {
+			return nil, types.NewError(types.ErrCodeInvalidRequest,
+				fmt.Sprintf("model %s does not support thinking", req.Model), "google")
+		}
+	}
+
+	if config == nil {
+		config = &genai.GenerateContentConfig{}
+	}
+	config.ThinkingConfig = &genai.ThinkingConfig{
+		IncludeThoughts: req.ThinkingConfig.IncludeThoughts,
+		ThinkingBudget:  req.ThinkingConfig.ThinkingBudget,
+	}
+	return config, nil
+}
+
+// newResponseID generates a unique response ID for responses without one
+func newResponseID() string {
+	return "google-" + uuid.New().String()
+}
+
 // convertJSONSchemaToGeminiSchema converts a JSON schema to Gemini schema format
 func convertJSONSchemaToGeminiSchema(schema interface{}) *genai.Schema {
 	if schema == nil {
@@ -802,6 +896,329 @@ func convertJSONSchemaToGeminiSchema(schema interface{}) *genai.Schema {
 	return geminiSchema
 }
 
+// generationConfig builds the generation config for a request. Parameters are only set when
+// the request provides them, so an explicit temperature of 0 is sent rather than dropped.
+func generationConfig(req *types.CompletionRequest, systemInstruction *genai.Content, candidates int) (*genai.GenerateContentConfig, error) {
+	config := &genai.GenerateContentConfig{SystemInstruction: systemInstruction}
+
+	// Set generation parameters
+	if req.MaxTokens > 0 {
+		config.MaxOutputTokens = int32(req.MaxTokens)
+	}
+	if candidates > 1 {
+		config.CandidateCount = int32(candidates)
+	}
+	if temperature := types.NormalizeTemperature(req.Temperature, maxTemperature, "google"); temperature != nil {
+		temp := float32(*temperature)
+		config.Temperature = &temp
+	}
+	if req.TopP != nil {
+		topP := float32(*req.TopP)
+		config.TopP = &topP
+	}
+	if req.TopK > 0 {
+		topK := float32(req.TopK)
+		config.TopK = &topK
+	}
+	if req.Seed != nil {
+		seed := int32(*req.Seed)
+		config.Seed = &seed
+	}
+	if len(req.Stop) > 0 {
+		config.StopSequences = req.Stop
+	}
+
+	// Add function tools if present
+	var tools []*genai.Tool
+	for _, tool := range req.Tools {
+		funcDecl := &genai.FunctionDeclaration{
+			Name:        tool.Function.Name,
+			Description: tool.Function.Description,
+			Parameters:  convertJSONSchemaToGeminiSchema(tool.Function.Parameters),
+		}
+		tools = append(tools, &genai.Tool{
+			FunctionDeclarations: []*genai.FunctionDeclaration{funcDecl},
+		})
+	}
+
+	// Add grounding tools if present (Google-specific: URL context, Google Search)
+	for _, gt := range req.GroundingTools {
+		switch gt.Type {
+		case types.GroundingToolURLContext:
+			tools = append(tools, &genai.Tool{
+				URLContext: &genai.URLContext{},
+			})
+		case types.GroundingToolGoogleSearch:
+			tools = append(tools, &genai.Tool{
+				GoogleSearch: &genai.GoogleSearch{},
+			})
+		}
+	}
+
+	if len(tools) > 0 {
+		config.Tools = tools
+		toolConfig, err := convertToolChoice(req.ToolChoice)
+		if err != nil {
+			return nil, err
+		}
+		config.ToolConfig = toolConfig
+	}
+
+	// Set JSON response format if requested
+	if req.ResponseFormat != nil && req.ResponseFormat.Type == "json_object" {
+		config.ResponseMIMEType = "application/json"
+		if req.ResponseFormat.Schema != nil {
+			config.ResponseSchema = convertJSONSchemaToGeminiSchema(req.ResponseFormat.Schema)
+		}
+	}
+
+	config, err := applyThinkingConfig(config, req)
+	if err != nil {
+		return nil, err
+	}
+	return applyGenerationOverrides(config, req), nil
+}
+
+// convertToolChoice converts a unified tool choice to a Gemini function calling config,
+// rejecting shapes that types.NormalizeToolChoice does not recognize
+func convertToolChoice(choice interface{}) (*genai.ToolConfig, error) {
+	normalized, err := types.NormalizeToolChoice(choice)
+	if err != nil {
+		return nil, err
+	}
+
+	var config genai.FunctionCallingConfig
+	switch c := normalized.(type) {
+	case types.ForcedToolChoice:
+		config.Mode = genai.FunctionCallingConfigModeAny
+		config.AllowedFunctionNames = []string{c.Name}
+	case string:
+		switch c {
+		case types.ToolChoiceAuto:
+			config.Mode = genai.FunctionCallingConfigModeAuto
+		case types.ToolChoiceNone:
+			config.Mode = genai.FunctionCallingConfigModeNone
+		case types.ToolChoiceRequired:
+			config.Mode = genai.FunctionCallingConfigModeAny
+		}
+	default:
+		return nil, nil
+	}
+	return &genai.ToolConfig{FunctionCallingConfig: &config}, nil
+}
+
+// convertMessages converts messages to Gemini contents. System messages are joined into a
+// separate system instruction, since Gemini follows them more closely there than as user turns.
+// Assistant tool calls become function calls, and tool messages become function responses.
+// A tool message with only text is attributed to the oldest unanswered tool call (or the last
+// call), unless toolTextAsUser is set, in which case it is sent as user text. Images are sent
+// as image parts, except in system messages, which only carry text.
+func convertMessages(messages []*types.Message, toolTextAsUser bool) ([]*genai.Content, *genai.Content, error) {
+	var contents []*genai.Content
+	var systemParts []string
+	var calls []types.ToolCall
+	answered := make(map[string]bool)
+	for _, msg := range messages {
+		if msg.Role == types.RoleAssistant && len(msg.ToolCalls) > 0 {
+			contents = append(contents, functionCallContent(msg))
+			calls = append(calls, msg.ToolCalls...)
+			continue
+		}
+		if msg.Role == types.RoleTool && (msg.ToolResult != nil || (!toolTextAsUser && msg.FlattenToText() != "")) {
+			if call, ok := respondedCall(msg, calls, answered); ok {
+				answered[call.ID] = true
+				contents = appendFunctionResponse(contents, functionResponsePart(msg, call))
+				continue
+			}
+		}
+
+		if msg.HasImages() && msg.Role != types.RoleSystem {
+			parts, err := contentParts(msg)
+			if err != nil {
+				return nil, nil, err
+			}
+			role := genai.Role(genai.RoleUser)
+			if msg.Role == types.RoleAssistant {
+				role = genai.RoleModel
+			}
+			contents = append(contents, genai.NewContentFromParts(parts, role))
+			continue
+		}
+
+		text := msg.FlattenToText()
+		if text == "" {
+			continue
+		}
+
+		switch msg.Role {
+		case types.RoleSystem:
+			systemParts = append(systemParts, text)
+		case types.RoleAssistant:
+			contents = append(contents, genai.NewContentFromText(text, genai.RoleModel))
+		default:
+			contents = append(contents, genai.NewContentFromText(text, genai.RoleUser))
+		}
+	}
+
+	if len(systemParts) == 0 {
+		return contents, nil, nil
+	}
+	return contents, genai.NewContentFromText(strings.Join(systemParts, "\n\n"), genai.RoleUser), nil
+}
+
+// placeSystemPrompt moves the system instruction into the conversation for models whose
+// placement asks for it: as its own leading user turn, or prepended to the first user turn.
+// Without a user turn to prepend to, the prompt is sent as its own turn.
+func placeSystemPrompt(contents []*genai.Content, instruction *genai.Content, placement types.SystemPlacement) ([]*genai.Content, *genai.Content) {
+	if instruction == nil {
+		return contents, nil
+	}
+
+	switch placement {
+	case types.SystemPlacementFirstMessage:
+	case types.SystemPlacementPrependUser:
+		for i, content := range contents {
+			if content.Role != genai.RoleUser || hasFunctionResponse(content) {
+				continue
+			}
+			text := instruction.Parts[0].Text + "\n\n"
+			prepended := *content
+			prepended.Parts = append([]*genai.Part{genai.NewPartFromText(text)}, content.Parts...)
+			placed := append([]*genai.Content{}, contents...)
+			placed[i] = &prepended
+			return placed, nil
+		}
+	default:
+		return contents, instruction
+	}
+	return append([]*genai.Content{instruction}, contents...), nil
+}
+
+// hasFunctionResponse reports whether content carries tool results rather than user input
+func hasFunctionResponse(content *genai.Content) bool {
+	for _, part := range content.Parts {
+		if part.FunctionResponse != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// checkSystemMessages handles system messages that follow other messages, which Gemini has no
+// place for: they are moved into the system instruction, so their position in the conversation
+// is lost. Strict mode rejects them instead.
+func checkSystemMessages(messages []*types.Message, mode SystemMessageMode) error {
+	started := false
+	for i, msg := range messages {
+		if msg.Role != types.RoleSystem {
+			started = true
+			continue
+		}
+		if !started {
+			continue
+		}
+
+		switch mode {
+		case SystemMessagesStrict:
+			return types.NewError(types.ErrCodeInvalidRequest,
+				fmt.Sprintf("system message at position %d follows other messages; Gemini only supports a leading system instruction", i), "google")
+		case SystemMessagesQuiet:
+		default:
+			slog.Warn("Google provider moved a mid-conversation system message into the system instruction", "position", i)
+		}
+	}
+	return nil
+}
+
+// functionCallContent converts an assistant message with tool calls into a model turn with
+// its text followed by a function call part per tool call
+func functionCallContent(msg *types.Message) *genai.Content {
+	var parts []*genai.Part
+	if text := msg.FlattenToText(); text != "" {
+		parts = append(parts, genai.NewPartFromText(text))
+	}
+	for _, call := range msg.ToolCalls {
+		args := call.Args
+		if args == nil && call.Function.Arguments != "" {
+			json.Unmarshal([]byte(call.Function.Arguments), &args)
+		}
+		parts = append(parts, &genai.Part{FunctionCall: &genai.FunctionCall{
+			ID:   call.ID,
+			Name: call.Function.Name,
+			Args: args,
+		}})
+	}
+	return genai.NewContentFromParts(parts, genai.RoleModel)
+}
+
+// respondedCall returns the tool call a tool message responds to: the call matching its
+// ToolResult, or for a message without one, the oldest unanswered call or else the last call
+func respondedCall(msg *types.Message, calls []types.ToolCall, answered map[string]bool) (types.ToolCall, bool) {
+	if len(calls) == 0 {
+		return types.ToolCall{}, false
+	}
+	if msg.ToolResult != nil {
+		for _, call := range calls {
+			if call.ID == msg.ToolResult.ToolCallID {
+				return call, true
+			}
+		}
+	}
+	for _, call := range calls {
+		if !answered[call.ID] {
+			return call, true
+		}
+	}
+	return calls[len(calls)-1], true
+}
+
+// functionResponsePart converts a tool message into a function response for the given call
+func functionResponsePart(msg *types.Message, call types.ToolCall) *genai.Part {
+	response := map[string]any{}
+	switch {
+	case msg.ToolResult != nil && msg.ToolResult.Error != "":
+		response["error"] = msg.ToolResult.Error
+	case msg.ToolResult != nil:
+		response["output"] = msg.ToolResult.Content
+	default:
+		response["output"] = msg.FlattenToText()
+	}
+	return &genai.Part{FunctionResponse: &genai.FunctionResponse{
+		ID:       call.ID,
+		Name:     call.Function.Name,
+		Response: response,
+	}}
+}
+
+// appendFunctionResponse adds a function response to the contents, grouping consecutive
+// responses into one user turn as Gemini expects for parallel calls
+func appendFunctionResponse(contents []*genai.Content, part *genai.Part) []*genai.Content {
+	if n := len(contents); n > 0 {
+		last := contents[n-1]
+		if last.Role == genai.RoleUser && len(last.Parts) > 0 && last.Parts[0].FunctionResponse != nil {
+			last.Parts = append(last.Parts, part)
+			return contents
+		}
+	}
+	return append(contents, genai.NewContentFromParts([]*genai.Part{part}, genai.RoleUser))
+}
+
+// contentBlocks converts a candidate's thought parts into typed message content. Text parts
+// aren't repeated as blocks, since TextData already holds all of the candidate's text.
+func contentBlocks(candidate *genai.Candidate) []types.MessageContent {
+	if candidate == nil || candidate.Content == nil {
+		return nil
+	}
+
+	var blocks []types.MessageContent
+	for _, part := range candidate.Content.Parts {
+		if part != nil && part.Thought && part.Text != "" {
+			blocks = append(blocks, types.ThinkingContent{Text: part.Text})
+		}
+	}
+	return blocks
+}
+
 // handleToolCalls processes tool calls from the response
 func (p *Provider) handleToolCalls(candidates []*genai.Candidate) []types.ToolCall {
 	var toolCalls []types.ToolCall
@@ -824,7 +1241,7 @@ func (p *Provider) handleToolCalls(candidates []*genai.Candidate) []types.ToolCa
 					// Use the function call ID if available, otherwise generate one
 					callID := funcCall.ID
 					if callID == "" {
-						callID = fmt.Sprintf("call_%s_%d", funcCall.Name, len(toolCalls))
+						callID = "call_" + uuid.New().String()
 					}
 
 					toolCall := types.ToolCall{
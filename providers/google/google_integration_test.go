@@ -53,7 +53,7 @@ func TestGemmaModelsIntegration(t *testing.T) {
 					types.NewTextMessage(types.RoleUser, "Say hello in exactly 5 words"),
 				},
 				MaxTokens:   50,
-				Temperature: 0.7,
+				Temperature: types.Float64(0.7),
 			}
 
 			resp, err := provider.Complete(ctx, req)
@@ -1,12 +1,48 @@
 package google
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/ztkent/ai-util/types"
+	"google.golang.org/genai"
 )
 
+// newTestProvider returns a provider backed by a fake Gemini API server
+func newTestProvider(t *testing.T, handler http.HandlerFunc) *Provider {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	client, err := genai.NewClient(context.Background(), &genai.ClientConfig{
+		APIKey:      "test-key",
+		Backend:     genai.BackendGeminiAPI,
+		HTTPOptions: genai.HTTPOptions{BaseURL: server.URL},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create genai client: %v", err)
+	}
+
+	return &Provider{
+		config:     &Config{BaseConfig: types.BaseConfig{Provider: "google", APIKey: "test-key"}},
+		client:     client,
+		httpClient: &http.Client{},
+	}
+}
+
+const testCandidateJSON = `{"candidates":[{"content":{"role":"model","parts":[{"text":"%s"}]}%s}]}`
+
 func TestGoogleProvider_GetName(t *testing.T) {
 	provider := NewProvider()
 	if provider.GetName() != "google" {
@@ -106,6 +142,24 @@ func TestGoogleProvider_EstimateTokens(t *testing.T) {
 	}
 }
 
+func TestGoogleProvider_SupportedCapabilities(t *testing.T) {
+	provider := NewProvider()
+	capabilities := provider.SupportedCapabilities()
+
+	for _, expected := range []types.ModelCapability{types.CapabilityChat, types.CapabilityTTS, types.CapabilityLive} {
+		found := false
+		for _, capability := range capabilities {
+			if capability == expected {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("Expected capability %s, got %v", expected, capabilities)
+		}
+	}
+}
+
 func TestConfig_Validate(t *testing.T) {
 	// Test valid config
 	config := &Config{
@@ -129,4 +183,1127 @@ func TestConfig_Validate(t *testing.T) {
 	if err := invalidConfig.Validate(); err == nil {
 		t.Error("Expected error for missing API key")
 	}
+
+	// Test unknown system placement
+	config.SystemPlacements = map[string]types.SystemPlacement{"gemini-2.5-pro": "sideways"}
+	if err := config.Validate(); err == nil {
+		t.Error("Expected error for unknown system placement")
+	}
+}
+
+func TestGoogleProvider_ResponseIDs(t *testing.T) {
+	provider := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "streamGenerateContent") {
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprintf(w, "data: "+testCandidateJSON+"\n\n", "Hello", "")
+			fmt.Fprintf(w, "data: "+testCandidateJSON+"\n\n", " world", `,"finishReason":"STOP"`)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, testCandidateJSON, "Hello", `,"finishReason":"STOP"`)
+	})
+
+	ctx := context.Background()
+	req := &types.CompletionRequest{
+		Model:    "gemini-2.5-flash",
+		Messages: []*types.Message{types.NewTextMessage(types.RoleUser, "Hi")},
+	}
+
+	first, err := provider.Complete(ctx, req)
+	if err != nil {
+		t.Fatalf("Complete failed: %v", err)
+	}
+	second, err := provider.Complete(ctx, req)
+	if err != nil {
+		t.Fatalf("Complete failed: %v", err)
+	}
+	if first.ID == "" || first.ID == second.ID {
+		t.Errorf("Expected unique response IDs, got '%s' and '%s'", first.ID, second.ID)
+	}
+
+	var streamIDs []string
+	err = provider.Stream(ctx, req, func(ctx context.Context, response *types.StreamResponse) error {
+		streamIDs = append(streamIDs, response.ID)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Stream failed: %v", err)
+	}
+	if len(streamIDs) != 2 {
+		t.Fatalf("Expected 2 stream chunks, got %d", len(streamIDs))
+	}
+	if streamIDs[0] == "" || streamIDs[0] != streamIDs[1] {
+		t.Errorf("Expected a stable stream ID across chunks, got %v", streamIDs)
+	}
+	if streamIDs[0] == first.ID || streamIDs[0] == second.ID {
+		t.Errorf("Expected stream ID to differ from completion IDs, got '%s'", streamIDs[0])
+	}
+}
+
+func TestGoogleProvider_ResponseMetadata(t *testing.T) {
+	provider := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"candidates":[{
+			"content":{"role":"model","parts":[{"text":"Paris"}]},
+			"finishReason":"STOP",
+			"groundingMetadata":{"webSearchQueries":["capital of france"],"groundingChunks":[{"web":{"uri":"https://example.com","title":"Example"}}]},
+			"safetyRatings":[{"category":"HARM_CATEGORY_HARASSMENT","probability":"NEGLIGIBLE"}],
+			"citationMetadata":{"citationSources":[{"uri":"https://example.com/source","startIndex":0,"endIndex":5}]}
+		}]}`)
+	})
+
+	resp, err := provider.Complete(context.Background(), &types.CompletionRequest{
+		Model:    "gemini-2.5-flash",
+		Messages: []*types.Message{types.NewTextMessage(types.RoleUser, "Capital of France?")},
+	})
+	if err != nil {
+		t.Fatalf("Complete failed: %v", err)
+	}
+
+	grounding, ok := resp.GroundingMetadata()
+	if !ok || len(grounding.Sources) != 1 || grounding.Sources[0].URI != "https://example.com" {
+		t.Errorf("Expected grounding source, got %+v", grounding)
+	}
+
+	ratings, ok := resp.SafetyRatings()
+	if !ok || len(ratings) != 1 || ratings[0].Category != "HARM_CATEGORY_HARASSMENT" {
+		t.Errorf("Expected safety rating, got %+v", ratings)
+	}
+
+	citations, ok := resp.Citations()
+	if !ok || len(citations) != 1 || citations[0].EndIndex != 5 {
+		t.Errorf("Expected citation, got %+v", citations)
+	}
+}
+
+func TestGoogleProvider_Seed(t *testing.T) {
+	var body map[string]interface{}
+	provider := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&body)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, testCandidateJSON, "Hello", `,"finishReason":"STOP"`)
+	})
+
+	seed := 42
+	_, err := provider.Complete(context.Background(), &types.CompletionRequest{
+		Model:    "gemini-2.5-flash",
+		Messages: []*types.Message{types.NewTextMessage(types.RoleUser, "Hi")},
+		Seed:     &seed,
+	})
+	if err != nil {
+		t.Fatalf("Complete failed: %v", err)
+	}
+
+	generationConfig, _ := body["generationConfig"].(map[string]interface{})
+	if generationConfig["seed"] != float64(42) {
+		t.Errorf("Expected seed 42 in generation config, got %v", generationConfig["seed"])
+	}
+}
+
+func TestGoogleProvider_StopSequences(t *testing.T) {
+	// The server stops generating at the first stop sequence it is sent
+	provider := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			GenerationConfig struct {
+				StopSequences []string `json:"stopSequences"`
+			} `json:"generationConfig"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		text := "1, 2, 3, 4, 5"
+		for _, stop := range body.GenerationConfig.StopSequences {
+			text, _, _ = strings.Cut(text, stop)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, testCandidateJSON, text, `,"finishReason":"STOP"`)
+	})
+
+	resp, err := provider.Complete(context.Background(), &types.CompletionRequest{
+		Model:    "gemini-2.5-flash",
+		Messages: []*types.Message{types.NewTextMessage(types.RoleUser, "Count to 5")},
+		Stop:     []string{", 4"},
+	})
+	if err != nil {
+		t.Fatalf("Complete failed: %v", err)
+	}
+	if resp.Message.TextData != "1, 2, 3" {
+		t.Errorf("Expected output truncated at the stop sequence, got %q", resp.Message.TextData)
+	}
+}
+
+func TestGoogleProvider_MaxTokensUnlimited(t *testing.T) {
+	var body map[string]interface{}
+	provider := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		body = nil
+		json.NewDecoder(r.Body).Decode(&body)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, testCandidateJSON, "Hello", `,"finishReason":"STOP"`)
+	})
+
+	for _, tc := range []struct {
+		maxTokens int
+		expected  interface{}
+	}{
+		{maxTokens: types.MaxTokensUnlimited, expected: nil},
+		{maxTokens: 4096, expected: float64(4096)},
+	} {
+		_, err := provider.Complete(context.Background(), &types.CompletionRequest{
+			Model:       "gemini-2.5-flash",
+			Messages:    []*types.Message{types.NewTextMessage(types.RoleUser, "Hi")},
+			MaxTokens:   tc.maxTokens,
+			Temperature: types.Float64(0.7),
+		})
+		if err != nil {
+			t.Fatalf("Complete failed: %v", err)
+		}
+
+		generationConfig, _ := body["generationConfig"].(map[string]interface{})
+		if generationConfig["maxOutputTokens"] != tc.expected {
+			t.Errorf("MaxTokens %d: expected maxOutputTokens %v, got %v", tc.maxTokens, tc.expected, generationConfig["maxOutputTokens"])
+		}
+	}
+}
+
+func TestGoogleProvider_StreamCallbackPanic(t *testing.T) {
+	provider := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintf(w, "data: "+testCandidateJSON+"\n\n", "Hello", "")
+		fmt.Fprintf(w, "data: "+testCandidateJSON+"\n\n", " world", `,"finishReason":"STOP"`)
+	})
+
+	calls := 0
+	err := provider.Stream(context.Background(), &types.CompletionRequest{
+		Model:    "gemini-2.5-flash",
+		Messages: []*types.Message{types.NewTextMessage(types.RoleUser, "Hi")},
+	}, func(ctx context.Context, response *types.StreamResponse) error {
+		calls++
+		panic("callback failed")
+	})
+
+	var aiErr *types.Error
+	if !errors.As(err, &aiErr) || aiErr.Code != types.ErrCodeCallbackPanic {
+		t.Fatalf("Expected a callback panic error, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("Expected the stream to stop after the panic, got %d callbacks", calls)
+	}
+}
+
+func TestGoogleProvider_StreamToolCallIDs(t *testing.T) {
+	// Gemini omits call IDs, and streams each call whole in its own chunk
+	const functionCallJSON = `{"candidates":[{"content":{"role":"model","parts":[{"functionCall":{"name":"get_weather","args":{"city":"%s"}}}]}%s}]}`
+	provider := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintf(w, "data: "+functionCallJSON+"\n\n", "Paris", "")
+		fmt.Fprintf(w, "data: "+functionCallJSON+"\n\n", "Tokyo", `,"finishReason":"STOP"`)
+	})
+
+	var toolCalls []types.ToolCall
+	err := provider.Stream(context.Background(), &types.CompletionRequest{
+		Model:    "gemini-2.5-flash",
+		Messages: []*types.Message{types.NewTextMessage(types.RoleUser, "Weather in Paris and Tokyo?")},
+	}, func(ctx context.Context, response *types.StreamResponse) error {
+		toolCalls = append(toolCalls, response.Delta.ToolCalls...)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Stream failed: %v", err)
+	}
+
+	if len(toolCalls) != 2 {
+		t.Fatalf("Expected 2 tool calls, got %d", len(toolCalls))
+	}
+	if toolCalls[0].ID == "" || toolCalls[0].ID == toolCalls[1].ID {
+		t.Errorf("Expected distinct IDs across chunks so the calls aren't merged, got %q and %q", toolCalls[0].ID, toolCalls[1].ID)
+	}
+	for i, city := range []string{"Paris", "Tokyo"} {
+		var args map[string]string
+		if err := json.Unmarshal([]byte(toolCalls[i].Function.Arguments), &args); err != nil || args["city"] != city {
+			t.Errorf("Tool call %d: expected city %q, got %q", i, city, toolCalls[i].Function.Arguments)
+		}
+	}
+}
+
+func TestGoogleProvider_MultiPartContent(t *testing.T) {
+	var body struct {
+		Contents []struct {
+			Parts []struct {
+				Text string `json:"text"`
+			} `json:"parts"`
+		} `json:"contents"`
+	}
+	provider := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&body)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, testCandidateJSON, "Hello", `,"finishReason":"STOP"`)
+	})
+
+	_, err := provider.Complete(context.Background(), &types.CompletionRequest{
+		Model: "gemini-2.5-flash",
+		Messages: []*types.Message{types.NewContentMessage(types.RoleUser, []types.MessageContent{
+			types.TextContent{Text: "First part."},
+			types.TextContent{Text: "Second part."},
+		})},
+	})
+	if err != nil {
+		t.Fatalf("Complete failed: %v", err)
+	}
+
+	if len(body.Contents) != 1 || len(body.Contents[0].Parts) != 1 {
+		t.Fatalf("Expected one content with one part, got %+v", body.Contents)
+	}
+	if text := body.Contents[0].Parts[0].Text; text != "First part.\nSecond part." {
+		t.Errorf("Expected both text parts to be sent, got %q", text)
+	}
+}
+
+func TestGoogleProvider_GenerationOverrides(t *testing.T) {
+	var body map[string]interface{}
+	provider := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&body)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, testCandidateJSON, "Hello", `,"finishReason":"STOP"`)
+	})
+
+	presencePenalty := float32(0.5)
+	temperature := float32(0.2)
+	_, err := provider.Complete(context.Background(), &types.CompletionRequest{
+		Model:       "gemini-2.5-flash",
+		Messages:    []*types.Message{types.NewTextMessage(types.RoleUser, "Hi")},
+		Temperature: types.Float64(0.9),
+		Metadata: map[string]interface{}{
+			OverridesMetadataKey: &GenerationOverrides{
+				CandidateCount:   2,
+				ResponseLogprobs: true,
+				PresencePenalty:  &presencePenalty,
+				Temperature:      &temperature,
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Complete failed: %v", err)
+	}
+
+	generationConfig, _ := body["generationConfig"].(map[string]interface{})
+	if generationConfig["candidateCount"] != float64(2) {
+		t.Errorf("Expected candidateCount 2, got %v", generationConfig["candidateCount"])
+	}
+	if generationConfig["responseLogprobs"] != true {
+		t.Errorf("Expected responseLogprobs true, got %v", generationConfig["responseLogprobs"])
+	}
+	if generationConfig["presencePenalty"] != float64(0.5) {
+		t.Errorf("Expected presencePenalty 0.5, got %v", generationConfig["presencePenalty"])
+	}
+	// Overrides take precedence over unified fields
+	if temp, _ := generationConfig["temperature"].(float64); float32(temp) != temperature {
+		t.Errorf("Expected override temperature 0.2, got %v", generationConfig["temperature"])
+	}
+}
+
+func TestGoogleProvider_ContentBlocks(t *testing.T) {
+	provider := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"candidates":[{"content":{"role":"model","parts":[{"text":"Let me think.","thought":true},{"text":"The answer is 4."}]},"finishReason":"STOP"}]}`)
+	})
+
+	resp, err := provider.Complete(context.Background(), &types.CompletionRequest{
+		Model:    "gemini-2.5-flash",
+		Messages: []*types.Message{types.NewTextMessage(types.RoleUser, "What is 2+2?")},
+	})
+	if err != nil {
+		t.Fatalf("Complete failed: %v", err)
+	}
+
+	if resp.Message.TextData != "The answer is 4." {
+		t.Errorf("Expected TextData to hold the text, got %q", resp.Message.TextData)
+	}
+	if len(resp.Message.Content) != 1 {
+		t.Fatalf("Expected only the thinking block in Content, got %d blocks", len(resp.Message.Content))
+	}
+	if thinking, ok := resp.Message.Content[0].(types.ThinkingContent); !ok || thinking.Text != "Let me think." {
+		t.Errorf("Expected the thinking block, got %#v", resp.Message.Content[0])
+	}
+	if text := resp.Message.FlattenToText(); text != "The answer is 4." {
+		t.Errorf("Expected the text once, got %q", text)
+	}
+}
+
+func TestGoogleProvider_MultiPartText(t *testing.T) {
+	provider := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"candidates":[{"content":{"role":"model","parts":[{"text":"A"},{"text":"B"}]},"finishReason":"STOP"}]}`)
+	})
+
+	resp, err := provider.Complete(context.Background(), &types.CompletionRequest{
+		Model:    "gemini-2.5-flash",
+		Messages: []*types.Message{types.NewTextMessage(types.RoleUser, "Say AB")},
+	})
+	if err != nil {
+		t.Fatalf("Complete failed: %v", err)
+	}
+
+	if resp.Message.TextData != "AB" || len(resp.Message.Content) != 0 {
+		t.Errorf("Expected the joined text only in TextData, got %q with %d blocks", resp.Message.TextData, len(resp.Message.Content))
+	}
+	if text := resp.Message.FlattenToText(); text != "AB" {
+		t.Errorf("Expected the text once, got %q", text)
+	}
+}
+
+func TestGoogleProvider_SystemInstruction(t *testing.T) {
+	var body map[string]interface{}
+	provider := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&body)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, testCandidateJSON, "Bonjour", `,"finishReason":"STOP"`)
+	})
+
+	_, err := provider.Complete(context.Background(), &types.CompletionRequest{
+		Model: "gemini-2.5-flash",
+		Messages: []*types.Message{
+			types.NewTextMessage(types.RoleSystem, "Always answer in French."),
+			types.NewTextMessage(types.RoleSystem, "Be brief."),
+			types.NewTextMessage(types.RoleUser, "Hi"),
+		},
+	})
+	if err != nil {
+		t.Fatalf("Complete failed: %v", err)
+	}
+
+	instruction, _ := body["systemInstruction"].(map[string]interface{})
+	parts, _ := instruction["parts"].([]interface{})
+	if len(parts) != 1 {
+		t.Fatalf("Expected a single system instruction part, got %v", body["systemInstruction"])
+	}
+	if text := parts[0].(map[string]interface{})["text"]; text != "Always answer in French.\n\nBe brief." {
+		t.Errorf("Expected system messages to be concatenated, got %q", text)
+	}
+
+	contents, _ := body["contents"].([]interface{})
+	if len(contents) != 1 {
+		t.Fatalf("Expected only the user turn in contents, got %d", len(contents))
+	}
+	if role := contents[0].(map[string]interface{})["role"]; role != "user" {
+		t.Errorf("Expected user role, got %v", role)
+	}
+}
+
+func TestGoogleProvider_ForcedToolChoice(t *testing.T) {
+	var body map[string]interface{}
+	provider := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&body)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"candidates":[{"content":{"role":"model","parts":[{"functionCall":{"name":"respond","args":{"city":"Paris"}}}]},"finishReason":"STOP"}]}`)
+	})
+
+	resp, err := provider.Complete(context.Background(), &types.CompletionRequest{
+		Model:    "gemini-2.5-flash",
+		Messages: []*types.Message{types.NewTextMessage(types.RoleUser, "Describe Paris")},
+		Tools: []types.Tool{{
+			Type:     "function",
+			Function: &types.ToolFunction{Name: "respond", Parameters: map[string]interface{}{"type": "object"}},
+		}},
+		ToolChoice: types.ForcedToolChoice{Name: "respond"},
+	})
+	if err != nil {
+		t.Fatalf("Complete failed: %v", err)
+	}
+
+	toolConfig, _ := body["toolConfig"].(map[string]interface{})
+	callingConfig, _ := toolConfig["functionCallingConfig"].(map[string]interface{})
+	if callingConfig["mode"] != "ANY" {
+		t.Errorf("Expected function calling mode ANY, got %v", callingConfig["mode"])
+	}
+	names, _ := callingConfig["allowedFunctionNames"].([]interface{})
+	if len(names) != 1 || names[0] != "respond" {
+		t.Errorf("Expected allowed function names [respond], got %v", callingConfig["allowedFunctionNames"])
+	}
+
+	if len(resp.Message.ToolCalls) != 1 || resp.Message.ToolCalls[0].Function.Arguments != `{"city":"Paris"}` {
+		t.Errorf("Expected respond tool call arguments, got %+v", resp.Message.ToolCalls)
+	}
+}
+
+func TestGoogleProvider_InvalidToolChoice(t *testing.T) {
+	provider := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Expected no request for an invalid tool choice")
+	})
+
+	_, err := provider.Complete(context.Background(), &types.CompletionRequest{
+		Model:    "gemini-2.5-flash",
+		Messages: []*types.Message{types.NewTextMessage(types.RoleUser, "Describe Paris")},
+		Tools: []types.Tool{{
+			Type:     "function",
+			Function: &types.ToolFunction{Name: "respond", Parameters: map[string]interface{}{"type": "object"}},
+		}},
+		ToolChoice: "any",
+	})
+	var aiErr *types.Error
+	if !errors.As(err, &aiErr) || aiErr.Code != types.ErrCodeInvalidRequest {
+		t.Errorf("Expected %s error, got %v", types.ErrCodeInvalidRequest, err)
+	}
+}
+
+func TestGoogleProvider_ThinkingConfig(t *testing.T) {
+	var body map[string]interface{}
+	provider := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&body)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"candidates":[{"content":{"role":"model","parts":[{"text":"Adding the numbers.","thought":true},{"text":"4"}]},"finishReason":"STOP"}]}`)
+	})
+
+	budget := int32(1024)
+	resp, err := provider.Complete(context.Background(), &types.CompletionRequest{
+		Model:          "gemini-2.5-flash",
+		Messages:       []*types.Message{types.NewTextMessage(types.RoleUser, "What is 2+2?")},
+		ThinkingConfig: &types.ThinkingConfig{IncludeThoughts: true, ThinkingBudget: &budget},
+	})
+	if err != nil {
+		t.Fatalf("Complete failed: %v", err)
+	}
+
+	generationConfig, _ := body["generationConfig"].(map[string]interface{})
+	thinkingConfig, _ := generationConfig["thinkingConfig"].(map[string]interface{})
+	if thinkingConfig["includeThoughts"] != true || thinkingConfig["thinkingBudget"] != float64(1024) {
+		t.Errorf("Expected thinking config to be sent, got %v", generationConfig["thinkingConfig"])
+	}
+
+	if resp.Message.TextData != "4" {
+		t.Errorf("Expected thoughts to be kept out of TextData, got %q", resp.Message.TextData)
+	}
+	if thoughts, ok := resp.Thoughts(); !ok || thoughts != "Adding the numbers." {
+		t.Errorf("Expected thoughts in metadata, got %q", thoughts)
+	}
+}
+
+func TestGoogleProvider_ThinkingConfigUnsupportedModel(t *testing.T) {
+	requested := false
+	provider := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		requested = true
+	})
+
+	budget := int32(1024)
+	_, err := provider.Complete(context.Background(), &types.CompletionRequest{
+		Model:          "gemma-3-27b-it",
+		Messages:       []*types.Message{types.NewTextMessage(types.RoleUser, "Hi")},
+		ThinkingConfig: &types.ThinkingConfig{ThinkingBudget: &budget},
+	})
+
+	var aiErr *types.Error
+	if !errors.As(err, &aiErr) || aiErr.Code != types.ErrCodeInvalidRequest {
+		t.Errorf("Expected %s error, got %v", types.ErrCodeInvalidRequest, err)
+	}
+	if requested {
+		t.Error("Expected the request not to be sent")
+	}
+}
+
+func TestGoogleProvider_JSONResponseFormat(t *testing.T) {
+	var bodies []map[string]interface{}
+	provider := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		bodies = append(bodies, body)
+		if strings.Contains(r.URL.Path, "streamGenerateContent") {
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprintf(w, "data: "+testCandidateJSON+"\n\n", `{\"ok\":true}`, `,"finishReason":"STOP"`)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, testCandidateJSON, `{\"ok\":true}`, `,"finishReason":"STOP"`)
+	})
+
+	req := func() *types.CompletionRequest {
+		return &types.CompletionRequest{
+			Model:    "gemini-2.5-flash",
+			Messages: []*types.Message{types.NewTextMessage(types.RoleUser, "Reply with JSON")},
+			ResponseFormat: &types.ResponseFormat{
+				Type: "json_object",
+				Schema: map[string]interface{}{
+					"type":       "object",
+					"properties": map[string]interface{}{"ok": map[string]interface{}{"type": "boolean"}},
+				},
+			},
+		}
+	}
+
+	resp, err := provider.Complete(context.Background(), req())
+	if err != nil {
+		t.Fatalf("Complete failed: %v", err)
+	}
+	if resp.Message.TextData != `{"ok":true}` {
+		t.Errorf("Expected raw JSON text, got %q", resp.Message.TextData)
+	}
+	if err := provider.Stream(context.Background(), req(), func(ctx context.Context, resp *types.StreamResponse) error {
+		return nil
+	}); err != nil {
+		t.Fatalf("Stream failed: %v", err)
+	}
+
+	if len(bodies) != 2 {
+		t.Fatalf("Expected 2 requests, got %d", len(bodies))
+	}
+	for i, body := range bodies {
+		generationConfig, _ := body["generationConfig"].(map[string]interface{})
+		if generationConfig["responseMimeType"] != "application/json" {
+			t.Errorf("Request %d: expected responseMimeType application/json, got %v", i, generationConfig["responseMimeType"])
+		}
+		schema, _ := generationConfig["responseSchema"].(map[string]interface{})
+		if schema["type"] != "OBJECT" {
+			t.Errorf("Request %d: expected an object response schema, got %v", i, generationConfig["responseSchema"])
+		}
+	}
+}
+
+func TestGoogleProvider_GroundingTools(t *testing.T) {
+	var body map[string]interface{}
+	provider := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&body)
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, `data: {"candidates":[{"content":{"role":"model","parts":[{"text":"Paris"}]},"finishReason":"STOP",`+
+			`"groundingMetadata":{"webSearchQueries":["capital of france"],"groundingChunks":[{"web":{"uri":"https://example.com","title":"Example"}}]}}]}`+"\n\n")
+	})
+
+	var metadata map[string]interface{}
+	err := provider.Stream(context.Background(), &types.CompletionRequest{
+		Model:    "gemini-2.5-flash",
+		Messages: []*types.Message{types.NewTextMessage(types.RoleUser, "What is the capital of France?")},
+		GroundingTools: []types.GroundingTool{
+			{Type: types.GroundingToolGoogleSearch},
+			{Type: types.GroundingToolURLContext},
+		},
+	}, func(ctx context.Context, resp *types.StreamResponse) error {
+		if resp.Metadata != nil {
+			metadata = resp.Metadata
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Stream failed: %v", err)
+	}
+
+	tools, _ := body["tools"].([]interface{})
+	if len(tools) != 2 {
+		t.Fatalf("Expected 2 grounding tools, got %v", body["tools"])
+	}
+	if _, ok := tools[0].(map[string]interface{})["googleSearch"]; !ok {
+		t.Errorf("Expected googleSearch tool, got %v", tools[0])
+	}
+	if _, ok := tools[1].(map[string]interface{})["urlContext"]; !ok {
+		t.Errorf("Expected urlContext tool, got %v", tools[1])
+	}
+
+	grounding, ok := (&types.CompletionResponse{Metadata: metadata}).GroundingMetadata()
+	if !ok || len(grounding.WebSearchQueries) != 1 || len(grounding.Sources) != 1 {
+		t.Errorf("Expected grounding metadata on the stream chunk, got %+v", metadata)
+	}
+}
+
+func TestGoogleProvider_InputTokenLimitExceeded(t *testing.T) {
+	provider := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"error":{"code":400,"message":"The input token count (1048600) exceeds the maximum number of tokens allowed (1048576).","status":"INVALID_ARGUMENT"}}`)
+	})
+
+	_, err := provider.Complete(context.Background(), &types.CompletionRequest{
+		Model:    "gemini-2.5-flash",
+		Messages: []*types.Message{types.NewTextMessage(types.RoleUser, "Hello")},
+	})
+
+	var aiErr *types.Error
+	if !errors.As(err, &aiErr) || aiErr.Code != types.ErrCodeTokenLimitExceeded {
+		t.Fatalf("Expected %s error, got %v", types.ErrCodeTokenLimitExceeded, err)
+	}
+	if aiErr.Details[types.DetailPromptTokens] != 1048600 || aiErr.Details[types.DetailModelLimit] != 1048576 {
+		t.Errorf("Expected prompt and limit details, got %v", aiErr.Details)
+	}
+	if aiErr.Details[types.DetailOverage] != 24 {
+		t.Errorf("Expected overage 24, got %v", aiErr.Details[types.DetailOverage])
+	}
+}
+
+func TestConvertMessages_ToolMessages(t *testing.T) {
+	messages := []*types.Message{
+		types.NewTextMessage(types.RoleUser, "What's the weather in Paris and Rome?"),
+		{
+			Role: types.RoleAssistant,
+			ToolCalls: []types.ToolCall{
+				{ID: "call_1", Type: "function", Function: types.ToolCallFunction{Name: "get_weather", Arguments: `{"city":"Paris"}`}},
+				{ID: "call_2", Type: "function", Function: types.ToolCallFunction{Name: "get_forecast", Arguments: `{"city":"Rome"}`}},
+			},
+		},
+		{Role: types.RoleTool, ToolResult: &types.ToolResult{ToolCallID: "call_2", Content: "Rain"}},
+		types.NewTextMessage(types.RoleTool, "Sunny, 22C"),
+	}
+
+	contents, _, _ := convertMessages(messages, false)
+	if len(contents) != 3 {
+		t.Fatalf("Expected user, model, and function response turns, got %d contents", len(contents))
+	}
+
+	calls := contents[1]
+	if calls.Role != genai.RoleModel || len(calls.Parts) != 2 || calls.Parts[0].FunctionCall == nil {
+		t.Fatalf("Expected model turn with 2 function calls, got %+v", calls)
+	}
+	if calls.Parts[0].FunctionCall.Name != "get_weather" || calls.Parts[0].FunctionCall.Args["city"] != "Paris" {
+		t.Errorf("Unexpected function call: %+v", calls.Parts[0].FunctionCall)
+	}
+
+	responses := contents[2]
+	if responses.Role != genai.RoleUser || len(responses.Parts) != 2 {
+		t.Fatalf("Expected one user turn with 2 function responses, got %+v", responses)
+	}
+	result := responses.Parts[0].FunctionResponse
+	if result == nil || result.Name != "get_forecast" || result.Response["output"] != "Rain" {
+		t.Errorf("Expected ToolResult matched to its call, got %+v", result)
+	}
+
+	// The plain text tool message answers the remaining call
+	coerced := responses.Parts[1].FunctionResponse
+	if coerced == nil || coerced.ID != "call_1" || coerced.Name != "get_weather" || coerced.Response["output"] != "Sunny, 22C" {
+		t.Errorf("Expected plain text tool message coerced into a get_weather response, got %+v", coerced)
+	}
+
+	// With coercion disabled, plain text tool messages are sent as user text
+	contents, _, _ = convertMessages(messages, true)
+	last := contents[len(contents)-1]
+	if last.Role != genai.RoleUser || last.Parts[0].Text != "Sunny, 22C" {
+		t.Errorf("Expected plain text tool message as user text, got %+v", last)
+	}
+}
+
+func TestGoogleProvider_SystemMessages(t *testing.T) {
+	requests := 0
+	provider := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, testCandidateJSON, "ok", `,"finishReason":"STOP"`)
+	})
+
+	var logs bytes.Buffer
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	t.Cleanup(func() { slog.SetDefault(defaultLogger) })
+
+	req := &types.CompletionRequest{
+		Model: "gemini-2.0-flash",
+		Messages: []*types.Message{
+			types.NewTextMessage(types.RoleSystem, "You are helpful."),
+			types.NewTextMessage(types.RoleUser, "Hello"),
+			types.NewTextMessage(types.RoleSystem, "Answer in French."),
+		},
+	}
+
+	// Lenient mode moves the late system message and warns
+	if _, err := provider.Complete(context.Background(), req); err != nil {
+		t.Fatalf("Complete failed: %v", err)
+	}
+	if !strings.Contains(logs.String(), "position=2") {
+		t.Errorf("Expected a warning for the system message at position 2, got %q", logs.String())
+	}
+	if strings.Count(logs.String(), "WARN") != 1 {
+		t.Errorf("Expected no warning for the leading system message, got %q", logs.String())
+	}
+
+	logs.Reset()
+	provider.config.SystemMessages = SystemMessagesQuiet
+	if _, err := provider.Complete(context.Background(), req); err != nil {
+		t.Fatalf("Complete failed: %v", err)
+	}
+	if logs.Len() != 0 {
+		t.Errorf("Expected no warning in quiet mode, got %q", logs.String())
+	}
+
+	// Strict mode rejects the request before it is sent
+	provider.config.SystemMessages = SystemMessagesStrict
+	_, err := provider.Complete(context.Background(), req)
+	var aiErr *types.Error
+	if !errors.As(err, &aiErr) || aiErr.Code != types.ErrCodeInvalidRequest {
+		t.Fatalf("Expected invalid request error in strict mode, got %v", err)
+	}
+	if requests != 2 {
+		t.Errorf("Expected the strict mode request not to be sent, got %d requests", requests)
+	}
+}
+
+func TestGoogleProvider_GenerateImage(t *testing.T) {
+	var path string
+	var body map[string]interface{}
+	provider := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		json.NewDecoder(r.Body).Decode(&body)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"predictions":[{"bytesBase64Encoded":"aGVsbG8=","mimeType":"image/png"}]}`)
+	})
+
+	resp, err := provider.GenerateImage(context.Background(), &types.ImageRequest{
+		Model:  "imagen-3.0-generate-002",
+		Prompt: "A cat",
+		N:      1,
+		Size:   "16:9",
+	})
+	if err != nil {
+		t.Fatalf("GenerateImage failed: %v", err)
+	}
+
+	if !strings.HasSuffix(path, "imagen-3.0-generate-002:predict") {
+		t.Errorf("Expected a predict request for the Imagen model, got %s", path)
+	}
+	parameters, _ := body["parameters"].(map[string]interface{})
+	if parameters["aspectRatio"] != "16:9" {
+		t.Errorf("Expected aspect ratio 16:9, got %v", body["parameters"])
+	}
+	if len(resp.Images) != 1 || resp.Images[0].Data != "aGVsbG8=" || resp.Images[0].MimeType != "image/png" {
+		t.Errorf("Unexpected images: %+v", resp.Images)
+	}
+
+	_, err = provider.GenerateImage(context.Background(), &types.ImageRequest{
+		Model:  "imagen-3.0-generate-002",
+		Prompt: "A cat",
+		Size:   "1024x1024",
+	})
+	if err == nil {
+		t.Error("Expected an error for a pixel size")
+	}
+}
+
+func TestGoogleProvider_Synthesize(t *testing.T) {
+	var body map[string]interface{}
+	provider := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&body)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"candidates":[{"content":{"role":"model","parts":[
+			{"inlineData":{"mimeType":"audio/L16;codec=pcm;rate=24000","data":"aGVsbG8="}}
+		]},"finishReason":"STOP"}]}`)
+	})
+
+	resp, err := provider.Synthesize(context.Background(), &types.TTSRequest{
+		Model: "gemini-2.5-flash-preview-tts",
+		Text:  "Hello",
+		Voice: "Kore",
+	})
+	if err != nil {
+		t.Fatalf("Synthesize failed: %v", err)
+	}
+	if string(resp.Audio) != "hello" || resp.MimeType != "audio/L16;codec=pcm;rate=24000" {
+		t.Errorf("Unexpected audio: %q (%s)", resp.Audio, resp.MimeType)
+	}
+
+	generationConfig, _ := body["generationConfig"].(map[string]interface{})
+	if modalities, _ := generationConfig["responseModalities"].([]interface{}); len(modalities) != 1 || modalities[0] != "AUDIO" {
+		t.Errorf("Expected audio response modality, got %v", generationConfig["responseModalities"])
+	}
+	speech, _ := json.Marshal(generationConfig["speechConfig"])
+	if !strings.Contains(string(speech), `"voiceName":"Kore"`) {
+		t.Errorf("Expected voice Kore in speech config, got %s", speech)
+	}
+
+	// Speakers select multi-speaker voices
+	_, err = provider.Synthesize(context.Background(), &types.TTSRequest{
+		Model:    "gemini-2.5-flash-preview-tts",
+		Text:     "Joe: Hi!\nJane: Hello!",
+		Speakers: map[string]string{"Joe": "Kore", "Jane": "Puck"},
+	})
+	if err != nil {
+		t.Fatalf("Synthesize failed: %v", err)
+	}
+	generationConfig, _ = body["generationConfig"].(map[string]interface{})
+	speech, _ = json.Marshal(generationConfig["speechConfig"])
+	if !strings.Contains(string(speech), `"speaker":"Jane"`) || !strings.Contains(string(speech), `"voiceName":"Puck"`) {
+		t.Errorf("Expected multi-speaker voice config, got %s", speech)
+	}
+}
+
+// testPNG is a 1x1 PNG image, base64 encoded
+const testPNG = "iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR42mNk+M9QDwADhgGAWjR9awAAAABJRU5ErkJggg=="
+
+func TestGoogleProvider_ImageContent(t *testing.T) {
+	var body struct {
+		Contents []struct {
+			Parts []struct {
+				Text       string `json:"text"`
+				InlineData *struct {
+					MimeType string `json:"mimeType"`
+					Data     string `json:"data"`
+				} `json:"inlineData"`
+			} `json:"parts"`
+		} `json:"contents"`
+	}
+	provider := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&body)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, testCandidateJSON, "A single white pixel", `,"finishReason":"STOP"`)
+	})
+
+	resp, err := provider.Complete(context.Background(), &types.CompletionRequest{
+		Model: "gemini-2.5-flash",
+		Messages: []*types.Message{
+			types.NewMultiImageMessage(types.RoleUser, "Describe this image",
+				types.ImageContent{Base64: testPNG, MimeType: "image/png"}),
+		},
+	})
+	if err != nil {
+		t.Fatalf("Complete failed: %v", err)
+	}
+	if resp.Message.TextData != "A single white pixel" {
+		t.Errorf("Unexpected response: %q", resp.Message.TextData)
+	}
+
+	if len(body.Contents) != 1 || len(body.Contents[0].Parts) != 2 {
+		t.Fatalf("Expected one turn with text and image parts, got %+v", body.Contents)
+	}
+	parts := body.Contents[0].Parts
+	if parts[0].Text != "Describe this image" {
+		t.Errorf("Expected the prompt text first, got %+v", parts[0])
+	}
+	if parts[1].InlineData == nil || parts[1].InlineData.MimeType != "image/png" || parts[1].InlineData.Data != testPNG {
+		t.Errorf("Expected the PNG as inline data, got %+v", parts[1].InlineData)
+	}
+}
+
+// recordingTransport records the URLs it fetches
+type recordingTransport struct {
+	urls []string
+}
+
+func (rt *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt.urls = append(rt.urls, req.URL.String())
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestGoogleProvider_ImageURL(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(testPNG)
+	images := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(png)
+	}))
+	defer images.Close()
+
+	var requestBody string
+	provider := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		requestBody = string(data)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, testCandidateJSON, "ok", `,"finishReason":"STOP"`)
+	})
+	transport := &recordingTransport{}
+	provider.httpClient = &http.Client{Transport: transport}
+
+	req := &types.CompletionRequest{
+		Model: "gemini-2.5-flash",
+		Messages: []*types.Message{
+			types.NewMultiImageMessage(types.RoleUser, "Describe this image",
+				types.ImageContent{URL: images.URL + "/pixel.png"}),
+		},
+	}
+	if _, err := provider.Complete(context.Background(), req); err != nil {
+		t.Fatalf("Complete failed: %v", err)
+	}
+	if len(transport.urls) != 1 || transport.urls[0] != images.URL+"/pixel.png" {
+		t.Errorf("Expected the image fetched with the provider's HTTP client, got %v", transport.urls)
+	}
+	if !strings.Contains(requestBody, testPNG) {
+		t.Errorf("Expected the fetched image inlined in the request, got %s", requestBody)
+	}
+	if image := req.Messages[0].Content[1].(types.ImageContent); image.URL == "" {
+		t.Error("Expected the caller's message to keep its URL")
+	}
+
+	// Images over the size limit are rejected
+	provider.config.MaxImageBytes = 10
+	_, err := provider.Complete(context.Background(), req)
+	var aiErr *types.Error
+	if !errors.As(err, &aiErr) || aiErr.Code != types.ErrCodeInvalidRequest {
+		t.Errorf("Expected an invalid request error for an oversized image, got %v", err)
+	}
+}
+
+func TestGoogleProvider_SystemPlacement(t *testing.T) {
+	type requestBody struct {
+		SystemInstruction *struct {
+			Parts []struct {
+				Text string `json:"text"`
+			} `json:"parts"`
+		} `json:"systemInstruction"`
+		Contents []struct {
+			Role  string `json:"role"`
+			Parts []struct {
+				Text string `json:"text"`
+			} `json:"parts"`
+		} `json:"contents"`
+	}
+	var body requestBody
+	provider := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		body = requestBody{}
+		json.NewDecoder(r.Body).Decode(&body)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, testCandidateJSON, "ok", `,"finishReason":"STOP"`)
+	})
+
+	provider.config.SystemPlacements = map[string]types.SystemPlacement{
+		"gemini-2.5-flash": types.SystemPlacementFirstMessage,
+		"gemini-2.5-pro":   types.SystemPlacementPrependUser,
+	}
+
+	models, err := provider.GetModels(context.Background())
+	if err != nil {
+		t.Fatalf("GetModels failed: %v", err)
+	}
+	for _, model := range models {
+		if model.ID == "gemini-2.5-pro" && model.SystemPlacement() != types.SystemPlacementPrependUser {
+			t.Errorf("Expected the configured placement in the model metadata, got %q", model.SystemPlacement())
+		}
+	}
+
+	complete := func(model string) {
+		t.Helper()
+		if _, err := provider.Complete(context.Background(), &types.CompletionRequest{
+			Model: model,
+			Messages: []*types.Message{
+				types.NewTextMessage(types.RoleSystem, "You are helpful."),
+				types.NewTextMessage(types.RoleUser, "Hello"),
+			},
+		}); err != nil {
+			t.Fatalf("Complete failed for %s: %v", model, err)
+		}
+	}
+
+	complete("gemini-2.5-flash")
+	if body.SystemInstruction != nil {
+		t.Errorf("Expected no system instruction for first_message, got %+v", body.SystemInstruction)
+	}
+	if len(body.Contents) != 2 || body.Contents[0].Role != "user" || body.Contents[0].Parts[0].Text != "You are helpful." {
+		t.Errorf("Expected the system prompt as a leading user turn, got %+v", body.Contents)
+	}
+
+	complete("gemini-2.5-pro")
+	if body.SystemInstruction != nil {
+		t.Errorf("Expected no system instruction for prepend_user, got %+v", body.SystemInstruction)
+	}
+	if len(body.Contents) != 1 || len(body.Contents[0].Parts) != 2 ||
+		body.Contents[0].Parts[0].Text != "You are helpful.\n\n" || body.Contents[0].Parts[1].Text != "Hello" {
+		t.Errorf("Expected the system prompt prepended to the user turn, got %+v", body.Contents)
+	}
+
+	// Models without an override keep the system instruction
+	complete("gemini-2.5-flash-lite")
+	if body.SystemInstruction == nil || body.SystemInstruction.Parts[0].Text != "You are helpful." {
+		t.Errorf("Expected a system instruction by default, got %+v", body.SystemInstruction)
+	}
+	if len(body.Contents) != 1 {
+		t.Errorf("Expected only the user turn in contents, got %+v", body.Contents)
+	}
+}
+
+func TestGoogleProvider_Temperature(t *testing.T) {
+	var body struct {
+		GenerationConfig map[string]interface{} `json:"generationConfig"`
+	}
+	provider := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		body.GenerationConfig = nil
+		json.NewDecoder(r.Body).Decode(&body)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, testCandidateJSON, "ok", `,"finishReason":"STOP"`)
+	})
+
+	complete := func(temperature *float64) {
+		t.Helper()
+		if _, err := provider.Complete(context.Background(), &types.CompletionRequest{
+			Model:       "gemini-2.5-flash",
+			Messages:    []*types.Message{types.NewTextMessage(types.RoleUser, "Hi")},
+			Temperature: temperature,
+		}); err != nil {
+			t.Fatalf("Complete failed: %v", err)
+		}
+	}
+
+	// An explicit zero is sent for deterministic output
+	complete(types.Float64(0))
+	if temperature, ok := body.GenerationConfig["temperature"]; !ok || temperature != 0.0 {
+		t.Errorf("Expected temperature 0 to be sent, got %v", body.GenerationConfig)
+	}
+
+	complete(nil)
+	if _, ok := body.GenerationConfig["temperature"]; ok {
+		t.Errorf("Expected no temperature when unset, got %v", body.GenerationConfig)
+	}
+
+	// Out of range temperatures are clamped
+	complete(types.Float64(3.5))
+	if temperature := body.GenerationConfig["temperature"]; temperature != 2.0 {
+		t.Errorf("Expected temperature clamped to 2, got %v", temperature)
+	}
+}
+
+func TestGoogleProvider_MultipleCandidates(t *testing.T) {
+	var body struct {
+		GenerationConfig map[string]interface{} `json:"generationConfig"`
+	}
+	provider := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&body)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"candidates":[
+			{"content":{"role":"model","parts":[{"text":"Red"}]},"finishReason":"STOP","index":0},
+			{"content":{"role":"model","parts":[{"text":"Gr"},{"text":"een"}]},"finishReason":"STOP","index":1},
+			{"content":{"role":"model","parts":[{"functionCall":{"name":"pick_color","args":{"color":"blue"}}}]},"finishReason":"STOP","index":2}
+		]}`)
+	})
+
+	resp, err := provider.Complete(context.Background(), &types.CompletionRequest{
+		Model:    "gemini-2.5-flash",
+		Messages: []*types.Message{types.NewTextMessage(types.RoleUser, "Name a color")},
+		N:        3,
+	})
+	if err != nil {
+		t.Fatalf("Complete failed: %v", err)
+	}
+
+	if body.GenerationConfig["candidateCount"] != 3.0 {
+		t.Errorf("Expected candidateCount=3 in the request, got %v", body.GenerationConfig)
+	}
+	if len(resp.Choices) != 3 {
+		t.Fatalf("Expected 3 choices, got %d", len(resp.Choices))
+	}
+	if resp.Choices[0].FlattenToText() != "Red" || resp.Choices[1].FlattenToText() != "Green" {
+		t.Errorf("Expected distinct text candidates, got %q and %q", resp.Choices[0].FlattenToText(), resp.Choices[1].FlattenToText())
+	}
+	if len(resp.Choices[2].ToolCalls) != 1 || len(resp.Choices[0].ToolCalls) != 0 {
+		t.Errorf("Expected the tool call only on the third candidate, got %+v", resp.Choices)
+	}
+	if resp.Message != resp.Choices[0] {
+		t.Errorf("Expected Message to be the first candidate, got %+v", resp.Message)
+	}
+}
+
+func TestGenerationConfig_ExplicitZero(t *testing.T) {
+	config, err := generationConfig(&types.CompletionRequest{
+		Model:       "gemini-2.5-flash",
+		MaxTokens:   100,
+		Temperature: types.Float64(0),
+		TopP:        types.Float64(0),
+	}, nil, 1)
+	if err != nil {
+		t.Fatalf("generationConfig failed: %v", err)
+	}
+	if config.MaxOutputTokens != 100 {
+		t.Errorf("Expected MaxOutputTokens 100, got %d", config.MaxOutputTokens)
+	}
+	if config.Temperature == nil || *config.Temperature != 0 {
+		t.Errorf("Expected Temperature 0, got %v", config.Temperature)
+	}
+	if config.TopP == nil || *config.TopP != 0 {
+		t.Errorf("Expected TopP 0, got %v", config.TopP)
+	}
+	if config.CandidateCount != 0 {
+		t.Errorf("Expected unset parameters to be left out, got CandidateCount %d", config.CandidateCount)
+	}
+
+	// Requests without parameters still get a config
+	config, err = generationConfig(&types.CompletionRequest{Model: "gemini-2.5-flash"}, nil, 1)
+	if err != nil || config == nil {
+		t.Fatalf("Expected an empty config, got %v (%v)", config, err)
+	}
+	if config.Temperature != nil || config.TopP != nil || config.MaxOutputTokens != 0 {
+		t.Errorf("Expected no parameters to be set, got %+v", config)
+	}
 }
@@ -0,0 +1,61 @@
+package google
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/ztkent/ai-util/types"
+	"google.golang.org/genai"
+)
+
+// GenerateImage generates images with Imagen models. Size is an aspect ratio such as "1:1"
+// or "16:9"; Quality is not supported by Imagen and is ignored.
+func (p *Provider) GenerateImage(ctx context.Context, req *types.ImageRequest) (*types.ImageResponse, error) {
+	if p.client == nil {
+		return nil, types.NewError(types.ErrCodeInvalidConfig, "Google AI client not initialized", "google")
+	}
+
+	config := &genai.GenerateImagesConfig{NumberOfImages: int32(req.N)}
+	if req.Size != "" {
+		if !strings.Contains(req.Size, ":") {
+			return nil, types.NewError(types.ErrCodeInvalidRequest,
+				fmt.Sprintf("invalid Imagen size %q: use an aspect ratio such as 1:1 or 16:9", req.Size), "google")
+		}
+		config.AspectRatio = req.Size
+	}
+
+	resp, err := p.client.Models.GenerateImages(ctx, req.Model, req.Prompt, config)
+	if err != nil {
+		return nil, wrapAPIError(err)
+	}
+
+	var images []types.ImageData
+	var filtered string
+	for _, generated := range resp.GeneratedImages {
+		if generated.Image == nil {
+			filtered = generated.RAIFilteredReason
+			continue
+		}
+		images = append(images, types.ImageData{
+			URL:           generated.Image.GCSURI,
+			Data:          base64.StdEncoding.EncodeToString(generated.Image.ImageBytes),
+			MimeType:      generated.Image.MIMEType,
+			RevisedPrompt: generated.EnhancedPrompt,
+		})
+	}
+	if len(images) == 0 {
+		message := "no images generated"
+		if filtered != "" {
+			message += ": " + filtered
+		}
+		return nil, types.NewError(types.ErrCodeContentFiltered, message, "google")
+	}
+
+	return &types.ImageResponse{
+		Model:    req.Model,
+		Provider: "google",
+		Images:   images,
+	}, nil
+}
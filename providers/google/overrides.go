@@ -0,0 +1,66 @@
+package google
+
+import (
+	"github.com/ztkent/ai-util/types"
+	"google.golang.org/genai"
+)
+
+// OverridesMetadataKey is the CompletionRequest.Metadata key for GenerationOverrides
+const OverridesMetadataKey = types.MetadataKeyGoogleOverrides
+
+// GenerationOverrides sets Gemini generation options that the unified request doesn't cover.
+// Attach it to CompletionRequest.Metadata under OverridesMetadataKey. Overrides are applied
+// after the unified request fields, so any override that is set takes precedence.
+type GenerationOverrides struct {
+	CandidateCount   int32    `json:"candidate_count,omitempty"`
+	ResponseLogprobs bool     `json:"response_logprobs,omitempty"`
+	Logprobs         *int32   `json:"logprobs,omitempty"`
+	PresencePenalty  *float32 `json:"presence_penalty,omitempty"`
+	FrequencyPenalty *float32 `json:"frequency_penalty,omitempty"`
+	Temperature      *float32 `json:"temperature,omitempty"`
+	TopP             *float32 `json:"top_p,omitempty"`
+	TopK             *float32 `json:"top_k,omitempty"`
+	ResponseMIMEType string   `json:"response_mime_type,omitempty"`
+}
+
+// applyGenerationOverrides merges any request overrides into the generation config,
+// creating the config if the unified fields didn't need one
+func applyGenerationOverrides(config *genai.GenerateContentConfig, req *types.CompletionRequest) *genai.GenerateContentConfig {
+	var overrides GenerationOverrides
+	if !req.ProviderOverrides(OverridesMetadataKey, &overrides) {
+		return config
+	}
+	if config == nil {
+		config = &genai.GenerateContentConfig{}
+	}
+
+	if overrides.CandidateCount > 0 {
+		config.CandidateCount = overrides.CandidateCount
+	}
+	if overrides.ResponseLogprobs {
+		config.ResponseLogprobs = true
+	}
+	if overrides.Logprobs != nil {
+		config.Logprobs = overrides.Logprobs
+	}
+	if overrides.PresencePenalty != nil {
+		config.PresencePenalty = overrides.PresencePenalty
+	}
+	if overrides.FrequencyPenalty != nil {
+		config.FrequencyPenalty = overrides.FrequencyPenalty
+	}
+	if overrides.Temperature != nil {
+		config.Temperature = overrides.Temperature
+	}
+	if overrides.TopP != nil {
+		config.TopP = overrides.TopP
+	}
+	if overrides.TopK != nil {
+		config.TopK = overrides.TopK
+	}
+	if overrides.ResponseMIMEType != "" {
+		config.ResponseMIMEType = overrides.ResponseMIMEType
+	}
+
+	return config
+}
@@ -0,0 +1,78 @@
+package google
+
+import (
+	"context"
+	"sort"
+
+	"github.com/ztkent/ai-util/types"
+	"google.golang.org/genai"
+)
+
+// Synthesize converts text to speech with Gemini TTS models. Speakers maps speaker names used
+// in the text to voices for multi-speaker audio; otherwise Voice selects a single voice.
+// Audio is returned as the raw PCM Gemini produces, described by the MIME type.
+func (p *Provider) Synthesize(ctx context.Context, req *types.TTSRequest) (*types.TTSResponse, error) {
+	if p.client == nil {
+		return nil, types.NewError(types.ErrCodeInvalidConfig, "Google AI client not initialized", "google")
+	}
+
+	config := &genai.GenerateContentConfig{
+		ResponseModalities: []string{string(genai.ModalityAudio)},
+		SpeechConfig:       speechConfig(req),
+	}
+
+	resp, err := p.client.Models.GenerateContent(ctx, req.Model, genai.Text(req.Text), config)
+	if err != nil {
+		return nil, wrapAPIError(err)
+	}
+
+	result := &types.TTSResponse{}
+	for _, candidate := range resp.Candidates {
+		if candidate.Content == nil {
+			continue
+		}
+		for _, part := range candidate.Content.Parts {
+			if part.InlineData == nil {
+				continue
+			}
+			result.Audio = append(result.Audio, part.InlineData.Data...)
+			if result.MimeType == "" {
+				result.MimeType = part.InlineData.MIMEType
+			}
+		}
+	}
+	if len(result.Audio) == 0 {
+		return nil, types.NewError(types.ErrCodeServerError, "no audio in response", "google")
+	}
+
+	return result, nil
+}
+
+// speechConfig builds the voice configuration for a TTS request, or nil for the default voice
+func speechConfig(req *types.TTSRequest) *genai.SpeechConfig {
+	if len(req.Speakers) > 0 {
+		speakers := make([]string, 0, len(req.Speakers))
+		for speaker := range req.Speakers {
+			speakers = append(speakers, speaker)
+		}
+		sort.Strings(speakers)
+
+		multi := &genai.MultiSpeakerVoiceConfig{}
+		for _, speaker := range speakers {
+			multi.SpeakerVoiceConfigs = append(multi.SpeakerVoiceConfigs, &genai.SpeakerVoiceConfig{
+				Speaker:     speaker,
+				VoiceConfig: prebuiltVoice(req.Speakers[speaker]),
+			})
+		}
+		return &genai.SpeechConfig{MultiSpeakerVoiceConfig: multi}
+	}
+
+	if req.Voice != "" {
+		return &genai.SpeechConfig{VoiceConfig: prebuiltVoice(req.Voice)}
+	}
+	return nil
+}
+
+func prebuiltVoice(name string) *genai.VoiceConfig {
+	return &genai.VoiceConfig{PrebuiltVoiceConfig: &genai.PrebuiltVoiceConfig{VoiceName: name}}
+}
@@ -0,0 +1,161 @@
+package google
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/ztkent/ai-util/types"
+	"google.golang.org/genai"
+)
+
+// DefaultMaxImageBytes is the largest image fetched from a URL when Config.MaxImageBytes is unset
+const DefaultMaxImageBytes = 20 << 20
+
+// inlineImageURLs returns messages with http(s) image URLs fetched and replaced by base64
+// data, since Gemini only reads files it hosts. Messages without such images are not copied.
+func (p *Provider) inlineImageURLs(ctx context.Context, messages []*types.Message) ([]*types.Message, error) {
+	limit := int64(DefaultMaxImageBytes)
+	if p.config != nil && p.config.MaxImageBytes > 0 {
+		limit = p.config.MaxImageBytes
+	}
+
+	var inlined []*types.Message
+	for i, msg := range messages {
+		if !hasImageURLs(msg) {
+			continue
+		}
+		if inlined == nil {
+			inlined = make([]*types.Message, len(messages))
+			copy(inlined, messages)
+		}
+
+		copied := *msg
+		copied.Content = make([]types.MessageContent, len(msg.Content))
+		for j, content := range msg.Content {
+			if image, ok := content.(types.ImageContent); ok && isHTTPURL(image.URL) {
+				fetched, err := p.fetchImage(ctx, image, limit)
+				if err != nil {
+					return nil, err
+				}
+				content = fetched
+			}
+			copied.Content[j] = content
+		}
+		inlined[i] = &copied
+	}
+
+	if inlined == nil {
+		return messages, nil
+	}
+	return inlined, nil
+}
+
+func hasImageURLs(msg *types.Message) bool {
+	for _, content := range msg.Content {
+		if image, ok := content.(types.ImageContent); ok && isHTTPURL(image.URL) {
+			return true
+		}
+	}
+	return false
+}
+
+func isHTTPURL(url string) bool {
+	return strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://")
+}
+
+// fetchImage downloads an image URL into base64 data with the provider's HTTP client, failing
+// if it exceeds limit bytes
+func (p *Provider) fetchImage(ctx context.Context, image types.ImageContent, limit int64) (types.ImageContent, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, image.URL, nil)
+	if err != nil {
+		return image, types.WrapError(err, types.ErrCodeInvalidRequest, "google")
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return image, types.WrapRequestError(err, "google")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return image, types.NewError(types.ErrCodeInvalidRequest,
+			fmt.Sprintf("fetching image %s: %s", image.URL, resp.Status), "google")
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return image, types.WrapRequestError(err, "google")
+	}
+	if int64(len(data)) > limit {
+		return image, types.NewError(types.ErrCodeInvalidRequest,
+			fmt.Sprintf("image %s exceeds the %d byte limit", image.URL, limit), "google")
+	}
+
+	mimeType := image.MimeType
+	if mimeType == "" {
+		mimeType = resp.Header.Get("Content-Type")
+	}
+	if mimeType == "" || !strings.HasPrefix(mimeType, "image/") {
+		mimeType = http.DetectContentType(data)
+	}
+
+	image.URL = ""
+	image.Base64 = base64.StdEncoding.EncodeToString(data)
+	image.MimeType = mimeType
+	return image, nil
+}
+
+// contentParts converts a message's text and images into Gemini parts, in order
+func contentParts(msg *types.Message) ([]*genai.Part, error) {
+	var parts []*genai.Part
+	if msg.TextData != "" {
+		parts = append(parts, genai.NewPartFromText(msg.TextData))
+	}
+
+	for _, content := range msg.Content {
+		switch c := content.(type) {
+		case types.TextContent:
+			// Responses keep their primary text in both TextData and Content
+			if c.Text != "" && c.Text != msg.TextData {
+				parts = append(parts, genai.NewPartFromText(c.Text))
+			}
+		case types.ImageContent:
+			part, err := imagePart(c)
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, part)
+		}
+	}
+	return parts, nil
+}
+
+// imagePart converts an image into an inline blob, or a file reference for URIs Gemini can
+// read itself (e.g. uploaded files and gs:// objects)
+func imagePart(image types.ImageContent) (*genai.Part, error) {
+	mimeType := image.MimeType
+	encoded := image.Base64
+	if rest, ok := strings.CutPrefix(image.URL, "data:"); ok {
+		header, data, found := strings.Cut(rest, ",")
+		if !found || !strings.HasSuffix(header, ";base64") {
+			return nil, types.NewError(types.ErrCodeInvalidRequest, "image data URLs must be base64 encoded", "google")
+		}
+		mimeType = strings.TrimSuffix(header, ";base64")
+		encoded = data
+	} else if image.URL != "" {
+		return genai.NewPartFromURI(image.URL, mimeType), nil
+	}
+
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, types.WrapError(err, types.ErrCodeInvalidRequest, "google")
+	}
+	if mimeType == "" {
+		mimeType = "image/jpeg"
+	}
+	return genai.NewPartFromBytes(data, mimeType), nil
+}
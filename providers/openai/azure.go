@@ -0,0 +1,56 @@
+package openai
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/ztkent/ai-util/types"
+)
+
+// isAzure reports whether requests are routed to Azure OpenAI deployments
+func (c *Config) isAzure() bool {
+	return c.AzureEndpoint != ""
+}
+
+// azureClientConfig builds a go-openai client config for Azure OpenAI, which routes requests
+// to /openai/deployments/{deployment} with an api-version query parameter and api-key auth
+func (c *Config) azureClientConfig() openai.ClientConfig {
+	clientConfig := openai.DefaultAzureConfig(c.APIKey, c.AzureEndpoint)
+	if c.AzureAPIVersion != "" {
+		clientConfig.APIVersion = c.AzureAPIVersion
+	}
+	clientConfig.AzureModelMapperFunc = c.azureDeployment
+	return clientConfig
+}
+
+// azureDeployment returns the deployment serving a model. Models without a mapping are
+// assumed to be deployment names.
+func (c *Config) azureDeployment(model string) string {
+	if deployment, ok := c.AzureDeployments[model]; ok {
+		return deployment
+	}
+	return model
+}
+
+// azureModelIDs returns the model names mapped to deployments, sorted
+func (c *Config) azureModelIDs() []string {
+	ids := make([]string, 0, len(c.AzureDeployments))
+	for model := range c.AzureDeployments {
+		ids = append(ids, model)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// azureModels returns a model for each configured deployment. Properties are looked up by
+// model name, so they are only known for models named like the OpenAI model they serve.
+func (c *Config) azureModels() []*types.Model {
+	var models []*types.Model
+	for _, id := range c.azureModelIDs() {
+		model := newModel(id)
+		model.Description = fmt.Sprintf("Azure OpenAI deployment %s: %s", c.AzureDeployments[id], id)
+		models = append(models, model)
+	}
+	return models
+}
@@ -0,0 +1,59 @@
+package openai
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/ztkent/ai-util/types"
+)
+
+// contextLengthExceeded is the API error code for requests over the context window
+const contextLengthExceeded = "context_length_exceeded"
+
+// Token counts reported in context length error messages, e.g. "This model's maximum context
+// length is 8192 tokens. However, you requested 9000 tokens (8000 in the messages, 1000 in
+// the completion)."
+var (
+	contextLimitPattern     = regexp.MustCompile(`maximum context length is (\d+) tokens`)
+	requestedTokensPattern  = regexp.MustCompile(`\((\d+) in the messages, (\d+) in the completion\)`)
+	messagesResultedPattern = regexp.MustCompile(`messages resulted in (\d+) tokens`)
+)
+
+// wrapAPIError wraps an error from the OpenAI API. Context length errors are reported as
+// ErrCodeTokenLimitExceeded, with token details when the message includes the counts.
+func wrapAPIError(err error, req *types.CompletionRequest) *types.Error {
+	var apiErr *openai.APIError
+	if !errors.As(err, &apiErr) || fmt.Sprint(apiErr.Code) != contextLengthExceeded {
+		return types.WrapRequestError(err, "openai")
+	}
+
+	limit, limitOK := parseTokenCount(contextLimitPattern, apiErr.Message, 1)
+	prompt, promptOK := parseTokenCount(requestedTokensPattern, apiErr.Message, 1)
+	completion, completionOK := parseTokenCount(requestedTokensPattern, apiErr.Message, 2)
+	if !promptOK {
+		prompt, promptOK = parseTokenCount(messagesResultedPattern, apiErr.Message, 1)
+	}
+	if !completionOK {
+		completion = max(req.MaxTokens, 0)
+	}
+	if !limitOK || !promptOK {
+		return types.WrapError(err, types.ErrCodeTokenLimitExceeded, "openai")
+	}
+
+	tokenErr := types.NewTokenLimitError(prompt, completion, limit, "openai")
+	tokenErr.Cause = err
+	return tokenErr
+}
+
+// parseTokenCount returns the number captured by the given group of pattern in message
+func parseTokenCount(pattern *regexp.Regexp, message string, group int) (int, bool) {
+	match := pattern.FindStringSubmatch(message)
+	if match == nil {
+		return 0, false
+	}
+	count, err := strconv.Atoi(match[group])
+	return count, err == nil
+}
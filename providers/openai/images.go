@@ -0,0 +1,51 @@
+package openai
+
+import (
+	"context"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/ztkent/ai-util/types"
+)
+
+// isImageModel reports whether a model generates images rather than chat completions
+func isImageModel(modelID string) bool {
+	return strings.HasPrefix(modelID, "dall-e") || strings.HasPrefix(modelID, "gpt-image")
+}
+
+// GenerateImage generates images with DALL-E or GPT Image models
+func (p *Provider) GenerateImage(ctx context.Context, req *types.ImageRequest) (*types.ImageResponse, error) {
+	if p.client == nil {
+		return nil, types.NewError(types.ErrCodeInvalidConfig, "provider not initialized", "openai")
+	}
+
+	resp, err := p.client.CreateImage(ctx, openai.ImageRequest{
+		Model:   req.Model,
+		Prompt:  req.Prompt,
+		N:       req.N,
+		Size:    req.Size,
+		Quality: req.Quality,
+	})
+	if err != nil {
+		return nil, types.WrapRequestError(err, p.GetName())
+	}
+
+	images := make([]types.ImageData, len(resp.Data))
+	for i, data := range resp.Data {
+		images[i] = types.ImageData{
+			URL:           data.URL,
+			Data:          data.B64JSON,
+			RevisedPrompt: data.RevisedPrompt,
+		}
+		if data.B64JSON != "" {
+			images[i].MimeType = "image/png"
+		}
+	}
+
+	return &types.ImageResponse{
+		Model:    req.Model,
+		Provider: p.GetName(),
+		Images:   images,
+		Created:  resp.Created,
+	}, nil
+}
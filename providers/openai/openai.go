@@ -4,26 +4,44 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"math"
+	"net/http"
 	"strings"
+	"sync"
 
 	"github.com/sashabaranov/go-openai"
 	"github.com/ztkent/ai-util/types"
 )
 
+// maxTemperature is the highest temperature OpenAI accepts
+const maxTemperature = 2.0
+
 // Provider implements the OpenAI provider
 type Provider struct {
 	client *openai.Client
 	config *Config
+	name   string // Registered name for OpenAI-compatible servers
+
+	counterMu    sync.RWMutex
+	tokenCounter types.TokenCounter
 }
 
 // Config holds OpenAI-specific configuration
 type Config struct {
 	types.BaseConfig
-	OrgID            string  `json:"org_id,omitempty"`
-	Project          string  `json:"project,omitempty"`
-	PresencePenalty  float32 `json:"presence_penalty,omitempty"`
-	FrequencyPenalty float32 `json:"frequency_penalty,omitempty"`
-	User             string  `json:"user,omitempty"`
+	OrgID            string                `json:"org_id,omitempty"`
+	Project          string                `json:"project,omitempty"`
+	PresencePenalty  float32               `json:"presence_penalty,omitempty"`
+	FrequencyPenalty float32               `json:"frequency_penalty,omitempty"`
+	User             string                `json:"user,omitempty"`
+	RoleMap          map[types.Role]string `json:"role_map,omitempty"`      // Remap outgoing roles for compatible endpoints (e.g. system -> developer)
+	BetaFeatures     []string              `json:"beta_features,omitempty"` // Sent in the OpenAI-Beta header (e.g. "assistants=v2")
+	Compatible       bool                  `json:"compatible,omitempty"`    // OpenAI-compatible server (e.g. Ollama, vLLM): API key optional, any model accepted
+
+	// Azure OpenAI: setting AzureEndpoint routes requests to the deployment mapped to each model
+	AzureEndpoint    string            `json:"azure_endpoint,omitempty"`
+	AzureAPIVersion  string            `json:"azure_api_version,omitempty"` // Defaults to the go-openai Azure version
+	AzureDeployments map[string]string `json:"azure_deployments,omitempty"` // Model name -> deployment name
 }
 
 // NewProvider creates a new OpenAI provider
@@ -31,8 +49,17 @@ func NewProvider() *Provider {
 	return &Provider{}
 }
 
+// NewCompatibleProvider creates a provider for an OpenAI-compatible server, such as Ollama
+// or vLLM, registered under name. Its config must set Compatible.
+func NewCompatibleProvider(name string) *Provider {
+	return &Provider{name: name}
+}
+
 // GetName returns the provider name
 func (p *Provider) GetName() string {
+	if p.name != "" {
+		return p.name
+	}
 	return "openai"
 }
 
@@ -48,11 +75,25 @@ func (p *Provider) Initialize(config types.Config) error {
 	}
 
 	clientConfig := openai.DefaultConfig(openaiConfig.APIKey)
-	if openaiConfig.BaseURL != "" {
-		clientConfig.BaseURL = openaiConfig.BaseURL
+	if openaiConfig.isAzure() {
+		clientConfig = openaiConfig.azureClientConfig()
+	} else {
+		if openaiConfig.BaseURL != "" {
+			clientConfig.BaseURL = openaiConfig.BaseURL
+		}
+		if openaiConfig.OrgID != "" {
+			clientConfig.OrgID = openaiConfig.OrgID
+		}
+	}
+	transport := openaiConfig.HTTPTransport()
+	if len(openaiConfig.BetaFeatures) > 0 {
+		transport = &headerTransport{
+			base:    transport,
+			headers: map[string]string{"OpenAI-Beta": strings.Join(openaiConfig.BetaFeatures, ",")},
+		}
 	}
-	if openaiConfig.OrgID != "" {
-		clientConfig.OrgID = openaiConfig.OrgID
+	clientConfig.HTTPClient = &http.Client{
+		Transport: &predictionTransport{base: &idempotencyTransport{base: transport}},
 	}
 
 	p.client = openai.NewClientWithConfig(clientConfig)
@@ -61,12 +102,17 @@ func (p *Provider) Initialize(config types.Config) error {
 	return nil
 }
 
-// GetModels returns available OpenAI models
+// GetModels returns available OpenAI models. For Azure OpenAI, these are the configured
+// deployments.
 func (p *Provider) GetModels(ctx context.Context) ([]*types.Model, error) {
 	if p.client == nil {
 		return nil, types.NewError(types.ErrCodeInvalidConfig, "provider not initialized", "openai")
 	}
 
+	if p.config.isAzure() {
+		return p.config.azureModels(), nil
+	}
+
 	response, err := p.client.ListModels(ctx)
 	if err != nil {
 		return nil, types.WrapError(err, types.ErrCodeServerError, "openai")
@@ -74,44 +120,81 @@ func (p *Provider) GetModels(ctx context.Context) ([]*types.Model, error) {
 
 	var models []*types.Model
 	for _, model := range response.Models {
-		aiModel := &types.Model{
-			ID:           model.ID,
-			Name:         model.ID,
-			Provider:     "openai",
-			Description:  fmt.Sprintf("OpenAI model: %s", model.ID),
-			Capabilities: getModelCapabilities(model.ID),
-		}
-
-		// Set model-specific properties
-		if maxTokens, ok := getModelMaxTokens(model.ID); ok {
-			aiModel.MaxTokens = maxTokens
-		}
-
+		aiModel := newModel(model.ID)
+		aiModel.Provider = p.GetName()
 		models = append(models, aiModel)
 	}
 
 	return models, nil
 }
 
+// newModel creates a model with the known properties of an OpenAI model ID
+func newModel(id string) *types.Model {
+	model := &types.Model{
+		ID:           id,
+		Name:         id,
+		Provider:     "openai",
+		Description:  fmt.Sprintf("OpenAI model: %s", id),
+		Capabilities: getModelCapabilities(id),
+	}
+
+	// Set model-specific properties
+	if maxTokens, ok := getModelMaxTokens(id); ok {
+		model.MaxTokens = maxTokens
+	}
+	if inputCost, outputCost, ok := getModelPricing(id); ok {
+		model.InputCost = inputCost
+		model.OutputCost = outputCost
+	}
+
+	return model
+}
+
 // Complete performs a completion request
 func (p *Provider) Complete(ctx context.Context, req *types.CompletionRequest) (*types.CompletionResponse, error) {
 	if p.client == nil {
 		return nil, types.NewError(types.ErrCodeInvalidConfig, "provider not initialized", "openai")
 	}
 
+	ctx, cancel := p.config.WithRequestTimeout(ctx)
+	defer cancel()
+
 	// Convert to OpenAI format
 	openaiReq, err := p.convertRequest(req)
 	if err != nil {
 		return nil, err
 	}
 
+	// Multiple candidates are only requested here, since streams carry a single message
+	if req.N > 1 {
+		openaiReq.N = req.N
+	}
+
+	// Predicted outputs and idempotency keys are added to the request by the client transport
+	var prediction *predictionState
+	if req.Prediction != "" {
+		ctx, prediction = withPrediction(ctx, req.Prediction)
+	}
+	if req.IdempotencyKey != "" {
+		ctx = withIdempotencyKey(ctx, req.IdempotencyKey)
+	}
+
 	resp, err := p.client.CreateChatCompletion(ctx, *openaiReq)
 	if err != nil {
-		return nil, types.WrapError(err, types.ErrCodeServerError, "openai")
+		return nil, wrapAPIError(err, req)
+	}
+
+	if len(resp.Choices) == 0 && promptFiltered(resp.PromptFilterResults) {
+		return nil, types.NewError(types.ErrCodeContentFiltered, "prompt was blocked by the content filter", "openai")
 	}
 
 	// Convert response
-	return p.convertResponse(&resp), nil
+	result := p.convertResponse(&resp)
+	if prediction != nil {
+		result.Usage.AcceptedPredictionTokens = prediction.accepted
+		result.Usage.RejectedPredictionTokens = prediction.rejected
+	}
+	return result, nil
 }
 
 // Stream performs a streaming completion request
@@ -126,10 +209,17 @@ func (p *Provider) Stream(ctx context.Context, req *types.CompletionRequest, cal
 		return err
 	}
 	openaiReq.Stream = true
+	callback = types.RecoverCallback(callback, "openai")
+	if req.Prediction != "" {
+		ctx, _ = withPrediction(ctx, req.Prediction)
+	}
+	if req.IdempotencyKey != "" {
+		ctx = withIdempotencyKey(ctx, req.IdempotencyKey)
+	}
 
 	stream, err := p.client.CreateChatCompletionStream(ctx, *openaiReq)
 	if err != nil {
-		return types.WrapError(err, types.ErrCodeServerError, "openai")
+		return wrapAPIError(err, req)
 	}
 	defer stream.Close()
 
@@ -139,7 +229,7 @@ func (p *Provider) Stream(ctx context.Context, req *types.CompletionRequest, cal
 			if err == io.EOF {
 				break
 			}
-			return types.WrapError(err, types.ErrCodeServerError, "openai")
+			return wrapAPIError(err, req)
 		}
 
 		streamResp := p.convertStreamResponse(&response)
@@ -151,24 +241,47 @@ func (p *Provider) Stream(ctx context.Context, req *types.CompletionRequest, cal
 	return nil
 }
 
-// EstimateTokens estimates token count for messages
+// EstimateTokens estimates token count for messages with the provider's token counter,
+// ApproxCounter unless another is set
 func (p *Provider) EstimateTokens(ctx context.Context, messages []*types.Message, model string) (int, error) {
-	// This is a simplified estimation - in practice you'd use tiktoken or similar
-	totalTokens := 0
-	for _, msg := range messages {
-		text := msg.GetText()
-		// Rough estimation: ~4 characters per token
-		totalTokens += len(text) / 4
-	}
-	return totalTokens, nil
+	p.counterMu.RLock()
+	counter := p.tokenCounter
+	p.counterMu.RUnlock()
+
+	if counter == nil {
+		counter = ApproxCounter{}
+	}
+	return counter.Count(messages, model)
+}
+
+// SetTokenCounter replaces the token counter used by EstimateTokens; nil restores the default
+func (p *Provider) SetTokenCounter(tc types.TokenCounter) {
+	p.counterMu.Lock()
+	defer p.counterMu.Unlock()
+	p.tokenCounter = tc
 }
 
-// ValidateModel checks if a model is supported
+// supportedModels lists the model IDs this provider accepts
+var supportedModels = []string{
+	"gpt-4", "gpt-4-turbo", "gpt-4o", "gpt-4o-mini",
+	"gpt-5", "o3-preview", "o3-mini",
+	"o1-preview", "o1-mini", "gpt-4-1106-preview", "gpt-4-0125-preview",
+}
+
+// ValidateModel checks if a model is supported. For Azure OpenAI, the model must be mapped
+// to a configured deployment.
 func (p *Provider) ValidateModel(model string) error {
-	supportedModels := []string{
-		"gpt-4", "gpt-4-turbo", "gpt-4o", "gpt-4o-mini",
-		"gpt-5", "o3-preview", "o3-mini",
-		"o1-preview", "o1-mini", "gpt-4-1106-preview", "gpt-4-0125-preview",
+	if p.config != nil && p.config.isAzure() {
+		if _, ok := p.config.AzureDeployments[model]; ok {
+			return nil
+		}
+		return types.NewError(types.ErrCodeModelNotFound,
+			fmt.Sprintf("model %s has no Azure OpenAI deployment", model), "openai")
+	}
+
+	// Compatible servers host arbitrary models
+	if p.config != nil && p.config.Compatible {
+		return nil
 	}
 
 	for _, supported := range supportedModels {
@@ -202,13 +315,15 @@ func (p *Provider) convertRequest(req *types.CompletionRequest) (*openai.ChatCom
 	openaiReq := &openai.ChatCompletionRequest{
 		Model:       req.Model,
 		Messages:    messages,
-		MaxTokens:   req.MaxTokens,
-		Temperature: float32(req.Temperature),
-		TopP:        float32(req.TopP),
+		MaxTokens:   max(req.MaxTokens, 0), // MaxTokensUnlimited omits the parameter
+		Temperature: convertTemperature(req.Temperature),
+		TopP:        convertTopP(req.TopP),
 		Seed:        req.Seed,
 		Stop:        req.Stop,
 		Stream:      req.Stream,
 		User:        p.config.User,
+		LogProbs:    req.LogProbs || req.TopLogProbs > 0,
+		TopLogProbs: req.TopLogProbs,
 	}
 
 	// Add tools if present
@@ -225,6 +340,11 @@ func (p *Provider) convertRequest(req *types.CompletionRequest) (*openai.ChatCom
 			}
 		}
 		openaiReq.Tools = tools
+		toolChoice, err := convertToolChoice(req.ToolChoice)
+		if err != nil {
+			return nil, err
+		}
+		openaiReq.ToolChoice = toolChoice
 	}
 
 	// Add response format if present
@@ -234,13 +354,38 @@ func (p *Provider) convertRequest(req *types.CompletionRequest) (*openai.ChatCom
 		}
 	}
 
+	// Store the completion for the dashboard, with the request metadata
+	if req.Store != nil && *req.Store {
+		metadata, err := storedMetadata(req.Metadata)
+		if err != nil {
+			return nil, err
+		}
+		openaiReq.Store = true
+		openaiReq.Metadata = metadata
+	}
+
+	applyRequestOverrides(openaiReq, req)
+
 	return openaiReq, nil
 }
 
+// convertToolChoice converts a unified tool choice to OpenAI format, rejecting shapes
+// that types.NormalizeToolChoice does not recognize
+func convertToolChoice(choice interface{}) (interface{}, error) {
+	normalized, err := types.NormalizeToolChoice(choice)
+	if err != nil {
+		return nil, err
+	}
+	if forced, ok := normalized.(types.ForcedToolChoice); ok {
+		return openai.ToolChoice{Type: openai.ToolTypeFunction, Function: openai.ToolFunction{Name: forced.Name}}, nil
+	}
+	return normalized, nil
+}
+
 // convertMessage converts unified message to OpenAI format
 func (p *Provider) convertMessage(msg *types.Message) (*openai.ChatCompletionMessage, error) {
 	openaiMsg := &openai.ChatCompletionMessage{
-		Role: string(msg.Role),
+		Role: p.mapRole(msg.Role),
 	}
 
 	// Handle simple text content
@@ -266,7 +411,11 @@ func (p *Provider) convertMessage(msg *types.Message) (*openai.ChatCompletionMes
 				if c.URL != "" {
 					imageURL.URL = c.URL
 				} else if c.Base64 != "" {
-					imageURL.URL = fmt.Sprintf("data:image/jpeg;base64,%s", c.Base64)
+					mimeType := c.MimeType
+					if mimeType == "" {
+						mimeType = "image/jpeg"
+					}
+					imageURL.URL = fmt.Sprintf("data:%s;base64,%s", mimeType, c.Base64)
 				}
 				parts = append(parts, openai.ChatMessagePart{
 					Type:     openai.ChatMessagePartTypeImageURL,
@@ -302,32 +451,36 @@ func (p *Provider) convertMessage(msg *types.Message) (*openai.ChatCompletionMes
 	return openaiMsg, nil
 }
 
+// mapRole returns the outgoing role name, applying the configured RoleMap
+func (p *Provider) mapRole(role types.Role) string {
+	if p.config != nil {
+		if mapped, ok := p.config.RoleMap[role]; ok && mapped != "" {
+			return mapped
+		}
+	}
+	return string(role)
+}
+
 // convertResponse converts OpenAI response to unified format
 func (p *Provider) convertResponse(resp *openai.ChatCompletionResponse) *types.CompletionResponse {
-	var message *types.Message
+	// Responses without choices get an empty message and finish reason
+	message := &types.Message{Role: types.RoleAssistant}
+	var finishReason string
+	var choices []*types.Message
+	for _, choice := range resp.Choices {
+		choices = append(choices, choiceMessage(choice))
+	}
+	var metadata map[string]interface{}
 	if len(resp.Choices) > 0 {
-		choice := resp.Choices[0]
-		message = &types.Message{
-			Role:     types.Role(choice.Message.Role),
-			TextData: choice.Message.Content,
-		}
-
-		// Handle tool calls
-		if len(choice.Message.ToolCalls) > 0 {
-			var toolCalls []types.ToolCall
-			for _, tc := range choice.Message.ToolCalls {
-				toolCalls = append(toolCalls, types.ToolCall{
-					ID:   tc.ID,
-					Type: string(tc.Type),
-					Function: types.ToolCallFunction{
-						Name:      tc.Function.Name,
-						Arguments: tc.Function.Arguments,
-					},
-				})
-			}
-			message.ToolCalls = toolCalls
+		message = choices[0]
+		finishReason = string(resp.Choices[0].FinishReason)
+		if logProbs := resp.Choices[0].LogProbs; logProbs != nil {
+			metadata = map[string]interface{}{types.MetadataKeyLogProbs: convertLogProbs(logProbs)}
 		}
 	}
+	if len(choices) < 2 {
+		choices = nil
+	}
 
 	usage := &types.Usage{
 		PromptTokens:     resp.Usage.PromptTokens,
@@ -338,14 +491,91 @@ func (p *Provider) convertResponse(resp *openai.ChatCompletionResponse) *types.C
 	return &types.CompletionResponse{
 		ID:           resp.ID,
 		Model:        resp.Model,
-		Provider:     "openai",
+		Provider:     p.GetName(),
 		Message:      message,
-		FinishReason: string(resp.Choices[0].FinishReason),
+		Choices:      choices,
+		FinishReason: finishReason,
 		Usage:        usage,
+		Metadata:     metadata,
 		Created:      int64(resp.Created),
 	}
 }
 
+// choiceMessage converts a response choice into a message with its text and tool calls
+func choiceMessage(choice openai.ChatCompletionChoice) *types.Message {
+	message := &types.Message{
+		Role:     types.Role(choice.Message.Role),
+		TextData: choice.Message.Content,
+	}
+
+	// Handle tool calls
+	if len(choice.Message.ToolCalls) > 0 {
+		var toolCalls []types.ToolCall
+		for _, tc := range choice.Message.ToolCalls {
+			toolCalls = append(toolCalls, types.ToolCall{
+				ID:   tc.ID,
+				Type: string(tc.Type),
+				Function: types.ToolCallFunction{
+					Name:      tc.Function.Name,
+					Arguments: tc.Function.Arguments,
+				},
+			})
+		}
+		message.ToolCalls = toolCalls
+	}
+	return message
+}
+
+// convertLogProbs converts the log probabilities of a choice's content tokens
+func convertLogProbs(logProbs *openai.LogProbs) []types.TokenLogProb {
+	tokens := make([]types.TokenLogProb, 0, len(logProbs.Content))
+	for _, lp := range logProbs.Content {
+		token := types.TokenLogProb{Token: lp.Token, LogProb: lp.LogProb}
+		for _, top := range lp.TopLogProbs {
+			token.TopLogProbs = append(token.TopLogProbs, types.TopTokenProb{Token: top.Token, LogProb: top.LogProb})
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens
+}
+
+// convertTemperature clamps the temperature to OpenAI's range. go-openai omits a zero
+// temperature, so an explicit 0 is sent as the smallest positive value instead.
+func convertTemperature(temperature *float64) float32 {
+	temperature = types.NormalizeTemperature(temperature, maxTemperature, "openai")
+	if temperature == nil {
+		return 0
+	}
+	if *temperature == 0 {
+		return math.SmallestNonzeroFloat32
+	}
+	return float32(*temperature)
+}
+
+// convertTopP converts an optional top_p. go-openai omits a zero top_p, so an explicit 0 is
+// sent as the smallest positive value instead.
+func convertTopP(topP *float64) float32 {
+	if topP == nil {
+		return 0
+	}
+	if *topP == 0 {
+		return math.SmallestNonzeroFloat32
+	}
+	return float32(*topP)
+}
+
+// promptFiltered reports whether a content filter (Azure OpenAI) blocked the prompt
+func promptFiltered(results []openai.PromptFilterResult) bool {
+	for _, result := range results {
+		filters := result.ContentFilterResults
+		if filters.Hate.Filtered || filters.SelfHarm.Filtered || filters.Sexual.Filtered ||
+			filters.Violence.Filtered || filters.JailBreak.Filtered || filters.Profanity.Filtered {
+			return true
+		}
+	}
+	return false
+}
+
 // convertStreamResponse converts OpenAI stream response to unified format
 func (p *Provider) convertStreamResponse(resp *openai.ChatCompletionStreamResponse) *types.StreamResponse {
 	var delta *types.Message
@@ -369,6 +599,7 @@ func (p *Provider) convertStreamResponse(resp *openai.ChatCompletionStreamRespon
 						Name:      tc.Function.Name,
 						Arguments: tc.Function.Arguments,
 					},
+					Index: tc.Index,
 				})
 			}
 			delta.ToolCalls = toolCalls
@@ -389,15 +620,43 @@ func (p *Provider) convertStreamResponse(resp *openai.ChatCompletionStreamRespon
 	return &types.StreamResponse{
 		ID:           resp.ID,
 		Model:        resp.Model,
-		Provider:     "openai",
+		Provider:     p.GetName(),
 		Delta:        delta,
 		FinishReason: finishReason,
 		Usage:        usage,
 	}
 }
 
+// SupportedCapabilities returns the capabilities offered by any of the supported models
+func (p *Provider) SupportedCapabilities() []types.ModelCapability {
+	models := make([]*types.Model, len(supportedModels))
+	for i, id := range supportedModels {
+		models[i] = &types.Model{ID: id, Capabilities: getModelCapabilities(id)}
+	}
+	return types.CapabilitiesOf(models)
+}
+
+// Validate validates OpenAI-specific configuration. Compatible servers don't require an
+// API key, but need a base URL.
+func (c *Config) Validate() error {
+	if !c.Compatible {
+		return c.BaseConfig.Validate()
+	}
+	if c.Provider == "" {
+		return types.NewError(types.ErrCodeInvalidConfig, "provider is required", "")
+	}
+	if c.BaseURL == "" {
+		return types.NewError(types.ErrCodeInvalidConfig, "base_url is required for OpenAI-compatible servers", c.Provider)
+	}
+	return nil
+}
+
 // getModelCapabilities returns capabilities for a given model
 func getModelCapabilities(modelID string) []string {
+	if isImageModel(modelID) {
+		return []string{string(types.CapabilityImage)}
+	}
+
 	capabilities := []string{string(types.CapabilityChat), string(types.CapabilityStreaming)}
 
 	// Add tools capability for newer models
@@ -428,3 +687,22 @@ func getModelMaxTokens(modelID string) (int, bool) {
 	tokens, exists := maxTokens[modelID]
 	return tokens, exists
 }
+
+// getModelPricing returns input and output prices per 1M tokens for known models
+func getModelPricing(modelID string) (float64, float64, bool) {
+	pricing := map[string][2]float64{
+		"gpt-4":              {30.00, 60.00},
+		"gpt-4-turbo":        {10.00, 30.00},
+		"gpt-4-1106-preview": {10.00, 30.00},
+		"gpt-4-0125-preview": {10.00, 30.00},
+		"gpt-4o":             {2.50, 10.00},
+		"gpt-4o-mini":        {0.15, 0.60},
+		"gpt-5":              {1.25, 10.00},
+		"o1-preview":         {15.00, 60.00},
+		"o1-mini":            {1.10, 4.40},
+		"o3-mini":            {1.10, 4.40},
+	}
+
+	prices, exists := pricing[modelID]
+	return prices[0], prices[1], exists
+}
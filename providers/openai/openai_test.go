@@ -0,0 +1,742 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/ztkent/ai-util/types"
+)
+
+func TestOpenAIProvider_GetName(t *testing.T) {
+	provider := NewProvider()
+	if provider.GetName() != "openai" {
+		t.Errorf("Expected provider name 'openai', got '%s'", provider.GetName())
+	}
+}
+
+func TestOpenAIProvider_RoleMap(t *testing.T) {
+	provider := NewProvider()
+	provider.config = &Config{
+		BaseConfig: types.BaseConfig{
+			Provider: "openai",
+			APIKey:   "test-key",
+		},
+		RoleMap: map[types.Role]string{
+			types.RoleSystem: "developer",
+		},
+	}
+
+	req := &types.CompletionRequest{
+		Model: "gpt-4o-mini",
+		Messages: []*types.Message{
+			types.NewTextMessage(types.RoleSystem, "You are a test assistant"),
+			types.NewTextMessage(types.RoleUser, "Hello"),
+		},
+	}
+
+	openaiReq, err := provider.convertRequest(req)
+	if err != nil {
+		t.Fatalf("Failed to convert request: %v", err)
+	}
+
+	if openaiReq.Messages[0].Role != "developer" {
+		t.Errorf("Expected system role to be mapped to 'developer', got '%s'", openaiReq.Messages[0].Role)
+	}
+	if openaiReq.Messages[1].Role != "user" {
+		t.Errorf("Expected unmapped user role to be 'user', got '%s'", openaiReq.Messages[1].Role)
+	}
+}
+
+func TestOpenAIProvider_Prediction(t *testing.T) {
+	var body map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&body)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{
+			"id":"chatcmpl-1","object":"chat.completion","created":1,"model":"gpt-4o",
+			"choices":[{"index":0,"message":{"role":"assistant","content":"func add(a, b int) int"},"finish_reason":"stop"}],
+			"usage":{"prompt_tokens":20,"completion_tokens":10,"total_tokens":30,
+				"completion_tokens_details":{"accepted_prediction_tokens":8,"rejected_prediction_tokens":2}}
+		}`)
+	}))
+	defer server.Close()
+
+	provider := NewProvider()
+	err := provider.Initialize(&Config{
+		BaseConfig: types.BaseConfig{
+			Provider: "openai",
+			APIKey:   "test-key",
+			BaseURL:  server.URL,
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to initialize provider: %v", err)
+	}
+
+	resp, err := provider.Complete(context.Background(), &types.CompletionRequest{
+		Model:      "gpt-4o",
+		Messages:   []*types.Message{types.NewTextMessage(types.RoleUser, "Rename sum to add")},
+		Prediction: "func sum(a, b int) int",
+	})
+	if err != nil {
+		t.Fatalf("Complete failed: %v", err)
+	}
+
+	prediction, _ := body["prediction"].(map[string]interface{})
+	if prediction["type"] != "content" || prediction["content"] != "func sum(a, b int) int" {
+		t.Errorf("Expected prediction payload in request, got %v", body["prediction"])
+	}
+	if body["model"] != "gpt-4o" {
+		t.Errorf("Expected the rest of the request to be preserved, got model %v", body["model"])
+	}
+
+	if resp.Usage.AcceptedPredictionTokens != 8 {
+		t.Errorf("Expected 8 accepted prediction tokens, got %d", resp.Usage.AcceptedPredictionTokens)
+	}
+	if resp.Usage.RejectedPredictionTokens != 2 {
+		t.Errorf("Expected 2 rejected prediction tokens, got %d", resp.Usage.RejectedPredictionTokens)
+	}
+	if resp.Message.TextData != "func add(a, b int) int" {
+		t.Errorf("Expected response content to be preserved, got '%s'", resp.Message.TextData)
+	}
+}
+
+// bodyRecorder records the request bodies it is sent
+type bodyRecorder struct {
+	bodies []string
+}
+
+func (r *bodyRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, _ := io.ReadAll(req.Body)
+	r.bodies = append(r.bodies, string(body))
+	return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("{}")), Header: http.Header{}}, nil
+}
+
+func TestPredictionTransport_OnlyChatCompletions(t *testing.T) {
+	recorder := &bodyRecorder{}
+	transport := &predictionTransport{base: recorder}
+	ctx, _ := withPrediction(context.Background(), "expected output")
+
+	send := func(method, url, body string) {
+		t.Helper()
+		req, err := http.NewRequestWithContext(ctx, method, url, strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		resp, err := transport.RoundTrip(req)
+		if err != nil {
+			t.Fatalf("RoundTrip failed: %v", err)
+		}
+		resp.Body.Close()
+	}
+
+	send(http.MethodPost, "https://api.openai.com/v1/embeddings", `{"input":"hello"}`)
+	send(http.MethodPost, "https://api.openai.com/v1/chat/completions", `{"model":"gpt-4o"}`)
+
+	if recorder.bodies[0] != `{"input":"hello"}` {
+		t.Errorf("Expected other requests to pass through unchanged, got %s", recorder.bodies[0])
+	}
+	if !strings.Contains(recorder.bodies[1], `"prediction"`) {
+		t.Errorf("Expected the prediction on the chat completion request, got %s", recorder.bodies[1])
+	}
+}
+
+func TestOpenAIProvider_MaxTokensUnlimited(t *testing.T) {
+	provider := NewProvider()
+	provider.config = &Config{BaseConfig: types.BaseConfig{Provider: "openai", APIKey: "test-key"}}
+
+	for _, tc := range []struct {
+		maxTokens int
+		expected  interface{}
+	}{
+		{maxTokens: types.MaxTokensUnlimited, expected: nil},
+		{maxTokens: 4096, expected: float64(4096)},
+	} {
+		openaiReq, err := provider.convertRequest(&types.CompletionRequest{
+			Model:     "gpt-4o",
+			Messages:  []*types.Message{types.NewTextMessage(types.RoleUser, "Hello")},
+			MaxTokens: tc.maxTokens,
+		})
+		if err != nil {
+			t.Fatalf("Failed to convert request: %v", err)
+		}
+
+		data, _ := json.Marshal(openaiReq)
+		var body map[string]interface{}
+		json.Unmarshal(data, &body)
+		if body["max_tokens"] != tc.expected {
+			t.Errorf("MaxTokens %d: expected max_tokens %v, got %v", tc.maxTokens, tc.expected, body["max_tokens"])
+		}
+	}
+}
+
+func TestOpenAIProvider_BetaFeatures(t *testing.T) {
+	var betaHeader string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		betaHeader = r.Header.Get("OpenAI-Beta")
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":"chatcmpl-1","object":"chat.completion","created":1,"model":"gpt-4o",
+			"choices":[{"index":0,"message":{"role":"assistant","content":"Hi"},"finish_reason":"stop"}],
+			"usage":{"prompt_tokens":1,"completion_tokens":1,"total_tokens":2}}`)
+	}))
+	defer server.Close()
+
+	provider := NewProvider()
+	err := provider.Initialize(&Config{
+		BaseConfig: types.BaseConfig{
+			Provider: "openai",
+			APIKey:   "test-key",
+			BaseURL:  server.URL,
+		},
+		BetaFeatures: []string{"assistants=v2", "realtime=v1"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to initialize provider: %v", err)
+	}
+
+	_, err = provider.Complete(context.Background(), &types.CompletionRequest{
+		Model:    "gpt-4o",
+		Messages: []*types.Message{types.NewTextMessage(types.RoleUser, "Hello")},
+	})
+	if err != nil {
+		t.Fatalf("Complete failed: %v", err)
+	}
+
+	if betaHeader != "assistants=v2,realtime=v1" {
+		t.Errorf("Expected OpenAI-Beta header 'assistants=v2,realtime=v1', got '%s'", betaHeader)
+	}
+}
+
+func TestOpenAIProvider_RequestOverrides(t *testing.T) {
+	provider := NewProvider()
+	provider.config = &Config{BaseConfig: types.BaseConfig{Provider: "openai", APIKey: "test-key"}}
+
+	// Overrides may arrive as generic maps, e.g. from JSON-decoded requests
+	openaiReq, err := provider.convertRequest(&types.CompletionRequest{
+		Model:    "gpt-4o",
+		Messages: []*types.Message{types.NewTextMessage(types.RoleUser, "Hello")},
+		Metadata: map[string]interface{}{
+			OverridesMetadataKey: map[string]interface{}{
+				"n":                2,
+				"presence_penalty": 0.5,
+				"logprobs":         true,
+				"top_logprobs":     3,
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to convert request: %v", err)
+	}
+
+	if openaiReq.N != 2 || openaiReq.PresencePenalty != 0.5 || !openaiReq.LogProbs || openaiReq.TopLogProbs != 3 {
+		t.Errorf("Expected overrides to be applied, got n=%d presence_penalty=%v logprobs=%v top_logprobs=%d",
+			openaiReq.N, openaiReq.PresencePenalty, openaiReq.LogProbs, openaiReq.TopLogProbs)
+	}
+}
+
+func TestOpenAIProvider_Timeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	provider := NewProvider()
+	err := provider.Initialize(&Config{
+		BaseConfig: types.BaseConfig{
+			Provider: "openai",
+			APIKey:   "test-key",
+			BaseURL:  server.URL,
+			Timeout:  1,
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to initialize provider: %v", err)
+	}
+
+	start := time.Now()
+	_, err = provider.Complete(context.Background(), &types.CompletionRequest{
+		Model:    "gpt-4o",
+		Messages: []*types.Message{types.NewTextMessage(types.RoleUser, "Hello")},
+	})
+
+	var aiErr *types.Error
+	if !errors.As(err, &aiErr) || aiErr.Code != types.ErrCodeTimeout {
+		t.Fatalf("Expected %s error, got %v", types.ErrCodeTimeout, err)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("Expected request to abort after the 1s timeout, took %v", elapsed)
+	}
+}
+
+func TestOpenAIProvider_StreamPastTimeout(t *testing.T) {
+	// The stream starts at once, then runs past the 1s timeout
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i, text := range []string{"Hello", " there"} {
+			if i > 0 {
+				time.Sleep(1500 * time.Millisecond)
+			}
+			fmt.Fprintf(w, "data: {\"id\":\"1\",\"model\":\"gpt-4o\",\"choices\":[{\"index\":0,\"delta\":{\"content\":%q}}]}\n\n", text)
+			w.(http.Flusher).Flush()
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer server.Close()
+
+	provider := NewProvider()
+	err := provider.Initialize(&Config{
+		BaseConfig: types.BaseConfig{
+			Provider: "openai",
+			APIKey:   "test-key",
+			BaseURL:  server.URL,
+			Timeout:  1,
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to initialize provider: %v", err)
+	}
+
+	var text strings.Builder
+	err = provider.Stream(context.Background(), &types.CompletionRequest{
+		Model:    "gpt-4o",
+		Messages: []*types.Message{types.NewTextMessage(types.RoleUser, "Hello")},
+	}, func(ctx context.Context, chunk *types.StreamResponse) error {
+		if chunk.Delta != nil {
+			text.WriteString(chunk.Delta.TextData)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Expected the stream to outlast the timeout, got %v", err)
+	}
+	if text.String() != "Hello there" {
+		t.Errorf("Expected the full streamed text, got %q", text.String())
+	}
+}
+
+func TestOpenAIProvider_ForcedToolChoice(t *testing.T) {
+	provider := NewProvider()
+	provider.config = &Config{BaseConfig: types.BaseConfig{Provider: "openai", APIKey: "test-key"}}
+
+	openaiReq, err := provider.convertRequest(&types.CompletionRequest{
+		Model:    "gpt-4o",
+		Messages: []*types.Message{types.NewTextMessage(types.RoleUser, "Hello")},
+		Tools: []types.Tool{{
+			Type:     "function",
+			Function: &types.ToolFunction{Name: "respond", Parameters: map[string]interface{}{"type": "object"}},
+		}},
+		ToolChoice: types.ForcedToolChoice{Name: "respond"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to convert request: %v", err)
+	}
+
+	data, _ := json.Marshal(openaiReq.ToolChoice)
+	expected := `{"type":"function","function":{"name":"respond"}}`
+	if string(data) != expected {
+		t.Errorf("Expected tool_choice %s, got %s", expected, data)
+	}
+}
+
+func TestOpenAIProvider_StoredCompletion(t *testing.T) {
+	provider := NewProvider()
+	provider.config = &Config{BaseConfig: types.BaseConfig{Provider: "openai", APIKey: "test-key"}}
+
+	store := true
+	req := &types.CompletionRequest{
+		Model:    "gpt-4o",
+		Messages: []*types.Message{types.NewTextMessage(types.RoleUser, "Hello")},
+		Store:    &store,
+		Metadata: map[string]interface{}{
+			"feature":                        "onboarding",
+			OverridesMetadataKey:             &RequestOverrides{N: 1},
+			types.MetadataKeyGoogleOverrides: map[string]interface{}{"top_k": 40}, // Set for a fallback to Google
+		},
+	}
+	openaiReq, err := provider.convertRequest(req)
+	if err != nil {
+		t.Fatalf("Failed to convert request: %v", err)
+	}
+	if !openaiReq.Store {
+		t.Error("Expected store to be set")
+	}
+	if len(openaiReq.Metadata) != 1 || openaiReq.Metadata["feature"] != "onboarding" {
+		t.Errorf("Expected only the string metadata to be stored, got %v", openaiReq.Metadata)
+	}
+
+	// Stored metadata must be strings
+	req.Metadata["attempt"] = 2
+	var aiErr *types.Error
+	if _, err := provider.convertRequest(req); !errors.As(err, &aiErr) || aiErr.Code != types.ErrCodeInvalidRequest {
+		t.Errorf("Expected %s error for non-string metadata, got %v", types.ErrCodeInvalidRequest, err)
+	}
+
+	// Without store, metadata isn't sent
+	req.Store = nil
+	openaiReq, err = provider.convertRequest(req)
+	if err != nil {
+		t.Fatalf("Failed to convert request: %v", err)
+	}
+	if openaiReq.Store || openaiReq.Metadata != nil {
+		t.Errorf("Expected no stored completion, got store %v with metadata %v", openaiReq.Store, openaiReq.Metadata)
+	}
+}
+
+func TestConvertToolChoice(t *testing.T) {
+	forced := `{"type":"function","function":{"name":"respond"}}`
+	cases := []struct {
+		choice   interface{}
+		expected string
+	}{
+		{types.ToolChoiceAuto, `"auto"`},
+		{types.ToolChoiceNone, `"none"`},
+		{types.ToolChoiceRequired, `"required"`},
+		{types.ForceToolChoice("respond"), forced},
+		{map[string]interface{}{"type": "function", "function": map[string]interface{}{"name": "respond"}}, forced},
+		{openai.ToolChoice{Type: openai.ToolTypeFunction, Function: openai.ToolFunction{Name: "respond"}}, forced},
+	}
+	for _, tc := range cases {
+		choice, err := convertToolChoice(tc.choice)
+		if err != nil {
+			t.Fatalf("Failed to convert tool choice %v: %v", tc.choice, err)
+		}
+		data, _ := json.Marshal(choice)
+		if string(data) != tc.expected {
+			t.Errorf("Expected tool_choice %s for %v, got %s", tc.expected, tc.choice, data)
+		}
+	}
+
+	for _, choice := range []interface{}{"any", 42, map[string]interface{}{"type": "function"}} {
+		var aiErr *types.Error
+		if _, err := convertToolChoice(choice); !errors.As(err, &aiErr) || aiErr.Code != types.ErrCodeInvalidRequest {
+			t.Errorf("Expected %s error for %v, got %v", types.ErrCodeInvalidRequest, choice, err)
+		}
+	}
+}
+
+func TestOpenAIProvider_ContextLengthExceeded(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"error":{"message":"This model's maximum context length is 8192 tokens. However, you requested 9000 tokens (8000 in the messages, 1000 in the completion). Please reduce the length of the messages or completion.","type":"invalid_request_error","param":"messages","code":"context_length_exceeded"}}`)
+	}))
+	defer server.Close()
+
+	provider := NewProvider()
+	err := provider.Initialize(&Config{
+		BaseConfig: types.BaseConfig{
+			Provider: "openai",
+			APIKey:   "test-key",
+			BaseURL:  server.URL,
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to initialize provider: %v", err)
+	}
+
+	_, err = provider.Complete(context.Background(), &types.CompletionRequest{
+		Model:     "gpt-4",
+		MaxTokens: 1000,
+		Messages:  []*types.Message{types.NewTextMessage(types.RoleUser, "Hello")},
+	})
+
+	var aiErr *types.Error
+	if !errors.As(err, &aiErr) || aiErr.Code != types.ErrCodeTokenLimitExceeded {
+		t.Fatalf("Expected %s error, got %v", types.ErrCodeTokenLimitExceeded, err)
+	}
+	expected := map[string]int{
+		types.DetailPromptTokens: 8000,
+		types.DetailMaxTokens:    1000,
+		types.DetailModelLimit:   8192,
+		types.DetailOverage:      808,
+	}
+	for key, value := range expected {
+		if aiErr.Details[key] != value {
+			t.Errorf("Expected %s = %d, got %v", key, value, aiErr.Details[key])
+		}
+	}
+}
+
+func TestOpenAIProvider_Azure(t *testing.T) {
+	var path, apiVersion, apiKey, auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		apiVersion = r.URL.Query().Get("api-version")
+		apiKey = r.Header.Get("api-key")
+		auth = r.Header.Get("Authorization")
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{
+			"id":"chatcmpl-1","object":"chat.completion","created":1,"model":"gpt-4o",
+			"choices":[{"index":0,"message":{"role":"assistant","content":"Hi"},"finish_reason":"stop"}],
+			"usage":{"prompt_tokens":5,"completion_tokens":1,"total_tokens":6}
+		}`)
+	}))
+	defer server.Close()
+
+	provider := NewProvider()
+	err := provider.Initialize(&Config{
+		BaseConfig:       types.BaseConfig{Provider: "openai", APIKey: "azure-key"},
+		AzureEndpoint:    server.URL,
+		AzureAPIVersion:  "2024-10-21",
+		AzureDeployments: map[string]string{"gpt-4o": "prod-gpt4o"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to initialize provider: %v", err)
+	}
+
+	resp, err := provider.Complete(context.Background(), &types.CompletionRequest{
+		Model:    "gpt-4o",
+		Messages: []*types.Message{types.NewTextMessage(types.RoleUser, "Hello")},
+	})
+	if err != nil {
+		t.Fatalf("Complete failed: %v", err)
+	}
+	if resp.Message.TextData != "Hi" {
+		t.Errorf("Expected response text 'Hi', got %q", resp.Message.TextData)
+	}
+
+	if path != "/openai/deployments/prod-gpt4o/chat/completions" {
+		t.Errorf("Expected request routed to the deployment, got path %s", path)
+	}
+	if apiVersion != "2024-10-21" {
+		t.Errorf("Expected api-version 2024-10-21, got %q", apiVersion)
+	}
+	if apiKey != "azure-key" || auth != "" {
+		t.Errorf("Expected api-key auth without a bearer token, got api-key %q and Authorization %q", apiKey, auth)
+	}
+
+	models, err := provider.GetModels(context.Background())
+	if err != nil {
+		t.Fatalf("GetModels failed: %v", err)
+	}
+	if len(models) != 1 || models[0].ID != "gpt-4o" || models[0].MaxTokens != 128000 {
+		t.Errorf("Expected the configured deployment as the only model, got %+v", models)
+	}
+	if err := provider.ValidateModel("gpt-4o"); err != nil {
+		t.Errorf("Expected deployed model to validate, got %v", err)
+	}
+	if err := provider.ValidateModel("gpt-4"); err == nil {
+		t.Error("Expected model without a deployment to fail validation")
+	}
+}
+
+func TestOpenAIProvider_GenerateImage(t *testing.T) {
+	var path string
+	var body map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		json.NewDecoder(r.Body).Decode(&body)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"created":1700000000,"data":[
+			{"url":"https://example.com/cat.png","revised_prompt":"A fluffy cat"},
+			{"b64_json":"aGVsbG8="}
+		]}`)
+	}))
+	defer server.Close()
+
+	provider := NewProvider()
+	err := provider.Initialize(&Config{
+		BaseConfig: types.BaseConfig{
+			Provider: "openai",
+			APIKey:   "test-key",
+			BaseURL:  server.URL,
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to initialize provider: %v", err)
+	}
+
+	resp, err := provider.GenerateImage(context.Background(), &types.ImageRequest{
+		Model:   "dall-e-3",
+		Prompt:  "A cat",
+		N:       2,
+		Size:    "1024x1024",
+		Quality: "hd",
+	})
+	if err != nil {
+		t.Fatalf("GenerateImage failed: %v", err)
+	}
+
+	if path != "/images/generations" {
+		t.Errorf("Expected request to /images/generations, got %s", path)
+	}
+	if body["model"] != "dall-e-3" || body["size"] != "1024x1024" || body["quality"] != "hd" || body["n"] != float64(2) {
+		t.Errorf("Unexpected request body: %v", body)
+	}
+	if len(resp.Images) != 2 {
+		t.Fatalf("Expected 2 images, got %d", len(resp.Images))
+	}
+	if resp.Images[0].URL != "https://example.com/cat.png" || resp.Images[0].RevisedPrompt != "A fluffy cat" {
+		t.Errorf("Unexpected URL image: %+v", resp.Images[0])
+	}
+	if resp.Images[1].Data != "aGVsbG8=" || resp.Images[1].MimeType != "image/png" {
+		t.Errorf("Unexpected base64 image: %+v", resp.Images[1])
+	}
+
+	if capabilities := getModelCapabilities("dall-e-3"); len(capabilities) != 1 || capabilities[0] != string(types.CapabilityImage) {
+		t.Errorf("Expected dall-e-3 to have only the image capability, got %v", capabilities)
+	}
+}
+
+func TestOpenAIProvider_ConvertResponseNoChoices(t *testing.T) {
+	provider := NewProvider()
+	resp := provider.convertResponse(&openai.ChatCompletionResponse{
+		ID:    "chatcmpl-1",
+		Model: "gpt-4o",
+		Usage: openai.Usage{PromptTokens: 5, TotalTokens: 5},
+	})
+
+	if resp.FinishReason != "" {
+		t.Errorf("Expected an empty finish reason, got '%s'", resp.FinishReason)
+	}
+	if resp.Message == nil || resp.Message.TextData != "" {
+		t.Errorf("Expected an empty message, got %+v", resp.Message)
+	}
+	if resp.Usage.PromptTokens != 5 {
+		t.Errorf("Expected usage to be kept, got %+v", resp.Usage)
+	}
+}
+
+func TestOpenAIProvider_PromptFiltered(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{
+			"id":"chatcmpl-1","object":"chat.completion","created":1,"model":"gpt-4o","choices":[],
+			"prompt_filter_results":[{"index":0,"content_filter_results":{"violence":{"filtered":true,"severity":"high"}}}],
+			"usage":{"prompt_tokens":5,"completion_tokens":0,"total_tokens":5}
+		}`)
+	}))
+	defer server.Close()
+
+	provider := NewProvider()
+	err := provider.Initialize(&Config{
+		BaseConfig: types.BaseConfig{Provider: "openai", APIKey: "test-key", BaseURL: server.URL},
+	})
+	if err != nil {
+		t.Fatalf("Failed to initialize provider: %v", err)
+	}
+
+	_, err = provider.Complete(context.Background(), &types.CompletionRequest{
+		Model:    "gpt-4o",
+		Messages: []*types.Message{types.NewTextMessage(types.RoleUser, "Hello")},
+	})
+	var aiErr *types.Error
+	if !errors.As(err, &aiErr) || aiErr.Code != types.ErrCodeContentFiltered {
+		t.Errorf("Expected %s error, got %v", types.ErrCodeContentFiltered, err)
+	}
+}
+
+func TestOpenAIProvider_MultipleChoices(t *testing.T) {
+	var body map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&body)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{
+			"id":"chatcmpl-1","object":"chat.completion","created":1,"model":"gpt-4o",
+			"choices":[
+				{"index":0,"message":{"role":"assistant","content":"Red"},"finish_reason":"stop"},
+				{"index":1,"message":{"role":"assistant","content":"Green"},"finish_reason":"stop"},
+				{"index":2,"message":{"role":"assistant","content":"Blue"},"finish_reason":"stop"}
+			],
+			"usage":{"prompt_tokens":5,"completion_tokens":3,"total_tokens":8}
+		}`)
+	}))
+	defer server.Close()
+
+	provider := NewProvider()
+	err := provider.Initialize(&Config{
+		BaseConfig: types.BaseConfig{Provider: "openai", APIKey: "test-key", BaseURL: server.URL},
+	})
+	if err != nil {
+		t.Fatalf("Failed to initialize provider: %v", err)
+	}
+
+	resp, err := provider.Complete(context.Background(), &types.CompletionRequest{
+		Model:    "gpt-4o",
+		Messages: []*types.Message{types.NewTextMessage(types.RoleUser, "Name a color")},
+		N:        3,
+	})
+	if err != nil {
+		t.Fatalf("Complete failed: %v", err)
+	}
+
+	if body["n"] != 3.0 {
+		t.Errorf("Expected n=3 in the request, got %v", body["n"])
+	}
+	if len(resp.Choices) != 3 {
+		t.Fatalf("Expected 3 choices, got %d", len(resp.Choices))
+	}
+	for i, expected := range []string{"Red", "Green", "Blue"} {
+		if resp.Choices[i].TextData != expected {
+			t.Errorf("Expected choice %d to be %q, got %q", i, expected, resp.Choices[i].TextData)
+		}
+	}
+	if resp.Message != resp.Choices[0] {
+		t.Errorf("Expected Message to be the first choice, got %+v", resp.Message)
+	}
+}
+
+func TestOpenAIProvider_LogProbs(t *testing.T) {
+	var body map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&body)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{
+			"id":"chatcmpl-1","object":"chat.completion","created":1,"model":"gpt-4o",
+			"choices":[{"index":0,"message":{"role":"assistant","content":"Yes."},"finish_reason":"stop",
+				"logprobs":{"content":[
+					{"token":"Yes","logprob":-0.01,"top_logprobs":[{"token":"Yes","logprob":-0.01},{"token":"No","logprob":-4.6}]},
+					{"token":".","logprob":-0.2,"top_logprobs":[{"token":".","logprob":-0.2},{"token":"!","logprob":-1.7}]}
+				]}
+			}],
+			"usage":{"prompt_tokens":5,"completion_tokens":2,"total_tokens":7}
+		}`)
+	}))
+	defer server.Close()
+
+	provider := NewProvider()
+	err := provider.Initialize(&Config{
+		BaseConfig: types.BaseConfig{Provider: "openai", APIKey: "test-key", BaseURL: server.URL},
+	})
+	if err != nil {
+		t.Fatalf("Failed to initialize provider: %v", err)
+	}
+
+	resp, err := provider.Complete(context.Background(), &types.CompletionRequest{
+		Model:       "gpt-4o",
+		Messages:    []*types.Message{types.NewTextMessage(types.RoleUser, "Is the sky blue?")},
+		TopLogProbs: 2,
+	})
+	if err != nil {
+		t.Fatalf("Complete failed: %v", err)
+	}
+
+	if body["logprobs"] != true || body["top_logprobs"] != 2.0 {
+		t.Errorf("Expected logprobs with 2 alternatives in the request, got logprobs=%v top_logprobs=%v", body["logprobs"], body["top_logprobs"])
+	}
+
+	logProbs, ok := resp.LogProbs()
+	if !ok || len(logProbs) != 2 {
+		t.Fatalf("Expected log probabilities for 2 tokens, got %+v", logProbs)
+	}
+	if logProbs[0].Token != "Yes" || logProbs[0].LogProb != -0.01 {
+		t.Errorf("Expected first token 'Yes' at -0.01, got %+v", logProbs[0])
+	}
+	if len(logProbs[0].TopLogProbs) != 2 || logProbs[0].TopLogProbs[1].Token != "No" || logProbs[0].TopLogProbs[1].LogProb != -4.6 {
+		t.Errorf("Expected 'No' as the second alternative, got %+v", logProbs[0].TopLogProbs)
+	}
+}
@@ -0,0 +1,53 @@
+package openai
+
+import (
+	"github.com/sashabaranov/go-openai"
+	"github.com/ztkent/ai-util/types"
+)
+
+// OverridesMetadataKey is the CompletionRequest.Metadata key for RequestOverrides
+const OverridesMetadataKey = types.MetadataKeyOpenAIOverrides
+
+// RequestOverrides sets OpenAI request options that the unified request doesn't cover.
+// Attach it to CompletionRequest.Metadata under OverridesMetadataKey. Overrides are applied
+// after the unified request fields and provider config, so any override that is set takes
+// precedence.
+type RequestOverrides struct {
+	N                 int            `json:"n,omitempty"`
+	PresencePenalty   *float32       `json:"presence_penalty,omitempty"`
+	FrequencyPenalty  *float32       `json:"frequency_penalty,omitempty"`
+	LogitBias         map[string]int `json:"logit_bias,omitempty"`
+	Logprobs          bool           `json:"logprobs,omitempty"`
+	TopLogprobs       int            `json:"top_logprobs,omitempty"`
+	ParallelToolCalls *bool          `json:"parallel_tool_calls,omitempty"`
+}
+
+// applyRequestOverrides merges any request overrides into the OpenAI request
+func applyRequestOverrides(openaiReq *openai.ChatCompletionRequest, req *types.CompletionRequest) {
+	var overrides RequestOverrides
+	if !req.ProviderOverrides(OverridesMetadataKey, &overrides) {
+		return
+	}
+
+	if overrides.N > 0 {
+		openaiReq.N = overrides.N
+	}
+	if overrides.PresencePenalty != nil {
+		openaiReq.PresencePenalty = *overrides.PresencePenalty
+	}
+	if overrides.FrequencyPenalty != nil {
+		openaiReq.FrequencyPenalty = *overrides.FrequencyPenalty
+	}
+	if overrides.LogitBias != nil {
+		openaiReq.LogitBias = overrides.LogitBias
+	}
+	if overrides.Logprobs {
+		openaiReq.LogProbs = true
+	}
+	if overrides.TopLogprobs > 0 {
+		openaiReq.TopLogProbs = overrides.TopLogprobs
+	}
+	if overrides.ParallelToolCalls != nil {
+		openaiReq.ParallelToolCalls = *overrides.ParallelToolCalls
+	}
+}
@@ -0,0 +1,103 @@
+package openai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// go-openai doesn't model predicted outputs, so the prediction parameter is added to the
+// outgoing request body and the prediction token counts are read from the raw response.
+
+type predictionKey struct{}
+
+// predictionState carries a request's prediction to the transport and its token usage back
+type predictionState struct {
+	content  string
+	accepted int
+	rejected int
+}
+
+// withPrediction returns a context that injects the prediction content into the request
+func withPrediction(ctx context.Context, content string) (context.Context, *predictionState) {
+	state := &predictionState{content: content}
+	return context.WithValue(ctx, predictionKey{}, state), state
+}
+
+// predictionTransport adds the prediction parameter to chat completion requests
+type predictionTransport struct {
+	base http.RoundTripper
+}
+
+func (t *predictionTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	state, ok := requestPrediction(req)
+	if !ok {
+		return t.base.RoundTrip(req)
+	}
+
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	var payload map[string]json.RawMessage
+	if err := json.Unmarshal(body, &payload); err == nil {
+		payload["prediction"], _ = json.Marshal(map[string]string{
+			"type":    "content",
+			"content": state.content,
+		})
+		if encoded, err := json.Marshal(payload); err == nil {
+			body = encoded
+		}
+	}
+
+	outReq := req.Clone(req.Context())
+	outReq.Body = io.NopCloser(bytes.NewReader(body))
+	outReq.ContentLength = int64(len(body))
+	outReq.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+
+	resp, err := t.base.RoundTrip(outReq)
+	if err != nil || resp.StatusCode != http.StatusOK || strings.Contains(resp.Header.Get("Content-Type"), "text/event-stream") {
+		return resp, err
+	}
+
+	// Read the prediction token counts, then restore the body for go-openai
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	var usage struct {
+		Usage struct {
+			CompletionTokensDetails struct {
+				AcceptedPredictionTokens int `json:"accepted_prediction_tokens"`
+				RejectedPredictionTokens int `json:"rejected_prediction_tokens"`
+			} `json:"completion_tokens_details"`
+		} `json:"usage"`
+	}
+	if err := json.Unmarshal(respBody, &usage); err == nil {
+		state.accepted = usage.Usage.CompletionTokensDetails.AcceptedPredictionTokens
+		state.rejected = usage.Usage.CompletionTokensDetails.RejectedPredictionTokens
+	}
+
+	return resp, nil
+}
+
+// requestPrediction returns the prediction for a chat completion request that carries one.
+// Other requests made with the same context pass through unchanged.
+func requestPrediction(req *http.Request) (*predictionState, bool) {
+	state, ok := req.Context().Value(predictionKey{}).(*predictionState)
+	if !ok || state.content == "" || req.Body == nil || req.Method != http.MethodPost ||
+		!strings.HasSuffix(req.URL.Path, "/chat/completions") {
+		return nil, false
+	}
+	return state, true
+}
@@ -0,0 +1,54 @@
+package openai
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/ztkent/ai-util/types"
+)
+
+// Limits OpenAI places on metadata stored with a completion
+const (
+	maxStoredMetadata      = 16
+	maxMetadataKeyLength   = 64
+	maxMetadataValueLength = 512
+)
+
+// storedMetadata converts request metadata to the metadata stored with a completion. Provider
+// overrides, for any provider, are skipped; every other value must be a string within
+// OpenAI's limits.
+func storedMetadata(metadata map[string]interface{}) (map[string]string, error) {
+	keys := make([]string, 0, len(metadata))
+	for key := range metadata {
+		if !types.IsInternalMetadataKey(key) {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	if len(keys) > maxStoredMetadata {
+		return nil, types.NewError(types.ErrCodeInvalidRequest,
+			fmt.Sprintf("stored completions allow at most %d metadata entries, got %d", maxStoredMetadata, len(keys)), "openai")
+	}
+
+	sort.Strings(keys)
+	stored := make(map[string]string, len(keys))
+	for _, key := range keys {
+		value, ok := metadata[key].(string)
+		if !ok {
+			return nil, types.NewError(types.ErrCodeInvalidRequest,
+				fmt.Sprintf("stored completion metadata %q must be a string, got %T", key, metadata[key]), "openai")
+		}
+		if len(key) > maxMetadataKeyLength {
+			return nil, types.NewError(types.ErrCodeInvalidRequest,
+				fmt.Sprintf("stored completion metadata key %q is longer than %d characters", key, maxMetadataKeyLength), "openai")
+		}
+		if len(value) > maxMetadataValueLength {
+			return nil, types.NewError(types.ErrCodeInvalidRequest,
+				fmt.Sprintf("stored completion metadata %q is longer than %d characters", key, maxMetadataValueLength), "openai")
+		}
+		stored[key] = value
+	}
+	return stored, nil
+}
@@ -0,0 +1,93 @@
+package openai
+
+import (
+	"strings"
+
+	"github.com/ztkent/ai-util/types"
+)
+
+// Tokenizer encodings used by OpenAI chat models
+const (
+	EncodingCL100kBase = "cl100k_base"
+	EncodingO200kBase  = "o200k_base"
+)
+
+// Fixed overhead OpenAI documents for chat formatting: each message is wrapped in
+// role/separator tokens, and every reply is primed with <|start|>assistant<|message|>
+const (
+	tokensPerMessage   = 3
+	tokensReplyPriming = 3
+)
+
+// o200kModelPrefixes lists the model families that use the o200k_base encoding
+var o200kModelPrefixes = []string{"gpt-4o", "chatgpt-4o", "gpt-4.1", "gpt-4.5", "gpt-5", "o1", "o3", "o4"}
+
+// EncodingForModel returns the tokenizer encoding for a model: o200k_base for gpt-4o and the
+// o-series, cl100k_base for gpt-4, gpt-3.5 and anything unrecognized
+func EncodingForModel(model string) string {
+	for _, prefix := range o200kModelPrefixes {
+		if strings.HasPrefix(model, prefix) {
+			return EncodingO200kBase
+		}
+	}
+	return EncodingCL100kBase
+}
+
+// TokenEncoder returns the number of tokens text encodes to with the named encoding
+// (EncodingCL100kBase or EncodingO200kBase), e.g. from a tiktoken implementation
+type TokenEncoder func(encoding, text string) (int, error)
+
+// ApproxCounter estimates OpenAI chat tokens at ~4 characters per token, plus the
+// per-message and reply priming overhead. It is the provider's default counter, since the
+// BPE tables for exact counts aren't bundled.
+type ApproxCounter struct{}
+
+// Count estimates the prompt tokens for messages
+func (ApproxCounter) Count(messages []*types.Message, model string) (int, error) {
+	return countChatTokens(messages, func(text string) (int, error) {
+		return types.ApproxTextTokens(text), nil
+	})
+}
+
+// EncoderCounter counts OpenAI chat tokens exactly, encoding each message's text and tool
+// calls with Encoder in the model's encoding (see EncodingForModel), plus the per-message and
+// reply priming overhead. Set it with Client.SetTokenCounter.
+type EncoderCounter struct {
+	Encoder TokenEncoder
+}
+
+// Count counts the prompt tokens for messages sent to model
+func (c EncoderCounter) Count(messages []*types.Message, model string) (int, error) {
+	if c.Encoder == nil {
+		return 0, types.NewError(types.ErrCodeInvalidConfig, "EncoderCounter needs an Encoder", "openai")
+	}
+	encoding := EncodingForModel(model)
+	return countChatTokens(messages, func(text string) (int, error) {
+		return c.Encoder(encoding, text)
+	})
+}
+
+// countChatTokens counts the tokens for messages with countText, adding the chat overhead
+func countChatTokens(messages []*types.Message, countText func(text string) (int, error)) (int, error) {
+	if len(messages) == 0 {
+		return 0, nil
+	}
+
+	total := tokensReplyPriming
+	for _, msg := range messages {
+		texts := []string{msg.FlattenToText()}
+		for _, tc := range msg.ToolCalls {
+			texts = append(texts, tc.Function.Name, tc.Function.Arguments)
+		}
+
+		total += tokensPerMessage
+		for _, text := range texts {
+			tokens, err := countText(text)
+			if err != nil {
+				return 0, err
+			}
+			total += tokens
+		}
+	}
+	return total, nil
+}
@@ -0,0 +1,112 @@
+package openai
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ztkent/ai-util/types"
+)
+
+func TestEncodingForModel(t *testing.T) {
+	tests := []struct {
+		model    string
+		expected string
+	}{
+		{"gpt-4", EncodingCL100kBase},
+		{"gpt-4-turbo", EncodingCL100kBase},
+		{"gpt-3.5-turbo", EncodingCL100kBase},
+		{"gpt-4o", EncodingO200kBase},
+		{"gpt-4o-mini", EncodingO200kBase},
+		{"gpt-5", EncodingO200kBase},
+		{"o1-preview", EncodingO200kBase},
+		{"o3-mini", EncodingO200kBase},
+		{"unknown-model", EncodingCL100kBase},
+	}
+
+	for _, tt := range tests {
+		if encoding := EncodingForModel(tt.model); encoding != tt.expected {
+			t.Errorf("Expected %s for %s, got %s", tt.expected, tt.model, encoding)
+		}
+	}
+}
+
+func TestOpenAIProvider_EstimateTokens(t *testing.T) {
+	provider := NewProvider()
+
+	tokens, err := provider.EstimateTokens(context.Background(), nil, "gpt-4o")
+	if err != nil {
+		t.Fatalf("EstimateTokens failed: %v", err)
+	}
+	if tokens != 0 {
+		t.Errorf("Expected 0 tokens for no messages, got %d", tokens)
+	}
+
+	messages := []*types.Message{
+		types.NewTextMessage(types.RoleSystem, "Be brief."),      // 9 chars -> 3 tokens
+		types.NewTextMessage(types.RoleUser, "Hello there, bot"), // 16 chars -> 4 tokens
+	}
+	tokens, err = provider.EstimateTokens(context.Background(), messages, "gpt-4o")
+	if err != nil {
+		t.Fatalf("EstimateTokens failed: %v", err)
+	}
+
+	// 3 priming + 2 messages * 3 overhead + 7 content tokens
+	if tokens != 16 {
+		t.Errorf("Expected 16 tokens, got %d", tokens)
+	}
+	// The content is approximated the same way as the shared types.ApproxTokenCounter
+	content, _ := types.ApproxTokenCounter{}.Count(messages, "gpt-4o")
+	if tokens-content != tokensReplyPriming+2*tokensPerMessage {
+		t.Errorf("Expected only chat overhead on top of the shared estimate of %d, got %d", content, tokens)
+	}
+}
+
+func TestEncoderCounter(t *testing.T) {
+	var encodings []string
+	counter := EncoderCounter{Encoder: func(encoding, text string) (int, error) {
+		encodings = append(encodings, encoding)
+		return len(strings.Fields(text)), nil
+	}}
+
+	messages := []*types.Message{
+		types.NewTextMessage(types.RoleUser, "What is the weather?"), // 4 tokens
+		{Role: types.RoleAssistant, ToolCalls: []types.ToolCall{{
+			Function: types.ToolCallFunction{Name: "weather", Arguments: `{"city": "Paris"}`}, // 1 + 2 tokens
+		}}},
+	}
+	tokens, err := counter.Count(messages, "gpt-4o")
+	if err != nil {
+		t.Fatalf("Count failed: %v", err)
+	}
+
+	// 3 priming + 2 messages * 3 overhead + 7 encoded tokens
+	if tokens != 16 {
+		t.Errorf("Expected 16 tokens, got %d", tokens)
+	}
+	if len(encodings) == 0 || encodings[0] != EncodingO200kBase {
+		t.Errorf("Expected the %s encoding for gpt-4o, got %v", EncodingO200kBase, encodings)
+	}
+
+	// The provider estimates with a counter set on it, and the default again once cleared
+	provider := NewProvider()
+	provider.SetTokenCounter(types.TokenCounterFunc(func(messages []*types.Message, model string) (int, error) {
+		return 42, nil
+	}))
+	if tokens, _ := provider.EstimateTokens(context.Background(), messages, "gpt-4o"); tokens != 42 {
+		t.Errorf("Expected 42 tokens from the custom counter, got %d", tokens)
+	}
+	provider.SetTokenCounter(nil)
+	if tokens, _ := provider.EstimateTokens(context.Background(), messages, "gpt-4o"); tokens == 42 {
+		t.Error("Expected the default counter after clearing the custom one")
+	}
+}
+
+func TestEncoderCounter_NoEncoder(t *testing.T) {
+	var aiErr *types.Error
+	_, err := EncoderCounter{}.Count([]*types.Message{types.NewTextMessage(types.RoleUser, "Hi")}, "gpt-4o")
+	if !errors.As(err, &aiErr) || aiErr.Code != types.ErrCodeInvalidConfig {
+		t.Errorf("Expected %s error without an Encoder, got %v", types.ErrCodeInvalidConfig, err)
+	}
+}
@@ -0,0 +1,43 @@
+package openai
+
+import (
+	"context"
+	"net/http"
+)
+
+// headerTransport sets fixed headers on every outgoing request
+type headerTransport struct {
+	base    http.RoundTripper
+	headers map[string]string
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	outReq := req.Clone(req.Context())
+	for key, value := range t.headers {
+		outReq.Header.Set(key, value)
+	}
+	return t.base.RoundTrip(outReq)
+}
+
+type idempotencyKey struct{}
+
+// withIdempotencyKey returns a context that sends key as the request's Idempotency-Key header
+func withIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKey{}, key)
+}
+
+// idempotencyTransport sets the Idempotency-Key header from the request context
+type idempotencyTransport struct {
+	base http.RoundTripper
+}
+
+func (t *idempotencyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	key, ok := req.Context().Value(idempotencyKey{}).(string)
+	if !ok || key == "" {
+		return t.base.RoundTrip(req)
+	}
+
+	outReq := req.Clone(req.Context())
+	outReq.Header.Set("Idempotency-Key", key)
+	return t.base.RoundTrip(outReq)
+}
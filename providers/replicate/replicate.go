@@ -4,22 +4,35 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/replicate/replicate-go"
 	"github.com/ztkent/ai-util/types"
 )
 
 // Provider implements the Replicate provider
 type Provider struct {
-	client *replicate.Client
-	config *Config
+	client     *replicate.Client
+	config     *Config
+	versions   map[string]string // model -> resolved version ID
+	versionsMu sync.RWMutex
+
+	counterMu    sync.RWMutex
+	tokenCounter types.TokenCounter
 }
 
 // Config holds Replicate-specific configuration
 type Config struct {
 	types.BaseConfig
-	WebhookURL  string                 `json:"webhook_url,omitempty"`
-	ExtraInputs map[string]interface{} `json:"extra_inputs,omitempty"`
+	WebhookURL      string                 `json:"webhook_url,omitempty"`
+	ExtraInputs     map[string]interface{} `json:"extra_inputs,omitempty"`
+	ToolPrompt      string                 `json:"tool_prompt,omitempty"`      // Overrides DefaultToolPrompt
+	OutputSeparator string                 `json:"output_separator,omitempty"` // Joins list outputs (default "")
+	// CumulativeOutput lists models (or model versions) whose stream output events repeat the
+	// full output so far rather than sending only new text
+	CumulativeOutput []string `json:"cumulative_output,omitempty"`
 }
 
 // NewProvider creates a new Replicate provider
@@ -60,6 +73,16 @@ func (p *Provider) GetModels(ctx context.Context) ([]*types.Model, error) {
 		return nil, types.NewError(types.ErrCodeInvalidConfig, "provider not initialized", "replicate")
 	}
 
+	return supportedModels(), nil
+}
+
+// SupportedCapabilities returns the capabilities offered by any of the provider's models
+func (p *Provider) SupportedCapabilities() []types.ModelCapability {
+	return types.CapabilitiesOf(supportedModels())
+}
+
+// supportedModels returns the models this provider offers
+func supportedModels() []*types.Model {
 	// For Replicate, we'll return a curated list of popular chat models
 	// In practice, you might want to query the Replicate API for available models
 	models := []*types.Model{
@@ -72,6 +95,7 @@ func (p *Provider) GetModels(ctx context.Context) ([]*types.Model, error) {
 			Capabilities: []string{
 				string(types.CapabilityChat),
 				string(types.CapabilityStreaming),
+				string(types.CapabilityTools), // Prompt-based, see buildToolPrompt
 			},
 		},
 		{
@@ -83,6 +107,7 @@ func (p *Provider) GetModels(ctx context.Context) ([]*types.Model, error) {
 			Capabilities: []string{
 				string(types.CapabilityChat),
 				string(types.CapabilityStreaming),
+				string(types.CapabilityTools), // Prompt-based, see buildToolPrompt
 			},
 		},
 		{
@@ -94,6 +119,7 @@ func (p *Provider) GetModels(ctx context.Context) ([]*types.Model, error) {
 			Capabilities: []string{
 				string(types.CapabilityChat),
 				string(types.CapabilityStreaming),
+				string(types.CapabilityTools), // Prompt-based, see buildToolPrompt
 			},
 		},
 		{
@@ -105,11 +131,12 @@ func (p *Provider) GetModels(ctx context.Context) ([]*types.Model, error) {
 			Capabilities: []string{
 				string(types.CapabilityChat),
 				string(types.CapabilityStreaming),
+				string(types.CapabilityTools), // Prompt-based, see buildToolPrompt
 			},
 		},
 	}
 
-	return models, nil
+	return models
 }
 
 // Complete performs a completion request
@@ -118,6 +145,21 @@ func (p *Provider) Complete(ctx context.Context, req *types.CompletionRequest) (
 		return nil, types.NewError(types.ErrCodeInvalidConfig, "provider not initialized", "replicate")
 	}
 
+	// Bound prediction creation and polling by the configured timeout
+	ctx, cancel := p.config.WithRequestTimeout(ctx)
+	defer cancel()
+
+	// Run prediction
+	prediction, err := p.createPrediction(ctx, req, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.waitForPrediction(ctx, req, prediction)
+}
+
+// createPrediction converts the request and starts a prediction for it
+func (p *Provider) createPrediction(ctx context.Context, req *types.CompletionRequest, stream bool) (*replicate.Prediction, error) {
 	// Convert request to Replicate format
 	input, err := p.convertRequest(req)
 	if err != nil {
@@ -132,20 +174,33 @@ func (p *Provider) Complete(ctx context.Context, req *types.CompletionRequest) (
 		}
 	}
 
-	// Run prediction
-	prediction, err := p.client.CreatePrediction(ctx, req.Model, input, webhook, false)
+	prediction, err := p.client.CreatePrediction(ctx, p.modelVersion(req.Model), input, webhook, stream)
 	if err != nil {
-		return nil, types.WrapError(err, types.ErrCodeServerError, "replicate")
+		return nil, types.WrapRequestError(err, "replicate")
 	}
+	return prediction, nil
+}
 
-	// Wait for completion
-	err = p.client.Wait(ctx, prediction)
+// waitForPrediction polls the prediction until it finishes and converts its output
+func (p *Provider) waitForPrediction(ctx context.Context, req *types.CompletionRequest, prediction *replicate.Prediction) (*types.CompletionResponse, error) {
+	err := p.client.Wait(ctx, prediction)
 	if err != nil {
-		return nil, types.WrapError(err, types.ErrCodeServerError, "replicate")
+		return nil, types.WrapRequestError(err, "replicate")
 	}
 
 	// Convert response
-	return p.convertResponse(prediction), nil
+	resp := p.convertResponse(prediction)
+
+	// Tools are described in the prompt, so tool calls come back as JSON text
+	if len(req.Tools) > 0 && resp.Message != nil {
+		if toolCall, ok := parseToolCall(resp.Message.TextData, req.Tools); ok {
+			resp.Message.TextData = ""
+			resp.Message.ToolCalls = []types.ToolCall{*toolCall}
+			resp.FinishReason = "tool_calls"
+		}
+	}
+
+	return resp, nil
 }
 
 // Stream performs a streaming completion request
@@ -154,15 +209,78 @@ func (p *Provider) Stream(ctx context.Context, req *types.CompletionRequest, cal
 		return types.NewError(types.ErrCodeInvalidConfig, "provider not initialized", "replicate")
 	}
 
-	// For now, we'll implement streaming by polling the prediction
-	// Replicate's streaming API is different and would need specific implementation
-	resp, err := p.Complete(ctx, req)
+	callback = types.RecoverCallback(callback, "replicate")
+
+	// Tool calls are parsed from the complete output, so they are sent as a single chunk
+	if len(req.Tools) > 0 {
+		resp, err := p.Complete(ctx, req)
+		if err != nil {
+			return err
+		}
+		return callback(ctx, responseChunk(resp))
+	}
+
+	// The configured timeout bounds creating the prediction, not the stream that follows
+	createCtx, cancel := p.config.WithRequestTimeout(ctx)
+	prediction, err := p.createPrediction(createCtx, req, true)
+	cancel()
 	if err != nil {
 		return err
 	}
 
-	// Simulate streaming by sending the complete response as a stream event
-	streamResp := &types.StreamResponse{
+	// Models without streaming support are polled and sent as a single chunk
+	if prediction.URLs["stream"] == "" {
+		waitCtx, cancel := p.config.WithRequestTimeout(ctx)
+		defer cancel()
+		resp, err := p.waitForPrediction(waitCtx, req, prediction)
+		if err != nil {
+			return err
+		}
+		return callback(ctx, responseChunk(resp))
+	}
+
+	responseID := prediction.ID
+	if responseID == "" {
+		responseID = "replicate-" + uuid.New().String()
+	}
+	chunk := func(text, finishReason string) *types.StreamResponse {
+		return &types.StreamResponse{
+			ID:           responseID,
+			Model:        req.Model,
+			Provider:     "replicate",
+			Delta:        &types.Message{Role: types.RoleAssistant, TextData: text},
+			FinishReason: finishReason,
+		}
+	}
+
+	output := outputDeltas{cumulative: p.cumulativeOutput(req.Model)}
+	return p.streamEvents(ctx, prediction.URLs["stream"], func(event replicate.SSEEvent) error {
+		switch event.Type {
+		case replicate.SSETypeOutput:
+			if delta := output.add(strings.TrimSuffix(event.Data, "\n")); delta != "" {
+				return callback(ctx, chunk(delta, ""))
+			}
+		case replicate.SSETypeError:
+			return types.NewError(types.ErrCodeServerError, fmt.Sprintf("prediction failed: %s", event.Data), "replicate")
+		case replicate.SSETypeDone:
+			final := chunk("", "stop")
+			if strings.Contains(event.Data, "canceled") {
+				final.FinishReason = "cancelled"
+			}
+			// Estimate usage (Replicate doesn't provide token counts)
+			final.Usage = &types.Usage{
+				CompletionTokens: types.ApproxTextTokens(output.text),
+				TotalTokens:      types.ApproxTextTokens(output.text),
+			}
+			return callback(ctx, final)
+		}
+		return nil
+	})
+}
+
+// responseChunk converts a complete response into a single stream chunk
+func responseChunk(resp *types.CompletionResponse) *types.StreamResponse {
+	return &types.StreamResponse{
 		ID:           resp.ID,
 		Model:        resp.Model,
 		Provider:     "replicate",
@@ -170,20 +288,56 @@ func (p *Provider) Stream(ctx context.Context, req *types.CompletionRequest, cal
 		FinishReason: resp.FinishReason,
 		Usage:        resp.Usage,
 	}
+}
 
-	return callback(ctx, streamResp)
+// cumulativeOutput reports whether a model's stream output events repeat the full output
+func (p *Provider) cumulativeOutput(model string) bool {
+	for _, cumulative := range p.config.CumulativeOutput {
+		if model == cumulative || strings.HasPrefix(model, cumulative+":") {
+			return true
+		}
+	}
+	return false
 }
 
-// EstimateTokens estimates token count for messages
+// outputDeltas turns streamed output events into incremental text. Most models send each
+// event as new text; models configured in Config.CumulativeOutput repeat the full output so
+// far, and only the new suffix is forwarded.
+type outputDeltas struct {
+	text       string
+	cumulative bool
+}
+
+// add records an output event and returns the text it adds
+func (o *outputDeltas) add(data string) string {
+	if o.cumulative && strings.HasPrefix(data, o.text) {
+		delta := data[len(o.text):]
+		o.text = data
+		return delta
+	}
+
+	o.text += data
+	return data
+}
+
+// EstimateTokens estimates token count for messages with the provider's token counter,
+// ~4 characters per token unless another is set
 func (p *Provider) EstimateTokens(ctx context.Context, messages []*types.Message, model string) (int, error) {
-	// Simple estimation for Replicate models
-	totalTokens := 0
-	for _, msg := range messages {
-		text := msg.GetText()
-		// Rough estimation: ~4 characters per token
-		totalTokens += len(text) / 4
+	p.counterMu.RLock()
+	counter := p.tokenCounter
+	p.counterMu.RUnlock()
+
+	if counter == nil {
+		counter = types.ApproxTokenCounter{}
 	}
-	return totalTokens, nil
+	return counter.Count(messages, model)
+}
+
+// SetTokenCounter replaces the token counter used by EstimateTokens; nil restores the default
+func (p *Provider) SetTokenCounter(tc types.TokenCounter) {
+	p.counterMu.Lock()
+	defer p.counterMu.Unlock()
+	p.tokenCounter = tc
 }
 
 // ValidateModel checks if a model is supported
@@ -216,6 +370,15 @@ func (p *Provider) convertRequest(req *types.CompletionRequest) (map[string]inte
 	// Build prompt from messages
 	prompt := p.buildPromptFromMessages(req.Messages)
 
+	// Replicate models have no native tool calling, so tools are described in the prompt
+	if len(req.Tools) > 0 {
+		toolPrompt, err := p.buildToolPrompt(req.Tools)
+		if err != nil {
+			return nil, err
+		}
+		prompt = fmt.Sprintf("System: %s\n\n%s", toolPrompt, prompt)
+	}
+
 	input := map[string]interface{}{
 		"prompt": prompt,
 	}
@@ -224,11 +387,11 @@ func (p *Provider) convertRequest(req *types.CompletionRequest) (map[string]inte
 	if req.MaxTokens > 0 {
 		input["max_new_tokens"] = req.MaxTokens
 	}
-	if req.Temperature > 0 {
-		input["temperature"] = req.Temperature
+	if req.Temperature != nil {
+		input["temperature"] = *req.Temperature
 	}
-	if req.TopP > 0 {
-		input["top_p"] = req.TopP
+	if req.TopP != nil {
+		input["top_p"] = *req.TopP
 	}
 	if req.TopK > 0 {
 		input["top_k"] = req.TopK
@@ -250,7 +413,16 @@ func (p *Provider) buildPromptFromMessages(messages []*types.Message) string {
 	var parts []string
 
 	for _, msg := range messages {
-		text := msg.GetText()
+		text := msg.FlattenToText()
+		if msg.Role == types.RoleAssistant && len(msg.ToolCalls) > 0 {
+			text = strings.TrimSpace(text + "\n" + formatToolCalls(msg.ToolCalls))
+		}
+		if msg.Role == types.RoleTool && msg.ToolResult != nil {
+			text = msg.ToolResult.Content
+			if msg.ToolResult.Error != "" {
+				text = "Error: " + msg.ToolResult.Error
+			}
+		}
 		if text == "" {
 			continue
 		}
@@ -262,6 +434,8 @@ func (p *Provider) buildPromptFromMessages(messages []*types.Message) string {
 			parts = append(parts, fmt.Sprintf("Human: %s", text))
 		case types.RoleAssistant:
 			parts = append(parts, fmt.Sprintf("Assistant: %s", text))
+		case types.RoleTool:
+			parts = append(parts, fmt.Sprintf("Tool: %s", text))
 		}
 	}
 
@@ -269,6 +443,14 @@ func (p *Provider) buildPromptFromMessages(messages []*types.Message) string {
 	return strings.Join(parts, "\n\n") + "\n\nAssistant: "
 }
 
+// outputSeparator returns the configured separator for joining list outputs
+func (p *Provider) outputSeparator() string {
+	if p.config == nil {
+		return ""
+	}
+	return p.config.OutputSeparator
+}
+
 // convertResponse converts Replicate prediction to unified format
 func (p *Provider) convertResponse(prediction *replicate.Prediction) *types.CompletionResponse {
 	var content string
@@ -280,7 +462,7 @@ func (p *Provider) convertResponse(prediction *replicate.Prediction) *types.Comp
 					parts = append(parts, str)
 				}
 			}
-			content = strings.Join(parts, "")
+			content = strings.Join(parts, p.outputSeparator())
 		} else if str, ok := prediction.Output.(string); ok {
 			content = str
 		}
@@ -293,8 +475,8 @@ func (p *Provider) convertResponse(prediction *replicate.Prediction) *types.Comp
 
 	// Estimate usage (Replicate doesn't provide token counts)
 	usage := &types.Usage{
-		CompletionTokens: len(content) / 4, // Rough estimation
-		TotalTokens:      len(content) / 4,
+		CompletionTokens: types.ApproxTextTokens(content), // Rough estimation
+		TotalTokens:      types.ApproxTextTokens(content),
 	}
 
 	finishReason := "stop"
@@ -305,12 +487,37 @@ func (p *Provider) convertResponse(prediction *replicate.Prediction) *types.Comp
 		finishReason = "cancelled"
 	}
 
+	// Prediction IDs are unique; generate one if the prediction has none
+	responseID := prediction.ID
+	if responseID == "" {
+		responseID = "replicate-" + uuid.New().String()
+	}
+
 	return &types.CompletionResponse{
-		ID:           prediction.ID,
+		ID:           responseID,
 		Model:        prediction.Model,
 		Provider:     "replicate",
 		Message:      message,
 		FinishReason: finishReason,
 		Usage:        usage,
+		Timing:       predictionTiming(prediction.Metrics),
+	}
+}
+
+// predictionTiming converts Replicate's prediction metrics into timing, treating time not
+// spent predicting (e.g. waiting for a cold boot) as queue time
+func predictionTiming(metrics *replicate.PredictionMetrics) *types.Timing {
+	if metrics == nil || metrics.TotalTime == nil {
+		return nil
 	}
+
+	timing := &types.Timing{TotalTime: secondsToDuration(*metrics.TotalTime)}
+	if metrics.PredictTime != nil && *metrics.PredictTime < *metrics.TotalTime {
+		timing.QueueTime = secondsToDuration(*metrics.TotalTime - *metrics.PredictTime)
+	}
+	return timing
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
 }
@@ -0,0 +1,414 @@
+package replicate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/replicate/replicate-go"
+	"github.com/ztkent/ai-util/types"
+)
+
+// newTestProvider returns a provider backed by a fake Replicate API server
+func newTestProvider(t *testing.T, handler http.HandlerFunc) *Provider {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	client, err := replicate.NewClient(replicate.WithToken("test-token"), replicate.WithBaseURL(server.URL))
+	if err != nil {
+		t.Fatalf("Failed to create replicate client: %v", err)
+	}
+
+	return &Provider{
+		client: client,
+		config: &Config{BaseConfig: types.BaseConfig{Provider: "replicate", APIKey: "test-token"}},
+	}
+}
+
+func TestReplicateProvider_GetName(t *testing.T) {
+	provider := NewProvider()
+	if provider.GetName() != "replicate" {
+		t.Errorf("Expected provider name 'replicate', got '%s'", provider.GetName())
+	}
+}
+
+func TestReplicateProvider_PromptToolCalls(t *testing.T) {
+	var prompt string
+	provider := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			var body struct {
+				Input map[string]interface{} `json:"input"`
+			}
+			json.NewDecoder(r.Body).Decode(&body)
+			prompt, _ = body.Input["prompt"].(string)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id":     "prediction-1",
+			"model":  "meta/meta-llama-3-8b-instruct",
+			"status": "succeeded",
+			"output": []string{"```json\n", `{"tool_call": {"name": "get_weather", `, `"arguments": {"city": "Paris"}}}`, "\n```"},
+		})
+	})
+
+	resp, err := provider.Complete(context.Background(), &types.CompletionRequest{
+		Model:    "meta/meta-llama-3-8b-instruct",
+		Messages: []*types.Message{types.NewTextMessage(types.RoleUser, "What's the weather in Paris?")},
+		Tools: []types.Tool{{
+			Type: "function",
+			Function: &types.ToolFunction{
+				Name:        "get_weather",
+				Description: "Get the current weather for a city",
+				Parameters: map[string]interface{}{
+					"type":       "object",
+					"properties": map[string]interface{}{"city": map[string]interface{}{"type": "string"}},
+				},
+			},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("Complete failed: %v", err)
+	}
+
+	if !strings.Contains(prompt, DefaultToolPrompt) || !strings.Contains(prompt, `"name":"get_weather"`) {
+		t.Errorf("Expected tool schemas in prompt, got %q", prompt)
+	}
+
+	if len(resp.Message.ToolCalls) != 1 {
+		t.Fatalf("Expected 1 tool call, got %d", len(resp.Message.ToolCalls))
+	}
+	toolCall := resp.Message.ToolCalls[0]
+	if toolCall.Function.Name != "get_weather" || toolCall.Args["city"] != "Paris" {
+		t.Errorf("Expected get_weather call for Paris, got %+v", toolCall)
+	}
+	if toolCall.Function.Arguments != `{"city":"Paris"}` {
+		t.Errorf("Expected serialized arguments, got '%s'", toolCall.Function.Arguments)
+	}
+	if resp.FinishReason != "tool_calls" {
+		t.Errorf("Expected finish reason 'tool_calls', got '%s'", resp.FinishReason)
+	}
+}
+
+func TestReplicateProvider_StreamPromptToolCalls(t *testing.T) {
+	provider := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id":     "prediction-1",
+			"status": "succeeded",
+			"output": []string{`{"tool_call": {"name": "get_weather", "arguments": {"city": "Paris"}}}`},
+		})
+	})
+
+	var chunks []*types.StreamResponse
+	err := provider.Stream(context.Background(), &types.CompletionRequest{
+		Model:    "meta/meta-llama-3-8b-instruct",
+		Messages: []*types.Message{types.NewTextMessage(types.RoleUser, "What's the weather in Paris?")},
+		Tools:    []types.Tool{{Type: "function", Function: &types.ToolFunction{Name: "get_weather"}}},
+	}, func(ctx context.Context, chunk *types.StreamResponse) error {
+		chunks = append(chunks, chunk)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Stream failed: %v", err)
+	}
+
+	if len(chunks) != 1 || len(chunks[0].Delta.ToolCalls) != 1 || chunks[0].FinishReason != "tool_calls" {
+		t.Fatalf("Expected a single tool call chunk, got %+v", chunks)
+	}
+	if call := chunks[0].Delta.ToolCalls[0]; call.Function.Name != "get_weather" || call.Args["city"] != "Paris" {
+		t.Errorf("Expected get_weather call for Paris, got %+v", call)
+	}
+
+	// Registered models advertise tools, so the client lets tool requests through
+	for _, model := range supportedModels() {
+		if !model.HasCapability(types.CapabilityTools) {
+			t.Errorf("Expected %s to support prompt-based tools", model.ID)
+		}
+	}
+}
+
+func TestParseToolCall_UnknownTool(t *testing.T) {
+	tools := []types.Tool{{Type: "function", Function: &types.ToolFunction{Name: "get_weather"}}}
+
+	if _, ok := parseToolCall(`{"tool_call": {"name": "delete_files", "arguments": {}}}`, tools); ok {
+		t.Error("Expected calls to unknown tools to be ignored")
+	}
+	if _, ok := parseToolCall("The weather in Paris is sunny.", tools); ok {
+		t.Error("Expected plain text to not be parsed as a tool call")
+	}
+}
+
+func TestReplicateProvider_ResolveVersions(t *testing.T) {
+	var predictionVersion string
+	provider := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/models/meta/meta-llama-3-8b-instruct":
+			fmt.Fprint(w, `{"owner":"meta","name":"meta-llama-3-8b-instruct","latest_version":{"id":"version-8b"}}`)
+		case "/models/mistralai/mistral-7b-instruct-v0.2":
+			fmt.Fprint(w, `{"owner":"mistralai","name":"mistral-7b-instruct-v0.2","latest_version":{"id":"version-7b"}}`)
+		case "/predictions", "/predictions/prediction-1":
+			if r.Method == http.MethodPost {
+				var body struct {
+					Version string `json:"version"`
+				}
+				json.NewDecoder(r.Body).Decode(&body)
+				predictionVersion = body.Version
+			}
+			fmt.Fprint(w, `{"id":"prediction-1","status":"succeeded","output":"Hello"}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"detail":"Not found"}`)
+		}
+	})
+
+	ctx := context.Background()
+	versions, errs := provider.ResolveVersions(ctx, []string{
+		"meta/meta-llama-3-8b-instruct",
+		"mistralai/mistral-7b-instruct-v0.2",
+		"invalid-model",
+	})
+
+	if versions["meta/meta-llama-3-8b-instruct"] != "version-8b" {
+		t.Errorf("Expected version-8b, got '%s'", versions["meta/meta-llama-3-8b-instruct"])
+	}
+	if versions["mistralai/mistral-7b-instruct-v0.2"] != "version-7b" {
+		t.Errorf("Expected version-7b, got '%s'", versions["mistralai/mistral-7b-instruct-v0.2"])
+	}
+	if len(errs) != 1 || errs["invalid-model"] == nil {
+		t.Errorf("Expected a single error for invalid-model, got %v", errs)
+	}
+
+	// Predictions use the cached version
+	_, err := provider.Complete(ctx, &types.CompletionRequest{
+		Model:    "meta/meta-llama-3-8b-instruct",
+		Messages: []*types.Message{types.NewTextMessage(types.RoleUser, "Hi")},
+	})
+	if err != nil {
+		t.Fatalf("Complete failed: %v", err)
+	}
+	if predictionVersion != "version-8b" {
+		t.Errorf("Expected prediction to use the resolved version, got '%s'", predictionVersion)
+	}
+
+	// Streamed predictions use it too
+	predictionVersion = ""
+	err = provider.Stream(ctx, &types.CompletionRequest{
+		Model:    "meta/meta-llama-3-8b-instruct",
+		Messages: []*types.Message{types.NewTextMessage(types.RoleUser, "Hi")},
+	}, func(ctx context.Context, chunk *types.StreamResponse) error { return nil })
+	if err != nil {
+		t.Fatalf("Stream failed: %v", err)
+	}
+	if predictionVersion != "version-8b" {
+		t.Errorf("Expected streamed prediction to use the resolved version, got '%s'", predictionVersion)
+	}
+}
+
+func TestReplicateProvider_OutputSeparator(t *testing.T) {
+	prediction := &replicate.Prediction{
+		ID:     "prediction-1",
+		Status: "succeeded",
+		Output: []interface{}{"first line", "second line"},
+	}
+
+	provider := &Provider{config: &Config{}}
+	if text := provider.convertResponse(prediction).Message.TextData; text != "first linesecond line" {
+		t.Errorf("Expected outputs joined directly by default, got %q", text)
+	}
+
+	provider.config.OutputSeparator = "\n"
+	if text := provider.convertResponse(prediction).Message.TextData; text != "first line\nsecond line" {
+		t.Errorf("Expected outputs joined by newline, got %q", text)
+	}
+}
+
+func TestReplicateProvider_PredictionTiming(t *testing.T) {
+	total, predict := 3.5, 1.25
+	prediction := &replicate.Prediction{
+		ID:      "prediction-1",
+		Status:  "succeeded",
+		Output:  "done",
+		Metrics: &replicate.PredictionMetrics{TotalTime: &total, PredictTime: &predict},
+	}
+
+	provider := &Provider{config: &Config{}}
+	timing := provider.convertResponse(prediction).Timing
+	if timing == nil {
+		t.Fatal("Expected timing from prediction metrics")
+	}
+	if timing.QueueTime != 2250*time.Millisecond {
+		t.Errorf("Expected queue time 2.25s, got %v", timing.QueueTime)
+	}
+	if timing.TotalTime != 3500*time.Millisecond {
+		t.Errorf("Expected total time 3.5s, got %v", timing.TotalTime)
+	}
+
+	prediction.Metrics = nil
+	if timing := provider.convertResponse(prediction).Timing; timing != nil {
+		t.Errorf("Expected no timing without metrics, got %+v", timing)
+	}
+}
+
+func TestReplicateProvider_StreamCumulativeOutput(t *testing.T) {
+	provider := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/predictions":
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"id":     "prediction-1",
+				"status": "starting",
+				"urls":   map[string]string{"stream": "http://" + r.Host + "/stream/prediction-1"},
+			})
+		case "/stream/prediction-1":
+			w.Header().Set("Content-Type", "text/event-stream")
+			for _, data := range []string{"Hel", "Hello", "Hello wor", "Hello world"} {
+				fmt.Fprintf(w, "event: output\ndata: %s\n\n", data)
+			}
+			fmt.Fprint(w, "event: done\ndata: {}\n\n")
+			w.(http.Flusher).Flush()
+			// Hold the connection open until the client has read the done event and hung up
+			<-r.Context().Done()
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	provider.config.CumulativeOutput = []string{"meta/meta-llama-3-8b-instruct"}
+
+	var deltas []string
+	var finishReason string
+	err := provider.Stream(context.Background(), &types.CompletionRequest{
+		Model:    "meta/meta-llama-3-8b-instruct",
+		Messages: []*types.Message{types.NewTextMessage(types.RoleUser, "Say hello")},
+	}, func(ctx context.Context, resp *types.StreamResponse) error {
+		if resp.Delta != nil && resp.Delta.TextData != "" {
+			deltas = append(deltas, resp.Delta.TextData)
+		}
+		if resp.FinishReason != "" {
+			finishReason = resp.FinishReason
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Stream failed: %v", err)
+	}
+
+	expected := []string{"Hel", "lo", " wor", "ld"}
+	if strings.Join(deltas, "|") != strings.Join(expected, "|") {
+		t.Errorf("Expected deltas %q, got %q", expected, deltas)
+	}
+	if finishReason != "stop" {
+		t.Errorf("Expected finish reason 'stop', got '%s'", finishReason)
+	}
+}
+
+func TestOutputDeltas_Incremental(t *testing.T) {
+	// Incremental events are forwarded unchanged, even when one happens to extend the last
+	var output outputDeltas
+	var deltas []string
+	for _, data := range []string{"Hel", "Hello", " Hel", "lo"} {
+		deltas = append(deltas, output.add(data))
+	}
+
+	if strings.Join(deltas, "") != "HelHello Hello" {
+		t.Errorf("Expected incremental events forwarded unchanged, got %q", deltas)
+	}
+}
+
+func TestReplicateProvider_StreamDeadline(t *testing.T) {
+	provider := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/predictions":
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"id":     "prediction-1",
+				"status": "starting",
+				"urls":   map[string]string{"stream": "http://" + r.Host + "/stream/prediction-1"},
+			})
+		case "/stream/prediction-1":
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(w, "event: output\ndata: Hel\n\n")
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		}
+	})
+
+	// An earlier caller deadline takes precedence over the configured timeout
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	var received string
+	start := time.Now()
+	err := provider.Stream(ctx, &types.CompletionRequest{
+		Model:    "meta/meta-llama-3-8b-instruct",
+		Messages: []*types.Message{types.NewTextMessage(types.RoleUser, "Say hello")},
+	}, func(ctx context.Context, resp *types.StreamResponse) error {
+		if resp.Delta != nil {
+			received += resp.Delta.TextData
+		}
+		return nil
+	})
+
+	var aiErr *types.Error
+	if !errors.As(err, &aiErr) || aiErr.Code != types.ErrCodeTimeout {
+		t.Fatalf("Expected %s error, got %v", types.ErrCodeTimeout, err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the stream to stop at the caller deadline, took %v", elapsed)
+	}
+	if received != "Hel" {
+		t.Errorf("Expected output before the deadline to be forwarded, got %q", received)
+	}
+}
+
+func TestReplicateProvider_StreamResumes(t *testing.T) {
+	var resumedFrom string
+	provider := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/predictions":
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"id":     "prediction-1",
+				"status": "starting",
+				"urls":   map[string]string{"stream": "http://" + r.Host + "/stream/prediction-1"},
+			})
+		case "/stream/prediction-1":
+			w.Header().Set("Content-Type", "text/event-stream")
+			// The first connection drops before the done event
+			if resumedFrom = r.Header.Get("Last-Event-ID"); resumedFrom == "" {
+				fmt.Fprint(w, "id: 1\nevent: output\ndata: Hello\n\n")
+				return
+			}
+			fmt.Fprint(w, "id: 2\nevent: output\ndata:  world\n\nevent: done\ndata: {}\n\n")
+		}
+	})
+
+	var text string
+	err := provider.Stream(context.Background(), &types.CompletionRequest{
+		Model:    "meta/meta-llama-3-8b-instruct",
+		Messages: []*types.Message{types.NewTextMessage(types.RoleUser, "Say hello")},
+	}, func(ctx context.Context, resp *types.StreamResponse) error {
+		if resp.Delta != nil {
+			text += resp.Delta.TextData
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Stream failed: %v", err)
+	}
+	if resumedFrom != "1" {
+		t.Errorf("Expected the stream resumed from event 1, got %q", resumedFrom)
+	}
+	if text != "Hello world" {
+		t.Errorf("Expected the text from both connections, got %q", text)
+	}
+}
@@ -0,0 +1,104 @@
+package replicate
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/replicate/replicate-go"
+	"github.com/ztkent/ai-util/types"
+)
+
+// maxStreamReconnects bounds how often a stream that drops before its done event is resumed
+const maxStreamReconnects = 3
+
+// streamEvents reads a prediction's server-sent events from url, passing each to handle until
+// the done event. The stream is read here rather than with replicate-go's StreamPrediction,
+// whose goroutines race when the context is cancelled mid-stream. A stream that drops early
+// is resumed from the last event ID.
+func (p *Provider) streamEvents(ctx context.Context, url string, handle func(replicate.SSEEvent) error) error {
+	var lastID string
+	for attempt := 0; ; attempt++ {
+		done, err := p.readEvents(ctx, url, &lastID, handle)
+		if done || err != nil {
+			return err
+		}
+		if attempt >= maxStreamReconnects {
+			return types.NewError(types.ErrCodeServerError, "prediction stream ended before completion", "replicate")
+		}
+	}
+}
+
+// readEvents reads events from a single connection to the stream, reporting whether the done
+// event arrived before the connection closed
+func (p *Provider) readEvents(ctx context.Context, url string, lastID *string, handle func(replicate.SSEEvent) error) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return false, types.WrapError(err, types.ErrCodeInvalidRequest, "replicate")
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Cache-Control", "no-cache")
+	if *lastID != "" {
+		req.Header.Set("Last-Event-ID", *lastID)
+	}
+
+	client := &http.Client{Transport: p.config.HTTPTransport()}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, types.WrapRequestError(err, "replicate")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, types.NewError(types.ErrCodeServerError,
+			fmt.Sprintf("prediction stream returned status %d", resp.StatusCode), "replicate")
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	event := replicate.SSEEvent{Type: replicate.SSETypeDefault}
+	var data []string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			if ctx.Err() != nil {
+				return false, types.WrapRequestError(ctx.Err(), "replicate")
+			}
+			if line == "" {
+				return false, nil
+			}
+		}
+		line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+
+		// A blank line ends the event
+		if line == "" {
+			event.Data = strings.Join(data, "\n")
+			if event.Data != "" || event.Type == replicate.SSETypeDone {
+				if event.ID != "" {
+					*lastID = event.ID
+				}
+				if err := handle(event); err != nil {
+					return false, err
+				}
+				if event.Type == replicate.SSETypeDone {
+					return true, nil
+				}
+			}
+			event = replicate.SSEEvent{Type: replicate.SSETypeDefault}
+			data = nil
+			continue
+		}
+
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "id":
+			event.ID = value
+		case "event":
+			event.Type = value
+		case "data":
+			data = append(data, value)
+		}
+	}
+}
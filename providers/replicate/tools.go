@@ -0,0 +1,102 @@
+package replicate
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/ztkent/ai-util/types"
+)
+
+// DefaultToolPrompt instructs models without native tool calling how to call a tool.
+// The tool schemas are appended after it.
+const DefaultToolPrompt = `You have access to the following tools. To call a tool, respond with only a JSON object of the form {"tool_call": {"name": "<tool name>", "arguments": {<arguments>}}} and no other text. If no tool is needed, respond normally.`
+
+// promptToolCall is the JSON shape models are asked to emit for a tool call
+type promptToolCall struct {
+	ToolCall *struct {
+		Name      string                 `json:"name"`
+		Arguments map[string]interface{} `json:"arguments"`
+	} `json:"tool_call"`
+}
+
+// buildToolPrompt serializes tool schemas into a system prompt
+func (p *Provider) buildToolPrompt(tools []types.Tool) (string, error) {
+	instructions := DefaultToolPrompt
+	if p.config != nil && p.config.ToolPrompt != "" {
+		instructions = p.config.ToolPrompt
+	}
+
+	var schemas []string
+	for _, tool := range tools {
+		if tool.Function == nil {
+			continue
+		}
+		schema, err := json.Marshal(tool.Function)
+		if err != nil {
+			return "", types.WrapError(err, types.ErrCodeInvalidRequest, "replicate")
+		}
+		schemas = append(schemas, string(schema))
+	}
+
+	return instructions + "\n\n" + strings.Join(schemas, "\n"), nil
+}
+
+// parseToolCall extracts a JSON tool call from model output. Models often wrap the JSON
+// in prose or code fences, so the outermost object in the output is used.
+func parseToolCall(content string, tools []types.Tool) (*types.ToolCall, bool) {
+	start := strings.Index(content, "{")
+	end := strings.LastIndex(content, "}")
+	if start == -1 || end <= start {
+		return nil, false
+	}
+
+	var call promptToolCall
+	if err := json.Unmarshal([]byte(content[start:end+1]), &call); err != nil || call.ToolCall == nil {
+		return nil, false
+	}
+
+	// Only accept calls to tools that were offered
+	known := false
+	for _, tool := range tools {
+		if tool.Function != nil && tool.Function.Name == call.ToolCall.Name {
+			known = true
+			break
+		}
+	}
+	if !known {
+		return nil, false
+	}
+
+	if call.ToolCall.Arguments == nil {
+		call.ToolCall.Arguments = map[string]interface{}{}
+	}
+	arguments, err := json.Marshal(call.ToolCall.Arguments)
+	if err != nil {
+		return nil, false
+	}
+
+	return &types.ToolCall{
+		ID:   "call_" + uuid.New().String(),
+		Type: "function",
+		Function: types.ToolCallFunction{
+			Name:      call.ToolCall.Name,
+			Arguments: string(arguments),
+		},
+		Args: call.ToolCall.Arguments,
+	}, true
+}
+
+// formatToolCalls renders an assistant's tool calls in the prompt format
+func formatToolCalls(toolCalls []types.ToolCall) string {
+	var calls []string
+	for _, tc := range toolCalls {
+		arguments := tc.Function.Arguments
+		if arguments == "" {
+			arguments = "{}"
+		}
+		calls = append(calls, fmt.Sprintf(`{"tool_call": {"name": %q, "arguments": %s}}`, tc.Function.Name, arguments))
+	}
+	return strings.Join(calls, "\n")
+}
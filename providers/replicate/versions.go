@@ -0,0 +1,102 @@
+package replicate
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/ztkent/ai-util/types"
+)
+
+// ResolveVersions resolves the latest version ID of each owner/name model concurrently and
+// caches them, so later predictions for those models run against the resolved versions.
+// It returns the resolved version IDs and any per-model errors, both keyed by model.
+func (p *Provider) ResolveVersions(ctx context.Context, models []string) (map[string]string, map[string]error) {
+	versions := make(map[string]string)
+	errs := make(map[string]error)
+
+	if p.client == nil {
+		for _, model := range models {
+			errs[model] = types.NewError(types.ErrCodeInvalidConfig, "provider not initialized", "replicate")
+		}
+		return versions, errs
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, model := range models {
+		wg.Add(1)
+		go func(model string) {
+			defer wg.Done()
+
+			version, err := p.resolveVersion(ctx, model)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs[model] = err
+				return
+			}
+			versions[model] = version
+		}(model)
+	}
+	wg.Wait()
+
+	p.versionsMu.Lock()
+	if p.versions == nil {
+		p.versions = make(map[string]string)
+	}
+	for model, version := range versions {
+		p.versions[model] = version
+	}
+	p.versionsMu.Unlock()
+
+	return versions, errs
+}
+
+// modelFormat matches Replicate model identifiers: owner/name with an optional :version, or a
+// bare version ID
+var modelFormat = regexp.MustCompile(`^([a-z0-9][a-z0-9._-]*/[a-z0-9][a-z0-9._-]*(:[a-f0-9]+)?|[a-f0-9]{64})$`)
+
+// ValidateModelFormat checks that a model identifier is in a form Replicate accepts, without
+// checking that the model exists
+func ValidateModelFormat(model string) error {
+	if !modelFormat.MatchString(model) {
+		return types.NewError(types.ErrCodeInvalidConfig,
+			fmt.Sprintf("model %q must be owner/name, owner/name:version, or a version ID", model), "replicate")
+	}
+	return nil
+}
+
+// resolveVersion looks up the latest version ID of an owner/name model
+func (p *Provider) resolveVersion(ctx context.Context, model string) (string, error) {
+	owner, name, ok := strings.Cut(model, "/")
+	if !ok || owner == "" || name == "" {
+		return "", types.NewError(types.ErrCodeInvalidRequest,
+			fmt.Sprintf("model %s must be in owner/name form", model), "replicate")
+	}
+
+	m, err := p.client.GetModel(ctx, owner, name)
+	if err != nil {
+		return "", types.WrapError(err, types.ErrCodeModelNotFound, "replicate")
+	}
+	if m.LatestVersion == nil || m.LatestVersion.ID == "" {
+		return "", types.NewError(types.ErrCodeModelNotFound,
+			fmt.Sprintf("model %s has no published version", model), "replicate")
+	}
+
+	return m.LatestVersion.ID, nil
+}
+
+// modelVersion returns the cached version for a model, or the model itself if unresolved
+func (p *Provider) modelVersion(model string) string {
+	p.versionsMu.RLock()
+	defer p.versionsMu.RUnlock()
+
+	if version, ok := p.versions[model]; ok {
+		return version
+	}
+	return model
+}
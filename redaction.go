@@ -0,0 +1,137 @@
+package aiutil
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/ztkent/ai-util/types"
+)
+
+// RedactionPattern is a named pattern of sensitive text to redact
+type RedactionPattern struct {
+	Name    string
+	Pattern *regexp.Regexp
+}
+
+// DefaultRedactionPatterns returns patterns for emails, credit card numbers, and phone numbers.
+// Credit cards are matched before phone numbers so long digit runs aren't partially redacted.
+func DefaultRedactionPatterns() []RedactionPattern {
+	return []RedactionPattern{
+		{Name: "EMAIL", Pattern: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)},
+		{Name: "CREDIT_CARD", Pattern: regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`)},
+		{Name: "PHONE", Pattern: regexp.MustCompile(`(?:\+?\d{1,3}[ .-]?)?\(?\d{3}\)?[ .-]?\d{3}[ .-]?\d{4}\b`)},
+	}
+}
+
+// RedactionMiddleware scrubs sensitive data from message text before requests are sent.
+// When Reversible is set, each match is replaced with a numbered placeholder and the
+// originals are restored in the response text.
+type RedactionMiddleware struct {
+	Patterns   []RedactionPattern
+	Reversible bool
+
+	mu           sync.Mutex
+	originals    map[string]string // placeholder -> original text
+	placeholders map[string]string // original text -> placeholder
+	counter      int
+}
+
+// NewRedactionMiddleware creates a redaction middleware, using the default patterns if none are given
+func NewRedactionMiddleware(reversible bool, patterns ...RedactionPattern) *RedactionMiddleware {
+	if len(patterns) == 0 {
+		patterns = DefaultRedactionPatterns()
+	}
+	return &RedactionMiddleware{
+		Patterns:     patterns,
+		Reversible:   reversible,
+		originals:    make(map[string]string),
+		placeholders: make(map[string]string),
+	}
+}
+
+func (m *RedactionMiddleware) ProcessRequest(ctx context.Context, req *types.CompletionRequest) (*types.CompletionRequest, error) {
+	// Copy messages so redaction doesn't rewrite the caller's history
+	messages := make([]*types.Message, len(req.Messages))
+	for i, msg := range req.Messages {
+		redacted := *msg
+		redacted.TextData = m.Redact(msg.TextData)
+		if len(msg.Content) > 0 {
+			redacted.Content = make([]types.MessageContent, len(msg.Content))
+			for j, content := range msg.Content {
+				if text, ok := content.(types.TextContent); ok {
+					content = types.TextContent{Text: m.Redact(text.Text)}
+				}
+				redacted.Content[j] = content
+			}
+		}
+		messages[i] = &redacted
+	}
+	req.Messages = messages
+	return req, nil
+}
+
+func (m *RedactionMiddleware) ProcessResponse(ctx context.Context, resp *types.CompletionResponse) (*types.CompletionResponse, error) {
+	if !m.Reversible || resp.Message == nil {
+		return resp, nil
+	}
+
+	resp.Message.TextData = m.Unredact(resp.Message.TextData)
+	for i, content := range resp.Message.Content {
+		if text, ok := content.(types.TextContent); ok {
+			resp.Message.Content[i] = types.TextContent{Text: m.Unredact(text.Text)}
+		}
+	}
+	return resp, nil
+}
+
+// Redact replaces all pattern matches in text
+func (m *RedactionMiddleware) Redact(text string) string {
+	if text == "" {
+		return text
+	}
+
+	for _, pattern := range m.Patterns {
+		text = pattern.Pattern.ReplaceAllStringFunc(text, func(match string) string {
+			return m.placeholder(pattern.Name, match)
+		})
+	}
+	return text
+}
+
+// Unredact restores original text for placeholders produced by a reversible middleware
+func (m *RedactionMiddleware) Unredact(text string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for placeholder, original := range m.originals {
+		text = strings.ReplaceAll(text, placeholder, original)
+	}
+	return text
+}
+
+// placeholder returns the replacement for a match, recording it when reversible
+func (m *RedactionMiddleware) placeholder(name, match string) string {
+	if !m.Reversible {
+		return fmt.Sprintf("[REDACTED_%s]", name)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.originals == nil {
+		m.originals = make(map[string]string)
+		m.placeholders = make(map[string]string)
+	}
+	if placeholder, exists := m.placeholders[match]; exists {
+		return placeholder
+	}
+
+	m.counter++
+	placeholder := fmt.Sprintf("[%s_%d]", name, m.counter)
+	m.placeholders[match] = placeholder
+	m.originals[placeholder] = match
+	return placeholder
+}
@@ -0,0 +1,69 @@
+package aiutil
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/ztkent/ai-util/types"
+)
+
+func TestRedactionMiddleware_RedactsRequest(t *testing.T) {
+	provider := newMockProvider("mock-model")
+	client := newMockClient(t, provider)
+	client.defaultConfig.Middleware = []Middleware{NewRedactionMiddleware(false)}
+
+	original := types.NewTextMessage(types.RoleUser,
+		"Email me at jane.doe@example.com, card 4111 1111 1111 1111, or call 555-123-4567.")
+	_, err := client.Complete(context.Background(), &types.CompletionRequest{
+		Model:    "mock-model",
+		Messages: []*types.Message{original},
+	})
+	if err != nil {
+		t.Fatalf("Complete failed: %v", err)
+	}
+
+	sent := provider.requests[0].Messages[0].GetText()
+	for _, secret := range []string{"jane.doe@example.com", "4111 1111 1111 1111", "555-123-4567"} {
+		if strings.Contains(sent, secret) {
+			t.Errorf("Expected %q to be redacted, got %q", secret, sent)
+		}
+	}
+	for _, placeholder := range []string{"[REDACTED_EMAIL]", "[REDACTED_CREDIT_CARD]", "[REDACTED_PHONE]"} {
+		if !strings.Contains(sent, placeholder) {
+			t.Errorf("Expected %s in outgoing request, got %q", placeholder, sent)
+		}
+	}
+
+	if !strings.Contains(original.GetText(), "jane.doe@example.com") {
+		t.Error("Expected caller's message to be left unmodified")
+	}
+}
+
+func TestRedactionMiddleware_Reversible(t *testing.T) {
+	provider := newMockProvider("mock-model")
+	provider.complete = func(ctx context.Context, req *types.CompletionRequest) (*types.CompletionResponse, error) {
+		// Echo the redacted prompt back so the placeholders appear in the response
+		return &types.CompletionResponse{
+			Provider: "mock",
+			Message:  types.NewTextMessage(types.RoleAssistant, "I will write to "+req.Messages[0].GetText()),
+		}, nil
+	}
+	client := newMockClient(t, provider)
+	client.defaultConfig.Middleware = []Middleware{NewRedactionMiddleware(true)}
+
+	resp, err := client.Complete(context.Background(), &types.CompletionRequest{
+		Model:    "mock-model",
+		Messages: []*types.Message{types.NewTextMessage(types.RoleUser, "jane.doe@example.com")},
+	})
+	if err != nil {
+		t.Fatalf("Complete failed: %v", err)
+	}
+
+	if sent := provider.requests[0].Messages[0].GetText(); sent != "[EMAIL_1]" {
+		t.Errorf("Expected reversible placeholder '[EMAIL_1]', got %q", sent)
+	}
+	if resp.Message.GetText() != "I will write to jane.doe@example.com" {
+		t.Errorf("Expected response to be un-redacted, got %q", resp.Message.GetText())
+	}
+}
@@ -5,20 +5,56 @@ import (
 	"fmt"
 	"log/slog"
 	"math"
+	"math/rand/v2"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/ztkent/ai-util/types"
 )
 
+// BackoffStrategy determines how the delay between retries is computed
+type BackoffStrategy int
+
+const (
+	// BackoffExponential doubles the delay after each attempt
+	BackoffExponential BackoffStrategy = iota
+	// BackoffFullJitter sleeps a random duration between 0 and the exponential delay
+	BackoffFullJitter
+	// BackoffDecorrelatedJitter sleeps a random duration between BaseDelay and 3x the previous delay
+	BackoffDecorrelatedJitter
+)
+
 // RetryConfig holds configuration for the retry logic
 type RetryConfig struct {
-	MaxAttempts    int           // Maximum number of retry attempts (default: 5)
-	BaseDelay      time.Duration // Initial delay for exponential backoff (default: 2s)
-	MaxDelay       time.Duration // Maximum delay between retries (default: 30s)
-	FallbackModels []string      // Models to try in order on quota errors (optional)
+	MaxAttempts    int             // Maximum number of retry attempts (default: 5)
+	BaseDelay      time.Duration   // Initial delay for exponential backoff (default: 2s)
+	MaxDelay       time.Duration   // Maximum delay between retries (default: 30s)
+	Backoff        BackoffStrategy // Backoff strategy between retries (default: BackoffFullJitter; zero value: BackoffExponential)
+	JitterFactor   float64         // Fraction of each BackoffExponential delay that is randomized, 0 to 1 (default: 0)
+	FallbackModels []string        // Models to try in order on quota errors (optional)
+
+	// Circuit breaker, shared by every WithRetry call using this config. It is created on
+	// first use, so copies of the config made after that share the same breaker.
+	CircuitThreshold int           // Consecutive failures that open the circuit (0 disables)
+	CircuitCooldown  time.Duration // Time the circuit stays open before a probe is allowed (default: 30s)
+	circuit          *circuitBreaker
+}
+
+// circuitInit guards the lazy creation of RetryConfig circuit breakers
+var circuitInit sync.Mutex
+
+// breaker returns the config's circuit breaker, creating it on first use
+func (c *RetryConfig) breaker() *circuitBreaker {
+	circuitInit.Lock()
+	defer circuitInit.Unlock()
+	if c.circuit == nil {
+		c.circuit = &circuitBreaker{}
+	}
+	return c.circuit
 }
 
 // DefaultRetryConfig returns the default retry configuration
@@ -27,6 +63,7 @@ func DefaultRetryConfig() *RetryConfig {
 		MaxAttempts: 5,
 		BaseDelay:   2 * time.Second,
 		MaxDelay:    30 * time.Second,
+		Backoff:     BackoffFullJitter,
 	}
 }
 
@@ -35,24 +72,48 @@ type CompletionFunc func(ctx context.Context, req *types.CompletionRequest) (*ty
 
 // WithRetry executes a completion request with smart retry logic
 // - Parses rate limit errors for suggested retry delays
-// - Uses exponential backoff for other transient errors
+// - Uses the configured BackoffStrategy for other transient errors
 // - Skips retries for non-retryable errors (auth, invalid request)
 // - Falls back through models in FallbackModels on quota errors (if provided)
+// - Fails fast while the circuit breaker is open (if CircuitThreshold is set)
+// - Sends the same idempotency key on every attempt, generating one if the request has none
 func WithRetry(ctx context.Context, req *types.CompletionRequest, config *RetryConfig, fn CompletionFunc) (*types.CompletionResponse, error) {
 	if config == nil {
 		config = DefaultRetryConfig()
 	}
 
+	// Keys generated here only last for this call, so reusing the request isn't deduplicated
+	idempotencyKey := req.IdempotencyKey
+	if idempotencyKey == "" {
+		req.IdempotencyKey = uuid.New().String()
+	}
+	defer func() { req.IdempotencyKey = idempotencyKey }()
+
 	var lastErr error
-	delay := config.BaseDelay
+	var delay time.Duration
+	var prevDelay time.Duration
 	fallbackIndex := 0
 	maxAttempts := config.MaxAttempts
 	if maxAttempts <= 0 {
 		maxAttempts = 5
 	}
 
+	var circuit *circuitBreaker
+	if config.CircuitThreshold > 0 {
+		circuit = config.breaker()
+	}
+
 	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		if circuit != nil && !circuit.allow(config.circuitCooldown()) {
+			return nil, types.NewError(types.ErrCodeServerError,
+				"circuit breaker open after repeated failures, not calling provider", "")
+		}
+
 		resp, err := fn(ctx, req)
+		if circuit != nil {
+			// Non-retryable errors mean the provider is reachable, so only transient errors count
+			circuit.record(err == nil || !IsRetryableError(err), config.CircuitThreshold)
+		}
 		if err == nil {
 			return resp, nil
 		}
@@ -77,6 +138,7 @@ func WithRetry(ctx context.Context, req *types.CompletionRequest, config *RetryC
 					"max_attempts", maxAttempts,
 					"error", err)
 				req.Model = config.FallbackModels[fallbackIndex]
+				req.IdempotencyKey = uuid.New().String() // A different request body needs its own key
 				fallbackIndex++
 			} else {
 				slog.Error("Quota exceeded and no more fallback models available",
@@ -100,6 +162,8 @@ func WithRetry(ctx context.Context, req *types.CompletionRequest, config *RetryC
 						"error", err)
 				}
 			} else {
+				delay = config.backoffDelay(attempt, prevDelay)
+				prevDelay = delay
 				slog.Warn("Operation failed, retrying with backoff",
 					"attempt", attempt,
 					"max_attempts", maxAttempts,
@@ -113,24 +177,139 @@ func WithRetry(ctx context.Context, req *types.CompletionRequest, config *RetryC
 				return nil, fmt.Errorf("retry cancelled: %w", ctx.Err())
 			case <-time.After(delay):
 			}
-
-			// Exponential backoff with max cap (only if not rate limited)
-			if ParseRateLimitDelay(err) == 0 {
-				maxDelay := config.MaxDelay
-				if maxDelay <= 0 {
-					maxDelay = 30 * time.Second
-				}
-				delay = time.Duration(math.Min(
-					float64(delay*2),
-					float64(maxDelay),
-				))
-			}
 		}
 	}
 
 	return nil, fmt.Errorf("operation failed after %d attempts: %w", maxAttempts, lastErr)
 }
 
+// circuitCooldown returns the time the circuit stays open
+func (c *RetryConfig) circuitCooldown() time.Duration {
+	if c.CircuitCooldown <= 0 {
+		return 30 * time.Second
+	}
+	return c.CircuitCooldown
+}
+
+// circuitState is the state of a circuit breaker
+type circuitState int
+
+const (
+	// circuitClosed allows every call
+	circuitClosed circuitState = iota
+	// circuitOpen rejects calls until the cooldown has passed
+	circuitOpen
+	// circuitHalfOpen allows a single probe call, which closes or reopens the circuit
+	circuitHalfOpen
+)
+
+// circuitBreaker tracks consecutive failures and stops calls while a provider is down.
+// The zero value is a closed circuit.
+type circuitBreaker struct {
+	mu       sync.Mutex
+	state    circuitState
+	failures int
+	openedAt time.Time
+	probing  bool
+	clock    func() time.Time // For testing
+}
+
+func (b *circuitBreaker) now() time.Time {
+	if b.clock != nil {
+		return b.clock()
+	}
+	return time.Now()
+}
+
+// allow reports whether a call may proceed. Once the cooldown has passed, an open circuit
+// becomes half-open and allows one probe at a time.
+func (b *circuitBreaker) allow(cooldown time.Duration) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case circuitOpen:
+		if b.now().Sub(b.openedAt) < cooldown {
+			return false
+		}
+		b.state = circuitHalfOpen
+		b.probing = true
+		return true
+	case circuitHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+// record updates the circuit with the outcome of a call. A success closes the circuit; a
+// failed probe, or threshold consecutive failures, opens it.
+func (b *circuitBreaker) record(success bool, threshold int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	if success {
+		b.state = circuitClosed
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.state == circuitHalfOpen || b.failures >= threshold {
+		b.state = circuitOpen
+		b.openedAt = b.now()
+	}
+}
+
+// backoffDelay computes the delay before retrying the given attempt (1-based)
+// prev is the previous backoff delay, used by decorrelated jitter
+func (c *RetryConfig) backoffDelay(attempt int, prev time.Duration) time.Duration {
+	maxDelay := c.MaxDelay
+	if maxDelay <= 0 {
+		maxDelay = 30 * time.Second
+	}
+
+	switch c.Backoff {
+	case BackoffFullJitter:
+		ceiling := exponentialDelay(c.BaseDelay, attempt, maxDelay)
+		if ceiling <= 0 {
+			return 0
+		}
+		return time.Duration(rand.Int64N(int64(ceiling) + 1))
+	case BackoffDecorrelatedJitter:
+		if prev < c.BaseDelay {
+			prev = c.BaseDelay
+		}
+		upper := prev * 3
+		if upper <= c.BaseDelay {
+			return min(c.BaseDelay, maxDelay)
+		}
+		delay := c.BaseDelay + time.Duration(rand.Int64N(int64(upper-c.BaseDelay)))
+		return min(delay, maxDelay)
+	default:
+		delay := exponentialDelay(c.BaseDelay, attempt, maxDelay)
+		jitter := time.Duration(float64(delay) * min(c.JitterFactor, 1))
+		if jitter <= 0 {
+			return delay
+		}
+		// Sleep a random duration in [delay-jitter, delay]
+		return delay - time.Duration(rand.Int64N(int64(jitter)+1))
+	}
+}
+
+// exponentialDelay returns base * 2^(attempt-1), capped at maxDelay
+func exponentialDelay(base time.Duration, attempt int, maxDelay time.Duration) time.Duration {
+	return time.Duration(math.Min(
+		float64(base)*math.Pow(2, float64(attempt-1)),
+		float64(maxDelay),
+	))
+}
+
 // ParseRateLimitDelay extracts the suggested retry delay from rate limit errors
 // Looks for patterns like "Please retry in 34.42245165s"
 func ParseRateLimitDelay(err error) time.Duration {
@@ -0,0 +1,293 @@
+package aiutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ztkent/ai-util/types"
+)
+
+func TestBackoffDelay_Exponential(t *testing.T) {
+	config := &RetryConfig{BaseDelay: time.Second, MaxDelay: 5 * time.Second}
+
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second}
+	for i, want := range expected {
+		if got := config.backoffDelay(i+1, 0); got != want {
+			t.Errorf("Attempt %d: expected delay %v, got %v", i+1, want, got)
+		}
+	}
+}
+
+func TestBackoffDelay_DecorrelatedJitter(t *testing.T) {
+	config := &RetryConfig{
+		BaseDelay: 100 * time.Millisecond,
+		MaxDelay:  2 * time.Second,
+		Backoff:   BackoffDecorrelatedJitter,
+	}
+
+	seen := make(map[time.Duration]bool)
+	prev := time.Duration(0)
+	for attempt := 1; attempt <= 50; attempt++ {
+		delay := config.backoffDelay(attempt, prev)
+		if delay < config.BaseDelay || delay > config.MaxDelay {
+			t.Fatalf("Attempt %d: delay %v outside [%v, %v]", attempt, delay, config.BaseDelay, config.MaxDelay)
+		}
+
+		upper := max(prev, config.BaseDelay) * 3
+		if delay > upper {
+			t.Fatalf("Attempt %d: delay %v exceeds 3x previous delay %v", attempt, delay, upper)
+		}
+
+		seen[delay] = true
+		prev = delay
+	}
+
+	if len(seen) < 2 {
+		t.Error("Expected decorrelated jitter delays to vary")
+	}
+}
+
+func TestBackoffDelay_FullJitter(t *testing.T) {
+	config := &RetryConfig{
+		BaseDelay: 100 * time.Millisecond,
+		MaxDelay:  time.Second,
+		Backoff:   BackoffFullJitter,
+	}
+
+	for attempt := 1; attempt <= 10; attempt++ {
+		ceiling := exponentialDelay(config.BaseDelay, attempt, config.MaxDelay)
+		if delay := config.backoffDelay(attempt, 0); delay < 0 || delay > ceiling {
+			t.Errorf("Attempt %d: delay %v outside [0, %v]", attempt, delay, ceiling)
+		}
+	}
+}
+
+func TestWithRetry_CircuitBreaker(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	config := &RetryConfig{
+		MaxAttempts:      1,
+		CircuitThreshold: 2,
+		CircuitCooldown:  time.Minute,
+	}
+	config.breaker().clock = func() time.Time { return now }
+
+	calls := 0
+	var failWith error
+	fn := func(ctx context.Context, req *types.CompletionRequest) (*types.CompletionResponse, error) {
+		calls++
+		if failWith != nil {
+			return nil, failWith
+		}
+		return &types.CompletionResponse{}, nil
+	}
+	call := func() error {
+		_, err := WithRetry(context.Background(), &types.CompletionRequest{Model: "mock-model"}, config, fn)
+		return err
+	}
+
+	// Consecutive failures up to the threshold open the circuit
+	failWith = errors.New("503 service unavailable")
+	call()
+	if config.circuit.state != circuitClosed {
+		t.Fatalf("Expected circuit to stay closed below the threshold, got %v", config.circuit.state)
+	}
+	call()
+	if config.circuit.state != circuitOpen {
+		t.Fatalf("Expected circuit to open at the threshold, got %v", config.circuit.state)
+	}
+
+	// An open circuit fails fast without calling the provider
+	err := call()
+	var aiErr *types.Error
+	if !errors.As(err, &aiErr) || aiErr.Code != types.ErrCodeServerError {
+		t.Errorf("Expected %s error from open circuit, got %v", types.ErrCodeServerError, err)
+	}
+	if calls != 2 {
+		t.Errorf("Expected open circuit to skip the call, got %d calls", calls)
+	}
+
+	// After the cooldown a failed probe reopens the circuit
+	now = now.Add(time.Minute)
+	call()
+	if calls != 3 || config.circuit.state != circuitOpen {
+		t.Errorf("Expected failed probe to reopen the circuit, got %d calls and state %v", calls, config.circuit.state)
+	}
+	call()
+	if calls != 3 {
+		t.Errorf("Expected reopened circuit to skip the call, got %d calls", calls)
+	}
+
+	// Only one probe is allowed while half-open
+	now = now.Add(time.Minute)
+	if !config.circuit.allow(config.CircuitCooldown) || config.circuit.state != circuitHalfOpen {
+		t.Fatalf("Expected half-open circuit to allow a probe, got state %v", config.circuit.state)
+	}
+	if config.circuit.allow(config.CircuitCooldown) {
+		t.Error("Expected half-open circuit to reject a second concurrent probe")
+	}
+
+	// A successful probe closes the circuit
+	config.circuit.record(true, config.CircuitThreshold)
+	failWith = nil
+	if err := call(); err != nil {
+		t.Errorf("Expected closed circuit to allow calls, got %v", err)
+	}
+	if config.circuit.state != circuitClosed || config.circuit.failures != 0 {
+		t.Errorf("Expected circuit closed with no failures, got state %v and %d failures",
+			config.circuit.state, config.circuit.failures)
+	}
+
+	// The breaker is shared safely across goroutines
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			config.circuit.allow(config.CircuitCooldown)
+			config.circuit.record(false, config.CircuitThreshold)
+		}()
+	}
+	wg.Wait()
+	if config.circuit.state != circuitOpen {
+		t.Errorf("Expected concurrent failures to open the circuit, got %v", config.circuit.state)
+	}
+}
+
+func TestWithRetry_CircuitSharedAcrossCalls(t *testing.T) {
+	config := &RetryConfig{
+		MaxAttempts:      1,
+		CircuitThreshold: 3,
+		CircuitCooldown:  time.Minute,
+	}
+
+	calls := 0
+	fn := func(ctx context.Context, req *types.CompletionRequest) (*types.CompletionResponse, error) {
+		calls++
+		return nil, errors.New("503 service unavailable")
+	}
+
+	// Failures from separate calls, including through a copy of the config, add up
+	WithRetry(context.Background(), &types.CompletionRequest{Model: "mock-model"}, config, fn)
+	copied := *config
+	WithRetry(context.Background(), &types.CompletionRequest{Model: "mock-model"}, &copied, fn)
+	WithRetry(context.Background(), &types.CompletionRequest{Model: "mock-model"}, config, fn)
+
+	_, err := WithRetry(context.Background(), &types.CompletionRequest{Model: "mock-model"}, &copied, fn)
+	var aiErr *types.Error
+	if !errors.As(err, &aiErr) || aiErr.Code != types.ErrCodeServerError {
+		t.Errorf("Expected %s error from open circuit, got %v", types.ErrCodeServerError, err)
+	}
+	if calls != 3 {
+		t.Errorf("Expected the circuit to open after 3 failed calls, got %d calls", calls)
+	}
+}
+
+func TestBackoffDelay_DefaultIsJittered(t *testing.T) {
+	config := DefaultRetryConfig()
+
+	// Clients retrying on the same schedule would hit the provider in lockstep
+	seen := make(map[time.Duration]bool)
+	for run := 0; run < 20; run++ {
+		delay := config.backoffDelay(1, 0)
+		if delay < 0 || delay > config.BaseDelay {
+			t.Fatalf("Delay %v outside [0, %v]", delay, config.BaseDelay)
+		}
+		seen[delay] = true
+	}
+	if len(seen) < 2 {
+		t.Error("Expected default delays to vary across runs")
+	}
+}
+
+func TestBackoffDelay_JitterFactor(t *testing.T) {
+	config := &RetryConfig{
+		BaseDelay:    100 * time.Millisecond,
+		MaxDelay:     time.Second,
+		JitterFactor: 0.5,
+	}
+
+	for attempt := 1; attempt <= 10; attempt++ {
+		ceiling := exponentialDelay(config.BaseDelay, attempt, config.MaxDelay)
+		seen := make(map[time.Duration]bool)
+		for run := 0; run < 20; run++ {
+			delay := config.backoffDelay(attempt, 0)
+			if delay < ceiling/2 || delay > ceiling {
+				t.Fatalf("Attempt %d: delay %v outside [%v, %v]", attempt, delay, ceiling/2, ceiling)
+			}
+			seen[delay] = true
+		}
+		if len(seen) < 2 {
+			t.Errorf("Attempt %d: expected jittered delays to vary across runs", attempt)
+		}
+	}
+}
+
+func TestWithRetry_IdempotencyKey(t *testing.T) {
+	var mu sync.Mutex
+	var keys []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/models" {
+			fmt.Fprint(w, `{"object":"list","data":[{"id":"gpt-4o-mini","object":"model"}]}`)
+			return
+		}
+
+		mu.Lock()
+		keys = append(keys, r.Header.Get("Idempotency-Key"))
+		attempt := len(keys)
+		mu.Unlock()
+
+		if attempt < 3 {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, `{"error":{"message":"server_error: try again","type":"server_error"}}`)
+			return
+		}
+		fmt.Fprint(w, `{"id":"chatcmpl-1","object":"chat.completion","created":1,"model":"gpt-4o-mini",
+			"choices":[{"index":0,"message":{"role":"assistant","content":"Hi"},"finish_reason":"stop"}],
+			"usage":{"prompt_tokens":1,"completion_tokens":1,"total_tokens":2}}`)
+	}))
+	defer server.Close()
+
+	client, err := NewAIClient().
+		WithOpenAI("test-key", WithOpenAIBaseURL(server.URL)).
+		WithDefaultProvider("openai").
+		Build()
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+
+	req := &types.CompletionRequest{
+		Model:    "gpt-4o-mini",
+		Messages: []*types.Message{types.NewTextMessage(types.RoleUser, "Hello")},
+	}
+	_, err = WithRetry(context.Background(), req, &RetryConfig{MaxAttempts: 3, BaseDelay: time.Millisecond}, client.Complete)
+	if err != nil {
+		t.Fatalf("WithRetry failed: %v", err)
+	}
+
+	if len(keys) != 3 {
+		t.Fatalf("Expected 3 attempts, got %d", len(keys))
+	}
+	if keys[0] == "" || keys[1] != keys[0] || keys[2] != keys[0] {
+		t.Errorf("Expected the same idempotency key on every attempt, got %v", keys)
+	}
+	if req.IdempotencyKey != "" {
+		t.Errorf("Expected the generated key not to stay on the request, got %q", req.IdempotencyKey)
+	}
+
+	// A caller-provided key is used as is
+	keys = nil
+	req.IdempotencyKey = "order-42"
+	if _, err := WithRetry(context.Background(), req, &RetryConfig{MaxAttempts: 3, BaseDelay: time.Millisecond}, client.Complete); err != nil {
+		t.Fatalf("WithRetry failed: %v", err)
+	}
+	if keys[0] != "order-42" {
+		t.Errorf("Expected the caller's idempotency key, got %q", keys[0])
+	}
+}
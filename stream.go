@@ -0,0 +1,352 @@
+package aiutil
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"strings"
+
+	"github.com/ztkent/ai-util/types"
+)
+
+// StreamComplete performs a streaming completion request and assembles the streamed
+// chunks into a complete response. Text deltas are concatenated and tool call fragments
+// are merged by index, so the returned message carries complete tool calls.
+// The callback is optional and receives each chunk as it arrives.
+func (c *Client) StreamComplete(ctx context.Context, req *types.CompletionRequest, callback types.StreamCallback) (*types.CompletionResponse, error) {
+	acc := newStreamAccumulator()
+	var timing types.Timing
+
+	err := c.stream(ctx, req, func(ctx context.Context, response *types.StreamResponse) error {
+		acc.add(response)
+		if callback != nil {
+			return callback(ctx, response)
+		}
+		return nil
+	}, &timing)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := acc.response()
+	resp.Timing = &timing
+
+	// JSON output cut off by the token limit is repaired on a best-effort basis
+	if req.ResponseFormat != nil && req.ResponseFormat.Type != "text" && isLengthFinishReason(resp.FinishReason) {
+		if repaired, ok := RepairJSON(resp.Message.TextData); ok {
+			resp.Message.TextData = repaired
+			if resp.Metadata == nil {
+				resp.Metadata = make(map[string]interface{})
+			}
+			resp.Metadata[types.MetadataKeyJSONRepaired] = true
+		}
+	}
+
+	return resp, nil
+}
+
+// CollectStream runs a streaming request and returns the assembled response, for callers
+// that want a streaming endpoint without handling incremental output
+func CollectStream(ctx context.Context, client *Client, req *types.CompletionRequest) (*types.CompletionResponse, error) {
+	return client.StreamComplete(ctx, req, nil)
+}
+
+// isLengthFinishReason reports whether a finish reason means the output hit the token limit
+func isLengthFinishReason(reason string) bool {
+	switch strings.ToLower(reason) {
+	case "length", "max_tokens":
+		return true
+	}
+	return false
+}
+
+// normalizeFinishReason maps a provider's finish reason to a common form, so "length",
+// "max_tokens" and Google's "MAX_TOKENS" all read as "length". Other reasons are lowercased.
+func normalizeFinishReason(reason string) string {
+	if isLengthFinishReason(reason) {
+		return "length"
+	}
+	return strings.ToLower(reason)
+}
+
+// streamAccumulator collects streamed chunks into a single completion response
+type streamAccumulator struct {
+	id           string
+	model        string
+	provider     string
+	text         strings.Builder
+	toolCalls    []types.ToolCall
+	finishReason string
+	usage        *types.Usage
+	metadata     map[string]interface{}
+}
+
+func newStreamAccumulator() *streamAccumulator {
+	return &streamAccumulator{}
+}
+
+// add merges a stream chunk into the accumulated response
+func (a *streamAccumulator) add(chunk *types.StreamResponse) {
+	if chunk == nil {
+		return
+	}
+
+	if chunk.ID != "" {
+		a.id = chunk.ID
+	}
+	if chunk.Model != "" {
+		a.model = chunk.Model
+	}
+	if chunk.Provider != "" {
+		a.provider = chunk.Provider
+	}
+	if chunk.FinishReason != "" {
+		a.finishReason = chunk.FinishReason
+	}
+	if chunk.Usage != nil {
+		a.usage = chunk.Usage
+	}
+	if len(chunk.Metadata) > 0 {
+		if a.metadata == nil {
+			a.metadata = make(map[string]interface{}, len(chunk.Metadata))
+		}
+		maps.Copy(a.metadata, chunk.Metadata)
+	}
+
+	if chunk.Delta != nil {
+		a.text.WriteString(chunk.Delta.TextData)
+		a.toolCalls = mergeToolCallDeltas(a.toolCalls, chunk.Delta.ToolCalls)
+	}
+}
+
+// response builds the assembled completion response
+func (a *streamAccumulator) response() *types.CompletionResponse {
+	message := &types.Message{
+		Role:     types.RoleAssistant,
+		TextData: a.text.String(),
+	}
+
+	if len(a.toolCalls) > 0 {
+		toolCalls := make([]types.ToolCall, len(a.toolCalls))
+		for i, tc := range a.toolCalls {
+			tc.Index = nil
+			if tc.Args == nil && tc.Function.Arguments != "" {
+				var args map[string]interface{}
+				if err := json.Unmarshal([]byte(tc.Function.Arguments), &args); err == nil {
+					tc.Args = args
+				}
+			}
+			toolCalls[i] = tc
+		}
+		message.ToolCalls = toolCalls
+	}
+
+	return &types.CompletionResponse{
+		ID:           a.id,
+		Model:        a.model,
+		Provider:     a.provider,
+		Message:      message,
+		FinishReason: a.finishReason,
+		Usage:        a.usage,
+		Metadata:     a.metadata,
+	}
+}
+
+// mergeToolCallDeltas merges streamed tool call fragments into the accumulated tool calls.
+// Fragments with an index are merged into the call at that index, with argument fragments
+// concatenated. Fragments without an index (providers that stream whole calls) are matched
+// by ID, or appended as new calls.
+func mergeToolCallDeltas(calls []types.ToolCall, deltas []types.ToolCall) []types.ToolCall {
+	for _, delta := range deltas {
+		existing := -1
+		for i := range calls {
+			if delta.Index != nil && calls[i].Index != nil && *calls[i].Index == *delta.Index {
+				existing = i
+				break
+			}
+			if delta.Index == nil && delta.ID != "" && calls[i].ID == delta.ID {
+				existing = i
+				break
+			}
+		}
+
+		if existing == -1 {
+			calls = append(calls, delta)
+			continue
+		}
+
+		call := &calls[existing]
+		if delta.ID != "" {
+			call.ID = delta.ID
+		}
+		if delta.Type != "" {
+			call.Type = delta.Type
+		}
+		if delta.Function.Name != "" {
+			call.Function.Name = delta.Function.Name
+		}
+		call.Function.Arguments += delta.Function.Arguments
+		if delta.Args != nil {
+			call.Args = delta.Args
+		}
+	}
+
+	return calls
+}
+
+// SegmentKind identifies the section of a response a stream segment belongs to
+type SegmentKind string
+
+const (
+	SegmentReasoning SegmentKind = "reasoning" // Model thinking, shown before or alongside the answer
+	SegmentAnswer    SegmentKind = "answer"    // Response text
+	SegmentTool      SegmentKind = "tool"      // A tool call
+)
+
+// StreamSegment is a typed piece of a streamed response
+type StreamSegment struct {
+	Kind     SegmentKind
+	Text     string          // Reasoning or answer text; the tool name for tool segments
+	ToolCall *types.ToolCall // Set for tool segments
+	Start    bool            // First segment of a new section, e.g. where a UI switches from reasoning to answer
+}
+
+// SegmentCallback receives stream segments in order
+type SegmentCallback func(ctx context.Context, segment StreamSegment) error
+
+// SegmentStream wraps a segment callback as a stream callback, splitting each chunk into
+// reasoning, answer, and tool segments. Reasoning comes from thinking content in the delta or,
+// for providers that stream it as metadata (Google), the chunk's thoughts. Within a chunk,
+// reasoning is delivered before answer text and tool calls.
+func SegmentStream(callback SegmentCallback) types.StreamCallback {
+	var last SegmentKind
+
+	emit := func(ctx context.Context, segment StreamSegment) error {
+		segment.Start = segment.Kind != last || segment.Kind == SegmentTool
+		last = segment.Kind
+		return callback(ctx, segment)
+	}
+
+	return func(ctx context.Context, response *types.StreamResponse) error {
+		if response.Delta == nil {
+			return nil
+		}
+
+		var reasoning []string
+		for _, content := range response.Delta.Content {
+			if thinking, ok := content.(types.ThinkingContent); ok && thinking.Text != "" {
+				reasoning = append(reasoning, thinking.Text)
+			}
+		}
+		if thoughts, ok := response.Metadata[types.MetadataKeyThoughts].(string); ok && thoughts != "" && len(reasoning) == 0 {
+			reasoning = append(reasoning, thoughts)
+		}
+
+		for _, text := range reasoning {
+			if err := emit(ctx, StreamSegment{Kind: SegmentReasoning, Text: text}); err != nil {
+				return err
+			}
+		}
+		if response.Delta.TextData != "" {
+			if err := emit(ctx, StreamSegment{Kind: SegmentAnswer, Text: response.Delta.TextData}); err != nil {
+				return err
+			}
+		}
+		for i := range response.Delta.ToolCalls {
+			call := response.Delta.ToolCalls[i]
+			// Fragments continuing a call streamed by index belong to the current tool segment
+			if call.Function.Name == "" && last == SegmentTool {
+				if err := callback(ctx, StreamSegment{Kind: SegmentTool, ToolCall: &call}); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := emit(ctx, StreamSegment{Kind: SegmentTool, Text: call.Function.Name, ToolCall: &call}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// SentenceBuffer wraps a stream callback so text is delivered in complete sentences rather
+// than token fragments. Deltas are buffered and flushed at sentence boundaries (., !, ? followed
+// by whitespace, or a newline); any remaining text is flushed with the final chunk.
+// Tool call deltas are passed through as they arrive.
+func SentenceBuffer(callback types.StreamCallback) types.StreamCallback {
+	var buffer string
+
+	return func(ctx context.Context, response *types.StreamResponse) error {
+		if response.Delta != nil {
+			buffer += response.Delta.TextData
+		}
+
+		for {
+			end := sentenceEnd(buffer)
+			if end == -1 {
+				break
+			}
+			if err := callback(ctx, sentenceChunk(response, buffer[:end], nil)); err != nil {
+				return err
+			}
+			buffer = buffer[end:]
+		}
+
+		var toolCalls []types.ToolCall
+		if response.Delta != nil {
+			toolCalls = response.Delta.ToolCalls
+		}
+
+		if response.FinishReason != "" {
+			final := sentenceChunk(response, buffer, toolCalls)
+			final.FinishReason = response.FinishReason
+			final.Usage = response.Usage
+			buffer = ""
+			return callback(ctx, final)
+		}
+		if len(toolCalls) > 0 {
+			return callback(ctx, sentenceChunk(response, "", toolCalls))
+		}
+		return nil
+	}
+}
+
+// sentenceEnd returns the index just past the first complete sentence in text, including
+// trailing whitespace, or -1 if text has no complete sentence yet
+func sentenceEnd(text string) int {
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '\n':
+			return i + 1
+		case '.', '!', '?':
+			// Punctuation at the end of the buffer may be followed by more (e.g. "3.14" or "?!")
+			if i+1 < len(text) && isSpace(text[i+1]) {
+				end := i + 1
+				for end < len(text) && isSpace(text[end]) && text[end] != '\n' {
+					end++
+				}
+				return end
+			}
+		}
+	}
+	return -1
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
+
+// sentenceChunk builds a stream chunk carrying buffered text, keeping the source chunk's identity
+func sentenceChunk(source *types.StreamResponse, text string, toolCalls []types.ToolCall) *types.StreamResponse {
+	return &types.StreamResponse{
+		ID:       source.ID,
+		Model:    source.Model,
+		Provider: source.Provider,
+		Delta: &types.Message{
+			Role:      types.RoleAssistant,
+			TextData:  text,
+			ToolCalls: toolCalls,
+		},
+		Metadata: source.Metadata,
+	}
+}
@@ -0,0 +1,245 @@
+package aiutil
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ztkent/ai-util/types"
+)
+
+func TestClient_StreamComplete_ToolCallFragments(t *testing.T) {
+	provider := newMockProvider("mock-model")
+	provider.stream = func(ctx context.Context, req *types.CompletionRequest, callback types.StreamCallback) error {
+		index := 0
+		chunks := []*types.StreamResponse{
+			{Delta: &types.Message{Role: types.RoleAssistant, TextData: "Checking "}},
+			{Delta: &types.Message{TextData: "the weather."}},
+			{Delta: &types.Message{ToolCalls: []types.ToolCall{{
+				Index:    &index,
+				ID:       "call_1",
+				Type:     "function",
+				Function: types.ToolCallFunction{Name: "get_weather", Arguments: `{"loc`},
+			}}}},
+			{Delta: &types.Message{ToolCalls: []types.ToolCall{{
+				Index:    &index,
+				Function: types.ToolCallFunction{Arguments: `ation": "Par`},
+			}}}},
+			{Delta: &types.Message{ToolCalls: []types.ToolCall{{
+				Index:    &index,
+				Function: types.ToolCallFunction{Arguments: `is"}`},
+			}}}},
+			{FinishReason: "tool_calls", Usage: &types.Usage{TotalTokens: 42}},
+		}
+		for _, chunk := range chunks {
+			chunk.ID = "stream-1"
+			chunk.Model = req.Model
+			chunk.Provider = "mock"
+			if err := callback(ctx, chunk); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	client := newMockClient(t, provider)
+
+	chunks := 0
+	resp, err := client.StreamComplete(context.Background(), &types.CompletionRequest{
+		Model:    "mock-model",
+		Messages: []*types.Message{types.NewTextMessage(types.RoleUser, "Weather in Paris?")},
+	}, func(ctx context.Context, response *types.StreamResponse) error {
+		chunks++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("StreamComplete failed: %v", err)
+	}
+
+	if chunks != 6 {
+		t.Errorf("Expected callback to receive 6 chunks, got %d", chunks)
+	}
+	if resp.Message.GetText() != "Checking the weather." {
+		t.Errorf("Expected assembled text, got %q", resp.Message.GetText())
+	}
+	if resp.FinishReason != "tool_calls" {
+		t.Errorf("Expected finish reason 'tool_calls', got %q", resp.FinishReason)
+	}
+	if resp.Usage == nil || resp.Usage.TotalTokens != 42 {
+		t.Errorf("Expected usage from final chunk, got %+v", resp.Usage)
+	}
+
+	if len(resp.Message.ToolCalls) != 1 {
+		t.Fatalf("Expected 1 tool call, got %d", len(resp.Message.ToolCalls))
+	}
+	toolCall := resp.Message.ToolCalls[0]
+	if toolCall.ID != "call_1" || toolCall.Function.Name != "get_weather" {
+		t.Errorf("Unexpected tool call identity: %+v", toolCall)
+	}
+	if toolCall.Function.Arguments != `{"location": "Paris"}` {
+		t.Errorf("Expected merged arguments, got %q", toolCall.Function.Arguments)
+	}
+	if toolCall.Args["location"] != "Paris" {
+		t.Errorf("Expected parsed args location 'Paris', got %v", toolCall.Args["location"])
+	}
+}
+
+func TestMergeToolCallDeltas_WholeCalls(t *testing.T) {
+	calls := mergeToolCallDeltas(nil, []types.ToolCall{
+		{ID: "call_a", Function: types.ToolCallFunction{Name: "a", Arguments: "{}"}},
+	})
+	calls = mergeToolCallDeltas(calls, []types.ToolCall{
+		{ID: "call_b", Function: types.ToolCallFunction{Name: "b", Arguments: "{}"}},
+	})
+
+	if len(calls) != 2 {
+		t.Fatalf("Expected 2 tool calls, got %d", len(calls))
+	}
+	if calls[0].Function.Name != "a" || calls[1].Function.Name != "b" {
+		t.Errorf("Unexpected tool call order: %+v", calls)
+	}
+}
+
+func TestSentenceBuffer(t *testing.T) {
+	var sentences []string
+	var finishReason string
+	callback := SentenceBuffer(func(ctx context.Context, response *types.StreamResponse) error {
+		sentences = append(sentences, response.Delta.TextData)
+		finishReason = response.FinishReason
+		return nil
+	})
+
+	ctx := context.Background()
+	fragments := []string{"The value of pi", " is 3.", "14. Is", " that right", "?"}
+	for i, fragment := range fragments {
+		chunk := &types.StreamResponse{Delta: &types.Message{TextData: fragment}}
+		if i == len(fragments)-1 {
+			chunk.FinishReason = "stop"
+		}
+		if err := callback(ctx, chunk); err != nil {
+			t.Fatalf("Callback failed: %v", err)
+		}
+	}
+
+	expected := []string{"The value of pi is 3.14. ", "Is that right?"}
+	if len(sentences) != len(expected) {
+		t.Fatalf("Expected %d sentence callbacks, got %d: %q", len(expected), len(sentences), sentences)
+	}
+	for i, sentence := range expected {
+		if sentences[i] != sentence {
+			t.Errorf("Sentence %d: expected %q, got %q", i, sentence, sentences[i])
+		}
+	}
+	if finishReason != "stop" {
+		t.Errorf("Expected finish reason on the final sentence, got '%s'", finishReason)
+	}
+}
+
+func TestCollectStream_InterleavedToolCalls(t *testing.T) {
+	provider := newMockProvider("mock-model")
+	provider.stream = func(ctx context.Context, req *types.CompletionRequest, callback types.StreamCallback) error {
+		first, second := 0, 1
+		chunks := []*types.StreamResponse{
+			{Delta: &types.Message{ToolCalls: []types.ToolCall{{
+				Index:    &first,
+				ID:       "call_1",
+				Function: types.ToolCallFunction{Name: "get_weather", Arguments: `{"city":`},
+			}}}},
+			{Delta: &types.Message{ToolCalls: []types.ToolCall{{
+				Index:    &second,
+				ID:       "call_2",
+				Function: types.ToolCallFunction{Name: "get_time", Arguments: `{"zone"`},
+			}}}},
+			{Delta: &types.Message{ToolCalls: []types.ToolCall{
+				{Index: &first, Function: types.ToolCallFunction{Arguments: ` "Paris"}`}},
+				{Index: &second, Function: types.ToolCallFunction{Arguments: `: "CET"}`}},
+			}}},
+			{FinishReason: "tool_calls", Usage: &types.Usage{TotalTokens: 7}},
+		}
+		for _, chunk := range chunks {
+			if err := callback(ctx, chunk); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	client := newMockClient(t, provider)
+
+	resp, err := CollectStream(context.Background(), client, &types.CompletionRequest{
+		Model:    "mock-model",
+		Messages: []*types.Message{types.NewTextMessage(types.RoleUser, "Weather and time in Paris?")},
+	})
+	if err != nil {
+		t.Fatalf("CollectStream failed: %v", err)
+	}
+
+	if resp.Usage == nil || resp.Usage.TotalTokens != 7 {
+		t.Errorf("Expected usage from final chunk, got %+v", resp.Usage)
+	}
+	if len(resp.Message.ToolCalls) != 2 {
+		t.Fatalf("Expected 2 tool calls, got %d", len(resp.Message.ToolCalls))
+	}
+	if args := resp.Message.ToolCalls[0].Function.Arguments; args != `{"city": "Paris"}` {
+		t.Errorf("Expected merged arguments for first call, got %q", args)
+	}
+	if args := resp.Message.ToolCalls[1].Function.Arguments; args != `{"zone": "CET"}` {
+		t.Errorf("Expected merged arguments for second call, got %q", args)
+	}
+	if resp.Message.ToolCalls[1].Args["zone"] != "CET" {
+		t.Errorf("Expected parsed args for second call, got %+v", resp.Message.ToolCalls[1].Args)
+	}
+}
+
+func TestSegmentStream(t *testing.T) {
+	provider := newMockProvider("mock-model")
+	provider.stream = func(ctx context.Context, req *types.CompletionRequest, callback types.StreamCallback) error {
+		index := 0
+		chunks := []*types.StreamResponse{
+			{Delta: &types.Message{Content: []types.MessageContent{types.ThinkingContent{Text: "The user wants "}}}},
+			{Delta: &types.Message{}, Metadata: map[string]interface{}{types.MetadataKeyThoughts: "the weather."}},
+			{Delta: &types.Message{TextData: "Let me "}},
+			{Delta: &types.Message{TextData: "check."}},
+			{Delta: &types.Message{ToolCalls: []types.ToolCall{{Index: &index, ID: "call_1", Function: types.ToolCallFunction{Name: "get_weather"}}}}},
+			{Delta: &types.Message{ToolCalls: []types.ToolCall{{Index: &index, Function: types.ToolCallFunction{Arguments: `{"city":"Paris"}`}}}}},
+			{Delta: &types.Message{}, FinishReason: "tool_calls"},
+		}
+		for _, chunk := range chunks {
+			if err := callback(ctx, chunk); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	client := newMockClient(t, provider)
+
+	var segments []StreamSegment
+	err := client.Stream(context.Background(), &types.CompletionRequest{
+		Model:    "mock-model",
+		Messages: []*types.Message{types.NewTextMessage(types.RoleUser, "Weather in Paris?")},
+	}, SegmentStream(func(ctx context.Context, segment StreamSegment) error {
+		segments = append(segments, segment)
+		return nil
+	}))
+	if err != nil {
+		t.Fatalf("Stream failed: %v", err)
+	}
+
+	expected := []StreamSegment{
+		{Kind: SegmentReasoning, Text: "The user wants ", Start: true},
+		{Kind: SegmentReasoning, Text: "the weather."},
+		{Kind: SegmentAnswer, Text: "Let me ", Start: true},
+		{Kind: SegmentAnswer, Text: "check."},
+		{Kind: SegmentTool, Text: "get_weather", Start: true},
+		{Kind: SegmentTool},
+	}
+	if len(segments) != len(expected) {
+		t.Fatalf("Expected %d segments, got %d: %+v", len(expected), len(segments), segments)
+	}
+	for i, want := range expected {
+		got := segments[i]
+		if got.Kind != want.Kind || got.Text != want.Text || got.Start != want.Start {
+			t.Errorf("Segment %d: expected %s %q (start %v), got %s %q (start %v)", i, want.Kind, want.Text, want.Start, got.Kind, got.Text, got.Start)
+		}
+	}
+	if segments[5].ToolCall == nil || segments[5].ToolCall.Function.Arguments != `{"city":"Paris"}` {
+		t.Errorf("Expected the argument fragment on the last tool segment, got %+v", segments[5].ToolCall)
+	}
+}
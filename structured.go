@@ -0,0 +1,58 @@
+package aiutil
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/ztkent/ai-util/types"
+)
+
+// structuredToolName is the synthetic tool CompleteStructured forces the model to call
+const structuredToolName = "respond"
+
+// CompleteStructured gets structured output from a tool-capable model by defining a synthetic
+// "respond" tool whose parameters are schema, forcing a call to it, and decoding the call's
+// arguments into target. Any tools already on the request are replaced.
+func (c *Client) CompleteStructured(ctx context.Context, req *types.CompletionRequest, schema map[string]interface{}, target interface{}) (*types.CompletionResponse, error) {
+	structuredReq := *req
+	structuredReq.Tools = []types.Tool{{
+		Type: "function",
+		Function: &types.ToolFunction{
+			Name:        structuredToolName,
+			Description: "Respond to the user with the structured result.",
+			Parameters:  schema,
+		},
+	}}
+	structuredReq.ToolChoice = types.ForcedToolChoice{Name: structuredToolName}
+
+	resp, err := c.complete(ctx, &structuredReq, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var arguments string
+	found := false
+	if resp.Message != nil {
+		for _, call := range resp.Message.ToolCalls {
+			if call.Function.Name == structuredToolName {
+				arguments = call.Function.Arguments
+				found = true
+				break
+			}
+		}
+	}
+	if !found {
+		return resp, types.NewError(types.ErrCodeServerError,
+			fmt.Sprintf("model did not call the %s tool", structuredToolName), resp.Provider)
+	}
+
+	if err := json.Unmarshal([]byte(arguments), target); err != nil {
+		repaired, ok := RepairJSON(arguments)
+		if !ok || json.Unmarshal([]byte(repaired), target) != nil {
+			return resp, types.WrapError(err, types.ErrCodeServerError, resp.Provider)
+		}
+	}
+
+	return resp, nil
+}
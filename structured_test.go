@@ -0,0 +1,82 @@
+package aiutil
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ztkent/ai-util/types"
+)
+
+func TestClient_CompleteStructured(t *testing.T) {
+	provider := newMockProvider("mock-model")
+	provider.complete = func(ctx context.Context, req *types.CompletionRequest) (*types.CompletionResponse, error) {
+		return &types.CompletionResponse{
+			Model:    req.Model,
+			Provider: "mock",
+			Message: &types.Message{
+				Role: types.RoleAssistant,
+				ToolCalls: []types.ToolCall{{
+					ID:   "call_1",
+					Type: "function",
+					Function: types.ToolCallFunction{
+						Name:      "respond",
+						Arguments: `{"city":"Paris","population":2102650,"landmarks":["Eiffel Tower","Louvre"]}`,
+					},
+				}},
+			},
+			FinishReason: "tool_calls",
+		}, nil
+	}
+	client := newMockClient(t, provider)
+
+	schema := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"city":       map[string]interface{}{"type": "string"},
+			"population": map[string]interface{}{"type": "integer"},
+			"landmarks":  map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+		},
+	}
+	var result struct {
+		City       string   `json:"city"`
+		Population int      `json:"population"`
+		Landmarks  []string `json:"landmarks"`
+	}
+
+	req := &types.CompletionRequest{
+		Model:    "mock-model",
+		Messages: []*types.Message{types.NewTextMessage(types.RoleUser, "Describe Paris")},
+	}
+	if _, err := client.CompleteStructured(context.Background(), req, schema, &result); err != nil {
+		t.Fatalf("CompleteStructured failed: %v", err)
+	}
+
+	if result.City != "Paris" || result.Population != 2102650 || len(result.Landmarks) != 2 || result.Landmarks[1] != "Louvre" {
+		t.Errorf("Expected parsed arguments, got %+v", result)
+	}
+
+	sent := provider.requests[0]
+	if len(sent.Tools) != 1 || sent.Tools[0].Function.Name != "respond" {
+		t.Fatalf("Expected a single respond tool, got %+v", sent.Tools)
+	}
+	if choice, ok := sent.ToolChoice.(types.ForcedToolChoice); !ok || choice.Name != "respond" {
+		t.Errorf("Expected tool choice forced to respond, got %v", sent.ToolChoice)
+	}
+	if len(req.Tools) != 0 || req.ToolChoice != nil {
+		t.Error("Expected the caller's request to be left unchanged")
+	}
+}
+
+func TestClient_CompleteStructured_NoToolCall(t *testing.T) {
+	provider := newMockProvider("mock-model")
+	client := newMockClient(t, provider)
+
+	var result map[string]interface{}
+	_, err := client.CompleteStructured(context.Background(), &types.CompletionRequest{
+		Model:    "mock-model",
+		Messages: []*types.Message{types.NewTextMessage(types.RoleUser, "Hello")},
+	}, map[string]interface{}{"type": "object"}, &result)
+	if err == nil {
+		t.Error("Expected error when the model doesn't call the respond tool")
+	}
+}
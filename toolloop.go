@@ -0,0 +1,128 @@
+package aiutil
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ztkent/ai-util/types"
+)
+
+// defaultMaxToolIterations bounds RunToolLoop when no limit is given
+const defaultMaxToolIterations = 10
+
+// ToolHandler executes a tool call and returns the result to send back to the model
+type ToolHandler func(ctx context.Context, call types.ToolCall) (string, error)
+
+// FinishAction tells RunToolLoop what to do after a finish reason hook runs
+type FinishAction int
+
+const (
+	FinishStop     FinishAction = iota // Return the response
+	FinishContinue                     // Send the request again, including any changes the hook made
+)
+
+// FinishReasonHook is called when a response finishes for a reason other than tool calls.
+// It may modify req, e.g. appending the partial output and a continuation prompt, before
+// returning FinishContinue.
+type FinishReasonHook func(ctx context.Context, req *types.CompletionRequest, resp *types.CompletionResponse) (FinishAction, error)
+
+// ToolLoopOptions configures RunToolLoop
+type ToolLoopOptions struct {
+	MaxIterations int     // Maximum completion requests (default 10)
+	CostBudget    float64 // Stop once the estimated cost of the loop exceeds this many dollars (0 disables)
+
+	// OnFinishReason maps finish reasons to hooks. Reasons are matched case-insensitively and
+	// token limit reasons are normalised, so a "length" hook also fires for Google's
+	// "MAX_TOKENS". Responses without a hook are returned.
+	OnFinishReason map[string]FinishReasonHook
+}
+
+// RunToolLoop completes req, executing any tool calls with the matching handlers and sending
+// the results back until the model responds without tool calls. Tool errors and unknown tools
+// are reported to the model as tool results rather than ending the loop. If the loop's
+// estimated cost exceeds opts.CostBudget, it stops with ErrCodeBudgetExceeded and returns the
+// latest response alongside the error.
+func (c *Client) RunToolLoop(ctx context.Context, req *types.CompletionRequest, handlers map[string]ToolHandler, opts *ToolLoopOptions) (*types.CompletionResponse, error) {
+	if opts == nil {
+		opts = &ToolLoopOptions{}
+	}
+	maxIterations := opts.MaxIterations
+	if maxIterations <= 0 {
+		maxIterations = defaultMaxToolIterations
+	}
+
+	loopReq := *req
+	loopReq.Messages = append([]*types.Message(nil), req.Messages...)
+
+	var cost float64
+	for i := 0; i < maxIterations; i++ {
+		resp, err := c.complete(ctx, &loopReq, "")
+		if err != nil {
+			return nil, err
+		}
+
+		if opts.CostBudget > 0 {
+			if provider, err := c.ProviderForModel(loopReq.Model); err == nil {
+				cost += c.usageCost(provider, loopReq.Model, resp.Model, resp.Usage)
+			}
+			if cost > opts.CostBudget {
+				budgetErr := types.NewError(types.ErrCodeBudgetExceeded,
+					fmt.Sprintf("tool loop cost $%.4f, exceeding the $%.4f budget", cost, opts.CostBudget), resp.Provider)
+				budgetErr.Details["cost"] = cost
+				return resp, budgetErr
+			}
+		}
+
+		if resp.Message != nil && len(resp.Message.ToolCalls) > 0 {
+			loopReq.Messages = append(loopReq.Messages, resp.Message)
+			for _, call := range resp.Message.ToolCalls {
+				loopReq.Messages = append(loopReq.Messages, runToolCall(ctx, handlers, call))
+			}
+			continue
+		}
+
+		hook, ok := finishReasonHook(opts.OnFinishReason, resp.FinishReason)
+		if !ok {
+			return resp, nil
+		}
+		action, err := hook(ctx, &loopReq, resp)
+		if err != nil {
+			return nil, err
+		}
+		if action != FinishContinue {
+			return resp, nil
+		}
+	}
+
+	return nil, types.NewError(types.ErrCodeInvalidRequest,
+		fmt.Sprintf("tool loop did not finish within %d iterations", maxIterations), "")
+}
+
+// finishReasonHook returns the hook for a finish reason, comparing normalised reasons
+func finishReasonHook(hooks map[string]FinishReasonHook, reason string) (FinishReasonHook, bool) {
+	reason = normalizeFinishReason(reason)
+	for key, hook := range hooks {
+		if normalizeFinishReason(key) == reason {
+			return hook, true
+		}
+	}
+	return nil, false
+}
+
+// runToolCall executes a tool call and wraps its result, or error, in a tool message
+func runToolCall(ctx context.Context, handlers map[string]ToolHandler, call types.ToolCall) *types.Message {
+	result := &types.ToolResult{ToolCallID: call.ID}
+
+	handler, ok := handlers[call.Function.Name]
+	if !ok {
+		result.Error = fmt.Sprintf("unknown tool %s", call.Function.Name)
+		result.Content = result.Error
+	} else if content, err := handler(ctx, call); err != nil {
+		result.Error = err.Error()
+		result.Content = "Error: " + err.Error()
+	} else {
+		result.Content = content
+	}
+
+	return &types.Message{Role: types.RoleTool, ToolResult: result}
+}
@@ -0,0 +1,179 @@
+package aiutil
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ztkent/ai-util/types"
+)
+
+func TestClient_RunToolLoop(t *testing.T) {
+	provider := newMockProvider("mock-model")
+	provider.complete = func(ctx context.Context, req *types.CompletionRequest) (*types.CompletionResponse, error) {
+		if len(provider.requests) == 1 {
+			return &types.CompletionResponse{
+				Model: req.Model,
+				Message: &types.Message{
+					Role: types.RoleAssistant,
+					ToolCalls: []types.ToolCall{{
+						ID:       "call_1",
+						Type:     "function",
+						Function: types.ToolCallFunction{Name: "get_weather", Arguments: `{"location":"Paris"}`},
+					}},
+				},
+				FinishReason: "tool_calls",
+			}, nil
+		}
+		return &types.CompletionResponse{
+			Model:        req.Model,
+			Message:      types.NewTextMessage(types.RoleAssistant, "It is sunny in Paris."),
+			FinishReason: "stop",
+		}, nil
+	}
+	client := newMockClient(t, provider)
+
+	handlers := map[string]ToolHandler{
+		"get_weather": func(ctx context.Context, call types.ToolCall) (string, error) {
+			return "sunny", nil
+		},
+	}
+	resp, err := client.RunToolLoop(context.Background(), &types.CompletionRequest{
+		Model:    "mock-model",
+		Messages: []*types.Message{types.NewTextMessage(types.RoleUser, "Weather in Paris?")},
+	}, handlers, nil)
+	if err != nil {
+		t.Fatalf("RunToolLoop failed: %v", err)
+	}
+
+	if resp.Message.GetText() != "It is sunny in Paris." {
+		t.Errorf("Expected final answer, got %q", resp.Message.GetText())
+	}
+	if len(provider.requests) != 2 {
+		t.Fatalf("Expected 2 requests, got %d", len(provider.requests))
+	}
+	messages := provider.requests[1].Messages
+	last := messages[len(messages)-1]
+	if last.Role != types.RoleTool || last.ToolResult == nil || last.ToolResult.ToolCallID != "call_1" || last.ToolResult.Content != "sunny" {
+		t.Errorf("Expected the tool result to be sent back, got %+v", last)
+	}
+}
+
+func TestClient_RunToolLoop_FinishReasonHook(t *testing.T) {
+	provider := newMockProvider("mock-model")
+	provider.complete = func(ctx context.Context, req *types.CompletionRequest) (*types.CompletionResponse, error) {
+		if len(provider.requests) == 1 {
+			return &types.CompletionResponse{
+				Model:        req.Model,
+				Message:      types.NewTextMessage(types.RoleAssistant, "The first half"),
+				FinishReason: "MAX_TOKENS", // Google's name for the token limit
+			}, nil
+		}
+		return &types.CompletionResponse{
+			Model:        req.Model,
+			Message:      types.NewTextMessage(types.RoleAssistant, " and the second half."),
+			FinishReason: "stop",
+		}, nil
+	}
+	client := newMockClient(t, provider)
+
+	hookCalls := 0
+	opts := &ToolLoopOptions{
+		OnFinishReason: map[string]FinishReasonHook{
+			"length": func(ctx context.Context, req *types.CompletionRequest, resp *types.CompletionResponse) (FinishAction, error) {
+				hookCalls++
+				req.Messages = append(req.Messages, resp.Message, types.NewTextMessage(types.RoleUser, continuationPrompt))
+				return FinishContinue, nil
+			},
+		},
+	}
+
+	resp, err := client.RunToolLoop(context.Background(), &types.CompletionRequest{
+		Model:    "mock-model",
+		Messages: []*types.Message{types.NewTextMessage(types.RoleUser, "Write a long answer")},
+	}, nil, opts)
+	if err != nil {
+		t.Fatalf("RunToolLoop failed: %v", err)
+	}
+
+	if hookCalls != 1 {
+		t.Errorf("Expected the length hook to fire once, got %d", hookCalls)
+	}
+	if resp.FinishReason != "stop" {
+		t.Errorf("Expected the continued response, got finish reason %q", resp.FinishReason)
+	}
+	continued := provider.requests[1].Messages
+	if len(continued) != 3 || continued[2].GetText() != continuationPrompt {
+		t.Errorf("Expected the hook's continuation prompt to be sent, got %d messages", len(continued))
+	}
+}
+
+func TestClient_RunToolLoop_MaxIterations(t *testing.T) {
+	provider := newMockProvider("mock-model")
+	provider.complete = func(ctx context.Context, req *types.CompletionRequest) (*types.CompletionResponse, error) {
+		return &types.CompletionResponse{
+			Model: req.Model,
+			Message: &types.Message{
+				Role:      types.RoleAssistant,
+				ToolCalls: []types.ToolCall{{ID: "call", Type: "function", Function: types.ToolCallFunction{Name: "missing"}}},
+			},
+			FinishReason: "tool_calls",
+		}, nil
+	}
+	client := newMockClient(t, provider)
+
+	_, err := client.RunToolLoop(context.Background(), &types.CompletionRequest{
+		Model:    "mock-model",
+		Messages: []*types.Message{types.NewTextMessage(types.RoleUser, "Loop forever")},
+	}, nil, &ToolLoopOptions{MaxIterations: 3})
+	if err == nil {
+		t.Fatal("Expected error when the loop exceeds MaxIterations")
+	}
+	if len(provider.requests) != 3 {
+		t.Errorf("Expected 3 requests, got %d", len(provider.requests))
+	}
+	if result := provider.requests[1].Messages[2].ToolResult; result == nil || result.Error == "" {
+		t.Errorf("Expected unknown tool to be reported as a tool error, got %+v", result)
+	}
+}
+
+func TestClient_RunToolLoop_CostBudget(t *testing.T) {
+	provider := newMockProvider()
+	provider.models = []*types.Model{{ID: "priced-model", Provider: "mock", InputCost: 10.00, OutputCost: 30.00}}
+	provider.complete = func(ctx context.Context, req *types.CompletionRequest) (*types.CompletionResponse, error) {
+		return &types.CompletionResponse{
+			Model:    req.Model,
+			Provider: "mock",
+			Message: &types.Message{
+				Role:      types.RoleAssistant,
+				ToolCalls: []types.ToolCall{{ID: "call", Type: "function", Function: types.ToolCallFunction{Name: "search"}}},
+			},
+			FinishReason: "tool_calls",
+			Usage:        &types.Usage{PromptTokens: 1000, CompletionTokens: 1000, TotalTokens: 2000},
+		}, nil
+	}
+	client := newMockClient(t, provider)
+
+	handlers := map[string]ToolHandler{
+		"search": func(ctx context.Context, call types.ToolCall) (string, error) {
+			return "more results", nil
+		},
+	}
+
+	// Each turn costs $0.04, so the third turn exceeds a $0.10 budget
+	resp, err := client.RunToolLoop(context.Background(), &types.CompletionRequest{
+		Model:    "priced-model",
+		Messages: []*types.Message{types.NewTextMessage(types.RoleUser, "Search forever")},
+	}, handlers, &ToolLoopOptions{MaxIterations: 10, CostBudget: 0.10})
+
+	var aiErr *types.Error
+	if !errors.As(err, &aiErr) || aiErr.Code != types.ErrCodeBudgetExceeded {
+		t.Fatalf("Expected %s error, got %v", types.ErrCodeBudgetExceeded, err)
+	}
+	if len(provider.requests) != 3 {
+		t.Errorf("Expected the loop to stop after 3 requests, got %d", len(provider.requests))
+	}
+	if resp == nil || len(resp.Message.ToolCalls) != 1 {
+		t.Error("Expected the latest response to be returned with the error")
+	}
+}
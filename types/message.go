@@ -1,6 +1,14 @@
 package types
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
 	"time"
 )
 
@@ -28,19 +36,28 @@ func (t TextContent) Type() string { return "text" }
 
 // ImageContent represents image content
 type ImageContent struct {
-	URL    string `json:"url,omitempty"`
-	Base64 string `json:"base64,omitempty"`
-	Detail string `json:"detail,omitempty"` // "low", "high", "auto"
+	URL      string `json:"url,omitempty"`
+	Base64   string `json:"base64,omitempty"`
+	MimeType string `json:"mime_type,omitempty"` // e.g. "image/png"; base64 data defaults to JPEG
+	Detail   string `json:"detail,omitempty"`    // "low", "high", "auto"
 }
 
 func (i ImageContent) Type() string { return "image" }
 
+// ThinkingContent represents model reasoning returned alongside the answer
+type ThinkingContent struct {
+	Text string `json:"text"`
+}
+
+func (t ThinkingContent) Type() string { return "thinking" }
+
 // ToolCall represents a tool/function call
 type ToolCall struct {
 	ID       string                 `json:"id"`
 	Type     string                 `json:"type"`
 	Function ToolCallFunction       `json:"function"`
 	Args     map[string]interface{} `json:"args,omitempty"`
+	Index    *int                   `json:"index,omitempty"` // Position of a streamed tool call delta
 }
 
 type ToolCallFunction struct {
@@ -85,6 +102,75 @@ func NewContentMessage(role Role, content []MessageContent) *Message {
 	}
 }
 
+// MaxImageFileSize is the largest image file, in bytes, that NewImageMessageFromFile will read
+var MaxImageFileSize int64 = 20 << 20
+
+// NewImageMessageFromFile creates a message with an image read from a local file.
+// The MIME type is detected from the file contents, falling back to the file extension.
+func NewImageMessageFromFile(role Role, path string) (*Message, error) {
+	image, err := loadImageFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewContentMessage(role, []MessageContent{image}), nil
+}
+
+// NewMultiImageMessage creates a content message with a text part followed by each image
+func NewMultiImageMessage(role Role, text string, images ...ImageContent) *Message {
+	content := make([]MessageContent, 0, len(images)+1)
+	if text != "" {
+		content = append(content, TextContent{Text: text})
+	}
+	for _, image := range images {
+		content = append(content, image)
+	}
+	return NewContentMessage(role, content)
+}
+
+// NewMultiImageMessageFromFiles creates a content message with a text part followed by an
+// image read from each local file, in order
+func NewMultiImageMessageFromFiles(role Role, text string, paths ...string) (*Message, error) {
+	images := make([]ImageContent, 0, len(paths))
+	for _, path := range paths {
+		image, err := loadImageFile(path)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, image)
+	}
+	return NewMultiImageMessage(role, text, images...), nil
+}
+
+// loadImageFile reads a local image file into base64 image content
+func loadImageFile(path string) (ImageContent, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return ImageContent{}, WrapError(err, ErrCodeInvalidRequest, "")
+	}
+	if info.Size() > MaxImageFileSize {
+		return ImageContent{}, NewError(ErrCodeInvalidRequest,
+			fmt.Sprintf("image %s is %d bytes, exceeding the %d byte limit", path, info.Size(), MaxImageFileSize), "")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ImageContent{}, WrapError(err, ErrCodeInvalidRequest, "")
+	}
+
+	mimeType := http.DetectContentType(data)
+	if !strings.HasPrefix(mimeType, "image/") {
+		mimeType = mime.TypeByExtension(filepath.Ext(path))
+	}
+	if !strings.HasPrefix(mimeType, "image/") {
+		return ImageContent{}, NewError(ErrCodeInvalidRequest, fmt.Sprintf("file %s is not a supported image", path), "")
+	}
+
+	return ImageContent{
+		Base64:   base64.StdEncoding.EncodeToString(data),
+		MimeType: mimeType,
+	}, nil
+}
+
 // GetText returns the text content of the message
 func (m *Message) GetText() string {
 	if m.TextData != "" {
@@ -100,6 +186,34 @@ func (m *Message) GetText() string {
 	return ""
 }
 
+// imagePlaceholder stands in for an image when a message is flattened to text
+const imagePlaceholder = "[image]"
+
+// FlattenToText returns all of the message's text: TextData followed by every text part,
+// joined by newlines, with images noted as placeholders. Unlike GetText, which returns only
+// the first text, nothing is dropped for paths that only support plain text. Thinking parts
+// are omitted.
+func (m *Message) FlattenToText() string {
+	var parts []string
+	if m.TextData != "" {
+		parts = append(parts, m.TextData)
+	}
+
+	for _, content := range m.Content {
+		switch c := content.(type) {
+		case TextContent:
+			// Responses keep their primary text in both TextData and Content
+			if c.Text != "" && c.Text != m.TextData {
+				parts = append(parts, c.Text)
+			}
+		case ImageContent:
+			parts = append(parts, imagePlaceholder)
+		}
+	}
+
+	return strings.Join(parts, "\n")
+}
+
 // HasImages returns true if the message contains image content
 func (m *Message) HasImages() bool {
 	for _, content := range m.Content {
@@ -109,3 +223,81 @@ func (m *Message) HasImages() bool {
 	}
 	return false
 }
+
+// contentTypeKey is the JSON key recording a content part's type, so parts can be decoded
+// back into their concrete types
+const contentTypeKey = "type"
+
+// MarshalJSON encodes the message, tagging each content part with its type
+func (m Message) MarshalJSON() ([]byte, error) {
+	// The alias has no methods, so this doesn't recurse into MarshalJSON
+	type messageJSON Message
+	encoded := struct {
+		messageJSON
+		Content []map[string]interface{} `json:"content,omitempty"`
+	}{messageJSON: messageJSON(m)}
+
+	for _, content := range m.Content {
+		data, err := json.Marshal(content)
+		if err != nil {
+			return nil, err
+		}
+		part := make(map[string]interface{})
+		if err := json.Unmarshal(data, &part); err != nil {
+			return nil, fmt.Errorf("content of type %s must encode as a JSON object: %w", content.Type(), err)
+		}
+		part[contentTypeKey] = content.Type()
+		encoded.Content = append(encoded.Content, part)
+	}
+
+	return json.Marshal(encoded)
+}
+
+// UnmarshalJSON decodes the message, restoring content parts to their concrete types
+func (m *Message) UnmarshalJSON(data []byte) error {
+	type messageJSON Message
+	var decoded struct {
+		messageJSON
+		Content []json.RawMessage `json:"content,omitempty"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+
+	*m = Message(decoded.messageJSON)
+	m.Content = nil
+	for _, raw := range decoded.Content {
+		content, err := unmarshalContent(raw)
+		if err != nil {
+			return err
+		}
+		m.Content = append(m.Content, content)
+	}
+	return nil
+}
+
+// unmarshalContent decodes a content part by its recorded type
+func unmarshalContent(data []byte) (MessageContent, error) {
+	var header struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, err
+	}
+
+	switch header.Type {
+	case "text":
+		var content TextContent
+		err := json.Unmarshal(data, &content)
+		return content, err
+	case "image":
+		var content ImageContent
+		err := json.Unmarshal(data, &content)
+		return content, err
+	case "thinking":
+		var content ThinkingContent
+		err := json.Unmarshal(data, &content)
+		return content, err
+	}
+	return nil, fmt.Errorf("unknown message content type %q", header.Type)
+}
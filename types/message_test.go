@@ -0,0 +1,193 @@
+package types
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewImageMessageFromFile(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 2, 2))); err != nil {
+		t.Fatalf("Failed to encode PNG: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "pixel.png")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatalf("Failed to write PNG: %v", err)
+	}
+
+	msg, err := NewImageMessageFromFile(RoleUser, path)
+	if err != nil {
+		t.Fatalf("NewImageMessageFromFile failed: %v", err)
+	}
+
+	if msg.Role != RoleUser || len(msg.Content) != 1 {
+		t.Fatalf("Expected a user message with one content part, got %+v", msg)
+	}
+	img, ok := msg.Content[0].(ImageContent)
+	if !ok {
+		t.Fatalf("Expected ImageContent, got %T", msg.Content[0])
+	}
+	if img.MimeType != "image/png" {
+		t.Errorf("Expected MIME type 'image/png', got '%s'", img.MimeType)
+	}
+	if img.Base64 != base64.StdEncoding.EncodeToString(buf.Bytes()) {
+		t.Error("Expected base64 data to match the file contents")
+	}
+}
+
+func TestNewMultiImageMessage(t *testing.T) {
+	images := []ImageContent{
+		{URL: "https://example.com/a.jpg"},
+		{URL: "https://example.com/b.jpg"},
+		{Base64: "aGVsbG8=", MimeType: "image/png"},
+	}
+	msg := NewMultiImageMessage(RoleUser, "Compare these images.", images...)
+
+	if msg.Role != RoleUser || len(msg.Content) != 4 {
+		t.Fatalf("Expected a user message with 4 content parts, got %+v", msg)
+	}
+	if text, ok := msg.Content[0].(TextContent); !ok || text.Text != "Compare these images." {
+		t.Errorf("Expected text part first, got %#v", msg.Content[0])
+	}
+	for i, expected := range images {
+		if img, ok := msg.Content[i+1].(ImageContent); !ok || img != expected {
+			t.Errorf("Expected image %d to be %+v, got %#v", i, expected, msg.Content[i+1])
+		}
+	}
+}
+
+func TestNewMultiImageMessageFromFiles(t *testing.T) {
+	dir := t.TempDir()
+	var paths []string
+	for i, name := range []string{"first.png", "second.png"} {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, i+1, i+1))); err != nil {
+			t.Fatalf("Failed to encode PNG: %v", err)
+		}
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+			t.Fatalf("Failed to write PNG: %v", err)
+		}
+		paths = append(paths, path)
+	}
+
+	msg, err := NewMultiImageMessageFromFiles(RoleUser, "What changed?", paths...)
+	if err != nil {
+		t.Fatalf("NewMultiImageMessageFromFiles failed: %v", err)
+	}
+	if len(msg.Content) != 3 {
+		t.Fatalf("Expected 3 content parts, got %d", len(msg.Content))
+	}
+	for i, path := range paths {
+		data, _ := os.ReadFile(path)
+		img, ok := msg.Content[i+1].(ImageContent)
+		if !ok || img.Base64 != base64.StdEncoding.EncodeToString(data) {
+			t.Errorf("Expected image %d to hold the contents of %s", i, path)
+		}
+	}
+
+	if _, err := NewMultiImageMessageFromFiles(RoleUser, "text", filepath.Join(dir, "missing.png")); err == nil {
+		t.Error("Expected error for a missing file")
+	}
+}
+
+func TestNewImageMessageFromFile_Limits(t *testing.T) {
+	dir := t.TempDir()
+
+	textPath := filepath.Join(dir, "notes.txt")
+	os.WriteFile(textPath, []byte("not an image"), 0o644)
+	if _, err := NewImageMessageFromFile(RoleUser, textPath); err == nil {
+		t.Error("Expected error for a non-image file")
+	}
+
+	original := MaxImageFileSize
+	defer func() { MaxImageFileSize = original }()
+	MaxImageFileSize = 10
+
+	largePath := filepath.Join(dir, "large.png")
+	os.WriteFile(largePath, bytes.Repeat([]byte{0}, 11), 0o644)
+	if _, err := NewImageMessageFromFile(RoleUser, largePath); err == nil {
+		t.Error("Expected error for an image exceeding MaxImageFileSize")
+	}
+
+	if _, err := NewImageMessageFromFile(RoleUser, filepath.Join(dir, "missing.png")); err == nil {
+		t.Error("Expected error for a missing file")
+	}
+}
+
+func TestMessage_FlattenToText(t *testing.T) {
+	msg := NewContentMessage(RoleUser, []MessageContent{
+		TextContent{Text: "Compare these two photos."},
+		ImageContent{URL: "https://example.com/a.jpg"},
+		TextContent{Text: "Which one is brighter?"},
+	})
+
+	expected := "Compare these two photos.\n[image]\nWhich one is brighter?"
+	if flattened := msg.FlattenToText(); flattened != expected {
+		t.Errorf("Expected %q, got %q", expected, flattened)
+	}
+	if msg.GetText() != "Compare these two photos." {
+		t.Errorf("Expected GetText to return only the first text part, got %q", msg.GetText())
+	}
+
+	simple := NewTextMessage(RoleUser, "Hello")
+	if simple.FlattenToText() != "Hello" {
+		t.Errorf("Expected simple text to be unchanged, got %q", simple.FlattenToText())
+	}
+
+	response := &Message{
+		Role:     RoleAssistant,
+		TextData: "The answer is 4.",
+		Content: []MessageContent{
+			ThinkingContent{Text: "Let me think."},
+			TextContent{Text: "The answer is 4."},
+		},
+	}
+	if response.FlattenToText() != "The answer is 4." {
+		t.Errorf("Expected thinking and repeated text to be omitted, got %q", response.FlattenToText())
+	}
+}
+
+func TestMessage_JSONContentRoundTrip(t *testing.T) {
+	original := NewContentMessage(RoleAssistant, []MessageContent{
+		ThinkingContent{Text: "Consider the image"},
+		TextContent{Text: "A cat"},
+		ImageContent{Base64: "aGVsbG8=", MimeType: "image/png", Detail: "low"},
+	})
+
+	data, err := json.Marshal(original)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	var decoded Message
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+
+	if len(decoded.Content) != 3 {
+		t.Fatalf("Expected 3 content parts, got %d", len(decoded.Content))
+	}
+	if thinking, ok := decoded.Content[0].(ThinkingContent); !ok || thinking.Text != "Consider the image" {
+		t.Errorf("Expected thinking content, got %#v", decoded.Content[0])
+	}
+	if text, ok := decoded.Content[1].(TextContent); !ok || text.Text != "A cat" {
+		t.Errorf("Expected text content, got %#v", decoded.Content[1])
+	}
+	if image, ok := decoded.Content[2].(ImageContent); !ok || image != original.Content[2] {
+		t.Errorf("Expected image content, got %#v", decoded.Content[2])
+	}
+	if decoded.Role != RoleAssistant || !decoded.Timestamp.Equal(original.Timestamp) {
+		t.Errorf("Expected role and timestamp to survive, got %s at %v", decoded.Role, decoded.Timestamp)
+	}
+
+	if err := json.Unmarshal([]byte(`{"role":"user","content":[{"type":"audio"}]}`), &decoded); err == nil {
+		t.Errorf("Expected error for unknown content type")
+	}
+}
@@ -0,0 +1,138 @@
+package types
+
+import "encoding/json"
+
+// Standard response metadata keys shared across providers
+const (
+	MetadataKeyGrounding     = "grounding"
+	MetadataKeySafetyRatings = "safety_ratings"
+	MetadataKeyCitations     = "citations"
+	MetadataKeyJSONRepaired  = "json_repaired" // Set when truncated JSON output was repaired
+	MetadataKeyThoughts      = "thoughts"      // Thought summary, when ThinkingConfig.IncludeThoughts is set
+	MetadataKeyRequestID     = "request_id"    // Taken from the request Metadata, or generated by the client
+	MetadataKeyLogProbs      = "logprobs"      // Token log probabilities, when CompletionRequest.LogProbs is set
+)
+
+// Request metadata keys that providers read their overrides from. These are internal to the
+// request: they aren't copied onto responses or sent to a provider as metadata.
+const (
+	MetadataKeyOpenAIOverrides = "openai_config"
+	MetadataKeyGoogleOverrides = "google_config"
+)
+
+// IsInternalMetadataKey reports whether a request metadata key holds provider overrides
+// rather than caller data
+func IsInternalMetadataKey(key string) bool {
+	return key == MetadataKeyOpenAIOverrides || key == MetadataKeyGoogleOverrides
+}
+
+// GroundingMetadata describes the sources a grounded response was based on
+type GroundingMetadata struct {
+	WebSearchQueries []string          `json:"web_search_queries,omitempty"`
+	Sources          []GroundingSource `json:"sources,omitempty"`
+}
+
+// GroundingSource is a single web source used for grounding
+type GroundingSource struct {
+	URI    string `json:"uri,omitempty"`
+	Title  string `json:"title,omitempty"`
+	Domain string `json:"domain,omitempty"`
+}
+
+// SafetyRating represents a provider's safety assessment for one harm category
+type SafetyRating struct {
+	Category    string  `json:"category"`
+	Probability string  `json:"probability,omitempty"`
+	Score       float64 `json:"score,omitempty"`
+	Blocked     bool    `json:"blocked,omitempty"`
+}
+
+// Citation attributes a span of the response to a source
+type Citation struct {
+	URI        string `json:"uri,omitempty"`
+	Title      string `json:"title,omitempty"`
+	License    string `json:"license,omitempty"`
+	StartIndex int    `json:"start_index,omitempty"`
+	EndIndex   int    `json:"end_index,omitempty"`
+}
+
+// TokenLogProb is the log probability of one output token, with the most likely alternatives
+// at its position
+type TokenLogProb struct {
+	Token       string         `json:"token"`
+	LogProb     float64        `json:"logprob"`
+	TopLogProbs []TopTokenProb `json:"top_logprobs,omitempty"`
+}
+
+// TopTokenProb is an alternative token and its log probability
+type TopTokenProb struct {
+	Token   string  `json:"token"`
+	LogProb float64 `json:"logprob"`
+}
+
+// GroundingMetadata returns the grounding metadata attached to the response, if any
+func (r *CompletionResponse) GroundingMetadata() (*GroundingMetadata, bool) {
+	var grounding GroundingMetadata
+	if !decodeMetadata(r.Metadata, MetadataKeyGrounding, &grounding) {
+		return nil, false
+	}
+	return &grounding, true
+}
+
+// SafetyRatings returns the safety ratings attached to the response, if any
+func (r *CompletionResponse) SafetyRatings() ([]SafetyRating, bool) {
+	var ratings []SafetyRating
+	if !decodeMetadata(r.Metadata, MetadataKeySafetyRatings, &ratings) {
+		return nil, false
+	}
+	return ratings, true
+}
+
+// Citations returns the citations attached to the response, if any
+func (r *CompletionResponse) Citations() ([]Citation, bool) {
+	var citations []Citation
+	if !decodeMetadata(r.Metadata, MetadataKeyCitations, &citations) {
+		return nil, false
+	}
+	return citations, true
+}
+
+// LogProbs returns the token log probabilities attached to the response, if any
+func (r *CompletionResponse) LogProbs() ([]TokenLogProb, bool) {
+	var logProbs []TokenLogProb
+	if !decodeMetadata(r.Metadata, MetadataKeyLogProbs, &logProbs) {
+		return nil, false
+	}
+	return logProbs, true
+}
+
+// Thoughts returns the model's thought summary attached to the response, if any
+func (r *CompletionResponse) Thoughts() (string, bool) {
+	var thoughts string
+	if !decodeMetadata(r.Metadata, MetadataKeyThoughts, &thoughts) {
+		return "", false
+	}
+	return thoughts, true
+}
+
+// ProviderOverrides decodes provider-specific overrides stored in the request metadata
+// under key into target. The value may be the provider's override struct, a pointer to
+// it, or a generic map with matching JSON keys. It reports whether overrides were found.
+func (r *CompletionRequest) ProviderOverrides(key string, target interface{}) bool {
+	return decodeMetadata(r.Metadata, key, target)
+}
+
+// decodeMetadata decodes a metadata value into target. Values may be stored as typed
+// structs by providers or as generic maps after a JSON round trip.
+func decodeMetadata(metadata map[string]interface{}, key string, target interface{}) bool {
+	value, exists := metadata[key]
+	if !exists || value == nil {
+		return false
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return false
+	}
+	return json.Unmarshal(data, target) == nil
+}
@@ -0,0 +1,78 @@
+package types
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestCompletionResponse_GroundingMetadata(t *testing.T) {
+	resp := &CompletionResponse{
+		Metadata: map[string]interface{}{
+			MetadataKeyGrounding: &GroundingMetadata{
+				WebSearchQueries: []string{"capital of france"},
+				Sources:          []GroundingSource{{URI: "https://example.com", Title: "Example"}},
+			},
+		},
+	}
+
+	grounding, ok := resp.GroundingMetadata()
+	if !ok {
+		t.Fatal("Expected grounding metadata to be present")
+	}
+	if len(grounding.WebSearchQueries) != 1 || grounding.WebSearchQueries[0] != "capital of france" {
+		t.Errorf("Unexpected web search queries: %v", grounding.WebSearchQueries)
+	}
+	if len(grounding.Sources) != 1 || grounding.Sources[0].URI != "https://example.com" {
+		t.Errorf("Unexpected grounding sources: %+v", grounding.Sources)
+	}
+}
+
+func TestCompletionResponse_SafetyRatings(t *testing.T) {
+	// Metadata decoded from JSON holds generic maps rather than typed structs
+	var resp CompletionResponse
+	data := `{"metadata":{"safety_ratings":[{"category":"HARM_CATEGORY_HARASSMENT","probability":"LOW","blocked":true}]}}`
+	if err := json.Unmarshal([]byte(data), &resp); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+
+	ratings, ok := resp.SafetyRatings()
+	if !ok {
+		t.Fatal("Expected safety ratings to be present")
+	}
+	if len(ratings) != 1 {
+		t.Fatalf("Expected 1 safety rating, got %d", len(ratings))
+	}
+	if ratings[0].Category != "HARM_CATEGORY_HARASSMENT" || ratings[0].Probability != "LOW" || !ratings[0].Blocked {
+		t.Errorf("Unexpected safety rating: %+v", ratings[0])
+	}
+}
+
+func TestCompletionResponse_Citations(t *testing.T) {
+	resp := &CompletionResponse{
+		Metadata: map[string]interface{}{
+			MetadataKeyCitations: []Citation{{URI: "https://example.com/paper", StartIndex: 10, EndIndex: 42}},
+		},
+	}
+
+	citations, ok := resp.Citations()
+	if !ok {
+		t.Fatal("Expected citations to be present")
+	}
+	if len(citations) != 1 || citations[0].StartIndex != 10 || citations[0].EndIndex != 42 {
+		t.Errorf("Unexpected citations: %+v", citations)
+	}
+}
+
+func TestCompletionResponse_MetadataAbsent(t *testing.T) {
+	resp := &CompletionResponse{}
+
+	if _, ok := resp.GroundingMetadata(); ok {
+		t.Error("Expected no grounding metadata")
+	}
+	if _, ok := resp.SafetyRatings(); ok {
+		t.Error("Expected no safety ratings")
+	}
+	if _, ok := resp.Citations(); ok {
+		t.Error("Expected no citations")
+	}
+}
@@ -3,19 +3,21 @@ package types
 import (
 	"encoding/json"
 	"fmt"
+	"sync"
 )
 
 // Model represents a unified model across all providers
 type Model struct {
-	ID           string                 `json:"id"`
-	Name         string                 `json:"name"`
-	Provider     string                 `json:"provider"`
-	Description  string                 `json:"description,omitempty"`
-	MaxTokens    int                    `json:"max_tokens,omitempty"`
-	InputCost    float64                `json:"input_cost,omitempty"`   // Cost per 1M tokens
-	OutputCost   float64                `json:"output_cost,omitempty"`  // Cost per 1M tokens
-	Capabilities []string               `json:"capabilities,omitempty"` // e.g., "chat", "completion", "vision", "tools"
-	Metadata     map[string]interface{} `json:"metadata,omitempty"`
+	ID              string                 `json:"id"`
+	Name            string                 `json:"name"`
+	Provider        string                 `json:"provider"`
+	Description     string                 `json:"description,omitempty"`
+	MaxTokens       int                    `json:"max_tokens,omitempty"`        // Context window size
+	MaxOutputTokens int                    `json:"max_output_tokens,omitempty"` // Completion cap, if the model has one
+	InputCost       float64                `json:"input_cost,omitempty"`        // Cost per 1M tokens
+	OutputCost      float64                `json:"output_cost,omitempty"`       // Cost per 1M tokens
+	Capabilities    []string               `json:"capabilities,omitempty"`      // e.g., "chat", "completion", "vision", "tools"
+	Metadata        map[string]interface{} `json:"metadata,omitempty"`
 }
 
 // ModelCapability represents what a model can do
@@ -36,6 +38,31 @@ const (
 	CapabilityImage      ModelCapability = "image_generation"
 )
 
+// ModelMetadataSystemPlacement is the model Metadata key under which providers report a
+// model's configured SystemPlacement, i.e. where they put the system prompt for that model
+const ModelMetadataSystemPlacement = "system_placement"
+
+// SystemPlacement is where a provider puts the system prompt in a request
+type SystemPlacement string
+
+const (
+	SystemPlacementInstruction  SystemPlacement = "system_instruction" // The provider's system instruction field
+	SystemPlacementFirstMessage SystemPlacement = "first_message"      // A separate user message before the conversation
+	SystemPlacementPrependUser  SystemPlacement = "prepend_user"       // Prepended to the first user message
+)
+
+// SystemPlacement returns the model's system prompt placement override, or "" if it has none
+func (m *Model) SystemPlacement() SystemPlacement {
+	switch placement := m.Metadata[ModelMetadataSystemPlacement].(type) {
+	case SystemPlacement:
+		return placement
+	case string:
+		return SystemPlacement(placement)
+	default:
+		return ""
+	}
+}
+
 // HasCapability checks if the model supports a specific capability
 func (m *Model) HasCapability(capability ModelCapability) bool {
 	for _, cap := range m.Capabilities {
@@ -46,6 +73,21 @@ func (m *Model) HasCapability(capability ModelCapability) bool {
 	return false
 }
 
+// CapabilitiesOf returns the distinct capabilities across models, in first-seen order
+func CapabilitiesOf(models []*Model) []ModelCapability {
+	seen := make(map[string]bool)
+	var capabilities []ModelCapability
+	for _, model := range models {
+		for _, capability := range model.Capabilities {
+			if !seen[capability] {
+				seen[capability] = true
+				capabilities = append(capabilities, ModelCapability(capability))
+			}
+		}
+	}
+	return capabilities
+}
+
 // String returns a string representation of the model
 func (m *Model) String() string {
 	return fmt.Sprintf("%s/%s", m.Provider, m.ID)
@@ -65,6 +107,7 @@ func (m *Model) MarshalJSON() ([]byte, error) {
 
 // ModelRegistry manages available models across providers
 type ModelRegistry struct {
+	mu     sync.RWMutex
 	models map[string]*Model
 }
 
@@ -77,19 +120,39 @@ func NewModelRegistry() *ModelRegistry {
 
 // Register adds a model to the registry
 func (r *ModelRegistry) Register(model *Model) {
-	key := fmt.Sprintf("%s/%s", model.Provider, model.ID)
-	r.models[key] = model
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.models[registryKey(model.Provider, model.ID)] = model
+}
+
+// Replace swaps the registered models for the given set in a single update, so readers
+// see either the old or the new models but never a mix
+func (r *ModelRegistry) Replace(models []*Model) {
+	updated := make(map[string]*Model, len(models))
+	for _, model := range models {
+		updated[registryKey(model.Provider, model.ID)] = model
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.models = updated
 }
 
 // Get retrieves a model by provider and ID
 func (r *ModelRegistry) Get(provider, id string) (*Model, bool) {
-	key := fmt.Sprintf("%s/%s", provider, id)
-	model, exists := r.models[key]
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	model, exists := r.models[registryKey(provider, id)]
 	return model, exists
 }
 
 // GetByProvider returns all models for a specific provider
 func (r *ModelRegistry) GetByProvider(provider string) []*Model {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
 	var models []*Model
 	for _, model := range r.models {
 		if model.Provider == provider {
@@ -101,6 +164,9 @@ func (r *ModelRegistry) GetByProvider(provider string) []*Model {
 
 // GetByCapability returns all models that support a specific capability
 func (r *ModelRegistry) GetByCapability(capability ModelCapability) []*Model {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
 	var models []*Model
 	for _, model := range r.models {
 		if model.HasCapability(capability) {
@@ -112,9 +178,16 @@ func (r *ModelRegistry) GetByCapability(capability ModelCapability) []*Model {
 
 // List returns all registered models
 func (r *ModelRegistry) List() []*Model {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
 	var models []*Model
 	for _, model := range r.models {
 		models = append(models, model)
 	}
 	return models
 }
+
+func registryKey(provider, id string) string {
+	return fmt.Sprintf("%s/%s", provider, id)
+}
@@ -1,6 +1,10 @@
 package types
 
-import "context"
+import (
+	"context"
+	"net/http"
+	"time"
+)
 
 // Provider defines the interface that all AI providers must implement
 type Provider interface {
@@ -25,10 +29,51 @@ type Provider interface {
 	// ValidateModel checks if a model is supported by this provider
 	ValidateModel(model string) error
 
+	// SupportedCapabilities returns the capabilities offered by any of the provider's models
+	SupportedCapabilities() []ModelCapability
+
 	// Close cleans up resources
 	Close() error
 }
 
+// StatefulProvider is implemented by providers that can keep conversation state server-side.
+// When a model supports it, a request with PreviousResponseID set only needs the messages
+// added since that response.
+type StatefulProvider interface {
+	SupportsPreviousResponse(model string) bool
+}
+
+// ModelPricing is a model's price, in the same units as Model.InputCost and Model.OutputCost
+type ModelPricing struct {
+	InputCost  float64 `json:"input_cost"`  // Cost per 1M input tokens
+	OutputCost float64 `json:"output_cost"` // Cost per 1M output tokens
+}
+
+// PricingProvider is implemented by providers whose API reports model pricing, such as
+// gateways. Providers without it keep the pricing from GetModels.
+type PricingProvider interface {
+	// GetPricing returns pricing keyed by model ID
+	GetPricing(ctx context.Context) (map[string]ModelPricing, error)
+}
+
+// ImageProvider is implemented by providers that can generate images with models that have
+// CapabilityImage
+type ImageProvider interface {
+	GenerateImage(ctx context.Context, req *ImageRequest) (*ImageResponse, error)
+}
+
+// TTSProvider is implemented by providers that can synthesize speech with models that have
+// CapabilityTTS
+type TTSProvider interface {
+	Synthesize(ctx context.Context, req *TTSRequest) (*TTSResponse, error)
+}
+
+// TokenCounterProvider is implemented by providers whose token estimates can come from a
+// pluggable TokenCounter. Setting nil restores the provider's built-in counter.
+type TokenCounterProvider interface {
+	SetTokenCounter(tc TokenCounter)
+}
+
 // Config represents provider configuration interface
 type Config interface {
 	GetProvider() string
@@ -44,6 +89,37 @@ type BaseConfig struct {
 	MaxRetries int    `json:"max_retries,omitempty"`
 }
 
+// RequestTimeout returns the configured request timeout, or 0 (no limit) when unset
+func (c *BaseConfig) RequestTimeout() time.Duration {
+	if c.Timeout <= 0 {
+		return 0
+	}
+	return time.Duration(c.Timeout) * time.Second
+}
+
+// WithRequestTimeout bounds a non-streaming request's context by the configured timeout.
+// With no timeout set, the context is only made cancelable.
+func (c *BaseConfig) WithRequestTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if timeout := c.RequestTimeout(); timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// HTTPTransport returns the transport for provider HTTP clients. The configured timeout
+// bounds the wait for response headers rather than the whole body, so a stream can run past
+// it once it has started.
+func (c *BaseConfig) HTTPTransport() http.RoundTripper {
+	timeout := c.RequestTimeout()
+	transport, ok := http.DefaultTransport.(*http.Transport)
+	if timeout <= 0 || !ok {
+		return http.DefaultTransport
+	}
+	transport = transport.Clone()
+	transport.ResponseHeaderTimeout = timeout
+	return transport
+}
+
 func (c *BaseConfig) GetProvider() string {
 	return c.Provider
 }
@@ -2,7 +2,12 @@ package types
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
+	"net"
+	"time"
 )
 
 // Error represents a structured error with provider context
@@ -25,6 +30,22 @@ func (e *Error) Unwrap() error {
 	return e.Cause
 }
 
+// StreamError is returned when a stream fails after output has been received. It wraps the
+// underlying error, so errors.As still finds a wrapped *Error, and carries the partial output.
+type StreamError struct {
+	Err              error
+	PartialContent   string     // Text received before the failure
+	PartialToolCalls []ToolCall // Tool calls received before the failure, fragments merged
+}
+
+func (e *StreamError) Error() string {
+	return fmt.Sprintf("stream failed after partial output: %v", e.Err)
+}
+
+func (e *StreamError) Unwrap() error {
+	return e.Err
+}
+
 // Common error codes
 const (
 	ErrCodeInvalidConfig      = "INVALID_CONFIG"
@@ -37,6 +58,10 @@ const (
 	ErrCodeTimeout            = "TIMEOUT"
 	ErrCodeTokenLimitExceeded = "TOKEN_LIMIT_EXCEEDED"
 	ErrCodeContentFiltered    = "CONTENT_FILTERED"
+	ErrCodeAborted            = "ABORTED"
+	ErrCodeCallbackPanic      = "CALLBACK_PANIC"
+	ErrCodeBudgetExceeded     = "BUDGET_EXCEEDED"
+	ErrCodeValidationFailed   = "VALIDATION_FAILED"
 )
 
 // NewError creates a new structured error
@@ -49,6 +74,28 @@ func NewError(code, message, provider string) *Error {
 	}
 }
 
+// Details keys set on ErrCodeTokenLimitExceeded errors
+const (
+	DetailPromptTokens = "prompt_tokens" // Tokens in the prompt
+	DetailMaxTokens    = "max_tokens"    // Completion tokens requested
+	DetailModelLimit   = "model_limit"   // Token limit that was exceeded
+	DetailOverage      = "overage"       // Tokens over the limit
+)
+
+// NewTokenLimitError creates an ErrCodeTokenLimitExceeded error with token details, where
+// the overage is the prompt and requested completion tokens beyond the model limit
+func NewTokenLimitError(promptTokens, maxTokens, modelLimit int, provider string) *Error {
+	overage := promptTokens + maxTokens - modelLimit
+	err := NewError(ErrCodeTokenLimitExceeded,
+		fmt.Sprintf("request needs %d tokens (%d prompt, %d completion), %d over the limit of %d",
+			promptTokens+maxTokens, promptTokens, maxTokens, overage, modelLimit), provider)
+	err.Details[DetailPromptTokens] = promptTokens
+	err.Details[DetailMaxTokens] = maxTokens
+	err.Details[DetailModelLimit] = modelLimit
+	err.Details[DetailOverage] = overage
+	return err
+}
+
 // WrapError wraps an existing error with provider context
 func WrapError(err error, code, provider string) *Error {
 	return &Error{
@@ -60,23 +107,69 @@ func WrapError(err error, code, provider string) *Error {
 	}
 }
 
+// WrapRequestError wraps an error from a provider API call, reporting timeouts as
+// ErrCodeTimeout and anything else as ErrCodeServerError
+func WrapRequestError(err error, provider string) *Error {
+	code := ErrCodeServerError
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		code = ErrCodeTimeout
+	}
+	return WrapError(err, code, provider)
+}
+
+// MaxTokensUnlimited can be set as CompletionRequest.MaxTokens to omit the max tokens
+// parameter and let the model decide. A MaxTokens of 0 means unset, and uses the client default.
+const MaxTokensUnlimited = -1
+
+// Float64 returns a pointer to v, for optional request fields such as Temperature and TopP
+func Float64(v float64) *float64 {
+	return &v
+}
+
+// NormalizeTemperature clamps a temperature to a provider's valid range of 0 to
+// maxTemperature, logging a warning when it is out of range. A nil temperature stays nil.
+func NormalizeTemperature(temperature *float64, maxTemperature float64, provider string) *float64 {
+	if temperature == nil {
+		return nil
+	}
+
+	clamped := min(max(*temperature, 0), maxTemperature)
+	if clamped != *temperature {
+		slog.Warn("Temperature out of range for provider, clamping",
+			"provider", provider,
+			"temperature", *temperature,
+			"clamped", clamped)
+	}
+	return &clamped
+}
+
 // CompletionRequest represents a unified completion request
 type CompletionRequest struct {
-	Messages       []*Message             `json:"messages"`
-	Model          string                 `json:"model"`
-	MaxTokens      int                    `json:"max_tokens,omitempty"`
-	Temperature    float64                `json:"temperature,omitempty"`
-	TopP           float64                `json:"top_p,omitempty"`
-	TopK           int                    `json:"top_k,omitempty"`
-	Seed           *int                   `json:"seed,omitempty"`
-	Stop           []string               `json:"stop,omitempty"`
-	Stream         bool                   `json:"stream,omitempty"`
-	Tools          []Tool                 `json:"tools,omitempty"`
-	GroundingTools []GroundingTool        `json:"grounding_tools,omitempty"` // Google-specific: URL context, Google Search
-	ToolChoice     interface{}            `json:"tool_choice,omitempty"`
-	ThinkingConfig *ThinkingConfig        `json:"thinking_config,omitempty"`
-	ResponseFormat *ResponseFormat        `json:"response_format,omitempty"`
-	Metadata       map[string]interface{} `json:"metadata,omitempty"`
+	Messages             []*Message             `json:"messages"`
+	Model                string                 `json:"model"`
+	RequiredCapabilities []ModelCapability      `json:"required_capabilities,omitempty"` // With no Model, the cheapest registered model supporting all of these is used
+	N                    int                    `json:"n,omitempty"`                     // Number of candidate completions (OpenAI and Google, Complete only), returned in Choices
+	MaxTokens            int                    `json:"max_tokens,omitempty"`            // 0 uses the client default, MaxTokensUnlimited omits the limit
+	Temperature          *float64               `json:"temperature,omitempty"`           // nil uses the client default; 0 is sent for deterministic output
+	TopP                 *float64               `json:"top_p,omitempty"`                 // nil leaves it to the provider; 0 is sent as set
+	TopK                 int                    `json:"top_k,omitempty"`
+	Seed                 *int                   `json:"seed,omitempty"`
+	Stop                 []string               `json:"stop,omitempty"`
+	Stream               bool                   `json:"stream,omitempty"`
+	Tools                []Tool                 `json:"tools,omitempty"`
+	GroundingTools       []GroundingTool        `json:"grounding_tools,omitempty"` // Google-specific: URL context, Google Search
+	ToolChoice           interface{}            `json:"tool_choice,omitempty"`
+	ThinkingConfig       *ThinkingConfig        `json:"thinking_config,omitempty"`
+	ResponseFormat       *ResponseFormat        `json:"response_format,omitempty"`
+	ResponseLanguage     string                 `json:"response_language,omitempty"`    // e.g. "French", "pt-BR"
+	LogProbs             bool                   `json:"logprobs,omitempty"`             // OpenAI-specific: return token log probabilities in Metadata
+	TopLogProbs          int                    `json:"top_logprobs,omitempty"`         // OpenAI-specific: alternatives returned per token (0 to 20); implies LogProbs
+	Prediction           string                 `json:"prediction,omitempty"`           // OpenAI-specific: expected output for predicted outputs
+	PreviousResponseID   string                 `json:"previous_response_id,omitempty"` // Server-side state to continue; Messages hold only the new turn
+	IdempotencyKey       string                 `json:"idempotency_key,omitempty"`      // Lets providers that support it (OpenAI) deduplicate retried requests
+	Store                *bool                  `json:"store,omitempty"`                // OpenAI-specific: store the completion, with Metadata, for the dashboard
+	Metadata             map[string]interface{} `json:"metadata,omitempty"`
 }
 
 // CompletionResponse represents a unified completion response
@@ -85,10 +178,67 @@ type CompletionResponse struct {
 	Model        string                 `json:"model"`
 	Provider     string                 `json:"provider"`
 	Message      *Message               `json:"message,omitempty"`
+	Choices      []*Message             `json:"choices,omitempty"` // Every candidate when more than one was returned; Choices[0] is Message
 	FinishReason string                 `json:"finish_reason,omitempty"`
 	Usage        *Usage                 `json:"usage,omitempty"`
 	Metadata     map[string]interface{} `json:"metadata,omitempty"`
 	Created      int64                  `json:"created,omitempty"`
+	Timing       *Timing                `json:"timing,omitempty"`
+}
+
+// Timing breaks down where the time for a completion went
+type Timing struct {
+	QueueTime      time.Duration `json:"queue_time"`                 // Time before the request was processed, client-side and provider-reported
+	FirstTokenTime time.Duration `json:"first_token_time,omitempty"` // Time until the first content arrived; streaming only
+	TotalTime      time.Duration `json:"total_time"`                 // Wall-clock time for the whole call
+}
+
+// ImageRequest represents an image generation request
+type ImageRequest struct {
+	Model   string `json:"model"`
+	Prompt  string `json:"prompt"`
+	N       int    `json:"n,omitempty"`       // Number of images, default 1
+	Size    string `json:"size,omitempty"`    // e.g. "1024x1024" (OpenAI) or an aspect ratio such as "16:9" (Imagen)
+	Quality string `json:"quality,omitempty"` // e.g. "hd" or "high" (OpenAI)
+}
+
+// ImageResponse represents an image generation response
+type ImageResponse struct {
+	Model    string      `json:"model"`
+	Provider string      `json:"provider"`
+	Images   []ImageData `json:"images"`
+	Created  int64       `json:"created,omitempty"`
+}
+
+// ImageData is a generated image, returned as either a URL or base64-encoded data
+type ImageData struct {
+	URL           string `json:"url,omitempty"`
+	Data          string `json:"data,omitempty"` // Base64-encoded image bytes
+	MimeType      string `json:"mime_type,omitempty"`
+	RevisedPrompt string `json:"revised_prompt,omitempty"` // The prompt the provider actually used, if it rewrote it
+}
+
+// TTSRequest represents a text-to-speech request
+type TTSRequest struct {
+	Model    string            `json:"model"`
+	Text     string            `json:"text"`
+	Voice    string            `json:"voice,omitempty"`    // Prebuilt voice name, e.g. "Kore"
+	Speakers map[string]string `json:"speakers,omitempty"` // Voice per speaker name in the text, for multi-speaker audio
+}
+
+// TTSResponse represents synthesized speech
+type TTSResponse struct {
+	Audio    []byte `json:"audio"`
+	MimeType string `json:"mime_type"` // e.g. "audio/L16;codec=pcm;rate=24000"
+}
+
+// EstimateCost returns the dollar cost of the response's usage at the model's per-1M-token
+// prices. It returns 0 when usage or pricing is unavailable.
+func (r *CompletionResponse) EstimateCost(model *Model) float64 {
+	if r.Usage == nil || model == nil {
+		return 0
+	}
+	return (float64(r.Usage.PromptTokens)*model.InputCost + float64(r.Usage.CompletionTokens)*model.OutputCost) / 1_000_000
 }
 
 // StreamResponse represents a streaming response chunk
@@ -104,9 +254,11 @@ type StreamResponse struct {
 
 // Usage represents token usage information
 type Usage struct {
-	PromptTokens     int `json:"prompt_tokens"`
-	CompletionTokens int `json:"completion_tokens"`
-	TotalTokens      int `json:"total_tokens"`
+	PromptTokens             int `json:"prompt_tokens"`
+	CompletionTokens         int `json:"completion_tokens"`
+	TotalTokens              int `json:"total_tokens"`
+	AcceptedPredictionTokens int `json:"accepted_prediction_tokens,omitempty"` // OpenAI-specific: predicted output tokens used
+	RejectedPredictionTokens int `json:"rejected_prediction_tokens,omitempty"` // OpenAI-specific: predicted output tokens discarded
 }
 
 // Tool represents a function/tool that can be called by the model
@@ -115,6 +267,74 @@ type Tool struct {
 	Function *ToolFunction `json:"function,omitempty"`
 }
 
+// Tool choice modes for CompletionRequest.ToolChoice
+const (
+	ToolChoiceAuto     = "auto"     // The model decides whether to call tools
+	ToolChoiceNone     = "none"     // The model does not call tools
+	ToolChoiceRequired = "required" // The model must call at least one tool
+)
+
+// ForcedToolChoice can be set as CompletionRequest.ToolChoice to require a call to the named tool
+type ForcedToolChoice struct {
+	Name string `json:"name"`
+}
+
+// ForceToolChoice returns a tool choice requiring a call to the named tool
+func ForceToolChoice(name string) ForcedToolChoice {
+	return ForcedToolChoice{Name: name}
+}
+
+// NormalizeToolChoice validates a CompletionRequest.ToolChoice and returns it as nil, one of
+// the ToolChoice mode strings, or a ForcedToolChoice. Besides those, it accepts the OpenAI
+// shape {"type": "function", "function": {"name": "x"}}, as a map or any struct that encodes
+// to it. Anything else is an ErrCodeInvalidRequest error, rather than being sent as is.
+func NormalizeToolChoice(choice interface{}) (interface{}, error) {
+	switch c := choice.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		switch c {
+		case "":
+			return nil, nil
+		case ToolChoiceAuto, ToolChoiceNone, ToolChoiceRequired:
+			return c, nil
+		}
+		return nil, NewError(ErrCodeInvalidRequest,
+			fmt.Sprintf("unknown tool choice %q; use auto, none, required or ForceToolChoice", c), "")
+	case ForcedToolChoice:
+		return forcedToolChoice(c.Name)
+	case *ForcedToolChoice:
+		if c == nil {
+			return nil, nil
+		}
+		return forcedToolChoice(c.Name)
+	}
+
+	var function struct {
+		Type     string `json:"type"`
+		Function struct {
+			Name string `json:"name"`
+		} `json:"function"`
+	}
+	data, err := json.Marshal(choice)
+	if err == nil {
+		err = json.Unmarshal(data, &function)
+	}
+	if err != nil || function.Type != "function" {
+		return nil, NewError(ErrCodeInvalidRequest,
+			fmt.Sprintf("unsupported tool choice of type %T", choice), "")
+	}
+	return forcedToolChoice(function.Function.Name)
+}
+
+// forcedToolChoice returns a ForcedToolChoice, requiring a tool name
+func forcedToolChoice(name string) (interface{}, error) {
+	if name == "" {
+		return nil, NewError(ErrCodeInvalidRequest, "forced tool choice needs a tool name", "")
+	}
+	return ForcedToolChoice{Name: name}, nil
+}
+
 // GroundingTool represents Google-specific grounding tools (URL context, Google Search)
 type GroundingTool struct {
 	Type string `json:"type"` // "url_context" or "google_search"
@@ -148,3 +368,16 @@ type ResponseFormat struct {
 
 // StreamCallback defines the signature for streaming callbacks
 type StreamCallback func(ctx context.Context, response *StreamResponse) error
+
+// RecoverCallback wraps a stream callback so a panic is returned as an error instead of
+// unwinding through the provider's stream loop, letting the stream be closed normally
+func RecoverCallback(callback StreamCallback, provider string) StreamCallback {
+	return func(ctx context.Context, response *StreamResponse) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = NewError(ErrCodeCallbackPanic, fmt.Sprintf("stream callback panicked: %v", r), provider)
+			}
+		}()
+		return callback(ctx, response)
+	}
+}
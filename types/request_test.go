@@ -0,0 +1,60 @@
+package types
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestCompletionResponse_EstimateCost(t *testing.T) {
+	model := &Model{ID: "gpt-4o", InputCost: 2.50, OutputCost: 10.00}
+	resp := &CompletionResponse{
+		Usage: &Usage{PromptTokens: 1000, CompletionTokens: 500, TotalTokens: 1500},
+	}
+
+	// 1000 * $2.50/1M + 500 * $10/1M
+	if cost := resp.EstimateCost(model); math.Abs(cost-0.0075) > 1e-12 {
+		t.Errorf("Expected cost 0.0075, got %v", cost)
+	}
+	if cost := resp.EstimateCost(nil); cost != 0 {
+		t.Errorf("Expected 0 cost without a model, got %v", cost)
+	}
+	if cost := (&CompletionResponse{}).EstimateCost(model); cost != 0 {
+		t.Errorf("Expected 0 cost without usage, got %v", cost)
+	}
+}
+
+func TestNormalizeToolChoice(t *testing.T) {
+	forced := ForceToolChoice("respond")
+	cases := []struct {
+		choice   interface{}
+		expected interface{}
+	}{
+		{nil, nil},
+		{"", nil},
+		{ToolChoiceRequired, ToolChoiceRequired},
+		{forced, forced},
+		{&forced, forced},
+		{map[string]interface{}{"type": "function", "function": map[string]interface{}{"name": "respond"}}, forced},
+		{struct {
+			Type     string            `json:"type"`
+			Function map[string]string `json:"function"`
+		}{"function", map[string]string{"name": "respond"}}, forced},
+	}
+	for _, tc := range cases {
+		normalized, err := NormalizeToolChoice(tc.choice)
+		if err != nil {
+			t.Fatalf("Failed to normalize tool choice %v: %v", tc.choice, err)
+		}
+		if normalized != tc.expected {
+			t.Errorf("Expected %v for %v, got %v", tc.expected, tc.choice, normalized)
+		}
+	}
+
+	for _, choice := range []interface{}{"any", ForcedToolChoice{}, []string{"respond"}, map[string]interface{}{"type": "code"}} {
+		var aiErr *Error
+		if _, err := NormalizeToolChoice(choice); !errors.As(err, &aiErr) || aiErr.Code != ErrCodeInvalidRequest {
+			t.Errorf("Expected %s error for %v, got %v", ErrCodeInvalidRequest, choice, err)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package types
+
+// TokenCounter counts the prompt tokens messages take up for a model. Providers estimate
+// tokens with a built-in counter; set an accurate one (a tokenizer, or a server-side count
+// call) with Client.SetTokenCounter.
+type TokenCounter interface {
+	Count(messages []*Message, model string) (int, error)
+}
+
+// TokenCounterFunc adapts a function to a TokenCounter
+type TokenCounterFunc func(messages []*Message, model string) (int, error)
+
+// Count calls f
+func (f TokenCounterFunc) Count(messages []*Message, model string) (int, error) {
+	return f(messages, model)
+}
+
+// ApproxTokenCounter estimates ~4 characters per token from each message's flattened text
+type ApproxTokenCounter struct{}
+
+// Count estimates the tokens in messages
+func (ApproxTokenCounter) Count(messages []*Message, model string) (int, error) {
+	total := 0
+	for _, msg := range messages {
+		total += ApproxTextTokens(msg.FlattenToText())
+	}
+	return total, nil
+}
+
+// ApproxTextTokens approximates the token count of text, rounding up from the ~4 characters
+// per token common tokenizers average on English. No tokenizer is bundled, so every built-in
+// estimate is based on it.
+func ApproxTextTokens(text string) int {
+	return (len(text) + 3) / 4
+}
@@ -0,0 +1,101 @@
+package aiutil
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/ztkent/ai-util/types"
+)
+
+// correctionPrompt asks the model to fix a response that failed validation
+const correctionPrompt = "Your previous response was rejected: %s. Respond again, fixing this."
+
+// ResponseValidator checks a completion response, returning an error describing what is wrong
+// with it. The error is sent back to the model when the request is retried.
+type ResponseValidator func(resp *types.CompletionResponse) error
+
+// RequireContains returns a validator requiring the response text to contain substr
+func RequireContains(substr string) ResponseValidator {
+	return func(resp *types.CompletionResponse) error {
+		if !strings.Contains(responseText(resp), substr) {
+			return fmt.Errorf("the response must contain %q", substr)
+		}
+		return nil
+	}
+}
+
+// RequireMatch returns a validator requiring the response text to match pattern
+func RequireMatch(pattern *regexp.Regexp) ResponseValidator {
+	return func(resp *types.CompletionResponse) error {
+		if !pattern.MatchString(responseText(resp)) {
+			return fmt.Errorf("the response must match the pattern %s", pattern)
+		}
+		return nil
+	}
+}
+
+// RequireMaxLength returns a validator limiting the response text to maxChars characters
+func RequireMaxLength(maxChars int) ResponseValidator {
+	return func(resp *types.CompletionResponse) error {
+		if length := len([]rune(responseText(resp))); length > maxChars {
+			return fmt.Errorf("the response must be at most %d characters, but was %d", maxChars, length)
+		}
+		return nil
+	}
+}
+
+// validateResponse runs the client's validators on resp. While one fails and retries remain,
+// the request is resent with the rejected response and a correction asking the model to fix
+// the problem. Usage is summed across all attempts. Once retries run out, an
+// ErrCodeValidationFailed error is returned. Responses with tool calls are returned as is,
+// since a correction can't follow a tool call without its results.
+func (c *Client) validateResponse(ctx context.Context, req *types.CompletionRequest, resp *types.CompletionResponse) (*types.CompletionResponse, error) {
+	usage := &types.Usage{}
+	addUsage(usage, resp.Usage)
+
+	messages := req.Messages
+	for attempt := 0; ; attempt++ {
+		var err error
+		if resp.Message == nil || len(resp.Message.ToolCalls) == 0 {
+			err = c.runValidators(resp)
+		}
+		if err == nil || attempt >= c.defaultConfig.ValidationRetries {
+			if err != nil {
+				return nil, types.WrapError(err, types.ErrCodeValidationFailed, resp.Provider)
+			}
+			if attempt > 0 {
+				resp.Usage = usage
+			}
+			return resp, nil
+		}
+
+		rejected := resp.Message
+		if rejected == nil {
+			rejected = types.NewTextMessage(types.RoleAssistant, "")
+		}
+		messages = append(append([]*types.Message(nil), messages...),
+			rejected,
+			types.NewTextMessage(types.RoleUser, fmt.Sprintf(correctionPrompt, err)),
+		)
+		retry := *req
+		retry.Messages = messages
+
+		resp, err = c.complete(ctx, &retry, "")
+		if err != nil {
+			return nil, err
+		}
+		addUsage(usage, resp.Usage)
+	}
+}
+
+// runValidators returns the first validation error for resp, if any
+func (c *Client) runValidators(resp *types.CompletionResponse) error {
+	for _, validator := range c.defaultConfig.Validators {
+		if err := validator(resp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package aiutil
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/ztkent/ai-util/types"
+)
+
+func TestClient_ResponseValidators(t *testing.T) {
+	provider := newMockProvider("mock-model")
+	replies := []string{"The capital is unknown.", "The capital of France is Paris."}
+	provider.complete = func(ctx context.Context, req *types.CompletionRequest) (*types.CompletionResponse, error) {
+		reply := replies[min(len(provider.requests)-1, len(replies)-1)]
+		return &types.CompletionResponse{
+			Model:    req.Model,
+			Provider: "mock",
+			Message:  types.NewTextMessage(types.RoleAssistant, reply),
+			Usage:    &types.Usage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15},
+		}, nil
+	}
+	client := newMockClient(t, provider)
+	client.defaultConfig.Validators = []ResponseValidator{RequireContains("Paris")}
+	client.defaultConfig.ValidationRetries = 2
+
+	resp, err := client.Complete(context.Background(), &types.CompletionRequest{
+		Model:    "mock-model",
+		Messages: []*types.Message{types.NewTextMessage(types.RoleUser, "What is the capital of France?")},
+	})
+	if err != nil {
+		t.Fatalf("Complete failed: %v", err)
+	}
+	if resp.Message.TextData != "The capital of France is Paris." {
+		t.Errorf("Expected the corrected response, got %q", resp.Message.TextData)
+	}
+	if len(provider.requests) != 2 {
+		t.Fatalf("Expected 1 corrective retry, got %d requests", len(provider.requests))
+	}
+
+	// The retry carries the rejected response and the validation error
+	retry := provider.requests[1].Messages
+	if len(retry) != 3 || retry[1].TextData != "The capital is unknown." {
+		t.Fatalf("Expected the rejected response in the retry, got %+v", retry)
+	}
+	if retry[2].Role != types.RoleUser || !strings.Contains(retry[2].TextData, `must contain "Paris"`) {
+		t.Errorf("Expected the validation error fed back, got %q", retry[2].TextData)
+	}
+	if resp.Usage.TotalTokens != 30 {
+		t.Errorf("Expected usage summed across attempts, got %d", resp.Usage.TotalTokens)
+	}
+}
+
+func TestClient_ResponseValidatorsExhausted(t *testing.T) {
+	provider := newMockProvider("mock-model")
+	client := newMockClient(t, provider)
+	client.defaultConfig.Validators = []ResponseValidator{
+		RequireMaxLength(100),
+		RequireMatch(regexp.MustCompile(`^\d+$`)),
+	}
+	client.defaultConfig.ValidationRetries = 1
+
+	resp, err := client.Complete(context.Background(), &types.CompletionRequest{
+		Model:    "mock-model",
+		Messages: []*types.Message{types.NewTextMessage(types.RoleUser, "Pick a number")},
+	})
+	var aiErr *types.Error
+	if !errors.As(err, &aiErr) || aiErr.Code != types.ErrCodeValidationFailed {
+		t.Fatalf("Expected %s error, got %v", types.ErrCodeValidationFailed, err)
+	}
+	if resp != nil {
+		t.Errorf("Expected no response with the error, got %+v", resp)
+	}
+	if len(provider.requests) != 2 {
+		t.Errorf("Expected the request and 1 retry, got %d requests", len(provider.requests))
+	}
+}
+
+func TestClient_ResponseValidatorsScope(t *testing.T) {
+	provider := newMockProvider("mock-model")
+	provider.complete = func(ctx context.Context, req *types.CompletionRequest) (*types.CompletionResponse, error) {
+		msg := types.NewTextMessage(types.RoleAssistant, "")
+		msg.ToolCalls = []types.ToolCall{{ID: "call_1", Type: "function", Function: types.ToolCallFunction{Name: "lookup", Arguments: "{}"}}}
+		return &types.CompletionResponse{Model: req.Model, Provider: "mock", Message: msg, FinishReason: "tool_calls"}, nil
+	}
+	client := newMockClient(t, provider)
+	client.defaultConfig.Validators = []ResponseValidator{RequireContains("Paris")}
+	client.defaultConfig.ValidationRetries = 2
+
+	// Tool call responses aren't validated
+	req := &types.CompletionRequest{
+		Model:    "mock-model",
+		Messages: []*types.Message{types.NewTextMessage(types.RoleUser, "Where is the Louvre?")},
+	}
+	if _, err := client.Complete(context.Background(), req); err != nil {
+		t.Fatalf("Expected tool calls to skip validation, got %v", err)
+	}
+
+	// Nor are internal completions
+	provider.complete = nil
+	if _, err := client.complete(context.Background(), req, ""); err != nil {
+		t.Fatalf("Expected internal completions to skip validation, got %v", err)
+	}
+	if len(provider.requests) != 2 {
+		t.Errorf("Expected no corrective retries, got %d requests", len(provider.requests))
+	}
+}